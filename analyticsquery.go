@@ -1,8 +1,61 @@
 package gocb
 
+import (
+	"time"
+)
+
 // AnalyticsQuery represents a pending Analytics query.
 type AnalyticsQuery struct {
-	options map[string]interface{}
+	options  map[string]interface{}
+	priority int
+	timeout  time.Duration
+}
+
+// String returns a one-line description of the query statement and its options.
+// Parameter values are redacted unless the log redaction level is RedactNone.
+func (aq *AnalyticsQuery) String() string {
+	return formatQueryOptions(aq.options)
+}
+
+// ClientContextId specifies the identifier the query is sent with, which is returned
+// with its results and can be used to find the query on the server.  A random
+// identifier is used if none is specified.
+func (aq *AnalyticsQuery) ClientContextId(clientContextId string) *AnalyticsQuery {
+	aq.options["client_context_id"] = clientContextId
+	return aq
+}
+
+// Priority specifies whether the query should be run ahead of queries without
+// priority when the analytics service is busy.
+func (aq *AnalyticsQuery) Priority(priority bool) *AnalyticsQuery {
+	if priority {
+		aq.priority = -1
+	} else {
+		aq.priority = 0
+	}
+	return aq
+}
+
+// Timeout indicates the maximum time to wait for this query to complete, overriding
+// the analytics timeout of the cluster.
+func (aq *AnalyticsQuery) Timeout(timeout time.Duration) *AnalyticsQuery {
+	aq.timeout = timeout
+	aq.options["timeout"] = timeout.String()
+	return aq
+}
+
+// Deferred specifies whether the query should be run in the background by the server,
+// which returns a handle to its results, available from the Handle method of
+// AnalyticsResultMetadata, rather than the results themselves.
+//
+// Experimental: This API is subject to change at any time.
+func (aq *AnalyticsQuery) Deferred(deferred bool) *AnalyticsQuery {
+	if deferred {
+		aq.options["mode"] = "async"
+	} else {
+		delete(aq.options, "mode")
+	}
+	return aq
 }
 
 // NewAnalyticsQuery creates a new N1qlQuery object from a query string.
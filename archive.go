@@ -0,0 +1,296 @@
+package gocb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArchiveOptions are the options available to ArchiveTo.  Zero values select the
+// defaults described for each option.
+type ArchiveOptions struct {
+	// Concurrency is the number of documents which are moved at once.  Defaults to 8.
+	Concurrency int
+	// OpsPerSecond limits the rate at which documents are moved.  Defaults to no limit.
+	OpsPerSecond int
+	// DryRun causes the documents which would be moved to be reported as moved, without
+	// any of them being read, stored or removed.
+	DryRun bool
+	// ReplicateTo and PersistTo specify the durability each document must achieve in
+	// the cold bucket before it is removed from the source bucket.
+	ReplicateTo uint
+	PersistTo   uint
+	// Expiry is the expiry of the documents stored in the cold bucket.  Defaults to
+	// the documents never expiring.
+	Expiry uint32
+	// ResumeFrom, if set, resumes an earlier archival from the checkpoint it reported,
+	// skipping the rows of the view which it had already processed.
+	ResumeFrom *ArchiveCheckpoint
+	// OnCheckpoint, if set, is invoked each time the checkpoint advances.  It is
+	// invoked with the checkpoints in order, and should not block for long.
+	OnCheckpoint func(ArchiveCheckpoint)
+}
+
+// ArchiveCheckpoint identifies the last row of a view which, along with every row
+// before it, has been processed by ArchiveTo.
+type ArchiveCheckpoint struct {
+	// Key is the JSON encoded key of the row.
+	Key json.RawMessage `json:"key"`
+	// DocId is the id of the document of the row.
+	DocId string `json:"id"`
+}
+
+// ArchiveReport describes the outcome of ArchiveTo.
+type ArchiveReport struct {
+	// Moved lists the documents which were stored in the cold bucket and removed from
+	// the source bucket, or which would have been, for a dry run.
+	Moved []string
+	// Conflicts lists the documents which were modified while they were being moved,
+	// and so were left in the source bucket.  The version of each which was stored in
+	// the cold bucket is replaced if it is archived again.
+	Conflicts []string
+	// Missing lists the documents which no longer existed when they were read, such as
+	// because they had already expired.
+	Missing []string
+	// Failed holds the error which prevented each document from being moved, keyed by
+	// document id.
+	Failed map[string]error
+	// Checkpoint is the last row processed, which can be passed as ResumeFrom to
+	// continue the archival.  It is nil if no row was processed.
+	Checkpoint *ArchiveCheckpoint
+}
+
+type archiveOutcome int
+
+const (
+	archiveMoved = archiveOutcome(iota)
+	archiveConflict
+	archiveMissing
+	archiveFailed
+)
+
+type archiveRow struct {
+	Key   json.RawMessage `json:"key"`
+	DocId string          `json:"id"`
+}
+
+// archivedValue holds an encoded document which is moved between buckets without
+// being decoded, so that its flags are preserved.
+type archivedValue struct {
+	bytes []byte
+	flags uint32
+}
+
+// archiveTranscoder passes archivedValue through without transcoding it.
+type archiveTranscoder struct {
+}
+
+func (t archiveTranscoder) Decode(bytes []byte, flags uint32, out interface{}) error {
+	value, ok := out.(*archivedValue)
+	if !ok {
+		return clientError{"Unexpected archived value type."}
+	}
+	value.bytes = append([]byte(nil), bytes...)
+	value.flags = flags
+	return nil
+}
+
+func (t archiveTranscoder) Encode(value interface{}) ([]byte, uint32, error) {
+	archived, ok := value.(*archivedValue)
+	if !ok {
+		return nil, 0, clientError{"Unexpected archived value type."}
+	}
+	return archived.bytes, archived.flags, nil
+}
+
+// ArchiveTo moves the documents listed by a view query from the bucket to a cold
+// bucket.  Each document is read with its flags, so that its encoding is preserved,
+// stored in the cold bucket with the specified durability, and then removed from the
+// bucket only if it has not changed since it was read.  Documents which changed in
+// the meantime are left in place and reported as conflicts.
+//
+// The rows of the view are processed in order, and the report carries a checkpoint
+// from which a later call can resume, such as after a failure.  Resuming requires the
+// view to be queried by range with its rows in a stable order.  A failure to move an
+// individual document is recorded in the report rather than stopping the archival.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ArchiveTo(cold *Bucket, q *ViewQuery, opts ArchiveOptions) (*ArchiveReport, error) {
+	start := time.Now()
+	report, err := b.archiveTo(cold, q, opts)
+	return report, b.wrapError(err, "ArchiveTo", "", start)
+}
+
+func (b *Bucket) archiveTo(cold *Bucket, q *ViewQuery, opts ArchiveOptions) (*ArchiveReport, error) {
+	ddoc, name, options, err := q.getInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	resumed := url.Values{}
+	for optName, values := range options {
+		resumed[optName] = append([]string{}, values...)
+	}
+	if opts.ResumeFrom != nil {
+		resumed.Set("startkey", string(opts.ResumeFrom.Key))
+		resumed.Set("startkey_docid", opts.ResumeFrom.DocId)
+	}
+
+	results, err := b.executeViewQuery(context.Background(), "_view", ddoc, name, resumed, viewRowsAll, b.viewTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var rowErr error
+	skipResumed := opts.ResumeFrom != nil
+	next := func() (archiveRow, bool) {
+		for {
+			data := results.NextBytes()
+			if data == nil {
+				return archiveRow{}, false
+			}
+			var row archiveRow
+			if rowErr = json.Unmarshal(data, &row); rowErr != nil {
+				return archiveRow{}, false
+			}
+			if skipResumed {
+				skipResumed = false
+				if row.DocId == opts.ResumeFrom.DocId && bytes.Equal(row.Key, opts.ResumeFrom.Key) {
+					continue
+				}
+			}
+			return row, true
+		}
+	}
+
+	report := runArchive(next, opts, b.archiveMover(cold, opts))
+	if err := results.Close(); err != nil {
+		return report, err
+	}
+	return report, rowErr
+}
+
+// archiveMover returns a function which moves a single document from the bucket to
+// the cold bucket.
+func (b *Bucket) archiveMover(cold *Bucket, opts ArchiveOptions) func(key string) (archiveOutcome, error) {
+	src := *b
+	src.transcoder = archiveTranscoder{}
+	dst := *cold
+	dst.transcoder = archiveTranscoder{}
+
+	return func(key string) (archiveOutcome, error) {
+		var value archivedValue
+		cas, err := src.getFromServer(nil, key, &value)
+		if ErrorCause(err) == ErrKeyNotFound {
+			return archiveMissing, nil
+		} else if err != nil {
+			return archiveFailed, err
+		}
+
+		coldCas, mt, err := dst.upsert(nil, key, &value, opts.Expiry)
+		if err != nil {
+			return archiveFailed, err
+		}
+		if err := dst.durability(key, coldCas, mt, opts.ReplicateTo, opts.PersistTo, false); err != nil {
+			return archiveFailed, err
+		}
+
+		_, _, err = src.remove(nil, key, cas)
+		switch ErrorCause(err) {
+		case nil, ErrKeyNotFound:
+			// A document which expired after it was read has still been archived.
+			return archiveMoved, nil
+		case ErrKeyExists:
+			return archiveConflict, nil
+		}
+		return archiveFailed, err
+	}
+}
+
+// runArchive moves the document of each row returned by next using move, reporting
+// the outcome of each and the checkpoint reached.
+func runArchive(next func() (archiveRow, bool), opts ArchiveOptions, move func(key string) (archiveOutcome, error)) *ArchiveReport {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	var limiter *tokenBucket
+	if opts.OpsPerSecond > 0 {
+		limiter = newTokenBucket(opts.OpsPerSecond)
+	}
+
+	report := &ArchiveReport{Failed: make(map[string]error)}
+	var lock sync.Mutex
+	var rows []archiveRow
+	done := make(map[int]bool)
+	checkpointed := 0
+
+	finish := func(seq int, outcome archiveOutcome, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		key := rows[seq].DocId
+		switch outcome {
+		case archiveMoved:
+			report.Moved = append(report.Moved, key)
+		case archiveConflict:
+			logDebugFields("Document changed while being archived, leaving it in place", keyField(key))
+			report.Conflicts = append(report.Conflicts, key)
+		case archiveMissing:
+			report.Missing = append(report.Missing, key)
+		default:
+			logDebugFields("Failed to archive document", keyField(key), LogField{"error", err})
+			report.Failed[key] = err
+		}
+
+		done[seq] = true
+		advanced := false
+		for done[checkpointed] {
+			delete(done, checkpointed)
+			checkpointed++
+			advanced = true
+		}
+		if advanced {
+			last := rows[checkpointed-1]
+			report.Checkpoint = &ArchiveCheckpoint{Key: last.Key, DocId: last.DocId}
+			if opts.OnCheckpoint != nil {
+				opts.OnCheckpoint(*report.Checkpoint)
+			}
+		}
+	}
+
+	slots := make(chan struct{}, opts.Concurrency)
+	var pending sync.WaitGroup
+	for row, ok := next(); ok; row, ok = next() {
+		lock.Lock()
+		seq := len(rows)
+		rows = append(rows, row)
+		lock.Unlock()
+
+		if opts.DryRun {
+			finish(seq, archiveMoved, nil)
+			continue
+		}
+		if limiter != nil {
+			limiter.wait(context.Background())
+		}
+
+		slots <- struct{}{}
+		pending.Add(1)
+		go func(seq int, key string) {
+			defer pending.Done()
+			outcome, err := move(key)
+			<-slots
+			finish(seq, outcome, err)
+		}(seq, row.DocId)
+	}
+	pending.Wait()
+
+	sort.Strings(report.Moved)
+	sort.Strings(report.Conflicts)
+	sort.Strings(report.Missing)
+	return report
+}
@@ -0,0 +1,131 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func archiveRows(ids ...string) func() (archiveRow, bool) {
+	i := 0
+	return func() (archiveRow, bool) {
+		if i >= len(ids) {
+			return archiveRow{}, false
+		}
+		row := archiveRow{Key: json.RawMessage(fmt.Sprintf("%d", i)), DocId: ids[i]}
+		i++
+		return row, true
+	}
+}
+
+func TestRunArchiveOutcomes(t *testing.T) {
+	outcomes := map[string]archiveOutcome{
+		"a": archiveMoved,
+		"b": archiveConflict,
+		"c": archiveMissing,
+		"d": archiveFailed,
+		"e": archiveMoved,
+	}
+	report := runArchive(archiveRows("e", "d", "c", "b", "a"), ArchiveOptions{Concurrency: 2}, func(key string) (archiveOutcome, error) {
+		if outcomes[key] == archiveFailed {
+			return archiveFailed, ErrTimeout
+		}
+		return outcomes[key], nil
+	})
+
+	if !reflect.DeepEqual(report.Moved, []string{"a", "e"}) {
+		t.Fatalf("Unexpected moved documents %v", report.Moved)
+	}
+	if !reflect.DeepEqual(report.Conflicts, []string{"b"}) {
+		t.Fatalf("Unexpected conflicts %v", report.Conflicts)
+	}
+	if !reflect.DeepEqual(report.Missing, []string{"c"}) {
+		t.Fatalf("Unexpected missing documents %v", report.Missing)
+	}
+	if len(report.Failed) != 1 || report.Failed["d"] != ErrTimeout {
+		t.Fatalf("Unexpected failures %v", report.Failed)
+	}
+	if report.Checkpoint == nil || report.Checkpoint.DocId != "a" || string(report.Checkpoint.Key) != "4" {
+		t.Fatalf("Unexpected checkpoint %+v", report.Checkpoint)
+	}
+}
+
+func TestRunArchiveCheckpointWaitsForEarlierRows(t *testing.T) {
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var checkpoints []string
+
+	opts := ArchiveOptions{
+		Concurrency: 3,
+		OnCheckpoint: func(cp ArchiveCheckpoint) {
+			lock.Lock()
+			checkpoints = append(checkpoints, cp.DocId)
+			lock.Unlock()
+		},
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	report := runArchive(archiveRows("slow", "fast1", "fast2"), opts, func(key string) (archiveOutcome, error) {
+		if key == "slow" {
+			<-release
+		}
+		return archiveMoved, nil
+	})
+
+	if !reflect.DeepEqual(checkpoints, []string{"fast2"}) {
+		t.Fatalf("Expected a single checkpoint once the slow row finished, got %v", checkpoints)
+	}
+	if report.Checkpoint.DocId != "fast2" {
+		t.Fatalf("Unexpected checkpoint %+v", report.Checkpoint)
+	}
+}
+
+func TestRunArchiveDryRun(t *testing.T) {
+	report := runArchive(archiveRows("a", "b"), ArchiveOptions{DryRun: true}, func(key string) (archiveOutcome, error) {
+		t.Fatalf("Dry run moved %s", key)
+		return archiveFailed, nil
+	})
+	if !reflect.DeepEqual(report.Moved, []string{"a", "b"}) {
+		t.Fatalf("Unexpected moved documents %v", report.Moved)
+	}
+	if report.Checkpoint == nil || report.Checkpoint.DocId != "b" {
+		t.Fatalf("Unexpected checkpoint %+v", report.Checkpoint)
+	}
+}
+
+func TestRunArchiveConcurrencyLimit(t *testing.T) {
+	var lock sync.Mutex
+	active, peak := 0, 0
+	runArchive(archiveRows("a", "b", "c", "d", "e", "f"), ArchiveOptions{Concurrency: 2}, func(key string) (archiveOutcome, error) {
+		lock.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		lock.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		lock.Lock()
+		active--
+		lock.Unlock()
+		return archiveMoved, nil
+	})
+	if peak > 2 {
+		t.Fatalf("Expected at most 2 concurrent moves, got %d", peak)
+	}
+}
+
+func TestArchiveTranscoderPreservesFlags(t *testing.T) {
+	var value archivedValue
+	if err := (archiveTranscoder{}).Decode([]byte("abc"), 0x1234, &value); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	bytes, flags, err := archiveTranscoder{}.Encode(&value)
+	if err != nil || string(bytes) != "abc" || flags != 0x1234 {
+		t.Fatalf("Unexpected encoding %q %x %v", bytes, flags, err)
+	}
+}
@@ -1,5 +1,9 @@
 package gocb
 
+import (
+	"crypto/tls"
+)
+
 // Authenticator provides an interface to authenticate to each service.
 type Authenticator interface {
 	clusterMgmt() userPassPair
@@ -26,6 +30,7 @@ type userPassPair struct {
 type BucketAuthenticatorMap map[string]BucketAuthenticator
 
 // ClusterAuthenticator implements an Authenticator which uses a list of buckets and passwords.
+// Clusters running Couchbase Server 5.0 or later should use PasswordAuthenticator instead.
 type ClusterAuthenticator struct {
 	Buckets  BucketAuthenticatorMap
 	Username string
@@ -37,7 +42,7 @@ func (ca ClusterAuthenticator) clusterMgmt() userPassPair {
 }
 
 func (ca ClusterAuthenticator) clusterAll() []userPassPair {
-	userPassList := make([]userPassPair, len(ca.Buckets))
+	userPassList := make([]userPassPair, 0, len(ca.Buckets))
 	for bucket, auth := range ca.Buckets {
 		userPassList = append(userPassList, userPassPair{
 			Username: bucket,
@@ -87,6 +92,8 @@ func (ca ClusterAuthenticator) bucketFts(bucket string) []userPassPair {
 }
 
 // PasswordAuthenticator implements an Authenticator which uses an RBAC username and password.
+// The same credentials are used for every service, and for every bucket, as required by
+// the role-based access control of Couchbase Server 5.0 and later.
 type PasswordAuthenticator struct {
 	Username string
 	Password string
@@ -135,3 +142,57 @@ func (ra PasswordAuthenticator) bucketFts(bucket string) []userPassPair {
 		ra.rbacAll(),
 	}
 }
+
+// CertAuthenticator implements an Authenticator which authenticates to every service
+// using a client certificate over TLS, rather than a username and password.  It can
+// only be used when the connection string uses TLS, and must be passed to
+// Cluster.Authenticate before any buckets are opened.
+//
+// Experimental: This API is subject to change at any time.
+type CertAuthenticator struct {
+	// Certificate is the client certificate, with its private key, presented to the
+	// cluster.
+	Certificate tls.Certificate
+	// GetClientCertificate, if set, is used instead of Certificate to select the client
+	// certificate for each connection, such as to allow it to be rotated.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+}
+
+func (ca CertAuthenticator) clientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if ca.GetClientCertificate != nil {
+		return ca.GetClientCertificate(info)
+	}
+	return &ca.Certificate, nil
+}
+
+func (ca CertAuthenticator) clusterMgmt() userPassPair {
+	return userPassPair{}
+}
+
+func (ca CertAuthenticator) clusterN1ql() []userPassPair {
+	return nil
+}
+
+func (ca CertAuthenticator) clusterFts() []userPassPair {
+	return nil
+}
+
+func (ca CertAuthenticator) bucketMemd(bucket string) userPassPair {
+	return userPassPair{}
+}
+
+func (ca CertAuthenticator) bucketMgmt(bucket string) userPassPair {
+	return userPassPair{}
+}
+
+func (ca CertAuthenticator) bucketViews(bucket string) userPassPair {
+	return userPassPair{}
+}
+
+func (ca CertAuthenticator) bucketN1ql(bucket string) []userPassPair {
+	return nil
+}
+
+func (ca CertAuthenticator) bucketFts(bucket string) []userPassPair {
+	return nil
+}
@@ -0,0 +1,67 @@
+package gocb
+
+import (
+	"crypto/tls"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"testing"
+)
+
+func TestClusterAuthenticatorClusterCredentials(t *testing.T) {
+	auth := ClusterAuthenticator{Buckets: BucketAuthenticatorMap{
+		"travel": {Password: "secret"},
+	}}
+
+	creds := auth.clusterN1ql()
+	if len(creds) != 1 || creds[0].Username != "travel" || creds[0].Password != "secret" {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+}
+
+func TestPasswordAuthenticatorCredentials(t *testing.T) {
+	auth := PasswordAuthenticator{Username: "frank", Password: "secret"}
+	expected := userPassPair{"frank", "secret"}
+
+	pairs := []userPassPair{auth.clusterMgmt(), auth.bucketMemd("a"), auth.bucketMgmt("b"), auth.bucketViews("c")}
+	pairs = append(pairs, auth.clusterN1ql()...)
+	pairs = append(pairs, auth.clusterFts()...)
+	pairs = append(pairs, auth.bucketN1ql("d")...)
+	pairs = append(pairs, auth.bucketFts("e")...)
+	if len(pairs) != 8 {
+		t.Fatalf("Unexpected credentials %+v", pairs)
+	}
+	for _, pair := range pairs {
+		if pair != expected {
+			t.Fatalf("Expected the RBAC credentials for every service, got %+v", pair)
+		}
+	}
+}
+
+func TestCertAuthenticatorRequiresTls(t *testing.T) {
+	c := &Cluster{}
+	if err := c.Authenticate(CertAuthenticator{}); err == nil {
+		t.Fatalf("Expected certificate authentication without TLS to fail")
+	}
+	if c.auth != nil {
+		t.Fatalf("Expected the authenticator not to be used")
+	}
+}
+
+func TestCertAuthenticatorPresentsCertificate(t *testing.T) {
+	cert := tls.Certificate{Certificate: [][]byte{[]byte("client")}}
+	c := &Cluster{agentConfig: gocbcore.AgentConfig{TlsConfig: &tls.Config{}}}
+	if err := c.Authenticate(CertAuthenticator{Certificate: cert}); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+
+	presented, err := c.agentConfig.TlsConfig.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || len(presented.Certificate) != 1 || string(presented.Certificate[0]) != "client" {
+		t.Fatalf("Unexpected client certificate %+v %v", presented, err)
+	}
+
+	if creds := c.auth.clusterN1ql(); len(creds) != 0 {
+		t.Fatalf("Expected no credentials to be sent, got %+v", creds)
+	}
+	if userPass := c.auth.bucketMgmt("default"); userPass.Username != "" || userPass.Password != "" {
+		t.Fatalf("Expected no credentials to be sent, got %+v", userPass)
+	}
+}
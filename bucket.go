@@ -3,6 +3,8 @@ package gocb
 import (
 	"gopkg.in/couchbase/gocbcore.v7"
 	"math/rand"
+	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +24,22 @@ type Bucket struct {
 	viewTimeout     time.Duration
 	n1qlTimeout     time.Duration
 	ftsTimeout      time.Duration
+	viewRetries     int
+
+	kvPoolSize          int
+	bulkInFlightPerNode int
+	bulkInterleave      bool
+	bulkFailFast        bool
+	bulkTimeout         time.Duration
+	nodeHealth          *nodeHealth
+
+	localCache *localCache
+	scheduler  atomic.Value
+	ops        *opTracker
+
+	softDeleteAware     bool
+	disableNetworkRetry bool
+	keyGenerator        KeyGenerator
 
 	internal *BucketInternal
 }
@@ -47,6 +65,12 @@ func createBucket(cluster *Cluster, config *gocbcore.AgentConfig) (*Bucket, erro
 		viewTimeout:     75 * time.Second,
 		n1qlTimeout:     75 * time.Second,
 		ftsTimeout:      75 * time.Second,
+		viewRetries:     defaultViewRetries,
+
+		kvPoolSize: config.KvPoolSize,
+		nodeHealth: newNodeHealth(),
+
+		ops: newOpTracker(),
 	}
 	bucket.internal = &BucketInternal{
 		b: bucket,
@@ -74,12 +98,16 @@ func (b *Bucket) SetOperationTimeout(timeout time.Duration) {
 	b.opTimeout = timeout
 }
 
-// BulkOperationTimeout returns the maximum amount of time to wait for a bulk op to succeed.
+// BulkOperationTimeout returns the maximum amount of time to wait for a bulk op to succeed,
+// from when it is dispatched.
 func (b *Bucket) BulkOperationTimeout() time.Duration {
 	return b.bulkOpTimeout
 }
 
 // SetBulkOperationTimeout sets the maxium amount of time to wait for a bulk op to succeed.
+// The timeout of each op of a bulk request applies from when it is dispatched, rather than
+// from when the request is made, as ops may wait for others to complete before being
+// dispatched.  See SetBulkInFlightLimit and SetBulkTimeout.
 func (b *Bucket) SetBulkOperationTimeout(timeout time.Duration) {
 	b.bulkOpTimeout = timeout
 }
@@ -125,11 +153,32 @@ func (b *Bucket) SetN1qlTimeout(timeout time.Duration) {
 }
 
 // SetTranscoder specifies a Transcoder to use when translating documents from their
-//  raw byte format to Go types and back.
+//  raw byte format to Go types and back.  Passing nil restores the DefaultTranscoder.
 func (b *Bucket) SetTranscoder(transcoder Transcoder) {
+	if transcoder == nil {
+		transcoder = &DefaultTranscoder{}
+	}
 	b.transcoder = transcoder
 }
 
+// Transcoder returns the Transcoder used to translate documents to and from their raw
+//  byte format.
+func (b *Bucket) Transcoder() Transcoder {
+	return b.transcoder
+}
+
+// WithTranscoder returns a view of the bucket which translates documents using the
+// specified Transcoder while sharing the connection of the bucket, so that documents
+// stored in different formats can be accessed side by side.  The bucket itself keeps
+// its current Transcoder.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) WithTranscoder(transcoder Transcoder) *Bucket {
+	view := *b
+	view.SetTranscoder(transcoder)
+	return &view
+}
+
 // InvalidateQueryCache forces the internal cache of prepared queries to be cleared.
 //  Queries to be cached are controlled by the Adhoc() method of N1qlQuery.
 func (b *Bucket) InvalidateQueryCache() {
@@ -141,15 +190,19 @@ type Cas gocbcore.Cas
 type pendingOp gocbcore.PendingOp
 
 func (b *Bucket) getViewEp() (string, error) {
-	capiEps := b.client.CapiEps()
+	capiEps := b.cluster.availableEps(b.client.CapiEps())
 	if len(capiEps) == 0 {
 		return "", &clientError{"No available view nodes."}
 	}
-	return capiEps[rand.Intn(len(capiEps))], nil
+	capiEps, err := b.cluster.closedEps(capiEps)
+	if err != nil {
+		return "", err
+	}
+	return b.cluster.selectEndpoint("views", capiEps), nil
 }
 
 func (b *Bucket) getMgmtEp() (string, error) {
-	mgmtEps := b.client.MgmtEps()
+	mgmtEps := b.cluster.availableEps(b.client.MgmtEps())
 	if len(mgmtEps) == 0 {
 		return "", &clientError{"No available management nodes."}
 	}
@@ -157,23 +210,32 @@ func (b *Bucket) getMgmtEp() (string, error) {
 }
 
 func (b *Bucket) getN1qlEp() (string, error) {
-	n1qlEps := b.client.N1qlEps()
+	n1qlEps := b.cluster.availableEps(b.cluster.serviceEps(N1qlService, b))
 	if len(n1qlEps) == 0 {
 		return "", &clientError{"No available N1QL nodes."}
 	}
-	return n1qlEps[rand.Intn(len(n1qlEps))], nil
+	n1qlEps, err := b.cluster.closedEps(n1qlEps)
+	if err != nil {
+		return "", err
+	}
+	return b.cluster.selectEndpoint("n1ql", b.cluster.reachableN1qlEps(n1qlEps)), nil
 }
 
 func (b *Bucket) getFtsEp() (string, error) {
-	ftsEps := b.client.FtsEps()
+	ftsEps := b.cluster.availableEps(b.cluster.serviceEps(FtsService, b))
 	if len(ftsEps) == 0 {
 		return "", &clientError{"No available FTS nodes."}
 	}
-	return ftsEps[rand.Intn(len(ftsEps))], nil
+	ftsEps, err := b.cluster.closedEps(ftsEps)
+	if err != nil {
+		return "", err
+	}
+	return b.cluster.selectEndpoint("fts", ftsEps), nil
 }
 
 // Close the instance’s underlying socket resources.  Note that operations pending on the connection may fail.
 func (b *Bucket) Close() error {
+	b.ops.shutdown()
 	b.cluster.closeBucket(b)
 	return b.client.Close()
 }
@@ -183,6 +245,13 @@ func (b *Bucket) IoRouter() *gocbcore.Agent {
 	return b.client
 }
 
+// httpClient returns the HTTP client shared by every bucket opened from the same Cluster.
+// Credentials are chosen per request, so a single transport and its connection pool
+// can safely be reused across buckets.
+func (b *Bucket) httpClient() *http.Client {
+	return b.cluster.httpCli
+}
+
 // Internal methods, not safe to be consumed by third parties.
 func (b *Bucket) Internal() *BucketInternal {
 	return b.internal
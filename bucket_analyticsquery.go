@@ -0,0 +1,9 @@
+package gocb
+
+// ExecuteAnalyticsQuery performs an analytics query against the analytics nodes of the
+// cluster using the credentials of the bucket, and returns a list of rows or an error.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteAnalyticsQuery(q *AnalyticsQuery) (AnalyticsResults, error) {
+	return b.cluster.doAnalyticsQuery(b, q)
+}
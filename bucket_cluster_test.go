@@ -3,6 +3,11 @@ package gocb
 // Get the mock first!
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -66,3 +71,129 @@ func TestBootstrapOn(t *testing.T) {
 	}
 
 }
+
+func TestSharedHttpTransport(t *testing.T) {
+	c, err := Connect("couchbase://foo.com,bar.com")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	bucketA := &Bucket{cluster: c, name: "a"}
+	bucketB := &Bucket{cluster: c, name: "b"}
+	if bucketA.httpClient() != bucketB.httpClient() {
+		t.Fatal("Expected buckets from the same cluster to share an HTTP client")
+	}
+	if bucketA.httpClient().Transport != c.httpCli.Transport {
+		t.Fatal("Expected buckets to use the cluster HTTP transport")
+	}
+
+	mgr := c.Manager("admin", "password")
+	if mgr.httpCli != c.httpCli {
+		t.Fatal("Expected the cluster manager to share the cluster HTTP client")
+	}
+}
+
+func TestHttpRedirectReappliesCredentials(t *testing.T) {
+	var authHeader string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authHeader = req.Header.Get("Authorization")
+	}))
+	defer target.Close()
+
+	targetUrl, _ := url.Parse(target.URL)
+	_, targetPort, _ := net.SplitHostPort(targetUrl.Host)
+	// Redirecting to a different hostname causes net/http to strip the credentials.
+	location := "http://localhost:" + targetPort + "/moved"
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, location, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	c, err := Connect("couchbase://localhost:" + targetPort)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c.agentConfig.HttpAddrs = []string{"localhost:" + targetPort}
+
+	req, _ := http.NewRequest("GET", redirector.URL+"/start", nil)
+	req.SetBasicAuth("frank", "password")
+	resp, err := doHttpWithTimeout(c.httpCli, req, 0)
+	if err != nil {
+		t.Fatalf("Expected redirect to a known endpoint to succeed: %v", err)
+	}
+	resp.Body.Close()
+
+	expectedReq, _ := http.NewRequest("GET", location, nil)
+	expectedReq.SetBasicAuth("frank", "password")
+	if authHeader != expectedReq.Header.Get("Authorization") {
+		t.Fatalf("Expected credentials to be re-applied to the redirected request, got %q", authHeader)
+	}
+
+	c.agentConfig.HttpAddrs = nil
+	req, _ = http.NewRequest("GET", redirector.URL+"/start", nil)
+	req.SetBasicAuth("frank", "password")
+	_, err = doHttpWithTimeout(c.httpCli, req, 0)
+	if ErrorCause(err) != ErrUnexpectedRedirect {
+		t.Fatalf("Expected ErrUnexpectedRedirect for an unknown host, got %v", err)
+	}
+	if !strings.Contains(err.Error(), location) {
+		t.Fatalf("Expected the error to name the redirect location, got %v", err)
+	}
+}
+
+func TestKnownHttpHostDefaultPorts(t *testing.T) {
+	c := &Cluster{}
+	c.agentConfig.HttpAddrs = []string{"10.0.0.1:8091", "fd00::2"}
+	c.analyticsHosts = []string{"https://cbas.example.com/analytics"}
+
+	known := []string{"http://10.0.0.1/pools", "http://10.0.0.1:8091/pools", "http://[fd00::2]:8091/pools",
+		"https://cbas.example.com:18091/query", "https://cbas.example.com/query"}
+	for _, location := range known {
+		redirect, _ := url.Parse(location)
+		if !c.isKnownHttpHost(normalizeHttpHost(redirect.Scheme, redirect.Host)) {
+			t.Fatalf("Expected %s to be a known endpoint", location)
+		}
+	}
+
+	unknown := []string{"http://10.0.0.1:8092/pools", "https://10.0.0.1/pools", "http://cbas.example.com/query"}
+	for _, location := range unknown {
+		redirect, _ := url.Parse(location)
+		if c.isKnownHttpHost(normalizeHttpHost(redirect.Scheme, redirect.Host)) {
+			t.Fatalf("Expected %s not to be a known endpoint", location)
+		}
+	}
+}
+
+func TestSharedServiceEndpoints(t *testing.T) {
+	c, err := Connect("couchbase://foo.com,bar.com")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	bucketA := &Bucket{cluster: c, name: "a"}
+	bucketB := &Bucket{cluster: c, name: "b"}
+	unopened := &Bucket{cluster: c, name: "c"}
+	c.bucketList = []*Bucket{bucketA, bucketB}
+
+	if c.serviceConfigBucket(unopened) != bucketA || c.serviceConfigBucket(nil) != bucketA {
+		t.Fatal("Expected every bucket to use the endpoints of the first open bucket")
+	}
+	c.closeBucket(bucketB)
+	if c.serviceConfigBucket(nil) != bucketA {
+		t.Fatal("Expected closing another bucket not to change the endpoints in use")
+	}
+	c.closeBucket(bucketA)
+	if c.serviceConfigBucket(unopened) != unopened || c.serviceConfigBucket(nil) != nil {
+		t.Fatal("Expected the fallback to be used once no bucket is open")
+	}
+
+	c.EnableAnalytics([]string{"http://a:8095"})
+	report, err := c.Diagnostics()
+	if err != nil {
+		t.Fatalf("Failed to get diagnostics: %v", err)
+	}
+	if len(report.Services) != 1 || report.Services[0].Service != CbasService || report.Services[0].Endpoint != "http://a:8095" {
+		t.Fatalf("Expected the shared analytics endpoint to be reported once, got %+v", report.Services)
+	}
+}
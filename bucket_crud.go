@@ -1,83 +1,129 @@
 package gocb
 
 import (
+	"encoding/json"
+	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
+	"reflect"
+	"sync/atomic"
+	"time"
 )
 
 // Get retrieves a document from the bucket
 func (b *Bucket) Get(key string, valuePtr interface{}) (Cas, error) {
-	return b.get(key, valuePtr)
+	start := time.Now()
+	cas, err := b.get(nil, key, valuePtr)
+	return cas, b.wrapError(err, "Get", key, start)
 }
 
 // GetAndTouch retrieves a document and simultaneously updates its expiry time.
 func (b *Bucket) GetAndTouch(key string, expiry uint32, valuePtr interface{}) (Cas, error) {
-	return b.getAndTouch(key, expiry, valuePtr)
+	start := time.Now()
+	cas, err := b.getAndTouch(key, expiry, valuePtr)
+	return cas, b.wrapError(err, "GetAndTouch", key, start)
 }
 
 // GetAndLock locks a document for a period of time, providing exclusive RW access to it.
+// The lock time is in seconds, and the server limits it to 30 seconds, applying
+// its default lock time to longer periods.  Until the lock expires or the document
+// is released with Unlock, mutations of the document which do not specify the CAS
+// returned by GetAndLock fail, and IsKeyLockedError reports their errors.
 func (b *Bucket) GetAndLock(key string, lockTime uint32, valuePtr interface{}) (Cas, error) {
-	return b.getAndLock(key, lockTime, valuePtr)
+	start := time.Now()
+	cas, err := b.getAndLock(key, lockTime, valuePtr)
+	return cas, b.wrapError(err, "GetAndLock", key, start)
 }
 
 // Unlock unlocks a document which was locked with GetAndLock.
 func (b *Bucket) Unlock(key string, cas Cas) (Cas, error) {
+	start := time.Now()
 	cas, _, err := b.unlock(key, cas)
-	return cas, err
+	return cas, b.wrapError(err, "Unlock", key, start)
 }
 
 // GetReplica returns the value of a particular document from a replica server.
 func (b *Bucket) GetReplica(key string, valuePtr interface{}, replicaIdx int) (Cas, error) {
-	return b.getReplica(key, valuePtr, replicaIdx)
+	start := time.Now()
+	cas, err := b.getReplica(nil, key, valuePtr, replicaIdx)
+	return cas, b.wrapError(err, "GetReplica", key, start)
+}
+
+// GetLength returns the size in bytes of the value of a document, without retrieving
+// the value itself.  This requires a server which supports virtual extended attributes.
+func (b *Bucket) GetLength(key string) (uint32, Cas, error) {
+	start := time.Now()
+	size, cas, err := b.getLength(nil, key)
+	return size, cas, b.wrapError(err, "GetLength", key, start)
+}
+
+// GetIfSmaller retrieves a document only if its value is no larger than maxBytes.  A
+// ValueTooLargeError holding the actual size is returned for larger documents, without
+// their value being transferred.
+func (b *Bucket) GetIfSmaller(key string, maxBytes uint32, valuePtr interface{}) (Cas, error) {
+	start := time.Now()
+	cas, err := b.getIfSmaller(key, maxBytes, valuePtr)
+	return cas, b.wrapError(err, "GetIfSmaller", key, start)
 }
 
 // Touch touches a document, specifying a new expiry time for it.
 func (b *Bucket) Touch(key string, cas Cas, expiry uint32) (Cas, error) {
+	start := time.Now()
 	cas, _, err := b.touch(key, cas, expiry)
-	return cas, err
+	return cas, b.wrapError(err, "Touch", key, start)
 }
 
 // Remove removes a document from the bucket.
 func (b *Bucket) Remove(key string, cas Cas) (Cas, error) {
-	cas, _, err := b.remove(key, cas)
-	return cas, err
+	start := time.Now()
+	cas, _, err := b.remove(nil, key, cas)
+	return cas, b.wrapError(err, "Remove", key, start)
 }
 
 // Upsert inserts or replaces a document in the bucket.
 func (b *Bucket) Upsert(key string, value interface{}, expiry uint32) (Cas, error) {
-	cas, _, err := b.upsert(key, value, expiry)
-	return cas, err
+	start := time.Now()
+	cas, _, err := b.upsert(nil, key, value, expiry)
+	return cas, b.wrapError(err, "Upsert", key, start)
 }
 
-// Insert inserts a new document to the bucket.
+// Insert inserts a new document to the bucket.  If the key is empty and the bucket has a
+// KeyGenerator, the document is inserted with a generated key, which InsertEx returns.
 func (b *Bucket) Insert(key string, value interface{}, expiry uint32) (Cas, error) {
-	cas, _, err := b.insert(key, value, expiry)
-	return cas, err
+	start := time.Now()
+	key, cas, _, err := b.insertGenerated(nil, key, value, expiry)
+	return cas, b.wrapError(err, "Insert", key, start)
 }
 
 // Replace replaces a document in the bucket.
 func (b *Bucket) Replace(key string, value interface{}, cas Cas, expiry uint32) (Cas, error) {
-	cas, _, err := b.replace(key, value, cas, expiry)
-	return cas, err
+	start := time.Now()
+	cas, _, err := b.replace(nil, key, value, cas, expiry)
+	return cas, b.wrapError(err, "Replace", key, start)
 }
 
 // Append appends a string value to a document.
 func (b *Bucket) Append(key, value string) (Cas, error) {
+	start := time.Now()
 	cas, _, err := b.append(key, value)
-	return cas, err
+	return cas, b.wrapError(err, "Append", key, start)
 }
 
 // Prepend prepends a string value to a document.
 func (b *Bucket) Prepend(key, value string) (Cas, error) {
+	start := time.Now()
 	cas, _, err := b.prepend(key, value)
-	return cas, err
+	return cas, b.wrapError(err, "Prepend", key, start)
 }
 
 // Counter performs an atomic addition or subtraction for an integer document.  Passing a
-// non-negative `initial` value will cause the document to be created if it did  not
-// already exist.
+// non-negative `initial` value will cause the document to be created if it did not
+// already exist, with `initial` as its value rather than `delta` applied to it.  A zero
+// `delta` reads the current value of the counter, and decrementing never takes the
+// value below zero.
 func (b *Bucket) Counter(key string, delta, initial int64, expiry uint32) (uint64, Cas, error) {
-	val, cas, _, err := b.counter(key, delta, initial, expiry)
-	return val, cas, err
+	start := time.Now()
+	val, cas, _, err := b.counter(nil, key, delta, initial, expiry)
+	return val, cas, b.wrapError(err, "Counter", key, start)
 }
 
 // ServerStats is a tree of statistics information returned from the server.
@@ -90,33 +136,35 @@ func (b *Bucket) Counter(key string, delta, initial int64, expiry uint32) (uint6
 type ServerStats map[string]map[string]string
 
 // Stats returns various server statistics from the cluster.
-func (b *Bucket) Stats(key string) (statsOut ServerStats, errOut error) {
-	signal := make(chan bool, 1)
+func (b *Bucket) Stats(key string) (ServerStats, error) {
+	start := time.Now()
+	stats, err := b.stats(key)
+	return stats, b.wrapError(err, "Stats", key, start)
+}
+
+func (b *Bucket) stats(key string) (statsOut ServerStats, errOut error) {
+	completion := b.ops.begin()
 	statsOut = make(ServerStats)
 
-	op, errOut := b.client.Stats(key, func(stats map[string]gocbcore.SingleServerStats) {
-		for curServer, curStats := range stats {
-			if curStats.Error != nil && errOut == nil {
-				errOut = curStats.Error
+	op, err := b.client.Stats(key, func(stats map[string]gocbcore.SingleServerStats) {
+		completion.complete(func() {
+			for curServer, curStats := range stats {
+				if curStats.Error != nil && errOut == nil {
+					errOut = curStats.Error
+				}
+				statsOut[curServer] = curStats.Stats
 			}
-			statsOut[curServer] = curStats.Stats
-		}
-		signal <- true
+		})
 	})
+	if err != nil {
+		completion.discard()
+		return nil, err
+	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return nil, ErrTimeout
+	if err := completion.wait(op, b.opTimeout); err != nil {
+		return nil, err
 	}
+	return
 }
 
 type ioGetCallback gocbcore.GetCallback
@@ -125,216 +173,436 @@ type ioCtrCallback gocbcore.CounterCallback
 
 type hlpGetHandler func(ioGetCallback) (pendingOp, error)
 
-func (b *Bucket) hlpGetExec(valuePtr interface{}, execFn hlpGetHandler) (casOut Cas, errOut error) {
-	signal := make(chan bool, 1)
+func (b *Bucket) hlpGetExec(opts *kvOpOptions, key string, valuePtr interface{}, execFn hlpGetHandler) (casOut Cas, errOut error) {
+	errOut = b.retryKv(opts, key, false, func(opts *kvOpOptions) error {
+		var err error
+		casOut, err = b.hlpGetExecOnce(opts, valuePtr, execFn)
+		return err
+	})
+	return
+}
+
+func (b *Bucket) hlpGetExecOnce(opts *kvOpOptions, valuePtr interface{}, execFn hlpGetHandler) (casOut Cas, errOut error) {
+	release, err := b.admitOp(opts)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	completion := b.ops.begin()
 	op, err := execFn(func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
-		errOut = err
-		if errOut == nil {
-			errOut = b.transcoder.Decode(bytes, flags, valuePtr)
+		completion.complete(func() {
+			errOut = err
 			if errOut == nil {
-				casOut = Cas(cas)
+				errOut = b.transcoder.Decode(bytes, flags, valuePtr)
+				if errOut == nil {
+					casOut = Cas(cas)
+				}
 			}
-		}
-		signal <- true
+		})
 	})
 	if err != nil {
+		completion.discard()
 		return 0, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return 0, ErrTimeout
+	if err := completion.wait(op, b.kvTimeout(opts)); err != nil {
+		return 0, err
 	}
+	return
+}
+
+// hlpGetExecIdempotent behaves as hlpGetExec for operations which are safe to dispatch
+// again if their connection is dropped.
+func (b *Bucket) hlpGetExecIdempotent(opts *kvOpOptions, key string, valuePtr interface{}, execFn hlpGetHandler) (casOut Cas, errOut error) {
+	errOut = b.retryIdempotent(opts, key, func(opts *kvOpOptions) error {
+		var err error
+		casOut, err = b.hlpGetExecOnce(opts, valuePtr, execFn)
+		return err
+	})
+	return
 }
 
 type hlpCasHandler func(ioCasCallback) (pendingOp, error)
 
-func (b *Bucket) hlpCasExec(execFn hlpCasHandler) (casOut Cas, mtOut MutationToken, errOut error) {
-	signal := make(chan bool, 1)
+func (b *Bucket) hlpCasExec(opts *kvOpOptions, key string, execFn hlpCasHandler) (casOut Cas, mtOut MutationToken, errOut error) {
+	errOut = b.retryKv(opts, key, false, func(opts *kvOpOptions) error {
+		var err error
+		casOut, mtOut, err = b.hlpCasExecOnce(opts, execFn)
+		return err
+	})
+	return
+}
+
+func (b *Bucket) hlpCasExecOnce(opts *kvOpOptions, execFn hlpCasHandler) (casOut Cas, mtOut MutationToken, errOut error) {
+	release, err := b.admitOp(opts)
+	if err != nil {
+		return 0, MutationToken{}, err
+	}
+	defer release()
+
+	completion := b.ops.begin()
 	op, err := execFn(func(cas gocbcore.Cas, mt gocbcore.MutationToken, err error) {
-		errOut = err
-		if errOut == nil {
-			casOut = Cas(cas)
-			mtOut = MutationToken{mt, b}
-		}
-		signal <- true
+		completion.complete(func() {
+			errOut = b.ambiguousNetworkError(err)
+			if errOut == nil {
+				casOut = Cas(cas)
+				mtOut = MutationToken{mt, b}
+			}
+		})
 	})
 	if err != nil {
+		completion.discard()
 		return 0, MutationToken{}, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return 0, MutationToken{}, ErrTimeout
+	if err := completion.wait(op, b.kvTimeout(opts)); err != nil {
+		return 0, MutationToken{}, err
 	}
+	return
 }
 
 type hlpCtrHandler func(ioCtrCallback) (pendingOp, error)
 
-func (b *Bucket) hlpCtrExec(execFn hlpCtrHandler) (valOut uint64, casOut Cas, mtOut MutationToken, errOut error) {
-	signal := make(chan bool, 1)
+func (b *Bucket) hlpCtrExec(opts *kvOpOptions, key string, execFn hlpCtrHandler) (valOut uint64, casOut Cas, mtOut MutationToken, errOut error) {
+	errOut = b.retryKv(opts, key, false, func(opts *kvOpOptions) error {
+		var err error
+		valOut, casOut, mtOut, err = b.hlpCtrExecOnce(opts, execFn)
+		return err
+	})
+	return
+}
+
+func (b *Bucket) hlpCtrExecOnce(opts *kvOpOptions, execFn hlpCtrHandler) (valOut uint64, casOut Cas, mtOut MutationToken, errOut error) {
+	release, err := b.admitOp(opts)
+	if err != nil {
+		return 0, 0, MutationToken{}, err
+	}
+	defer release()
+
+	completion := b.ops.begin()
 	op, err := execFn(func(value uint64, cas gocbcore.Cas, mt gocbcore.MutationToken, err error) {
-		errOut = err
-		if errOut == nil {
-			valOut = value
-			casOut = Cas(cas)
-			mtOut = MutationToken{mt, b}
-		}
-		signal <- true
+		completion.complete(func() {
+			errOut = b.ambiguousNetworkError(err)
+			if errOut == nil {
+				valOut = value
+				casOut = Cas(cas)
+				mtOut = MutationToken{mt, b}
+			}
+		})
 	})
 	if err != nil {
+		completion.discard()
 		return 0, 0, MutationToken{}, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return 0, 0, MutationToken{}, ErrTimeout
+	if err := completion.wait(op, b.kvTimeout(opts)); err != nil {
+		return 0, 0, MutationToken{}, err
 	}
+	return
 }
 
-func (b *Bucket) get(key string, valuePtr interface{}) (Cas, error) {
-	return b.hlpGetExec(valuePtr, func(cb ioGetCallback) (pendingOp, error) {
-		op, err := b.client.Get([]byte(key), gocbcore.GetCallback(cb))
+func (b *Bucket) get(opts *kvOpOptions, key string, valuePtr interface{}) (Cas, error) {
+	if b.softDeleteAware {
+		return b.getSoftDeleteAware(opts, key, valuePtr)
+	}
+
+	if cas, ok, err := b.getCached(key, valuePtr); ok {
+		return cas, err
+	}
+	return b.getFromServer(opts, key, valuePtr)
+}
+
+// getCached retrieves a document from the local cache, returning false if it is not
+// cached.
+func (b *Bucket) getCached(key string, valuePtr interface{}) (Cas, bool, error) {
+	lc := b.localCache
+	if lc == nil || !lc.matches(key) {
+		return 0, false, nil
+	}
+
+	entry, ok := lc.get(key)
+	if !ok {
+		return 0, false, nil
+	}
+	err := b.transcoder.Decode(append([]byte(nil), entry.bytes...), entry.flags, valuePtr)
+	if err != nil {
+		return 0, true, err
+	}
+	return entry.cas, true, nil
+}
+
+// getFromServer retrieves a document from its active node, storing it in the local
+// cache if the cache holds its key.
+func (b *Bucket) getFromServer(opts *kvOpOptions, key string, valuePtr interface{}) (Cas, error) {
+	lc := b.localCache
+	if lc == nil || !lc.matches(key) {
+		return b.hlpGetExecIdempotent(opts, key, valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+			op, err := b.client.Get([]byte(key), gocbcore.GetCallback(cb))
+			return op, err
+		})
+	}
+
+	version := atomic.LoadUint64(&lc.version)
+	return b.hlpGetExecIdempotent(opts, key, valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+		op, err := b.client.Get([]byte(key), func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+			if err == nil {
+				lc.store(key, version, bytes, flags, Cas(cas))
+			}
+			cb(bytes, flags, cas, err)
+		})
 		return op, err
 	})
 }
 
 func (b *Bucket) getAndTouch(key string, expiry uint32, valuePtr interface{}) (Cas, error) {
-	return b.hlpGetExec(valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	cas, err := b.hlpGetExec(nil, key, valuePtr, func(cb ioGetCallback) (pendingOp, error) {
 		op, err := b.client.GetAndTouch([]byte(key), expiry, gocbcore.GetCallback(cb))
 		return op, err
 	})
+	return cas, b.ambiguousNetworkError(err)
 }
 
 func (b *Bucket) getAndLock(key string, lockTime uint32, valuePtr interface{}) (Cas, error) {
-	return b.hlpGetExec(valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	cas, err := b.hlpGetExec(nil, key, valuePtr, func(cb ioGetCallback) (pendingOp, error) {
 		op, err := b.client.GetAndLock([]byte(key), lockTime, gocbcore.GetCallback(cb))
 		return op, err
 	})
+	return cas, b.ambiguousNetworkError(err)
 }
 
 func (b *Bucket) unlock(key string, cas Cas) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Unlock([]byte(key), gocbcore.Cas(cas), gocbcore.UnlockCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) getReplica(key string, valuePtr interface{}, replicaIdx int) (Cas, error) {
-	return b.hlpGetExec(valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+// The virtual extended attribute holding the size of a document's value.
+const valueBytesXattr = "$document.value_bytes"
+
+var getLengthSubDocOps = []gocbcore.SubDocOp{{
+	Op:    gocbcore.SubDocOpGet,
+	Path:  valueBytesXattr,
+	Flags: gocbcore.SubdocFlag(SubdocFlagXattr),
+}}
+
+func decodeValueBytes(results []gocbcore.SubDocResult, err error) (uint32, error) {
+	if err != nil {
+		return 0, err
+	}
+	if len(results) != 1 {
+		return 0, ErrCliInternalError
+	}
+	if results[0].Err != nil {
+		return 0, results[0].Err
+	}
+
+	var size uint32
+	err = json.Unmarshal(results[0].Value, &size)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (b *Bucket) getLength(opts *kvOpOptions, key string) (sizeOut uint32, casOut Cas, errOut error) {
+	errOut = b.retryIdempotent(opts, key, func(opts *kvOpOptions) error {
+		var err error
+		sizeOut, casOut, err = b.getLengthOnce(opts, key)
+		return err
+	})
+	return
+}
+
+func (b *Bucket) getLengthOnce(opts *kvOpOptions, key string) (sizeOut uint32, casOut Cas, errOut error) {
+	completion := b.ops.begin()
+	op, err := b.client.SubDocLookup([]byte(key), getLengthSubDocOps, gocbcore.SubdocDocFlagNone,
+		func(results []gocbcore.SubDocResult, cas gocbcore.Cas, err error) {
+			completion.complete(func() {
+				sizeOut, errOut = decodeValueBytes(results, err)
+				if errOut == nil {
+					casOut = Cas(cas)
+				}
+			})
+		})
+	if err != nil {
+		completion.discard()
+		return 0, 0, err
+	}
+
+	if err := completion.wait(op, b.kvTimeout(opts)); err != nil {
+		return 0, 0, err
+	}
+	return
+}
+
+func (b *Bucket) getIfSmaller(key string, maxBytes uint32, valuePtr interface{}) (Cas, error) {
+	size, _, err := b.getLength(nil, key)
+	if err != nil {
+		return 0, err
+	}
+	if size > maxBytes {
+		return 0, ValueTooLargeError{Size: size, MaxBytes: maxBytes}
+	}
+
+	// The document may have grown since its size was checked, so that is checked
+	// again before the value is decoded.
+	return b.hlpGetExecIdempotent(nil, key, valuePtr, func(cb ioGetCallback) (pendingOp, error) {
+		op, err := b.client.Get([]byte(key), func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+			if err == nil && uint32(len(bytes)) > maxBytes {
+				err = ValueTooLargeError{Size: uint32(len(bytes)), MaxBytes: maxBytes}
+			}
+			cb(bytes, flags, cas, err)
+		})
+		return op, err
+	})
+}
+
+func (b *Bucket) getReplica(opts *kvOpOptions, key string, valuePtr interface{}, replicaIdx int) (Cas, error) {
+	// Replicas are read from other nodes than the one the key is active on, so are not
+	// subject to its circuit breaker.
+	return b.hlpGetExecIdempotent(opts, "", valuePtr, func(cb ioGetCallback) (pendingOp, error) {
 		op, err := b.client.GetReplica([]byte(key), replicaIdx, gocbcore.GetCallback(cb))
 		return op, err
 	})
 }
 
 func (b *Bucket) touch(key string, cas Cas, expiry uint32) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Touch([]byte(key), gocbcore.Cas(cas), expiry, gocbcore.TouchCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) remove(key string, cas Cas) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+func (b *Bucket) remove(opts *kvOpOptions, key string, cas Cas) (Cas, MutationToken, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(opts, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Remove([]byte(key), gocbcore.Cas(cas), gocbcore.RemoveCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) upsert(key string, value interface{}, expiry uint32) (Cas, MutationToken, error) {
+func invalidValueError(valueType reflect.Type) error {
+	return detailedError{ErrInvalidValue, fmt.Sprintf("Values of type %s cannot be encoded for storage.", valueType)}
+}
+
+// encodeValue transcodes a document value, rejecting values which could never be
+// stored before anything is sent to the server.
+func (b *Bucket) encodeValue(value interface{}) ([]byte, uint32, error) {
+	valueType := reflect.TypeOf(value)
+	for valueType != nil && valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	if valueType != nil {
+		switch valueType.Kind() {
+		case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+			return nil, 0, invalidValueError(valueType)
+		}
+	}
+
 	bytes, flags, err := b.transcoder.Encode(value)
+	if err != nil {
+		if typeErr, ok := err.(*json.UnsupportedTypeError); ok {
+			return nil, 0, invalidValueError(typeErr.Type)
+		}
+		return nil, 0, err
+	}
+
+	maxSize := b.cluster.MaxValueSize()
+	if len(bytes) > maxSize {
+		return nil, 0, detailedError{ErrTooBig,
+			fmt.Sprintf("Document value of %d bytes exceeds the maximum of %d bytes.", len(bytes), maxSize)}
+	}
+
+	return bytes, flags, nil
+}
+
+func (b *Bucket) upsert(opts *kvOpOptions, key string, value interface{}, expiry uint32) (Cas, MutationToken, error) {
+	defer b.invalidateLocalCache(key)
+
+	bytes, flags, err := b.encodeValue(value)
 	if err != nil {
 		return 0, MutationToken{}, err
 	}
 
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	return b.hlpCasExec(opts, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Set([]byte(key), bytes, flags, expiry, gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) insert(key string, value interface{}, expiry uint32) (Cas, MutationToken, error) {
-	bytes, flags, err := b.transcoder.Encode(value)
+func (b *Bucket) insert(opts *kvOpOptions, key string, value interface{}, expiry uint32) (Cas, MutationToken, error) {
+	defer b.invalidateLocalCache(key)
+
+	bytes, flags, err := b.encodeValue(value)
 	if err != nil {
 		return 0, MutationToken{}, err
 	}
 
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	return b.hlpCasExec(opts, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Add([]byte(key), bytes, flags, expiry, gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) replace(key string, value interface{}, cas Cas, expiry uint32) (Cas, MutationToken, error) {
-	bytes, flags, err := b.transcoder.Encode(value)
+func (b *Bucket) replace(opts *kvOpOptions, key string, value interface{}, cas Cas, expiry uint32) (Cas, MutationToken, error) {
+	defer b.invalidateLocalCache(key)
+
+	bytes, flags, err := b.encodeValue(value)
 	if err != nil {
 		return 0, MutationToken{}, err
 	}
 
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	return b.hlpCasExec(opts, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Replace([]byte(key), bytes, flags, gocbcore.Cas(cas), expiry, gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
 func (b *Bucket) append(key, value string) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Append([]byte(key), []byte(value), gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
 func (b *Bucket) prepend(key, value string) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.Prepend([]byte(key), []byte(value), gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
-func (b *Bucket) counter(key string, delta, initial int64, expiry uint32) (uint64, Cas, MutationToken, error) {
+func (b *Bucket) counter(opts *kvOpOptions, key string, delta, initial int64, expiry uint32) (uint64, Cas, MutationToken, error) {
+	defer b.invalidateLocalCache(key)
+
 	realInitial := uint64(0xFFFFFFFFFFFFFFFF)
 	if initial >= 0 {
 		realInitial = uint64(initial)
 	}
 
-	if delta > 0 {
-		return b.hlpCtrExec(func(cb ioCtrCallback) (pendingOp, error) {
-			op, err := b.client.Increment([]byte(key), uint64(delta), realInitial, expiry, gocbcore.CounterCallback(cb))
-			return op, err
-		})
-	} else if delta < 0 {
-		return b.hlpCtrExec(func(cb ioCtrCallback) (pendingOp, error) {
+	if delta < 0 {
+		return b.hlpCtrExec(opts, key, func(cb ioCtrCallback) (pendingOp, error) {
 			op, err := b.client.Decrement([]byte(key), uint64(-delta), realInitial, expiry, gocbcore.CounterCallback(cb))
 			return op, err
 		})
-	} else {
-		return 0, 0, MutationToken{}, clientError{"Delta must be a non-zero value."}
 	}
+	return b.hlpCtrExec(opts, key, func(cb ioCtrCallback) (pendingOp, error) {
+		op, err := b.client.Increment([]byte(key), uint64(delta), realInitial, expiry, gocbcore.CounterCallback(cb))
+		return op, err
+	})
 }
@@ -190,7 +190,7 @@ func (b *Bucket) SetExists(key string, value interface{}) (bool, Cas, error) {
 		}
 	}
 
-	return false, 0, nil
+	return false, cas, nil
 }
 
 // SetSize returns the current number of values in a set.
@@ -222,7 +222,7 @@ func (b *Bucket) SetRemove(key string, value interface{}) (Cas, error) {
 			if item == value {
 				foundItem = true
 			} else {
-				newSetContents = append(newSetContents, value)
+				newSetContents = append(newSetContents, item)
 			}
 		}
 
@@ -280,6 +280,9 @@ func TestDsSetRemove(t *testing.T) {
 	if len(setContents) != 3 {
 		t.Fatalf("SetRemove failed to remove the item")
 	}
+	if setContents[0] != "one" || setContents[1] != "two" || setContents[2] != "four" {
+		t.Fatalf("SetRemove did not preserve the other items: %v", setContents)
+	}
 }
 
 func TestDsSetSize(t *testing.T) {
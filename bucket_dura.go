@@ -1,7 +1,11 @@
 package gocb
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
+	"time"
 )
 
 func (b *Bucket) observeOnceCas(key []byte, cas Cas, forDelete bool, replicaIdx int, commCh chan uint) (pendingOp, error) {
@@ -54,36 +58,90 @@ func (b *Bucket) observeOnceCas(key []byte, cas Cas, forDelete bool, replicaIdx
 		})
 }
 
-func (b *Bucket) observeOnceSeqNo(key []byte, mt MutationToken, replicaIdx int, commCh chan uint) (pendingOp, error) {
-	return b.client.ObserveSeqNo(key, mt.token.VbUuid, replicaIdx,
-		func(currentSeqNo gocbcore.SeqNo, persistSeqNo gocbcore.SeqNo, err error) {
+// Flags reported by an observe callback, in addition to the replicated and persisted
+// flags 1 and 2.
+const (
+	observeUuidChanged  = uint(4)
+	observeMutationLost = uint(8)
+)
+
+func (b *Bucket) observeOnceVb(mt MutationToken, replicaIdx int, commCh chan uint) (pendingOp, error) {
+	return b.client.ObserveVb(mt.token.VbId, mt.token.VbUuid, replicaIdx,
+		func(vbUuid gocbcore.VbUuid, persistSeqNo gocbcore.SeqNo, currentSeqNo gocbcore.SeqNo,
+			oldVbUuid gocbcore.VbUuid, lastSeqNo gocbcore.SeqNo, err error) {
 			if err != nil {
 				commCh <- 0
 				return
 			}
-
-			didReplicate := currentSeqNo >= mt.token.SeqNo
-			didPersist := persistSeqNo >= mt.token.SeqNo
-
-			var out uint
-			if didReplicate {
-				out |= 1
-			}
-			if didPersist {
-				out |= 2
-			}
-			commCh <- out
+			commCh <- observeVbState(mt, replicaIdx, vbUuid, persistSeqNo, currentSeqNo, oldVbUuid, lastSeqNo)
 		})
 }
 
-func (b *Bucket) observeOne(key []byte, mt MutationToken, cas Cas, forDelete bool, replicaIdx int, replicaCh, persistCh chan bool) {
-	observeOnce := func(commCh chan uint) (pendingOp, error) {
-		if mt.token.VbUuid != 0 && mt.token.SeqNo != 0 {
-			return b.observeOnceSeqNo(key, mt, replicaIdx, commCh)
+// observeVbState interprets the state of a vbucket reported by a server for the mutation
+// described by mt.  A mutation is lost if the vbucket failed over from the branch it was
+// performed on before the mutation was received, or if the active copy of the vbucket
+// reports that it no longer holds the mutation.  A failover from some other branch
+// means whether the mutation survived cannot be determined.
+func observeVbState(mt MutationToken, replicaIdx int, vbUuid gocbcore.VbUuid, persistSeqNo, currentSeqNo gocbcore.SeqNo,
+	oldVbUuid gocbcore.VbUuid, lastSeqNo gocbcore.SeqNo) uint {
+	if vbUuid != mt.token.VbUuid {
+		if oldVbUuid != mt.token.VbUuid {
+			// The vbucket has failed over since the mutation was performed.
+			return observeUuidChanged
+		}
+		if lastSeqNo < mt.token.SeqNo {
+			return observeMutationLost
 		}
-		return b.observeOnceCas(key, cas, forDelete, replicaIdx, commCh)
+	} else if replicaIdx == 0 && currentSeqNo < mt.token.SeqNo {
+		// The active has gone back on the sequence number it assigned the mutation.
+		return observeMutationLost
 	}
 
+	didReplicate := currentSeqNo >= mt.token.SeqNo
+	didPersist := persistSeqNo >= mt.token.SeqNo
+
+	var out uint
+	if didReplicate {
+		out |= 1
+	}
+	if didPersist {
+		out |= 2
+	}
+	return out
+}
+
+// DurabilityError occurs when a mutation cannot be observed to meet the requested
+// durability requirements.  The mutation itself may still have succeeded.  Its cause is
+// ErrNotEnoughReplicas when the bucket does not have enough replicas to meet the
+// requirements, or ErrDurabilityTimeout when they were not met in time.
+type DurabilityError struct {
+	Err error
+	// ReplicateTo and PersistTo are the requirements which were requested.
+	ReplicateTo uint
+	PersistTo   uint
+	// Replicated and Persisted are the number of nodes, including the active, which the
+	// mutation was observed to have reached and to have been persisted on.
+	Replicated uint
+	Persisted  uint
+}
+
+func (e *DurabilityError) Error() string {
+	return fmt.Sprintf("%s (replicated to %d of %d, persisted to %d of %d)",
+		e.Err, e.Replicated, e.ReplicateTo, e.Persisted, e.PersistTo)
+}
+
+// Unwrap returns the cause of the error.
+func (e *DurabilityError) Unwrap() error {
+	return e.Err
+}
+
+type observeOnceFn func(replicaIdx int, commCh chan uint) (pendingOp, error)
+
+// errObservePending is the error of a poll of a replica which has not yet replicated or
+// persisted the mutation being observed.
+var errObservePending = errors.New("The mutation has not been observed yet.")
+
+func (b *Bucket) observeOne(observeOnce observeOnceFn, replicaIdx int, timeout time.Duration, replicaCh, persistCh chan bool, failedCh chan error) {
 	sentReplicated := false
 	sentPersisted := false
 
@@ -98,20 +156,26 @@ func (b *Bucket) observeOne(key []byte, mt MutationToken, cas Cas, forDelete boo
 		}
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.duraTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
+	// Polls are not retries of a failed operation, so they are neither limited by the
+	// retry budget nor recorded in the metrics of the cluster.
 	commCh := make(chan uint)
-	for {
-		op, err := observeOnce(commCh)
+	err := retryWithTracker(ctx, newRetryTracker(RetryBudget{}), func() error {
+		op, err := observeOnce(replicaIdx, commCh)
 		if err != nil {
-			gocbcore.ReleaseTimer(timeoutTmr, false)
-			failMe()
-			return
+			return err
 		}
 
 		select {
 		case val := <-commCh:
-			// Got Value
+			if (val & observeMutationLost) != 0 {
+				return ErrMutationLost
+			}
+			if (val & observeUuidChanged) != 0 {
+				return ErrVbucketUUIDChanged
+			}
 			if (val&1) != 0 && !sentReplicated {
 				replicaCh <- true
 				sentReplicated = true
@@ -122,45 +186,41 @@ func (b *Bucket) observeOne(key []byte, mt MutationToken, cas Cas, forDelete boo
 			}
 
 			if sentReplicated && sentPersisted {
-				return
-			}
-
-			waitTmr := gocbcore.AcquireTimer(b.duraPollTimeout)
-			select {
-			case <-waitTmr.C:
-				gocbcore.ReleaseTimer(waitTmr, true)
-				// Fall through to outside for loop
-			case <-timeoutTmr.C:
-				gocbcore.ReleaseTimer(waitTmr, false)
-				gocbcore.ReleaseTimer(timeoutTmr, true)
-				failMe()
-				return
+				return nil
 			}
-
-		case <-timeoutTmr.C:
-			// Timed out
+			return errObservePending
+		case <-ctx.Done():
 			op.Cancel()
-			gocbcore.ReleaseTimer(timeoutTmr, true)
-			failMe()
-			return
+			return ErrTimeout
 		}
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		return "observe_pending", b.duraPollTimeout, err == errObservePending
+	})
+	if err == ErrMutationLost || err == ErrVbucketUUIDChanged {
+		failedCh <- err
 	}
+	failMe()
 }
 
-func (b *Bucket) durability(key string, cas Cas, mt MutationToken, replicaTo, persistTo uint, forDelete bool) error {
-	numServers := b.client.NumReplicas() + 1
+func (b *Bucket) observeDurability(observeOnce observeOnceFn, replicaTo, persistTo uint, timeout time.Duration) error {
+	return b.awaitDurability(observeOnce, b.client.NumReplicas()+1, replicaTo, persistTo, timeout)
+}
 
+func (b *Bucket) awaitDurability(observeOnce observeOnceFn, numServers int, replicaTo, persistTo uint, timeout time.Duration) error {
 	if replicaTo > uint(numServers-1) || persistTo > uint(numServers) {
-		return ErrNotEnoughReplicas
+		return &DurabilityError{
+			Err:         ErrNotEnoughReplicas,
+			ReplicateTo: replicaTo,
+			PersistTo:   persistTo,
+		}
 	}
 
-	keyBytes := []byte(key)
-
 	replicaCh := make(chan bool, numServers)
 	persistCh := make(chan bool, numServers)
+	failedCh := make(chan error, numServers)
 
 	for replicaIdx := 0; replicaIdx < numServers; replicaIdx++ {
-		go b.observeOne(keyBytes, mt, cas, forDelete, replicaIdx, replicaCh, persistCh)
+		go b.observeOne(observeOnce, replicaIdx, timeout, replicaCh, persistCh, failedCh)
 	}
 
 	results := int(0)
@@ -179,84 +239,146 @@ func (b *Bucket) durability(key string, cas Cas, mt MutationToken, replicaTo, pe
 				persists++
 			}
 			results++
+		case err := <-failedCh:
+			return err
 		}
 
 		if replicas >= replicaTo && persists >= persistTo {
 			return nil
-		} else if results == ((numServers * 2) - 1) {
-			return ErrDurabilityTimeout
+		} else if results == numServers*2 {
+			select {
+			case err := <-failedCh:
+				return err
+			default:
+			}
+			return &DurabilityError{
+				Err:         ErrDurabilityTimeout,
+				ReplicateTo: replicaTo,
+				PersistTo:   persistTo,
+				Replicated:  replicas,
+				Persisted:   persists,
+			}
+		}
+	}
+}
+
+func (b *Bucket) durability(key string, cas Cas, mt MutationToken, replicaTo, persistTo uint, forDelete bool) error {
+	keyBytes := []byte(key)
+
+	return b.observeDurability(func(replicaIdx int, commCh chan uint) (pendingOp, error) {
+		if mt.token.VbUuid != 0 && mt.token.SeqNo != 0 {
+			return b.observeOnceVb(mt, replicaIdx, commCh)
 		}
+		return b.observeOnceCas(keyBytes, cas, forDelete, replicaIdx, commCh)
+	}, replicaTo, persistTo, b.duraTimeout)
+}
+
+// WaitForDurability waits for the mutation described by a MutationToken to meet the
+// specified durability requirements, without having performed the mutation itself.
+// The token may have been unmarshalled from JSON produced by another process.  If the
+// mutation was rolled back by a failover ErrMutationLost is returned, while if the
+// vbucket has failed over such that whether the mutation survived cannot be determined
+// ErrVbucketUUIDChanged is returned.  A timeout of zero uses the durability timeout of
+// the bucket.
+func (b *Bucket) WaitForDurability(token MutationToken, replicateTo, persistTo uint, timeout time.Duration) error {
+	if token.token.VbUuid == 0 && token.token.SeqNo == 0 {
+		return clientError{"A mutation token is required to wait for durability."}
+	}
+	if timeout <= 0 {
+		timeout = b.duraTimeout
 	}
+
+	start := time.Now()
+	err := b.observeDurability(func(replicaIdx int, commCh chan uint) (pendingOp, error) {
+		return b.observeOnceVb(token, replicaIdx, commCh)
+	}, replicateTo, persistTo, timeout)
+	return b.wrapError(err, "WaitForDurability", "", start)
 }
 
 // TouchDura touches a document, specifying a new expiry time for it.  Additionally checks document durability.
 func (b *Bucket) TouchDura(key string, cas Cas, expiry uint32, replicateTo, persistTo uint) (Cas, error) {
+	start := time.Now()
 	cas, mt, err := b.touch(key, cas, expiry)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "TouchDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "TouchDura", key, start)
 }
 
 // RemoveDura removes a document from the bucket.  Additionally checks document durability.
 func (b *Bucket) RemoveDura(key string, cas Cas, replicateTo, persistTo uint) (Cas, error) {
-	cas, mt, err := b.remove(key, cas)
+	start := time.Now()
+	cas, mt, err := b.remove(nil, key, cas)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "RemoveDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, true)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, true)
+	return cas, b.wrapError(err, "RemoveDura", key, start)
 }
 
 // UpsertDura inserts or replaces a document in the bucket.  Additionally checks document durability.
 func (b *Bucket) UpsertDura(key string, value interface{}, expiry uint32, replicateTo, persistTo uint) (Cas, error) {
-	cas, mt, err := b.upsert(key, value, expiry)
+	start := time.Now()
+	cas, mt, err := b.upsert(nil, key, value, expiry)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "UpsertDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "UpsertDura", key, start)
 }
 
 // InsertDura inserts a new document to the bucket.  Additionally checks document durability.
 func (b *Bucket) InsertDura(key string, value interface{}, expiry uint32, replicateTo, persistTo uint) (Cas, error) {
-	cas, mt, err := b.insert(key, value, expiry)
+	start := time.Now()
+	cas, mt, err := b.insert(nil, key, value, expiry)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "InsertDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "InsertDura", key, start)
 }
 
 // ReplaceDura replaces a document in the bucket.  Additionally checks document durability.
 func (b *Bucket) ReplaceDura(key string, value interface{}, cas Cas, expiry uint32, replicateTo, persistTo uint) (Cas, error) {
-	cas, mt, err := b.replace(key, value, cas, expiry)
+	start := time.Now()
+	cas, mt, err := b.replace(nil, key, value, cas, expiry)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "ReplaceDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "ReplaceDura", key, start)
 }
 
 // AppendDura appends a string value to a document.  Additionally checks document durability.
 func (b *Bucket) AppendDura(key, value string, replicateTo, persistTo uint) (Cas, error) {
+	start := time.Now()
 	cas, mt, err := b.append(key, value)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "AppendDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "AppendDura", key, start)
 }
 
 // PrependDura prepends a string value to a document.  Additionally checks document durability.
 func (b *Bucket) PrependDura(key, value string, replicateTo, persistTo uint) (Cas, error) {
+	start := time.Now()
 	cas, mt, err := b.prepend(key, value)
 	if err != nil {
-		return cas, err
+		return cas, b.wrapError(err, "PrependDura", key, start)
 	}
-	return cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return cas, b.wrapError(err, "PrependDura", key, start)
 }
 
 // CounterDura performs an atomic addition or subtraction for an integer document.  Additionally checks document durability.
 func (b *Bucket) CounterDura(key string, delta, initial int64, expiry uint32, replicateTo, persistTo uint) (uint64, Cas, error) {
-	val, cas, mt, err := b.counter(key, delta, initial, expiry)
+	start := time.Now()
+	val, cas, mt, err := b.counter(nil, key, delta, initial, expiry)
 	if err != nil {
-		return val, cas, err
+		return val, cas, b.wrapError(err, "CounterDura", key, start)
 	}
-	return val, cas, b.durability(key, cas, mt, replicateTo, persistTo, false)
+	err = b.durability(key, cas, mt, replicateTo, persistTo, false)
+	return val, cas, b.wrapError(err, "CounterDura", key, start)
 }
@@ -0,0 +1,146 @@
+package gocb
+
+import (
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeObserveOp struct{}
+
+func (op *fakeObserveOp) Cancel() bool {
+	return true
+}
+
+type fakeVbState struct {
+	vbUuid       gocbcore.VbUuid
+	persistSeqNo gocbcore.SeqNo
+	currentSeqNo gocbcore.SeqNo
+	oldVbUuid    gocbcore.VbUuid
+	lastSeqNo    gocbcore.SeqNo
+}
+
+// fakeVbCluster serves observe responses for a vbucket from each server, which the
+// test can change at any time to simulate a failover.
+type fakeVbCluster struct {
+	lock   sync.Mutex
+	states []fakeVbState
+}
+
+func (c *fakeVbCluster) set(states ...fakeVbState) {
+	c.lock.Lock()
+	c.states = states
+	c.lock.Unlock()
+}
+
+func (c *fakeVbCluster) observeOnce(mt MutationToken) observeOnceFn {
+	return func(replicaIdx int, commCh chan uint) (pendingOp, error) {
+		c.lock.Lock()
+		state := c.states[replicaIdx]
+		c.lock.Unlock()
+		go func() {
+			commCh <- observeVbState(mt, replicaIdx, state.vbUuid, state.persistSeqNo, state.currentSeqNo,
+				state.oldVbUuid, state.lastSeqNo)
+		}()
+		return &fakeObserveOp{}, nil
+	}
+}
+
+func testDuraToken() MutationToken {
+	return MutationToken{token: gocbcore.MutationToken{VbId: 12, VbUuid: 100, SeqNo: 50}}
+}
+
+func TestDurabilityDetectsFailoverRollback(t *testing.T) {
+	b := &Bucket{duraPollTimeout: time.Millisecond}
+	mt := testDuraToken()
+
+	// The mutation has reached the active but not the replica when the active fails,
+	// and the replica is promoted without it.  The new active goes on to accept more
+	// writes, so its sequence numbers pass that of the lost mutation.
+	cluster := &fakeVbCluster{}
+	cluster.set(
+		fakeVbState{vbUuid: 100, currentSeqNo: 50, persistSeqNo: 49},
+		fakeVbState{vbUuid: 100, currentSeqNo: 45, persistSeqNo: 45},
+	)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cluster.set(
+			fakeVbState{vbUuid: 200, currentSeqNo: 60, persistSeqNo: 60, oldVbUuid: 100, lastSeqNo: 45},
+			fakeVbState{vbUuid: 200, currentSeqNo: 60, persistSeqNo: 60, oldVbUuid: 100, lastSeqNo: 45},
+		)
+	}()
+
+	err := b.awaitDurability(cluster.observeOnce(mt), 2, 1, 2, time.Second)
+	if err != ErrMutationLost {
+		t.Fatalf("Expected the rolled back mutation to be reported lost, got %v", err)
+	}
+}
+
+func TestDurabilityDetectsSequenceRegression(t *testing.T) {
+	b := &Bucket{duraPollTimeout: time.Millisecond}
+	mt := testDuraToken()
+
+	// The active rolled back to before the mutation without changing its vbucket uuid.
+	cluster := &fakeVbCluster{}
+	cluster.set(
+		fakeVbState{vbUuid: 100, currentSeqNo: 40, persistSeqNo: 40},
+		fakeVbState{vbUuid: 100, currentSeqNo: 40, persistSeqNo: 40},
+	)
+	err := b.awaitDurability(cluster.observeOnce(mt), 2, 1, 1, time.Second)
+	if err != ErrMutationLost {
+		t.Fatalf("Expected the rolled back mutation to be reported lost, got %v", err)
+	}
+}
+
+func TestDurabilitySurvivesFailover(t *testing.T) {
+	b := &Bucket{duraPollTimeout: time.Millisecond}
+	mt := testDuraToken()
+
+	// The replica received the mutation before it was promoted.
+	cluster := &fakeVbCluster{}
+	cluster.set(
+		fakeVbState{vbUuid: 200, currentSeqNo: 55, persistSeqNo: 55, oldVbUuid: 100, lastSeqNo: 52},
+		fakeVbState{vbUuid: 200, currentSeqNo: 55, persistSeqNo: 55, oldVbUuid: 100, lastSeqNo: 52},
+	)
+	if err := b.awaitDurability(cluster.observeOnce(mt), 2, 1, 2, time.Second); err != nil {
+		t.Fatalf("Expected the surviving mutation to be durable, got %v", err)
+	}
+
+	// After failing over from a different branch, the outcome cannot be determined.
+	cluster.set(
+		fakeVbState{vbUuid: 300, currentSeqNo: 55, persistSeqNo: 55, oldVbUuid: 200, lastSeqNo: 52},
+		fakeVbState{vbUuid: 300, currentSeqNo: 55, persistSeqNo: 55, oldVbUuid: 200, lastSeqNo: 52},
+	)
+	if err := b.awaitDurability(cluster.observeOnce(mt), 2, 1, 2, time.Second); err != ErrVbucketUUIDChanged {
+		t.Fatalf("Expected ErrVbucketUUIDChanged, got %v", err)
+	}
+}
+
+func TestDurabilityErrorReportsProgress(t *testing.T) {
+	b := &Bucket{duraPollTimeout: time.Millisecond}
+	mt := testDuraToken()
+
+	// The mutation reaches the replica but is never persisted by it.
+	cluster := &fakeVbCluster{}
+	cluster.set(
+		fakeVbState{vbUuid: 100, currentSeqNo: 50, persistSeqNo: 50},
+		fakeVbState{vbUuid: 100, currentSeqNo: 50, persistSeqNo: 45},
+	)
+	err := b.awaitDurability(cluster.observeOnce(mt), 2, 1, 2, 20*time.Millisecond)
+	duraErr, ok := err.(*DurabilityError)
+	if !ok {
+		t.Fatalf("Expected a DurabilityError, got %v", err)
+	}
+	if ErrorCause(err) != ErrDurabilityTimeout {
+		t.Fatalf("Expected ErrDurabilityTimeout as the cause, got %v", ErrorCause(err))
+	}
+	if duraErr.Replicated != 2 || duraErr.Persisted != 1 || duraErr.ReplicateTo != 1 || duraErr.PersistTo != 2 {
+		t.Fatalf("Unexpected durability progress %+v", duraErr)
+	}
+
+	err = b.awaitDurability(cluster.observeOnce(mt), 2, 2, 0, time.Second)
+	if ErrorCause(err) != ErrNotEnoughReplicas {
+		t.Fatalf("Expected ErrNotEnoughReplicas, got %v", err)
+	}
+}
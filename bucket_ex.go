@@ -0,0 +1,241 @@
+package gocb
+
+import (
+	"time"
+)
+
+// GetOptions are the options available to GetEx.
+type GetOptions struct {
+	Priority OpPriority
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// UpsertOptions are the options available to UpsertEx.
+type UpsertOptions struct {
+	Expiry   uint32
+	Priority OpPriority
+	// DurabilityLevel is the level of synchronous replication the mutation must achieve.
+	DurabilityLevel DurabilityLevel
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// InsertOptions are the options available to InsertEx.
+type InsertOptions struct {
+	Expiry   uint32
+	Priority OpPriority
+	// DurabilityLevel is the level of synchronous replication the mutation must achieve.
+	DurabilityLevel DurabilityLevel
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// ReplaceOptions are the options available to ReplaceEx.
+type ReplaceOptions struct {
+	Cas      Cas
+	Expiry   uint32
+	Priority OpPriority
+	// DurabilityLevel is the level of synchronous replication the mutation must achieve.
+	DurabilityLevel DurabilityLevel
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// RemoveOptions are the options available to RemoveEx.
+type RemoveOptions struct {
+	Cas      Cas
+	Priority OpPriority
+	// DurabilityLevel is the level of synchronous replication the mutation must achieve.
+	DurabilityLevel DurabilityLevel
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// CounterOptions are the options available to CounterEx.
+type CounterOptions struct {
+	Initial  int64
+	Expiry   uint32
+	Priority OpPriority
+	// RetryStrategy, if set, overrides the retry strategy of the cluster for the operation.
+	RetryStrategy RetryStrategy
+	// ParentSpan, if set, is the span the operation is traced as a child of.
+	ParentSpan RequestSpanContext
+	// Timeout, if set, overrides the operation timeout of the bucket for the operation.
+	Timeout time.Duration
+}
+
+// kvOpOptions holds the settings of a KV operation which can be overridden through
+// its options.  It is passed down to the dispatch of the operation, and a nil
+// *kvOpOptions uses the settings of the bucket.
+type kvOpOptions struct {
+	priority      OpPriority
+	retryStrategy RetryStrategy
+	parentSpan    RequestSpanContext
+	timeout       time.Duration
+}
+
+func newKvOpOptions(priority OpPriority, strategy RetryStrategy, parent RequestSpanContext, timeout time.Duration) *kvOpOptions {
+	return &kvOpOptions{
+		priority:      priority,
+		retryStrategy: strategy,
+		parentSpan:    parent,
+		timeout:       timeout,
+	}
+}
+
+// withTimeout returns a copy of the options which uses the specified timeout.
+func (opts *kvOpOptions) withTimeout(timeout time.Duration) *kvOpOptions {
+	var timed kvOpOptions
+	if opts != nil {
+		timed = *opts
+	}
+	timed.timeout = timeout
+	return &timed
+}
+
+func (opts *kvOpOptions) opPriority() OpPriority {
+	if opts == nil {
+		return PriorityNormal
+	}
+	return opts.priority
+}
+
+func (opts *kvOpOptions) span() RequestSpanContext {
+	if opts == nil {
+		return nil
+	}
+	return opts.parentSpan
+}
+
+// kvTimeout returns the timeout of a KV operation, which is the operation timeout of
+// the bucket unless its options override it.
+func (b *Bucket) kvTimeout(opts *kvOpOptions) time.Duration {
+	if opts != nil && opts.timeout > 0 {
+		return opts.timeout
+	}
+	return b.opTimeout
+}
+
+// GetEx retrieves a document from the bucket using the specified options.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) GetEx(key string, valuePtr interface{}, opts *GetOptions) (Cas, error) {
+	if opts == nil {
+		opts = &GetOptions{}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	cas, err := b.get(opOpts, key, valuePtr)
+	return cas, b.wrapOpError(opOpts, err, "Get", key, start)
+}
+
+// UpsertEx inserts or replaces a document in the bucket using the specified options.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) UpsertEx(key string, value interface{}, opts *UpsertOptions) (Cas, error) {
+	if opts == nil {
+		opts = &UpsertOptions{}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	cas, _, err := b.mutateWithLevel(key, opts.DurabilityLevel, false, func() (Cas, MutationToken, error) {
+		return b.upsert(opOpts, key, value, opts.Expiry)
+	})
+	return cas, b.wrapOpError(opOpts, err, "Upsert", key, start)
+}
+
+// MutationResult is the outcome of a mutation performed using the specified options.
+type MutationResult struct {
+	// Key is the key of the document, which is generated for inserts with an empty key
+	// when the bucket has a KeyGenerator.
+	Key           string
+	Cas           Cas
+	MutationToken MutationToken
+}
+
+// InsertEx inserts a new document to the bucket using the specified options.  If the
+// key is empty and the bucket has a KeyGenerator, the document is inserted with a
+// generated key, which is returned in the result.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) InsertEx(key string, value interface{}, opts *InsertOptions) (MutationResult, error) {
+	if opts == nil {
+		opts = &InsertOptions{}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	if err := b.checkDurabilityLevel(opts.DurabilityLevel); err != nil {
+		return MutationResult{Key: key}, b.wrapOpError(opOpts, err, "Insert", key, start)
+	}
+	key, cas, mt, err := b.insertGenerated(opOpts, key, value, opts.Expiry)
+	if err == nil {
+		err = b.levelDurability(key, cas, opts.DurabilityLevel, false)
+	}
+	return MutationResult{Key: key, Cas: cas, MutationToken: mt}, b.wrapOpError(opOpts, err, "Insert", key, start)
+}
+
+// ReplaceEx replaces a document in the bucket using the specified options.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ReplaceEx(key string, value interface{}, opts *ReplaceOptions) (Cas, error) {
+	if opts == nil {
+		opts = &ReplaceOptions{}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	cas, _, err := b.mutateWithLevel(key, opts.DurabilityLevel, false, func() (Cas, MutationToken, error) {
+		return b.replace(opOpts, key, value, opts.Cas, opts.Expiry)
+	})
+	return cas, b.wrapOpError(opOpts, err, "Replace", key, start)
+}
+
+// RemoveEx removes a document from the bucket using the specified options.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) RemoveEx(key string, opts *RemoveOptions) (Cas, error) {
+	if opts == nil {
+		opts = &RemoveOptions{}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	cas, _, err := b.mutateWithLevel(key, opts.DurabilityLevel, true, func() (Cas, MutationToken, error) {
+		return b.remove(opOpts, key, opts.Cas)
+	})
+	return cas, b.wrapOpError(opOpts, err, "Remove", key, start)
+}
+
+// CounterEx performs an atomic addition or subtraction for an integer document using
+// the specified options.  A non-negative Initial value causes the document to be created
+// if it did not already exist, so Initial must be negative to require that it exists.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) CounterEx(key string, delta int64, opts *CounterOptions) (uint64, Cas, error) {
+	if opts == nil {
+		opts = &CounterOptions{Initial: -1}
+	}
+	start := time.Now()
+	opOpts := newKvOpOptions(opts.Priority, opts.RetryStrategy, opts.ParentSpan, opts.Timeout)
+	val, cas, _, err := b.counter(opOpts, key, delta, opts.Initial, opts.Expiry)
+	return val, cas, b.wrapOpError(opOpts, err, "Counter", key, start)
+}
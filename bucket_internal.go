@@ -29,46 +29,43 @@ func (bi *BucketInternal) RemoveMeta(key string, value, extra []byte, datatype u
 }
 
 func (b *Bucket) getRandom(valuePtr interface{}) (keyOut string, casOut Cas, errOut error) {
-	signal := make(chan bool, 1)
+	completion := b.ops.begin()
 	op, err := b.client.GetRandom(func(keyBytes, bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
-		errOut = err
-		if errOut == nil {
-			errOut = b.transcoder.Decode(bytes, flags, valuePtr)
+		completion.complete(func() {
+			errOut = err
 			if errOut == nil {
-				casOut = Cas(cas)
-				keyOut = string(keyBytes)
+				errOut = b.transcoder.Decode(bytes, flags, valuePtr)
+				if errOut == nil {
+					casOut = Cas(cas)
+					keyOut = string(keyBytes)
+				}
 			}
-		}
-		signal <- true
+		})
 	})
 	if err != nil {
+		completion.discard()
 		return "", 0, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return "", 0, ErrTimeout
+	if err := completion.wait(op, b.opTimeout); err != nil {
+		return "", 0, err
 	}
+	return
 }
 
 func (b *Bucket) upsertMeta(key string, value, extra []byte, datatype uint8, options, flags uint32, expiry uint32, cas, revseqno uint64) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.SetMeta([]byte(key), value, extra, datatype, options, flags, expiry, cas, revseqno, gocbcore.StoreCallback(cb))
 		return op, err
 	})
 }
 
 func (b *Bucket) removeMeta(key string, value, extra []byte, datatype uint8, options, flags uint32, expiry uint32, cas, revseqno uint64) (Cas, MutationToken, error) {
-	return b.hlpCasExec(func(cb ioCasCallback) (pendingOp, error) {
+	defer b.invalidateLocalCache(key)
+
+	return b.hlpCasExec(nil, key, func(cb ioCasCallback) (pendingOp, error) {
 		op, err := b.client.DeleteMeta([]byte(key), value, extra, datatype, options, flags, expiry, cas, revseqno, gocbcore.RemoveCallback(cb))
 		return op, err
 	})
@@ -2,6 +2,8 @@ package gocb
 
 import (
 	"gopkg.in/couchbase/gocbcore.v7"
+	"sync"
+	"time"
 )
 
 type bulkOp struct {
@@ -24,43 +26,61 @@ type BulkOp interface {
 	execute(*Bucket, chan BulkOp)
 	markError(err error)
 	cancel() bool
+	bulkKey() string
+	bulkErr() error
 }
 
-// Do execute one or more `BulkOp` items in parallel.
+// Do execute one or more `BulkOp` items in parallel.  The error of each operation is
+// set on the BulkOp.  Do returns ErrTimeout if any of the operations timed out, and
+// ErrShutdown if the bucket was closed before they completed.
 func (b *Bucket) Do(ops []BulkOp) error {
-	timeoutTmr := gocbcore.AcquireTimer(b.bulkOpTimeout)
-
-	// Make the channel big enough to hold all our ops in case
-	//   we get delayed inside execute (don't want to block the
-	//   individual op handlers when they dispatch their signal).
-	signal := make(chan BulkOp, len(ops))
-	for _, item := range ops {
-		item.execute(b, signal)
+	start := time.Now()
+	err := b.do(ops, PriorityNormal)
+	return b.wrapError(err, "Do", "", start)
+}
+
+// DoWithPriority executes one or more `BulkOp` items in parallel, dispatching them with
+// the specified priority when the bucket's operation queue is congested.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) DoWithPriority(ops []BulkOp, priority OpPriority) error {
+	start := time.Now()
+	err := b.do(ops, priority)
+	return b.wrapError(err, "Do", "", start)
+}
+
+func (b *Bucket) do(ops []BulkOp, priority OpPriority) error {
+	if generator := b.KeyGenerator(); generator != nil {
+		return b.doGenerated(ops, generator, priority)
 	}
-	for range ops {
-		select {
-		case item := <-signal:
-			// We're really just clearing the pendop from this thread,
-			//   since it already completed, no cancel actually occurs
-			item.cancel()
-		case <-timeoutTmr.C:
-			gocbcore.ReleaseTimer(timeoutTmr, true)
-			for _, item := range ops {
-				if !item.cancel() {
-					<-signal
-					continue
-				}
+	return b.doBatch(ops, priority)
+}
 
-				// We use this method to mark the individual items as
-				// having timed out so we don't move `Err` in bulkOp
-				// and break backwards compatibility.
-				item.markError(ErrTimeout)
+func (b *Bucket) doBatch(ops []BulkOp, priority OpPriority) error {
+	return newBulkDispatcher(b, ops, b.bulkOpNode, priority).run(b.bulkTimeout)
+}
+
+// awaitAbandonedOps waits for the callbacks of abandoned operations which could no
+// longer be cancelled, giving up on them after orphanedOpGracePeriod.
+func awaitAbandonedOps(signal chan BulkOp, uncancelled int) {
+	if uncancelled == 0 {
+		return
+	}
+
+	graceTmr := gocbcore.AcquireTimer(orphanedOpGracePeriod)
+	for ; uncancelled > 0; uncancelled-- {
+		select {
+		case <-signal:
+		case <-graceTmr.C:
+			gocbcore.ReleaseTimer(graceTmr, true)
+			if opCompletionAssertions {
+				panic("gocb: bulk operation dropped without its callback being invoked")
 			}
-			return ErrTimeout
+			logWarnf("%d bulk operation callbacks were never invoked, abandoning them", uncancelled)
+			return
 		}
 	}
-	gocbcore.ReleaseTimer(timeoutTmr, false)
-	return nil
+	gocbcore.ReleaseTimer(graceTmr, false)
 }
 
 // GetOp represents a type of `BulkOp` used for Get operations. See BulkOp.
@@ -77,6 +97,14 @@ func (item *GetOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *GetOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *GetOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.Get([]byte(item.Key), func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
 		item.Err = err
@@ -96,6 +124,146 @@ func (item *GetOp) execute(b *Bucket, signal chan BulkOp) {
 	}
 }
 
+// GetLengthOp represents a type of `BulkOp` used for GetLength operations. See BulkOp.
+type GetLengthOp struct {
+	bulkOp
+
+	Key  string
+	Size uint32
+	Cas  Cas
+	Err  error
+}
+
+func (item *GetLengthOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *GetLengthOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetLengthOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *GetLengthOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.SubDocLookup([]byte(item.Key), getLengthSubDocOps, gocbcore.SubdocDocFlagNone,
+		func(results []gocbcore.SubDocResult, cas gocbcore.Cas, err error) {
+			item.Size, item.Err = decodeValueBytes(results, err)
+			if item.Err == nil {
+				item.Cas = Cas(cas)
+			}
+			signal <- item
+		})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// GetIfSmallerOp represents a type of `BulkOp` used for GetIfSmaller operations. See BulkOp.
+// Documents larger than MaxBytes fail with a ValueTooLargeError, and Size holds the size
+// of the document's value whether or not it was retrieved.
+type GetIfSmallerOp struct {
+	bulkOp
+
+	Key      string
+	MaxBytes uint32
+	Value    interface{}
+	Size     uint32
+	Cas      Cas
+	Err      error
+
+	// The lookup of the size dispatches the get from its callback, so the pending
+	// operation may change while the bulk request is being cancelled.
+	lock          sync.Mutex
+	cancelled     bool
+	getDispatched bool
+}
+
+func (item *GetIfSmallerOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *GetIfSmallerOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetIfSmallerOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *GetIfSmallerOp) cancel() bool {
+	item.lock.Lock()
+	defer item.lock.Unlock()
+	item.cancelled = true
+	return item.bulkOp.cancel()
+}
+
+func (item *GetIfSmallerOp) setLookupOp(op gocbcore.PendingOp) {
+	item.lock.Lock()
+	if !item.getDispatched && !item.cancelled {
+		item.bulkOp.pendop = op
+	}
+	item.lock.Unlock()
+}
+
+func (item *GetIfSmallerOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.SubDocLookup([]byte(item.Key), getLengthSubDocOps, gocbcore.SubdocDocFlagNone,
+		func(results []gocbcore.SubDocResult, cas gocbcore.Cas, err error) {
+			item.Size, item.Err = decodeValueBytes(results, err)
+			if item.Err == nil && item.Size > item.MaxBytes {
+				item.Err = ValueTooLargeError{Size: item.Size, MaxBytes: item.MaxBytes}
+			}
+			if item.Err != nil {
+				signal <- item
+				return
+			}
+			item.executeGet(b, signal)
+		})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.setLookupOp(op)
+	}
+}
+
+func (item *GetIfSmallerOp) executeGet(b *Bucket, signal chan BulkOp) {
+	item.lock.Lock()
+	defer item.lock.Unlock()
+	if item.cancelled {
+		// The bulk request was abandoned while the size was being looked up.
+		signal <- item
+		return
+	}
+	item.getDispatched = true
+
+	op, err := b.client.Get([]byte(item.Key), func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+		item.Err = err
+		if item.Err == nil {
+			item.Size = uint32(len(bytes))
+			if item.Size > item.MaxBytes {
+				item.Err = ValueTooLargeError{Size: item.Size, MaxBytes: item.MaxBytes}
+			} else {
+				item.Err = b.transcoder.Decode(bytes, flags, item.Value)
+			}
+			if item.Err == nil {
+				item.Cas = Cas(cas)
+			}
+		}
+		signal <- item
+	})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
 // GetAndTouchOp represents a type of `BulkOp` used for GetAndTouch operations. See BulkOp.
 type GetAndTouchOp struct {
 	bulkOp
@@ -111,8 +279,145 @@ func (item *GetAndTouchOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *GetAndTouchOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetAndTouchOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *GetAndTouchOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.GetAndTouch([]byte(item.Key), item.Expiry,
+		func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+			item.Err = err
+			b.invalidateLocalCache(item.Key)
+			if item.Err == nil {
+				item.Err = b.transcoder.Decode(bytes, flags, item.Value)
+				if item.Err == nil {
+					item.Cas = Cas(cas)
+				}
+			}
+			signal <- item
+		})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// GetAndLockOp represents a type of `BulkOp` used for GetAndLock operations. See BulkOp.
+type GetAndLockOp struct {
+	bulkOp
+
+	Key      string
+	Value    interface{}
+	LockTime uint32
+	Cas      Cas
+	Err      error
+}
+
+func (item *GetAndLockOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *GetAndLockOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetAndLockOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *GetAndLockOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.GetAndLock([]byte(item.Key), item.LockTime,
+		func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+			item.Err = err
+			b.invalidateLocalCache(item.Key)
+			if item.Err == nil {
+				item.Err = b.transcoder.Decode(bytes, flags, item.Value)
+				if item.Err == nil {
+					item.Cas = Cas(cas)
+				}
+			}
+			signal <- item
+		})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// UnlockOp represents a type of `BulkOp` used for Unlock operations. See BulkOp.
+type UnlockOp struct {
+	bulkOp
+
+	Key string
+	Cas Cas
+	Err error
+}
+
+func (item *UnlockOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *UnlockOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *UnlockOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *UnlockOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.Unlock([]byte(item.Key), gocbcore.Cas(item.Cas),
+		func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
+			item.Err = err
+			b.invalidateLocalCache(item.Key)
+			if item.Err == nil {
+				item.Cas = Cas(cas)
+			}
+			signal <- item
+		})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// GetReplicaOp represents a type of `BulkOp` used for GetReplica operations, reading a
+// document from the replica with the specified index, where the first replica is
+// replica 1. See BulkOp.
+type GetReplicaOp struct {
+	bulkOp
+
+	Key        string
+	ReplicaIdx int
+	Value      interface{}
+	Cas        Cas
+	Err        error
+}
+
+func (item *GetReplicaOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *GetReplicaOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *GetReplicaOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *GetReplicaOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.GetReplica([]byte(item.Key), item.ReplicaIdx,
 		func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
 			item.Err = err
 			if item.Err == nil {
@@ -145,10 +450,19 @@ func (item *TouchOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *TouchOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *TouchOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *TouchOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.Touch([]byte(item.Key), gocbcore.Cas(item.Cas), item.Expiry,
 		func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 			item.Err = err
+			b.invalidateLocalCache(item.Key)
 			if item.Err == nil {
 				item.Cas = Cas(cas)
 			}
@@ -175,10 +489,19 @@ func (item *RemoveOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *RemoveOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *RemoveOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *RemoveOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.Remove([]byte(item.Key), gocbcore.Cas(item.Cas),
 		func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 			item.Err = err
+			b.invalidateLocalCache(item.Key)
 			if item.Err == nil {
 				item.Cas = Cas(cas)
 			}
@@ -207,8 +530,16 @@ func (item *UpsertOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *UpsertOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *UpsertOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *UpsertOp) execute(b *Bucket, signal chan BulkOp) {
-	bytes, flags, err := b.transcoder.Encode(item.Value)
+	bytes, flags, err := b.encodeValue(item.Value)
 	if err != nil {
 		item.Err = err
 		signal <- item
@@ -216,6 +547,7 @@ func (item *UpsertOp) execute(b *Bucket, signal chan BulkOp) {
 		op, err := b.client.Set([]byte(item.Key), bytes, flags, item.Expiry,
 			func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 				item.Err = err
+				b.invalidateLocalCache(item.Key)
 				if item.Err == nil {
 					item.Cas = Cas(cas)
 				}
@@ -231,6 +563,7 @@ func (item *UpsertOp) execute(b *Bucket, signal chan BulkOp) {
 }
 
 // InsertOp represents a type of `BulkOp` used for Insert operations. See BulkOp.
+// If Key is empty and the bucket has a KeyGenerator, Key is set to a generated key.
 type InsertOp struct {
 	bulkOp
 
@@ -245,8 +578,16 @@ func (item *InsertOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *InsertOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *InsertOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *InsertOp) execute(b *Bucket, signal chan BulkOp) {
-	bytes, flags, err := b.transcoder.Encode(item.Value)
+	bytes, flags, err := b.encodeValue(item.Value)
 	if err != nil {
 		item.Err = err
 		signal <- item
@@ -254,6 +595,7 @@ func (item *InsertOp) execute(b *Bucket, signal chan BulkOp) {
 		op, err := b.client.Add([]byte(item.Key), bytes, flags, item.Expiry,
 			func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 				item.Err = err
+				b.invalidateLocalCache(item.Key)
 				if item.Err == nil {
 					item.Cas = Cas(cas)
 				}
@@ -283,8 +625,16 @@ func (item *ReplaceOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *ReplaceOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *ReplaceOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *ReplaceOp) execute(b *Bucket, signal chan BulkOp) {
-	bytes, flags, err := b.transcoder.Encode(item.Value)
+	bytes, flags, err := b.encodeValue(item.Value)
 	if err != nil {
 		item.Err = err
 		signal <- item
@@ -292,6 +642,7 @@ func (item *ReplaceOp) execute(b *Bucket, signal chan BulkOp) {
 		op, err := b.client.Replace([]byte(item.Key), bytes, flags, gocbcore.Cas(item.Cas), item.Expiry,
 			func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 				item.Err = err
+				b.invalidateLocalCache(item.Key)
 				if item.Err == nil {
 					item.Cas = Cas(cas)
 				}
@@ -320,10 +671,19 @@ func (item *AppendOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *AppendOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *AppendOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *AppendOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.Append([]byte(item.Key), []byte(item.Value),
 		func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 			item.Err = err
+			b.invalidateLocalCache(item.Key)
 			if item.Err == nil {
 				item.Cas = Cas(cas)
 			}
@@ -351,10 +711,19 @@ func (item *PrependOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *PrependOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *PrependOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *PrependOp) execute(b *Bucket, signal chan BulkOp) {
 	op, err := b.client.Prepend([]byte(item.Key), []byte(item.Value),
 		func(cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 			item.Err = err
+			b.invalidateLocalCache(item.Key)
 			if item.Err == nil {
 				item.Cas = Cas(cas)
 			}
@@ -385,25 +754,39 @@ func (item *CounterOp) markError(err error) {
 	item.Err = err
 }
 
+func (item *CounterOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *CounterOp) bulkErr() error {
+	return item.Err
+}
+
 func (item *CounterOp) execute(b *Bucket, signal chan BulkOp) {
 	realInitial := uint64(0xFFFFFFFFFFFFFFFF)
 	if item.Initial > 0 {
 		realInitial = uint64(item.Initial)
 	}
+	item.dispatch(b, signal, item, realInitial)
+}
 
-	if item.Delta > 0 {
+// dispatch performs the counter operation, signalling self once it completes so that
+// types embedding CounterOp are signalled rather than the embedded op.
+func (item *CounterOp) dispatch(b *Bucket, signal chan BulkOp, self BulkOp, realInitial uint64) {
+	if item.Delta >= 0 {
 		op, err := b.client.Increment([]byte(item.Key), uint64(item.Delta), realInitial, item.Expiry,
 			func(value uint64, cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 				item.Err = err
+				b.invalidateLocalCache(item.Key)
 				if item.Err == nil {
 					item.Value = value
 					item.Cas = Cas(cas)
 				}
-				signal <- item
+				signal <- self
 			})
 		if err != nil {
 			item.Err = err
-			signal <- item
+			signal <- self
 		} else {
 			item.bulkOp.pendop = op
 		}
@@ -411,20 +794,18 @@ func (item *CounterOp) execute(b *Bucket, signal chan BulkOp) {
 		op, err := b.client.Decrement([]byte(item.Key), uint64(-item.Delta), realInitial, item.Expiry,
 			func(value uint64, cas gocbcore.Cas, mutToken gocbcore.MutationToken, err error) {
 				item.Err = err
+				b.invalidateLocalCache(item.Key)
 				if item.Err == nil {
 					item.Value = value
 					item.Cas = Cas(cas)
 				}
-				signal <- item
+				signal <- self
 			})
 		if err != nil {
 			item.Err = err
-			signal <- item
+			signal <- self
 		} else {
 			item.bulkOp.pendop = op
 		}
-	} else {
-		item.Err = clientError{"Delta must be a non-zero value."}
-		signal <- item
 	}
 }
@@ -1,6 +1,22 @@
 package gocb
 
-// ExecuteN1qlQuery performs a n1ql query and returns a list of rows or an error.
+import (
+	"context"
+)
+
+// ExecuteN1qlQuery performs a n1ql query and returns a list of rows or an error.  The
+// params may be a []interface{} of positional parameters, or named parameters given as
+// a map[string]interface{} or as a struct, as NamedParameters accepts.
 func (b *Bucket) ExecuteN1qlQuery(q *N1qlQuery, params interface{}) (QueryResults, error) {
-	return b.cluster.doN1qlQuery(b, q, params)
+	return b.cluster.doN1qlQuery(context.Background(), b, q, params)
+}
+
+// ExecuteN1qlQueryContext performs a n1ql query as ExecuteN1qlQuery does, stopping it
+// when ctx is cancelled.  If ctx has a deadline sooner than the N1QL timeout, the
+// deadline is used as the timeout of the query, including on the server.  Cancelling
+// ctx while the results are being read causes them to fail with its error.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteN1qlQueryContext(ctx context.Context, q *N1qlQuery, params interface{}) (QueryResults, error) {
+	return b.cluster.doN1qlQuery(ctx, b, q, params)
 }
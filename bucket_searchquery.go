@@ -1,6 +1,19 @@
 package gocb
 
+import (
+	"context"
+)
+
 // ExecuteSearchQuery performs a view query and returns a list of rows or an error.
 func (b *Bucket) ExecuteSearchQuery(q *SearchQuery) (SearchResults, error) {
-	return b.cluster.doSearchQuery(b, q)
+	return b.cluster.doSearchQuery(context.Background(), b, q)
+}
+
+// ExecuteSearchQueryContext performs a search query as ExecuteSearchQuery does,
+// stopping it when ctx is cancelled.  If ctx has a deadline sooner than the FTS
+// timeout, the deadline is used as the timeout of the query, including on the server.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteSearchQueryContext(ctx context.Context, q *SearchQuery) (SearchResults, error) {
+	return b.cluster.doSearchQuery(ctx, b, q)
 }
@@ -0,0 +1,136 @@
+package gocb
+
+import (
+	"time"
+)
+
+// The extended attribute recording when a document was soft-deleted, and the virtual
+// extended attribute holding the flags of a document.
+const (
+	softDeletedXattr = "_gocb.deleted"
+	docFlagsXattr    = "$document.flags"
+)
+
+// SoftDeleteAware returns whether Get treats soft-deleted documents as missing.
+func (b *Bucket) SoftDeleteAware() bool {
+	return b.softDeleteAware
+}
+
+// SetSoftDeleteAware specifies whether Get treats documents soft-deleted using
+// SoftRemove as missing, returning ErrKeyNotFound for them.  Each Get then fetches the
+// soft-deletion marker alongside the document in a single sub-document lookup.  Only
+// Get is affected; soft-deleted documents are still returned by views and N1QL
+// queries, which must filter them out themselves.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetSoftDeleteAware(enabled bool) {
+	b.softDeleteAware = enabled
+}
+
+// SoftRemove marks a document as deleted without removing it, by recording the time
+// of deletion in the _gocb.deleted extended attribute.  The document can later be
+// restored using Restore.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SoftRemove(key string, cas Cas) (Cas, error) {
+	start := time.Now()
+	deletedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	frag, err := b.mutateIn(b.MutateInEx(key, SubdocDocFlagNone, cas, 0).
+		UpsertEx(softDeletedXattr, deletedAt, SubdocFlagXattr|SubdocFlagCreatePath))
+	if err != nil {
+		return 0, b.wrapError(err, "SoftRemove", key, start)
+	}
+	return frag.Cas(), b.wrapError(nil, "SoftRemove", key, start)
+}
+
+// Restore undoes the soft-deletion of a document.  Restoring a document which has not
+// been soft-deleted fails with ErrSubDocPathNotFound.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Restore(key string) (Cas, error) {
+	start := time.Now()
+	frag, err := b.mutateIn(b.MutateInEx(key, SubdocDocFlagNone, 0, 0).
+		RemoveEx(softDeletedXattr, SubdocFlagXattr))
+	if err != nil {
+		return 0, b.wrapError(err, "Restore", key, start)
+	}
+	return frag.Cas(), b.wrapError(nil, "Restore", key, start)
+}
+
+// GetIncludingDeleted retrieves a document whether or not it has been soft-deleted,
+// additionally returning whether it has been.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) GetIncludingDeleted(key string, valuePtr interface{}) (Cas, bool, error) {
+	start := time.Now()
+	cas, deleted, err := b.getWithSoftDelete(key, valuePtr)
+	return cas, deleted, b.wrapError(err, "GetIncludingDeleted", key, start)
+}
+
+func (b *Bucket) getWithSoftDelete(key string, valuePtr interface{}) (Cas, bool, error) {
+	data, flags, cas, deleted, err := b.lookupWithSoftDelete(nil, key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = b.transcoder.Decode(data, flags, valuePtr)
+	if err != nil {
+		return 0, false, err
+	}
+	return cas, deleted, nil
+}
+
+func (b *Bucket) getSoftDeleteAware(opts *kvOpOptions, key string, valuePtr interface{}) (Cas, error) {
+	data, flags, cas, deleted, err := b.lookupWithSoftDelete(opts, key)
+	if err != nil {
+		return 0, err
+	}
+	if deleted {
+		return 0, ErrKeyNotFound
+	}
+
+	err = b.transcoder.Decode(data, flags, valuePtr)
+	if err != nil {
+		return 0, err
+	}
+	return cas, nil
+}
+
+// lookupWithSoftDelete fetches a document along with its soft-deletion marker and
+// flags in a single lookup, so that the document can be decoded exactly as a plain Get
+// would.
+func (b *Bucket) lookupWithSoftDelete(opts *kvOpOptions, key string) ([]byte, uint32, Cas, bool, error) {
+	frag, err := b.lookupIn(opts, b.LookupIn(key).
+		GetEx(softDeletedXattr, SubdocFlagXattr).
+		GetEx(docFlagsXattr, SubdocFlagXattr).
+		GetEx("", SubdocFlagNone))
+	return parseSoftDeleteLookup(frag, err)
+}
+
+func parseSoftDeleteLookup(frag *DocumentFragment, err error) ([]byte, uint32, Cas, bool, error) {
+	// A missing soft-deletion marker fails its path, which fails the lookup as a
+	// whole with ErrSubDocBadMulti.
+	err = unwrapOperationError(err)
+	if err != nil && err != ErrSubDocBadMulti {
+		return nil, 0, 0, false, err
+	}
+	if frag == nil || len(frag.contents) != 3 {
+		return nil, 0, 0, false, ErrCliInternalError
+	}
+
+	deletedErr := frag.contents[0].err
+	if deletedErr != nil && deletedErr != ErrSubDocPathNotFound {
+		return nil, 0, 0, false, deletedErr
+	}
+
+	var flags uint32
+	err = frag.ContentByIndex(1, &flags)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	if err := frag.contents[2].err; err != nil {
+		return nil, 0, 0, false, err
+	}
+
+	return frag.contents[2].data, flags, frag.Cas(), deletedErr == nil, nil
+}
@@ -0,0 +1,47 @@
+package gocb
+
+import (
+	"testing"
+)
+
+func softDeleteFragment(deleted bool, flags string, doc string) *DocumentFragment {
+	frag := &DocumentFragment{
+		cas: 5,
+		contents: []subDocResult{
+			{path: softDeletedXattr, data: []byte(`"2018-01-01T00:00:00Z"`)},
+			{path: docFlagsXattr, data: []byte(flags)},
+			{path: "", data: []byte(doc)},
+		},
+	}
+	if !deleted {
+		frag.contents[0] = subDocResult{path: softDeletedXattr, err: ErrSubDocPathNotFound}
+	}
+	return frag
+}
+
+func TestParseSoftDeleteLookup(t *testing.T) {
+	data, flags, cas, deleted, err := parseSoftDeleteLookup(softDeleteFragment(false, "33554432", `{"a":1}`), ErrSubDocBadMulti)
+	if err != nil {
+		t.Fatalf("Failed to parse lookup: %v", err)
+	}
+	if deleted || cas != 5 || flags != cfFmtJson || string(data) != `{"a":1}` {
+		t.Fatalf("Unexpected live document: %s %d %d %t", data, flags, cas, deleted)
+	}
+
+	_, _, _, deleted, err = parseSoftDeleteLookup(softDeleteFragment(true, "33554432", `{"a":1}`), nil)
+	if err != nil || !deleted {
+		t.Fatalf("Expected document to be soft-deleted, got %t (%v)", deleted, err)
+	}
+
+	_, _, _, _, err = parseSoftDeleteLookup(nil, ErrKeyNotFound)
+	if err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	frag := softDeleteFragment(false, "33554432", "")
+	frag.contents[2].err = ErrSubDocNotJson
+	_, _, _, _, err = parseSoftDeleteLookup(frag, ErrSubDocBadMulti)
+	if err != ErrSubDocNotJson {
+		t.Fatalf("Expected document error to be returned, got %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package gocb
 
 import (
 	"encoding/json"
+	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
 	"log"
+	"time"
 )
 
 type subDocResult struct {
@@ -33,6 +35,10 @@ func (frag *DocumentFragment) MutationToken() MutationToken {
 // ContentByIndex retrieves the value of the operation by its index. The index is the position of
 // the operation as it was added to the builder.
 func (frag *DocumentFragment) ContentByIndex(idx int, valuePtr interface{}) error {
+	if idx < 0 || idx >= len(frag.contents) {
+		return detailedError{ErrSubDocPathNotRequested,
+			fmt.Sprintf("No sub-document operation has index %d, as there were %d.", idx, len(frag.contents))}
+	}
 	res := frag.contents[idx]
 	if res.err != nil {
 		return res.err
@@ -50,7 +56,7 @@ func (frag *DocumentFragment) ContentByIndex(idx int, valuePtr interface{}) erro
 }
 
 // Content retrieves the value of the operation by its path. The path is the path provided
-// to the operation
+// to the operation.
 func (frag *DocumentFragment) Content(path string, valuePtr interface{}) error {
 	if frag.pathMap == nil {
 		frag.pathMap = make(map[string]int)
@@ -58,7 +64,12 @@ func (frag *DocumentFragment) Content(path string, valuePtr interface{}) error {
 			frag.pathMap[v.path] = i
 		}
 	}
-	return frag.ContentByIndex(frag.pathMap[path], valuePtr)
+	idx, ok := frag.pathMap[path]
+	if !ok {
+		return detailedError{ErrSubDocPathNotRequested,
+			fmt.Sprintf("No sub-document operation was provided the path %s.", path)}
+	}
+	return frag.ContentByIndex(idx, valuePtr)
 }
 
 // Exists checks whether the indicated path exists in this DocumentFragment and no
@@ -78,7 +89,9 @@ type LookupInBuilder struct {
 
 // Execute executes this set of lookup operations on the bucket.
 func (set *LookupInBuilder) Execute() (*DocumentFragment, error) {
-	return set.bucket.lookupIn(set)
+	start := time.Now()
+	frag, err := set.bucket.lookupIn(nil, set)
+	return frag, set.bucket.wrapError(err, "LookupIn", set.name, start)
 }
 
 // GetEx allows you to perform a sub-document Get operation with flags
@@ -144,13 +157,22 @@ func (set *LookupInBuilder) GetCount(path string) *LookupInBuilder {
 	return set.GetCountEx(path, SubdocFlagNone)
 }
 
-func (b *Bucket) lookupIn(set *LookupInBuilder) (resOut *DocumentFragment, errOut error) {
-	signal := make(chan bool, 1)
+func (b *Bucket) lookupIn(opts *kvOpOptions, set *LookupInBuilder) (resOut *DocumentFragment, errOut error) {
+	errOut = b.retryIdempotent(opts, set.name, func(opts *kvOpOptions) error {
+		var err error
+		resOut, err = b.lookupInOnce(opts, set)
+		return err
+	})
+	return
+}
+
+func (b *Bucket) lookupInOnce(opts *kvOpOptions, set *LookupInBuilder) (resOut *DocumentFragment, errOut error) {
+	completion := b.ops.begin()
 	op, err := b.client.SubDocLookup([]byte(set.name), set.ops, set.flags,
 		func(results []gocbcore.SubDocResult, cas gocbcore.Cas, err error) {
-			errOut = err
+			completion.complete(func() {
+				errOut = err
 
-			{
 				resSet := &DocumentFragment{}
 				resSet.contents = make([]subDocResult, len(results))
 				resSet.cas = Cas(cas)
@@ -164,27 +186,17 @@ func (b *Bucket) lookupIn(set *LookupInBuilder) (resOut *DocumentFragment, errOu
 				}
 
 				resOut = resSet
-			}
-
-			signal <- true
+			})
 		})
 	if err != nil {
+		completion.discard()
 		return nil, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return nil, ErrTimeout
+	if err := completion.wait(op, b.kvTimeout(opts)); err != nil {
+		return nil, err
 	}
+	return
 }
 
 // LookupInEx creates a sub-document lookup operation builder.
@@ -214,7 +226,13 @@ type MutateInBuilder struct {
 
 // Execute executes this set of mutation operations on the bucket.
 func (set *MutateInBuilder) Execute() (*DocumentFragment, error) {
-	return set.bucket.mutateIn(set)
+	start := time.Now()
+	frag, err := set.bucket.mutateIn(set)
+	err = set.bucket.wrapError(err, "MutateIn", set.name, start)
+	if opErr, ok := err.(*OperationError); ok {
+		opErr.cas = Cas(set.cas)
+	}
+	return frag, err
 }
 
 func (set *MutateInBuilder) marshalValue(value interface{}) []byte {
@@ -531,47 +549,41 @@ func (b *Bucket) mutateIn(set *MutateInBuilder) (resOut *DocumentFragment, errOu
 	if errOut != nil {
 		return
 	}
+	defer b.invalidateLocalCache(set.name)
 
-	signal := make(chan bool, 1)
+	completion := b.ops.begin()
 	op, err := b.client.SubDocMutate([]byte(set.name), set.ops, set.flags, set.cas, set.expiry,
 		func(results []gocbcore.SubDocResult, cas gocbcore.Cas, mt gocbcore.MutationToken, err error) {
-			errOut = err
-			if errOut == nil {
-				resSet := &DocumentFragment{
-					cas: Cas(cas),
-					mt:  MutationToken{mt, b},
-				}
-				resSet.contents = make([]subDocResult, len(results))
-
-				for i := range results {
-					resSet.contents[i].path = set.ops[i].Path
-					resSet.contents[i].err = results[i].Err
-					if results[i].Value != nil {
-						resSet.contents[i].data = append([]byte(nil), results[i].Value...)
+			completion.complete(func() {
+				errOut = b.ambiguousNetworkError(err)
+				if errOut == nil {
+					resSet := &DocumentFragment{
+						cas: Cas(cas),
+						mt:  MutationToken{mt, b},
+					}
+					resSet.contents = make([]subDocResult, len(results))
+
+					for i := range results {
+						resSet.contents[i].path = set.ops[i].Path
+						resSet.contents[i].err = results[i].Err
+						if results[i].Value != nil {
+							resSet.contents[i].data = append([]byte(nil), results[i].Value...)
+						}
 					}
-				}
 
-				resOut = resSet
-			}
-			signal <- true
+					resOut = resSet
+				}
+			})
 		})
 	if err != nil {
+		completion.discard()
 		return nil, err
 	}
 
-	timeoutTmr := gocbcore.AcquireTimer(b.opTimeout)
-	select {
-	case <-signal:
-		gocbcore.ReleaseTimer(timeoutTmr, false)
-		return
-	case <-timeoutTmr.C:
-		gocbcore.ReleaseTimer(timeoutTmr, true)
-		if !op.Cancel() {
-			<-signal
-			return
-		}
-		return nil, ErrTimeout
+	if err := completion.wait(op, b.opTimeout); err != nil {
+		return nil, err
 	}
+	return
 }
 
 // MutateInEx creates a sub-document mutation operation builder.
@@ -55,3 +55,26 @@ func TestSubDocXattrs(t *testing.T) {
 		t.Fatalf("document x attribute had wrong value")
 	}
 }
+
+func TestDocumentFragmentUnrequestedPath(t *testing.T) {
+	frag := &DocumentFragment{
+		contents: []subDocResult{
+			{path: "name", data: []byte(`"frank"`)},
+			{path: "missing", err: ErrSubDocPathNotFound},
+		},
+	}
+
+	var name string
+	if err := frag.Content("name", &name); err != nil || name != "frank" {
+		t.Fatalf("Expected the content of a requested path, got %q (%v)", name, err)
+	}
+	if err := frag.Content("age", &name); ErrorCause(err) != ErrSubDocPathNotRequested {
+		t.Fatalf("Expected the content of an unrequested path to fail, got %v", err)
+	}
+	if err := frag.ContentByIndex(2, &name); ErrorCause(err) != ErrSubDocPathNotRequested {
+		t.Fatalf("Expected an index beyond the operations to fail, got %v", err)
+	}
+	if frag.Exists("age") || frag.Exists("missing") || !frag.Exists("name") {
+		t.Fatal("Expected Exists to only report requested paths which were found")
+	}
+}
@@ -1,11 +1,17 @@
 package gocb
 
+import (
+	"time"
+)
+
 // RemoveMt performs a Remove operation and includes MutationToken in the results.
 func (b *Bucket) RemoveMt(key string, cas Cas) (Cas, MutationToken, error) {
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.remove(key, cas)
+	start := time.Now()
+	cas, mt, err := b.remove(nil, key, cas)
+	return cas, mt, b.wrapError(err, "RemoveMt", key, start)
 }
 
 // UpsertMt performs a Upsert operation and includes MutationToken in the results.
@@ -13,7 +19,9 @@ func (b *Bucket) UpsertMt(key string, value interface{}, expiry uint32) (Cas, Mu
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.upsert(key, value, expiry)
+	start := time.Now()
+	cas, mt, err := b.upsert(nil, key, value, expiry)
+	return cas, mt, b.wrapError(err, "UpsertMt", key, start)
 }
 
 // InsertMt performs a Insert operation and includes MutationToken in the results.
@@ -21,7 +29,9 @@ func (b *Bucket) InsertMt(key string, value interface{}, expiry uint32) (Cas, Mu
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.insert(key, value, expiry)
+	start := time.Now()
+	cas, mt, err := b.insert(nil, key, value, expiry)
+	return cas, mt, b.wrapError(err, "InsertMt", key, start)
 }
 
 // ReplaceMt performs a Replace operation and includes MutationToken in the results.
@@ -29,7 +39,9 @@ func (b *Bucket) ReplaceMt(key string, value interface{}, cas Cas, expiry uint32
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.replace(key, value, cas, expiry)
+	start := time.Now()
+	cas, mt, err := b.replace(nil, key, value, cas, expiry)
+	return cas, mt, b.wrapError(err, "ReplaceMt", key, start)
 }
 
 // AppendMt performs a Append operation and includes MutationToken in the results.
@@ -37,7 +49,9 @@ func (b *Bucket) AppendMt(key, value string) (Cas, MutationToken, error) {
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.append(key, value)
+	start := time.Now()
+	cas, mt, err := b.append(key, value)
+	return cas, mt, b.wrapError(err, "AppendMt", key, start)
 }
 
 // PrependMt performs a Prepend operation and includes MutationToken in the results.
@@ -45,7 +59,9 @@ func (b *Bucket) PrependMt(key, value string) (Cas, MutationToken, error) {
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.prepend(key, value)
+	start := time.Now()
+	cas, mt, err := b.prepend(key, value)
+	return cas, mt, b.wrapError(err, "PrependMt", key, start)
 }
 
 // CounterMt performs a Counter operation and includes MutationToken in the results.
@@ -53,5 +69,7 @@ func (b *Bucket) CounterMt(key string, delta, initial int64, expiry uint32) (uin
 	if !b.mtEnabled {
 		panic("You must use OpenBucketMt with Mt operation variants.")
 	}
-	return b.counter(key, delta, initial, expiry)
+	start := time.Now()
+	val, cas, mt, err := b.counter(nil, key, delta, initial, expiry)
+	return val, cas, mt, b.wrapError(err, "CounterMt", key, start)
 }
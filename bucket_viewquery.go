@@ -1,10 +1,11 @@
 package gocb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/url"
+	"time"
 )
 
 type viewError struct {
@@ -13,11 +14,49 @@ type viewError struct {
 }
 
 type viewResponse struct {
-	TotalRows int               `json:"total_rows,omitempty"`
-	Rows      []json.RawMessage `json:"rows,omitempty"`
-	Error     string            `json:"error,omitempty"`
-	Reason    string            `json:"reason,omitempty"`
-	Errors    []viewError       `json:"errors,omitempty"`
+	TotalRows int                `json:"total_rows,omitempty"`
+	Rows      []json.RawMessage  `json:"rows,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Reason    string             `json:"reason,omitempty"`
+	Errors    []ViewPartialError `json:"errors,omitempty"`
+}
+
+type viewIdRow struct {
+	Id string `json:"id"`
+}
+
+// viewRowMode specifies how much of each row of a view response is retained.
+type viewRowMode int
+
+const (
+	viewRowsAll = viewRowMode(iota)
+	viewRowsIdsOnly
+	viewRowsCountOnly
+)
+
+// The row which replaces each row of a response whose rows are only counted.
+var countedViewRow = json.RawMessage("{}")
+
+// viewRowFilter returns the filter which discards the parts of each row of a view
+// response which are not needed in mode, so that they are not retained as the rows are
+// streamed.  In viewRowsIdsOnly mode only the id of each row is kept, and in
+// viewRowsCountOnly mode nothing is.
+func viewRowFilter(mode viewRowMode) func(row json.RawMessage) (json.RawMessage, error) {
+	switch mode {
+	case viewRowsIdsOnly:
+		return func(row json.RawMessage) (json.RawMessage, error) {
+			var idRow viewIdRow
+			if err := json.Unmarshal(row, &idRow); err != nil {
+				return nil, err
+			}
+			return json.Marshal(idRow)
+		}
+	case viewRowsCountOnly:
+		return func(row json.RawMessage) (json.RawMessage, error) {
+			return countedViewRow, nil
+		}
+	}
+	return nil
 }
 
 func (e *viewError) Error() string {
@@ -25,6 +64,8 @@ func (e *viewError) Error() string {
 }
 
 // ViewResults implements an iterator interface which can be used to iterate over the rows of the query results.
+// Rows are read from the response as they are iterated over rather than being held in memory, so the
+// response remains open until Close is called.
 type ViewResults interface {
 	One(valuePtr interface{}) error
 	Next(valuePtr interface{}) bool
@@ -38,12 +79,65 @@ type ViewResultMetrics interface {
 	TotalRows() int
 }
 
+// ViewPartialError describes a failure of a node to contribute its rows to the results
+// of a view query, which were otherwise returned by the other nodes.
+//
+// Experimental: This API is subject to change at any time.
+type ViewPartialError struct {
+	From    string `json:"from"`
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func (e ViewPartialError) Error() string {
+	return e.From + " - " + e.Reason
+}
+
+// ViewResultErrors allows access to the partial failures reported in a view response,
+// which are only known once every row has been read.  This is implemented as an
+// additional interface to maintain ABI compatibility for the 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type ViewResultErrors interface {
+	Errors() []ViewPartialError
+}
+
+// ViewRowResults allows the id, key and value of each row of view query results to be
+// decoded separately.  This is implemented as an additional interface to maintain ABI
+// compatibility for the 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type ViewRowResults interface {
+	NextRow(idPtr, keyPtr, valuePtr interface{}) bool
+}
+
+type viewRowFields struct {
+	Id    json.RawMessage `json:"id"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
 type viewResults struct {
-	index     int
-	rows      []json.RawMessage
-	totalRows int
-	err       error
-	endErr    error
+	index      int
+	rows       []json.RawMessage
+	totalRows  int
+	err        error
+	endErr     error
+	partial    []ViewPartialError
+	cached     bool
+	onClose    func()
+	projection *rowProjection
+	stream     *resultStream
+	complete   func(stream *resultStream) error
+	// retainRows indicates rows read from the stream are kept once they have been
+	// iterated over, so that the results can be stored in the QueryCache.
+	retainRows bool
+	// aborted indicates One aborted the response before the total number of rows
+	// was received.
+	aborted bool
+	// stopOnError indicates that no further rows are returned once a partial failure
+	// has been reported, as requested by ViewErrorStop.
+	stopOnError bool
 }
 
 func (r *viewResults) Next(valuePtr interface{}) bool {
@@ -64,20 +158,113 @@ func (r *viewResults) Next(valuePtr interface{}) bool {
 	return true
 }
 
-func (r *viewResults) NextBytes() []byte {
+// NextRow decodes the id, key and value of the next row into idPtr, keyPtr and valuePtr
+// respectively, skipping any which are nil.  The rows of reduced views have no id, so
+// idPtr is left unchanged for them.
+func (r *viewResults) NextRow(idPtr, keyPtr, valuePtr interface{}) bool {
 	if r.err != nil {
+		return false
+	}
+
+	row := r.NextBytes()
+	if row == nil {
+		return false
+	}
+
+	var fields viewRowFields
+	if r.err = json.Unmarshal(row, &fields); r.err != nil {
+		return false
+	}
+	decode := func(data json.RawMessage, ptr interface{}) {
+		if r.err == nil && ptr != nil && data != nil {
+			r.err = json.Unmarshal(data, ptr)
+		}
+	}
+	decode(fields.Id, idPtr)
+	decode(fields.Key, keyPtr)
+	decode(fields.Value, valuePtr)
+	return r.err == nil
+}
+
+func (r *viewResults) NextBytes() []byte {
+	if r.err != nil || (r.stopOnError && r.endErr != nil) {
 		return nil
 	}
 
+	if r.index+1 >= len(r.rows) && r.stream != nil {
+		if !r.retainRows {
+			r.rows = r.rows[:0]
+			r.index = -1
+		}
+		row, err := r.stream.nextRow()
+		if err != nil {
+			r.stream.abort()
+			r.stream = nil
+			r.err = err
+			return nil
+		}
+		if row != nil {
+			r.rows = append(r.rows, row)
+		} else {
+			r.completeStream()
+		}
+	}
+
 	if r.index+1 >= len(r.rows) {
 		return nil
 	}
 	r.index++
 
+	if r.projection != nil {
+		var row []byte
+		row, r.err = r.projection.project(r.rows[r.index])
+		return row
+	}
 	return r.rows[r.index]
 }
 
+// finishStream reads the rest of the response, discarding the rows which were not
+// iterated over unless they are to be retained.
+func (r *viewResults) finishStream() error {
+	for {
+		row, err := r.stream.nextRow()
+		if err != nil {
+			r.stream.abort()
+			r.stream = nil
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if r.retainRows {
+			r.rows = append(r.rows, row)
+		}
+	}
+	r.completeStream()
+	return nil
+}
+
+// completeStream decodes the fields which followed the rows of a response which has
+// been read in full.  Any errors which occurred after the rows were sent are returned
+// by Close.
+func (r *viewResults) completeStream() {
+	stream := r.stream
+	r.stream = nil
+	if err := r.complete(stream); err != nil {
+		r.endErr = err
+	}
+}
+
 func (r *viewResults) Close() error {
+	if r.stream != nil {
+		if r.err == nil {
+			r.err = r.finishStream()
+		} else {
+			r.stream.abort()
+			r.stream = nil
+		}
+	}
+
 	if r.err != nil {
 		return r.err
 	}
@@ -86,9 +273,21 @@ func (r *viewResults) Close() error {
 		return r.endErr
 	}
 
+	if r.onClose != nil {
+		r.onClose()
+		r.onClose = nil
+	}
+
 	return nil
 }
 
+// Cached returns whether these results were served from the cluster's QueryCache.
+func (r *viewResults) Cached() bool {
+	return r.cached
+}
+
+// One decodes the first row of the results and closes them.  If the rest of the rows
+// are still being sent, the response is aborted so that they are not transferred.
 func (r *viewResults) One(valuePtr interface{}) error {
 	if !r.Next(valuePtr) {
 		err := r.Close()
@@ -98,6 +297,16 @@ func (r *viewResults) One(valuePtr interface{}) error {
 		return ErrNoResults
 	}
 
+	if r.stream != nil {
+		if _, ok := r.stream.fields["total_rows"]; !ok {
+			r.aborted = true
+		}
+		r.stream.abort()
+		r.stream = nil
+		r.onClose = nil
+		return nil
+	}
+
 	// Ignore any errors occurring after we already have our result
 	err := r.Close()
 	if err != nil {
@@ -108,48 +317,157 @@ func (r *viewResults) One(valuePtr interface{}) error {
 	return nil
 }
 
+// Errors returns the partial failures reported by the nodes which could not contribute
+// to the results.  They are only known once every row has been read, such as after
+// Close.  The failures are also returned by Close.
+func (r *viewResults) Errors() []ViewPartialError {
+	return r.partial
+}
+
+// TotalRows returns the total number of rows in the index.  If One aborted the response
+// before the total was received, TotalRows returns zero and Err returns ErrAborted.
 func (r *viewResults) TotalRows() int {
+	if r.aborted {
+		return 0
+	}
 	return r.totalRows
 }
 
-func (b *Bucket) executeViewQuery(viewType, ddoc, viewName string, options url.Values) (ViewResults, error) {
-	capiEp, err := b.getViewEp()
-	if err != nil {
+// Err returns ErrAborted if One aborted the response before the total number of rows
+// was received.
+func (r *viewResults) Err() error {
+	if r.aborted {
+		return ErrAborted
+	}
+	return nil
+}
+
+func (b *Bucket) executeViewQuery(ctx context.Context, viewType, ddoc, viewName string, options url.Values, mode viewRowMode, timeout time.Duration) (results ViewResults, errOut error) {
+	start := time.Now()
+	var capiEp string
+	tracker := newClusterRetryTracker(b.cluster)
+	defer func() {
+		operation := "ExecuteViewQuery"
+		if viewType == "_spatial" {
+			operation = "ExecuteSpatialQuery"
+		}
+		b.cluster.recordOperation("views", b.name, operation, errOut, time.Since(start))
+		b.cluster.traceQuery(ctx, b, operation, "views", capiEp, start, errOut)
+		if errOut != nil {
+			errOut = b.cluster.wrapOperationError(errOut, &OperationError{
+				Operation:   operation,
+				Bucket:      b.name,
+				Endpoint:    capiEp,
+				Elapsed:     time.Since(start),
+				RetryReport: tracker.retryReport(),
+			})
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	reqUri := fmt.Sprintf("%s/_design/%s/%s/%s?%s", capiEp, ddoc, viewType, viewName, options.Encode())
+	queryCache := b.cluster.resultCache
+	cacheKey, cacheable := "", false
+	if queryCache != nil && mode != viewRowsCountOnly {
+		cacheKey, cacheable = viewQueryCacheKey(b.name, viewType, ddoc, viewName, options, mode)
+	}
+	if cacheable {
+		if cached, ok := b.cluster.lookupQueryCache(queryCache, "view", b.name, cacheKey); ok {
+			return &viewResults{
+				index:     -1,
+				rows:      cached.Rows,
+				totalRows: cached.TotalRows,
+				cached:    true,
+			}, nil
+		}
+	}
 
-	req, err := http.NewRequest("GET", reqUri, nil)
+	capiEp, err := b.getViewEp()
 	if err != nil {
 		return nil, err
 	}
 
-	if b.cluster.auth != nil {
-		userPass := b.cluster.auth.bucketViews(b.name)
-		req.SetBasicAuth(userPass.Username, userPass.Password)
-	} else {
-		req.SetBasicAuth(b.name, b.password)
+	resp, cancel, capiEp, err := b.sendViewQuery(ctx, capiEp, b.getViewEpExcluding, tracker, viewType, ddoc, viewName, options, timeout)
+	if err != nil {
+		return nil, err
 	}
 
-	resp, err := doHttpWithTimeout(b.client.HttpClient(), req, b.viewTimeout)
+	stream := newResultStream(resp.Body, cancel, "rows")
+	stream.rowFilter = viewRowFilter(mode)
+	viewRes, err := readViewStream(stream, resp.StatusCode)
 	if err != nil {
 		return nil, err
 	}
+	viewRes.stopOnError = options.Get("on_error") == string(ViewErrorStop)
+	if cacheable {
+		viewRes.retainRows = true
+		viewRes.onClose = func() {
+			queryCache.Set(cacheKey, &CachedQueryResult{
+				Rows:      viewRes.rows,
+				TotalRows: viewRes.totalRows,
+			})
+		}
+	}
+	return viewRes, nil
+}
 
-	viewResp := viewResponse{}
-	jsonDec := json.NewDecoder(resp.Body)
-	err = jsonDec.Decode(&viewResp)
+// readViewStream reads a view response up to its first row, leaving the remaining rows
+// to be read as the results are iterated.
+func readViewStream(stream *resultStream, statusCode int) (*viewResults, error) {
+	err := stream.readFirstRow()
 	if err != nil {
+		stream.abort()
 		return nil, err
 	}
 
-	err = resp.Body.Close()
+	viewRes := &viewResults{
+		index: -1,
+		rows:  stream.rows,
+	}
+	complete := func(stream *resultStream) error {
+		viewResp := viewResponse{}
+		err := stream.decodeFields(&viewResp)
+		if err != nil {
+			return err
+		}
+
+		endErr, err := checkViewResponse(&viewResp, statusCode)
+		if err != nil {
+			return err
+		}
+		viewRes.totalRows = viewResp.TotalRows
+		viewRes.endErr = endErr
+		viewRes.partial = viewResp.Errors
+		return nil
+	}
+
+	if stream.done {
+		err = complete(stream)
+		if err != nil {
+			return nil, err
+		}
+		return viewRes, nil
+	}
+
+	// The total number of rows precedes the rows, so is already available.
+	prefix := viewResponse{}
+	err = stream.decodeFields(&prefix)
 	if err != nil {
-		logDebugf("Failed to close socket (%s)", err)
+		stream.abort()
+		return nil, err
 	}
+	viewRes.totalRows = prefix.TotalRows
+	viewRes.stream = stream
+	viewRes.complete = complete
+	return viewRes, nil
+}
 
-	if resp.StatusCode != 200 {
+// checkViewResponse returns the error a view response failed with, or the errors which
+// occurred while the rows were produced if it succeeded.
+func checkViewResponse(viewResp *viewResponse, statusCode int) (error, error) {
+	if statusCode != 200 {
 		if viewResp.Error != "" {
 			return nil, &viewError{
 				Message: viewResp.Error,
@@ -159,7 +477,7 @@ func (b *Bucket) executeViewQuery(viewType, ddoc, viewName string, options url.V
 
 		return nil, &viewError{
 			Message: "HTTP Error",
-			Reason:  fmt.Sprintf("Status code was %d.", resp.StatusCode),
+			Reason:  fmt.Sprintf("Status code was %d.", statusCode),
 		}
 	}
 
@@ -170,31 +488,100 @@ func (b *Bucket) executeViewQuery(viewType, ddoc, viewName string, options url.V
 			Reason:  endErr.Reason,
 		})
 	}
-
-	return &viewResults{
-		index:     -1,
-		rows:      viewResp.Rows,
-		totalRows: viewResp.TotalRows,
-		endErr:    endErrs.get(),
-	}, nil
+	return endErrs.get(), nil
 }
 
 // ExecuteViewQuery performs a view query and returns a list of rows or an error.
 func (b *Bucket) ExecuteViewQuery(q *ViewQuery) (ViewResults, error) {
+	return b.ExecuteViewQueryContext(context.Background(), q)
+}
+
+// ExecuteViewQueryContext performs a view query as ExecuteViewQuery does, stopping it
+// when ctx is cancelled.  If ctx has a deadline sooner than the view timeout of the
+// bucket, the deadline is used as the timeout instead.  Cancelling ctx while the rows
+// are being read causes them to fail with its error.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteViewQueryContext(ctx context.Context, q *ViewQuery) (ViewResults, error) {
 	ddoc, name, opts, err := q.getInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.executeViewQuery("_view", ddoc, name, opts)
+	mode := viewRowsAll
+	if q.idsOnly {
+		mode = viewRowsIdsOnly
+	}
+	results, err := b.executeViewQuery(ctx, "_view", ddoc, name, opts, mode, b.viewQueryTimeout(q.timeout))
+	if err != nil {
+		return nil, err
+	}
+	results.(*viewResults).projection = q.projection
+	return results, nil
 }
 
 // ExecuteSpatialQuery performs a spatial query and returns a list of rows or an error.
+// The results implement SpatialViewResults, which decodes the geometry of each row.
 func (b *Bucket) ExecuteSpatialQuery(q *SpatialQuery) (ViewResults, error) {
 	ddoc, name, opts, err := q.getInfo()
 	if err != nil {
 		return nil, err
 	}
 
-	return b.executeViewQuery("_spatial", ddoc, name, opts)
+	return b.executeViewQuery(context.Background(), "_spatial", ddoc, name, opts, viewRowsAll, b.viewTimeout)
+}
+
+// viewRangeOptions are the view query options which restrict the rows returned to a
+// part of the index.
+var viewRangeOptions = []string{"key", "keys", "startkey", "endkey", "startkey_docid", "endkey_docid", "skip", "limit"}
+
+// CountViewRows returns the number of rows a view query would return, without the rows
+// themselves being retained.  Queries over the whole index are answered from the
+// index's total_rows using a limit of zero, while queries restricted to a key range
+// are counted as the rows are streamed.  Reduced queries return ErrCountNotSupported,
+// since their rows are the results of the reduction rather than index entries.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) CountViewRows(q *ViewQuery) (int, error) {
+	ddoc, name, opts, err := q.getInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	if opts.Get("reduce") == "true" || opts.Get("group") == "true" || opts.Get("group_level") != "" {
+		return 0, detailedError{ErrCountNotSupported,
+			"Row counts cannot be estimated for reduced view queries, whose rows are the results of the reduction rather than index entries."}
+	}
+
+	countOpts := url.Values{}
+	for k, v := range opts {
+		countOpts[k] = v
+	}
+	countOpts.Set("reduce", "false")
+
+	ranged := false
+	for _, opt := range viewRangeOptions {
+		if _, ok := countOpts[opt]; ok {
+			ranged = true
+		}
+	}
+
+	if !ranged {
+		countOpts.Set("limit", "0")
+	}
+
+	results, err := b.executeViewQuery(context.Background(), "_view", ddoc, name, countOpts, viewRowsCountOnly, b.viewTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	viewRes := results.(*viewResults)
+	count := 0
+	for viewRes.NextBytes() != nil {
+		count++
+	}
+	if !ranged {
+		count = viewRes.totalRows
+	}
+	return count, viewRes.Close()
 }
@@ -0,0 +1,329 @@
+package gocb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func makeViewResponseBody(numRows, valueSize int) []byte {
+	value := strings.Repeat("x", valueSize)
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"total_rows":%d,"rows":[`, numRows)
+	for i := 0; i < numRows; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"id":"doc-%d","key":"key-%d","value":{"payload":"%s"}}`, i, i, value)
+	}
+	body.WriteString("]}")
+	return body.Bytes()
+}
+
+func retainedHeapBytes(fn func() interface{}) (uint64, interface{}) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	retained := fn()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0, retained
+	}
+	return after.HeapAlloc - before.HeapAlloc, retained
+}
+
+func readFilteredViewStream(t *testing.T, body []byte, mode viewRowMode) *viewResults {
+	stream := newResultStream(ioutil.NopCloser(bytes.NewReader(body)), nil, "rows")
+	stream.rowFilter = viewRowFilter(mode)
+	results, err := readViewStream(stream, 200)
+	if err != nil {
+		t.Fatalf("Failed to read response %v", err)
+	}
+	return results
+}
+
+func TestViewIdsOnlyRows(t *testing.T) {
+	results := readFilteredViewStream(t, makeViewResponseBody(3, 10), viewRowsIdsOnly)
+
+	i := 0
+	for row := results.NextBytes(); row != nil; row = results.NextBytes() {
+		var fields map[string]interface{}
+		err := json.Unmarshal(row, &fields)
+		if err != nil {
+			t.Fatalf("Failed to decode row %v", err)
+		}
+		if len(fields) != 1 || fields["id"] != fmt.Sprintf("doc-%d", i) {
+			t.Fatalf("Expected only the id to be retained, got %s", row)
+		}
+		i++
+	}
+	if err := results.Close(); err != nil || i != 3 || results.TotalRows() != 3 {
+		t.Fatalf("Unexpected results %d %d (%v)", i, results.TotalRows(), err)
+	}
+}
+
+func TestViewIdsOnlyMemory(t *testing.T) {
+	body := makeViewResponseBody(5000, 2048)
+
+	// The rows are streamed, so the memory retained while iterating over them is far
+	// less than the size of the response.
+	idsBytes, idsResults := retainedHeapBytes(func() interface{} {
+		results := readFilteredViewStream(t, body, viewRowsIdsOnly)
+		count := 0
+		for results.NextBytes() != nil {
+			count++
+		}
+		if count != 5000 {
+			t.Fatalf("Expected every row, got %d", count)
+		}
+		return results
+	})
+
+	if idsBytes*10 > uint64(len(body)) {
+		t.Fatalf("Expected ids-only rows to retain far less memory than the response, got %d bytes versus %d bytes", idsBytes, len(body))
+	}
+
+	runtime.KeepAlive(idsResults)
+}
+
+func TestViewCountOnlyRows(t *testing.T) {
+	results := readFilteredViewStream(t, makeViewResponseBody(5, 10), viewRowsCountOnly)
+	count := 0
+	for row := results.NextBytes(); row != nil; row = results.NextBytes() {
+		if string(row) != "{}" {
+			t.Fatalf("Expected counted rows not to be retained, got %s", row)
+		}
+		count++
+	}
+	if err := results.Close(); err != nil || count != 5 || results.TotalRows() != 5 {
+		t.Fatalf("Unexpected counted response %d %d (%v)", count, results.TotalRows(), err)
+	}
+
+	errBody := `{"rows":[],"errors":[{"from":"local","message":"failed","reason":"timeout"}]}`
+	results = readFilteredViewStream(t, []byte(errBody), viewRowsCountOnly)
+	if results.NextBytes() != nil {
+		t.Fatalf("Expected no rows")
+	}
+	if err := results.Close(); err == nil || len(results.Errors()) != 1 || results.Errors()[0].Reason != "timeout" {
+		t.Fatalf("Unexpected counted error response %v %+v", err, results.Errors())
+	}
+}
+
+func TestCountViewRowsReduced(t *testing.T) {
+	fakeBucket := &Bucket{}
+	_, err := fakeBucket.CountViewRows(NewViewQuery("ddoc", "view").Reduce(true))
+	if ErrorCause(err) != ErrCountNotSupported {
+		t.Fatalf("Expected ErrCountNotSupported for reduced queries, got %v", err)
+	}
+}
+
+func TestViewResultsNextRow(t *testing.T) {
+	results := &viewResults{
+		index: -1,
+		rows: []json.RawMessage{
+			json.RawMessage(`{"id":"doc-1","key":["a",1],"value":{"size":10}}`),
+			json.RawMessage(`{"key":"reduced","value":42}`),
+		},
+	}
+	var rowResults ViewRowResults = results
+
+	var id string
+	var key []interface{}
+	var value struct {
+		Size int `json:"size"`
+	}
+	if !rowResults.NextRow(&id, &key, &value) {
+		t.Fatalf("Failed to read the first row %v", results.err)
+	}
+	if id != "doc-1" || len(key) != 2 || key[0] != "a" || value.Size != 10 {
+		t.Fatalf("Unexpected row %s %v %v", id, key, value)
+	}
+
+	var total int
+	if !rowResults.NextRow(nil, nil, &total) {
+		t.Fatalf("Failed to read the reduced row %v", results.err)
+	}
+	if total != 42 {
+		t.Fatalf("Unexpected reduced value %d", total)
+	}
+	if rowResults.NextRow(nil, nil, nil) {
+		t.Fatalf("Expected no more rows")
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestIsStaleViewResponse(t *testing.T) {
+	newResp := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+
+	tests := []struct {
+		status int
+		body   string
+		stale  bool
+	}{
+		{200, `{"rows":[]}`, false},
+		{301, ``, false},
+		{500, `{"error":"error","reason":"badarg"}`, false},
+		{503, ``, true},
+		{500, `{"error":"error","reason":"The node is being rebalanced"}`, true},
+		{404, `{"error":"not_found","reason":"missing"}`, false},
+		{404, `{"error":"not_found","reason":"Node is in pending state"}`, true},
+	}
+	for _, test := range tests {
+		resp := newResp(test.status, test.body)
+		stale, err := isStaleViewResponse(resp)
+		if err != nil || stale != test.stale {
+			t.Fatalf("Expected status %d and body %s to be stale=%v, got %v (%v)", test.status, test.body, test.stale, stale, err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		if string(data) != test.body {
+			t.Fatalf("Expected the body to remain readable, got %s", data)
+		}
+	}
+}
+
+func TestSendViewQueryRetriesWithinTimeout(t *testing.T) {
+	var attempts int32
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(40 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"not_found","reason":"Node is in pending state"}`)
+	}))
+	defer stale.Close()
+
+	b := &Bucket{cluster: &Cluster{httpCli: http.DefaultClient}, viewTimeout: 100 * time.Millisecond, viewRetries: 10}
+	nextEp := func(tried map[string]bool) (string, error) {
+		return stale.URL, nil
+	}
+
+	start := time.Now()
+	tracker := newRetryTracker(RetryBudget{})
+	resp, cancel, _, err := b.sendViewQuery(context.Background(), stale.URL, nextEp, tracker, "_view", "ddoc", "view", url.Values{}, b.viewTimeout)
+	if err == nil {
+		defer cancel()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected the last stale response, got status %d", resp.StatusCode)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("Expected the retries to be bounded by the view timeout, took %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&attempts); n < 2 || n > 3 {
+		t.Fatalf("Expected the query to be retried within its timeout, got %d attempts", n)
+	}
+	if report := tracker.retryReport(); report == nil || report.TotalBackoff <= 0 {
+		t.Fatalf("Expected the retries to back off, got %+v", report)
+	}
+
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":"error","reason":"reduce_overflow_error"}`)
+	}))
+	defer failed.Close()
+
+	atomic.StoreInt32(&attempts, 0)
+	resp, cancel, _, err = b.sendViewQuery(context.Background(), failed.URL, nextEp, newRetryTracker(RetryBudget{}), "_view", "ddoc", "view", url.Values{}, b.viewTimeout)
+	if err != nil {
+		t.Fatalf("Expected the failed response to be returned, got %v", err)
+	}
+	cancel()
+	if resp.StatusCode != http.StatusInternalServerError || atomic.LoadInt32(&attempts) != 1 {
+		t.Fatalf("Expected a view error not to be retried, got %d attempts", atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestViewResultsPartialErrors(t *testing.T) {
+	body := `{"total_rows":2,"rows":[{"id":"a","key":1,"value":null}],"errors":[{"from":"10.0.0.2:8092","reason":"timeout"}]}`
+	results, err := readViewStream(newResultStream(ioutil.NopCloser(strings.NewReader(body)), func() {}, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read response %v", err)
+	}
+	var row interface{}
+	count := 0
+	for results.Next(&row) {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("Expected the rows of the other nodes, got %d", count)
+	}
+	if results.Close() == nil {
+		t.Fatalf("Expected Close to return the partial failures")
+	}
+	var errs ViewResultErrors = results
+	if len(errs.Errors()) != 1 || errs.Errors()[0].From != "10.0.0.2:8092" {
+		t.Fatalf("Unexpected partial errors %+v", errs.Errors())
+	}
+
+	stopped := &viewResults{
+		index:       -1,
+		rows:        []json.RawMessage{json.RawMessage(`{"id":"a"}`)},
+		endErr:      &viewError{Reason: "timeout"},
+		partial:     []ViewPartialError{{From: "local", Reason: "timeout"}},
+		stopOnError: true,
+	}
+	if stopped.Next(&row) {
+		t.Fatalf("Expected no rows once a partial failure has been reported")
+	}
+
+	if NewViewQuery("ddoc", "view").OnError(ViewErrorStop).options.Get("on_error") != "stop" {
+		t.Fatalf("Expected the on_error option to be set")
+	}
+}
+
+func TestOperationTimeoutOverrides(t *testing.T) {
+	b := &Bucket{opTimeout: 2500 * time.Millisecond, viewTimeout: 75 * time.Second}
+
+	if b.kvTimeout(nil) != 2500*time.Millisecond || b.kvTimeout(newKvOpOptions(PriorityNormal, nil, nil, 0)) != 2500*time.Millisecond || b.viewQueryTimeout(0) != 75*time.Second {
+		t.Fatalf("Expected no override without a timeout")
+	}
+	if timeout := b.kvTimeout(newKvOpOptions(PriorityNormal, nil, nil, 10*time.Second)); timeout != 10*time.Second || b.opTimeout != 2500*time.Millisecond {
+		t.Fatalf("Expected the operation timeout to be overridden for the operation only, got %s", timeout)
+	}
+	q := NewViewQuery("ddoc", "view").Timeout(5 * time.Minute)
+	if timeout := b.viewQueryTimeout(q.timeout); timeout != 5*time.Minute || b.viewTimeout != 75*time.Second {
+		t.Fatalf("Expected the view timeout to be overridden for the query only, got %s", timeout)
+	}
+
+	aq := NewAnalyticsQuery("SELECT 1").Timeout(time.Minute)
+	if aq.timeout != time.Minute || aq.options["timeout"] != "1m0s" {
+		t.Fatalf("Unexpected analytics timeout %v %v", aq.timeout, aq.options["timeout"])
+	}
+}
+
+func TestKvOperationTimeoutOverride(t *testing.T) {
+	conn := newFakeConn()
+	b := &Bucket{ops: newOpTracker(), opTimeout: 10 * time.Second}
+
+	start := time.Now()
+	_, _, err := b.hlpCasExec(newKvOpOptions(PriorityNormal, nil, nil, 20*time.Millisecond), "", func(cb ioCasCallback) (pendingOp, error) {
+		return conn.dispatch(cb, 10*time.Second), nil
+	})
+	if err != ErrTimeout || time.Since(start) > 5*time.Second {
+		t.Fatalf("Expected the operation to time out after its own timeout, got %v after %s", err, time.Since(start))
+	}
+
+	b.opTimeout = 20 * time.Millisecond
+	_, _, err = b.hlpCasExec(newKvOpOptions(PriorityNormal, nil, nil, 10*time.Second), "", func(cb ioCasCallback) (pendingOp, error) {
+		return conn.dispatch(cb, 100*time.Millisecond), nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the operation to outlast the bucket timeout, got %v", err)
+	}
+}
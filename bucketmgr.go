@@ -2,7 +2,9 @@ package gocb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -66,7 +68,7 @@ func (bm *BucketManager) capiRequest(method, uri, contentType string, body io.Re
 	}
 
 	req.SetBasicAuth(bm.username, bm.password)
-	return bm.bucket.client.HttpClient().Do(req)
+	return doHttpWithTimeout(bm.bucket.httpClient(), req, 0)
 }
 
 func (bm *BucketManager) mgmtRequest(method, uri, contentType string, body io.Reader) (*http.Response, error) {
@@ -91,33 +93,45 @@ func (bm *BucketManager) mgmtRequest(method, uri, contentType string, body io.Re
 		req.SetBasicAuth(bm.username, bm.password)
 	}
 
-	return bm.bucket.client.HttpClient().Do(req)
+	return doHttpWithTimeout(bm.bucket.httpClient(), req, 0)
 }
 
 // Flush will delete all the of the data from a bucket.
-// Keep in mind that you must have flushing enabled in the buckets configuration.
+// Keep in mind that you must have flushing enabled in the buckets configuration, otherwise
+// ErrFlushDisabled is returned.
 func (bm *BucketManager) Flush() error {
 	reqUri := fmt.Sprintf("/pools/default/buckets/%s/controller/doFlush", bm.bucket.name)
 	resp, err := bm.mgmtRequest("POST", reqUri, "", nil)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
-		return clientError{string(data)}
+		return flushError(resp.StatusCode, data)
 	}
 	return nil
 }
 
-// GetDesignDocument retrieves a single design document for the given bucket..
+// flushError returns the error for a failed flush request, recognising the response
+// the server sends when flush is not enabled on the bucket.
+func flushError(status int, data []byte) error {
+	if status == 400 && strings.Contains(string(data), "Flush is disabled") {
+		return ErrFlushDisabled
+	}
+	return clientError{string(data)}
+}
+
+// GetDesignDocument retrieves a single design document for the given bucket.  If it does
+// not exist, ErrDesignDocumentNotFound is returned.
 func (bm *BucketManager) GetDesignDocument(name string) (*DesignDocument, error) {
 	reqUri := fmt.Sprintf("/_design/%s", name)
 
@@ -125,16 +139,20 @@ func (bm *BucketManager) GetDesignDocument(name string) (*DesignDocument, error)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
+	if resp.StatusCode == 404 {
+		return nil, ErrDesignDocumentNotFound
+	}
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return nil, clientError{string(data)}
 	}
 
@@ -157,16 +175,17 @@ func (bm *BucketManager) GetDesignDocuments() ([]*DesignDocument, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return nil, clientError{string(data)}
 	}
 
@@ -189,18 +208,21 @@ func (bm *BucketManager) GetDesignDocuments() ([]*DesignDocument, error) {
 	var ddocs []*DesignDocument
 	for index, ddocData := range ddocsObj.Rows {
 		ddoc := &ddocsObj.Rows[index].Doc.Json
-		ddoc.Name = ddocData.Doc.Meta.Id[8:]
+		ddoc.Name = strings.TrimPrefix(ddocData.Doc.Meta.Id, "_design/")
 		ddocs = append(ddocs, ddoc)
 	}
 
 	return ddocs, nil
 }
 
-// InsertDesignDocument inserts a design document to the given bucket.
+// InsertDesignDocument inserts a design document to the given bucket.  If a design
+// document with the same name already exists, ErrDesignDocumentAlreadyExists is returned.
 func (bm *BucketManager) InsertDesignDocument(ddoc *DesignDocument) error {
-	oldDdoc, err := bm.GetDesignDocument(ddoc.Name)
-	if oldDdoc != nil || err == nil {
-		return clientError{"Design document already exists"}
+	_, err := bm.GetDesignDocument(ddoc.Name)
+	if err == nil {
+		return ErrDesignDocumentAlreadyExists
+	} else if err != ErrDesignDocumentNotFound {
+		return err
 	}
 	return bm.UpsertDesignDocument(ddoc)
 }
@@ -219,23 +241,25 @@ func (bm *BucketManager) UpsertDesignDocument(ddoc *DesignDocument) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
 	if resp.StatusCode != 201 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return clientError{string(data)}
 	}
 
 	return nil
 }
 
-// RemoveDesignDocument will remove a design document from the given bucket.
+// RemoveDesignDocument will remove a design document from the given bucket.  If it does
+// not exist, ErrDesignDocumentNotFound is returned.
 func (bm *BucketManager) RemoveDesignDocument(name string) error {
 	reqUri := fmt.Sprintf("/_design/%s", name)
 
@@ -243,16 +267,20 @@ func (bm *BucketManager) RemoveDesignDocument(name string) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
+	if resp.StatusCode == 404 {
+		return ErrDesignDocumentNotFound
+	}
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return clientError{string(data)}
 	}
 
@@ -351,10 +379,12 @@ func (bm *BucketManager) DropPrimaryIndex(customName string, ignoreIfNotExists b
 	return bm.dropIndex(customName, ignoreIfNotExists)
 }
 
-// GetIndexes returns a list of all currently registered indexes.
+// GetIndexes returns a list of all currently registered indexes on the bucket.  Indexes
+// on other buckets are not included, so that indexes with the same names on other
+// buckets are not built or watched by BuildDeferredIndexes and WatchIndexes.
 func (bm *BucketManager) GetIndexes() ([]IndexInfo, error) {
-	q := NewN1qlQuery("SELECT `indexes`.* FROM system:indexes")
-	rows, err := bm.bucket.ExecuteN1qlQuery(q, nil)
+	q := NewN1qlQuery("SELECT `indexes`.* FROM system:indexes WHERE keyspace_id=$1")
+	rows, err := bm.bucket.ExecuteN1qlQuery(q, []interface{}{bm.bucket.name})
 	if err != nil {
 		return nil, err
 	}
@@ -414,6 +444,8 @@ func (bm *BucketManager) BuildDeferredIndexes() ([]string, error) {
 	return deferredList, nil
 }
 
+var errIndexesNotOnline = errors.New("Not all watched indexes are online.")
+
 func checkIndexesActive(indexes []IndexInfo, checkList []string) (bool, error) {
 	var checkIndexes []IndexInfo
 	for i := 0; i < len(checkList); i++ {
@@ -445,9 +477,12 @@ func (bm *BucketManager) WatchIndexes(watchList []string, watchPrimary bool, tim
 		watchList = append(watchList, "#primary")
 	}
 
-	curInterval := 50 * time.Millisecond
-	timeoutTime := time.Now().Add(timeout)
-	for {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	tracker := newRetryTracker(bm.bucket.cluster.retryBudget)
+	err := retryWithTracker(ctx, tracker, func() error {
 		indexes, err := bm.GetIndexes()
 		if err != nil {
 			return err
@@ -458,22 +493,21 @@ func (bm *BucketManager) WatchIndexes(watchList []string, watchPrimary bool, tim
 			return err
 		}
 
-		if allOnline {
-			break
-		}
-
-		curInterval += 500 * time.Millisecond
-		if curInterval > 1000 {
-			curInterval = 1000
-		}
-
-		if time.Now().Add(curInterval).After(timeoutTime) {
-			return ErrTimeout
+		if !allOnline {
+			return errIndexesNotOnline
 		}
-
-		// Wait till our next poll interval
-		time.Sleep(curInterval)
-	}
-
-	return nil
+		return nil
+	}, retryWithBackoff("indexes_not_online", func(err error) bool {
+		return err == errIndexesNotOnline
+	}, ExponentialBackoff(50*time.Millisecond, 1*time.Second, 2)))
+	if err == errIndexesNotOnline {
+		err = ErrTimeout
+	}
+
+	return bm.bucket.cluster.wrapOperationError(err, &OperationError{
+		Operation:   "WatchIndexes",
+		Bucket:      bm.bucket.name,
+		Elapsed:     time.Since(start),
+		RetryReport: tracker.retryReport(),
+	})
 }
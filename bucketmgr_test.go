@@ -0,0 +1,17 @@
+package gocb
+
+import (
+	"testing"
+)
+
+func TestFlushError(t *testing.T) {
+	err := flushError(400, []byte(`{"_":"Flush is disabled for the bucket"}`))
+	if err != ErrFlushDisabled {
+		t.Fatalf("Expected ErrFlushDisabled, got %v", err)
+	}
+
+	err = flushError(503, []byte("Service unavailable"))
+	if err == ErrFlushDisabled || err.Error() != "Service unavailable" {
+		t.Fatalf("Expected the response to be returned, got %v", err)
+	}
+}
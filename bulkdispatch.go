@@ -0,0 +1,382 @@
+package gocb
+
+import (
+	"time"
+)
+
+// The number of bulk operations kept in flight to each node for every connection to
+// it, unless a limit is set with SetBulkInFlightLimit.
+const bulkInFlightPerConnection = 512
+
+// BulkInFlightLimit returns the maximum number of operations of a bulk request kept
+// in flight to each node at once.
+func (b *Bucket) BulkInFlightLimit() int {
+	if b.bulkInFlightPerNode > 0 {
+		return b.bulkInFlightPerNode
+	}
+	connections := b.kvPoolSize
+	if connections <= 0 {
+		connections = 1
+	}
+	return connections * bulkInFlightPerConnection
+}
+
+// SetBulkInFlightLimit sets the maximum number of operations of a bulk request kept in
+// flight to each node at once.  Further operations are dispatched to a node as those
+// in flight to it complete, so that large bulk requests do not overflow the queues of
+// the server.  Zero restores the default of 512 operations per connection to the node.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetBulkInFlightLimit(perNode int) {
+	b.bulkInFlightPerNode = perNode
+}
+
+// BulkInterleave returns whether bulk requests dispatch operations to each node in turn.
+func (b *Bucket) BulkInterleave() bool {
+	return b.bulkInterleave
+}
+
+// SetBulkInterleave specifies whether bulk requests dispatch their operations to each
+// node in turn, one at a time, rather than filling the in-flight limit of one node
+// before moving on to the next.  Interleaving spreads the start of a large request
+// fairly across the cluster.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetBulkInterleave(enabled bool) {
+	b.bulkInterleave = enabled
+}
+
+// BulkFailFastOnNodeDown returns whether bulk requests fail operations for unavailable
+// nodes without attempting them.
+func (b *Bucket) BulkFailFastOnNodeDown() bool {
+	return b.bulkFailFast
+}
+
+// SetBulkFailFastOnNodeDown specifies whether operations of bulk requests which would be
+// sent to a node known to be unavailable fail immediately with ErrNodeUnavailable, rather
+// than each waiting to time out, while the other operations complete normally.  A node
+// is known to be unavailable when no server is mapped to the vbucket of a key, or when
+// several consecutive operations to it have failed with network errors or timeouts, in
+// which case it is attempted again after a few seconds.  Use NewBulkReport to separate
+// the operations which were not attempted from those which failed.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetBulkFailFastOnNodeDown(enabled bool) {
+	b.bulkFailFast = enabled
+}
+
+// BulkTimeout returns the maximum amount of time to wait for a bulk request as a whole.
+func (b *Bucket) BulkTimeout() time.Duration {
+	return b.bulkTimeout
+}
+
+// SetBulkTimeout sets the maximum amount of time to wait for a bulk request as a whole,
+// after which its remaining operations fail with ErrTimeout.  Each operation is also
+// limited to BulkOperationTimeout from when it is dispatched.  Zero, the default,
+// applies no limit beyond those of the individual operations.  In either case Do
+// returns ErrTimeout if any of the operations timed out, as it did when
+// BulkOperationTimeout limited the request as a whole.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetBulkTimeout(timeout time.Duration) {
+	b.bulkTimeout = timeout
+}
+
+func (b *Bucket) bulkOpNode(key string) int {
+	return b.client.VbucketToServer(b.client.KeyToVbucket([]byte(key)), 0)
+}
+
+type bulkOpState int
+
+const (
+	bulkOpQueued = bulkOpState(iota)
+	// bulkOpAdmitting is an operation waiting for the operation queue of the bucket to
+	// admit it.
+	bulkOpAdmitting
+	bulkOpInFlight
+	// bulkOpExpiring is an operation which timed out but could no longer be cancelled,
+	// so whose callback is still to be received.
+	bulkOpExpiring
+	bulkOpDone
+)
+
+type bulkDeadline struct {
+	index    int
+	deadline time.Time
+}
+
+// bulkDispatcher executes the operations of a bulk request, keeping at most perNode
+// of them in flight to each node and dispatching the rest as those complete.  When the
+// bucket has operation queue limits, each operation is also admitted by the scheduler
+// before it is dispatched, one at a time so that the batch does not occupy the queue.
+type bulkDispatcher struct {
+	b          *Bucket
+	ops        []BulkOp
+	perNode    int
+	opTimeout  time.Duration
+	interleave bool
+	failFast   bool
+	health     *nodeHealth
+	scheduler  *opScheduler
+	priority   OpPriority
+
+	indexes   map[BulkOp]int
+	opNodes   []int
+	states    []bulkOpState
+	nodes     []int
+	queues    map[int][]int
+	inFlight  map[int]int
+	deadlines []bulkDeadline
+	completed int
+	timedOut  int
+
+	// waiter is the pending admission of the operation at waiterIndex.
+	waiter      *opWaiter
+	waiterIndex int
+}
+
+func newBulkDispatcher(b *Bucket, ops []BulkOp, nodeOf func(key string) int, priority OpPriority) *bulkDispatcher {
+	d := &bulkDispatcher{
+		b:          b,
+		ops:        ops,
+		perNode:    b.BulkInFlightLimit(),
+		opTimeout:  b.bulkOpTimeout,
+		interleave: b.bulkInterleave,
+		failFast:   b.bulkFailFast,
+		health:     b.nodeHealth,
+		scheduler:  b.opScheduler(),
+		priority:   priority,
+		indexes:    make(map[BulkOp]int, len(ops)),
+		opNodes:    make([]int, len(ops)),
+		states:     make([]bulkOpState, len(ops)),
+		queues:     make(map[int][]int),
+		inFlight:   make(map[int]int),
+	}
+	for i, item := range ops {
+		node := nodeOf(item.bulkKey())
+		if _, ok := d.queues[node]; !ok {
+			d.nodes = append(d.nodes, node)
+		}
+		d.indexes[item] = i
+		d.opNodes[i] = node
+		d.queues[node] = append(d.queues[node], i)
+	}
+	return d
+}
+
+// run executes the operations, returning ErrTimeout if any of them timed out, or if
+// the batch as a whole did.
+func (d *bulkDispatcher) run(batchTimeout time.Duration) error {
+	// Make the channel big enough to hold all our ops in case
+	//   we get delayed inside execute (don't want to block the
+	//   individual op handlers when they dispatch their signal).
+	signal := make(chan BulkOp, len(d.ops))
+
+	var batchCh <-chan time.Time
+	if batchTimeout > 0 {
+		batchTmr := time.NewTimer(batchTimeout)
+		defer batchTmr.Stop()
+		batchCh = batchTmr.C
+	}
+
+	opTmr := time.NewTimer(0)
+	if !opTmr.Stop() {
+		<-opTmr.C
+	}
+	defer opTmr.Stop()
+
+	d.dispatch(signal)
+	for d.completed < len(d.ops) {
+		var opCh <-chan time.Time
+		if len(d.deadlines) > 0 {
+			opTmr.Reset(time.Until(d.deadlines[0].deadline))
+			opCh = opTmr.C
+		}
+
+		var admitCh <-chan error
+		if d.waiter != nil {
+			admitCh = d.waiter.ready
+		}
+
+		fired := false
+		select {
+		case err := <-admitCh:
+			d.admitted(signal, err)
+		case item := <-signal:
+			// We're really just clearing the pendop from this thread,
+			//   since it already completed, no cancel actually occurs
+			item.cancel()
+			d.complete(d.indexes[item])
+		case <-opCh:
+			fired = true
+			d.expire(time.Now())
+		case <-batchCh:
+			d.abandon(signal, ErrTimeout)
+			return ErrTimeout
+		case <-d.b.ops.closedCh():
+			d.abandon(signal, ErrShutdown)
+			return ErrShutdown
+		}
+		if opCh != nil && !fired && !opTmr.Stop() {
+			<-opTmr.C
+		}
+
+		d.dispatch(signal)
+	}
+	if d.timedOut > 0 {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// dispatch executes queued operations for every node below its in-flight limit.
+func (d *bulkDispatcher) dispatch(signal chan BulkOp) {
+	for {
+		dispatched := false
+		for _, node := range d.nodes {
+			if d.failFast && len(d.queues[node]) > 0 && d.health.isDown(node) {
+				d.failNode(node)
+				continue
+			}
+
+			for len(d.queues[node]) > 0 && d.inFlight[node] < d.perNode {
+				if d.waiter != nil {
+					return
+				}
+				index := d.queues[node][0]
+				d.queues[node] = d.queues[node][1:]
+				d.inFlight[node]++
+				dispatched = true
+				if d.scheduler != nil {
+					d.admit(signal, index)
+				} else {
+					d.execute(signal, index)
+				}
+
+				if d.interleave {
+					break
+				}
+			}
+		}
+		if !dispatched || !d.interleave {
+			return
+		}
+	}
+}
+
+// admit requests admission for an operation from the scheduler, executing it at once
+// if the queue admits it immediately.
+func (d *bulkDispatcher) admit(signal chan BulkOp, index int) {
+	w := d.scheduler.enqueue(d.priority)
+	d.states[index] = bulkOpAdmitting
+	select {
+	case err := <-w.ready:
+		d.waiterIndex = index
+		d.admitted(signal, err)
+	default:
+		d.waiter = w
+		d.waiterIndex = index
+	}
+}
+
+// admitted executes the operation waiting for admission once the scheduler admits it,
+// or fails it if it was shed from the queue.
+func (d *bulkDispatcher) admitted(signal chan BulkOp, err error) {
+	index := d.waiterIndex
+	d.waiter = nil
+	if err != nil {
+		d.ops[index].markError(err)
+		d.inFlight[d.opNodes[index]]--
+		d.states[index] = bulkOpDone
+		d.completed++
+		return
+	}
+	d.execute(signal, index)
+}
+
+func (d *bulkDispatcher) execute(signal chan BulkOp, index int) {
+	d.states[index] = bulkOpInFlight
+	if d.opTimeout > 0 {
+		d.deadlines = append(d.deadlines, bulkDeadline{index, time.Now().Add(d.opTimeout)})
+	}
+	d.ops[index].execute(d.b, signal)
+}
+
+// release returns the admission of a completed operation to the scheduler.
+func (d *bulkDispatcher) release() {
+	if d.scheduler != nil {
+		d.scheduler.release()
+	}
+}
+
+// failNode fails the operations queued for an unavailable node without attempting them.
+func (d *bulkDispatcher) failNode(node int) {
+	for _, index := range d.queues[node] {
+		d.ops[index].markError(ErrNodeUnavailable)
+		d.states[index] = bulkOpDone
+		d.completed++
+	}
+	d.queues[node] = nil
+}
+
+func (d *bulkDispatcher) complete(index int) {
+	switch d.states[index] {
+	case bulkOpInFlight, bulkOpExpiring:
+		node := d.opNodes[index]
+		d.health.record(node, d.ops[index].bulkErr())
+		d.inFlight[node]--
+		d.states[index] = bulkOpDone
+		d.completed++
+		d.release()
+	}
+}
+
+// expire times out the operations which have been in flight for longer than the
+// operation timeout.  Operations are dispatched in order, so their deadlines are too.
+func (d *bulkDispatcher) expire(now time.Time) {
+	for len(d.deadlines) > 0 && !d.deadlines[0].deadline.After(now) {
+		index := d.deadlines[0].index
+		d.deadlines = d.deadlines[1:]
+		if d.states[index] != bulkOpInFlight {
+			continue
+		}
+
+		if d.ops[index].cancel() {
+			d.ops[index].markError(ErrTimeout)
+			d.timedOut++
+			d.complete(index)
+		} else {
+			d.states[index] = bulkOpExpiring
+		}
+	}
+}
+
+// abandon fails every operation which has not completed with err, waiting for the
+// callbacks of those which could no longer be cancelled.
+func (d *bulkDispatcher) abandon(signal chan BulkOp, err error) {
+	if d.waiter != nil && !d.scheduler.cancel(d.waiter) {
+		if admitErr := <-d.waiter.ready; admitErr == nil {
+			d.release()
+		}
+	}
+	d.waiter = nil
+
+	uncancelled := 0
+	for index, item := range d.ops {
+		switch d.states[index] {
+		case bulkOpQueued, bulkOpAdmitting:
+			item.markError(err)
+		case bulkOpInFlight:
+			d.release()
+			if item.cancel() {
+				item.markError(err)
+			} else {
+				uncancelled++
+			}
+		case bulkOpExpiring:
+			d.release()
+			uncancelled++
+		}
+	}
+	awaitAbandonedOps(signal, uncancelled)
+}
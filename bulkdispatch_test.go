@@ -0,0 +1,368 @@
+package gocb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBulkNodes struct {
+	lock     sync.Mutex
+	inFlight map[int]int
+	maxSeen  map[int]int
+	order    []int
+}
+
+func newFakeBulkNodes() *fakeBulkNodes {
+	return &fakeBulkNodes{
+		inFlight: make(map[int]int),
+		maxSeen:  make(map[int]int),
+	}
+}
+
+func (n *fakeBulkNodes) start(node int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.inFlight[node]++
+	if n.inFlight[node] > n.maxSeen[node] {
+		n.maxSeen[node] = n.inFlight[node]
+	}
+	n.order = append(n.order, node)
+}
+
+func (n *fakeBulkNodes) finish(node int) {
+	n.lock.Lock()
+	n.inFlight[node]--
+	n.lock.Unlock()
+}
+
+// fakeBulkOp completes after a delay from when it is executed, unless it is
+// cancelled first.
+type fakeBulkOp struct {
+	key    string
+	node   int
+	delay  time.Duration
+	result error
+	nodes  *fakeBulkNodes
+	Err    error
+
+	lock       sync.Mutex
+	dispatched time.Time
+	completed  bool
+	cancelled  bool
+}
+
+func (item *fakeBulkOp) bulkKey() string {
+	return item.key
+}
+
+func (item *fakeBulkOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *fakeBulkOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *fakeBulkOp) cancel() bool {
+	item.lock.Lock()
+	defer item.lock.Unlock()
+	if item.completed || item.cancelled {
+		return false
+	}
+	item.cancelled = true
+	item.nodes.finish(item.node)
+	return true
+}
+
+func (item *fakeBulkOp) execute(b *Bucket, signal chan BulkOp) {
+	item.dispatched = time.Now()
+	item.nodes.start(item.node)
+	go func() {
+		time.Sleep(item.delay)
+		item.lock.Lock()
+		if item.cancelled {
+			item.lock.Unlock()
+			return
+		}
+		item.completed = true
+		item.Err = item.result
+		item.nodes.finish(item.node)
+		item.lock.Unlock()
+		signal <- item
+	}()
+}
+
+func makeFakeBulkOps(count, numNodes int, delay time.Duration) ([]BulkOp, map[string]int, *fakeBulkNodes) {
+	nodes := newFakeBulkNodes()
+	keyNodes := make(map[string]int)
+	var ops []BulkOp
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keyNodes[key] = i % numNodes
+		ops = append(ops, &fakeBulkOp{key: key, node: i % numNodes, delay: delay, nodes: nodes})
+	}
+	return ops, keyNodes, nodes
+}
+
+func TestBulkDispatchLargerThanInFlightLimit(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: time.Second, bulkInFlightPerNode: 8}
+	ops, keyNodes, nodes := makeFakeBulkOps(200, 2, time.Millisecond)
+
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+
+	for _, op := range ops {
+		item := op.(*fakeBulkOp)
+		if item.Err != nil || !item.completed {
+			t.Fatalf("Expected %s to complete successfully, got %v", item.key, item.Err)
+		}
+	}
+	for node := 0; node < 2; node++ {
+		if nodes.maxSeen[node] != 8 {
+			t.Fatalf("Expected node %d to have at most 8 operations in flight, saw %d", node, nodes.maxSeen[node])
+		}
+	}
+}
+
+func TestBulkDispatchDefaultInFlightLimit(t *testing.T) {
+	b := &Bucket{}
+	if b.BulkInFlightLimit() != bulkInFlightPerConnection {
+		t.Fatalf("Expected a limit of %d for a single connection, got %d", bulkInFlightPerConnection, b.BulkInFlightLimit())
+	}
+	b.kvPoolSize = 4
+	if b.BulkInFlightLimit() != 4*bulkInFlightPerConnection {
+		t.Fatalf("Expected the limit to scale with the connections, got %d", b.BulkInFlightLimit())
+	}
+	b.SetBulkInFlightLimit(10)
+	if b.BulkInFlightLimit() != 10 {
+		t.Fatalf("Expected the configured limit, got %d", b.BulkInFlightLimit())
+	}
+}
+
+func TestKvPoolSize(t *testing.T) {
+	c := &Cluster{}
+	if c.KvPoolSize() != 1 {
+		t.Fatalf("Expected a single connection per node by default, got %d", c.KvPoolSize())
+	}
+	c.SetKvPoolSize(4)
+	config, err := c.makeAgentConfig("default", "default", "", false)
+	if err != nil {
+		t.Fatalf("Failed to make the agent config: %v", err)
+	}
+	if config.KvPoolSize != 4 {
+		t.Fatalf("Expected the pool size to be passed to the agent, got %d", config.KvPoolSize)
+	}
+	c.SetKvPoolSize(-1)
+	if c.KvPoolSize() != 1 {
+		t.Fatalf("Expected a negative size to restore the default, got %d", c.KvPoolSize())
+	}
+}
+
+func TestBulkDispatchTimesOpsFromDispatch(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: 100 * time.Millisecond, bulkInFlightPerNode: 1}
+	ops, keyNodes, _ := makeFakeBulkOps(6, 1, 30*time.Millisecond)
+
+	// The batch takes well over the operation timeout as a whole, but no operation
+	// takes longer than it from when it was dispatched.
+	start := time.Now()
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("Expected operations to be dispatched one at a time, took %s", time.Since(start))
+	}
+	last := ops[len(ops)-1].(*fakeBulkOp)
+	if last.Err != nil || last.dispatched.Sub(start) < 100*time.Millisecond {
+		t.Fatalf("Expected the last chunk to be dispatched late and succeed, got %v after %s", last.Err, last.dispatched.Sub(start))
+	}
+
+	// An operation still exceeds the timeout once it has been dispatched.
+	ops, keyNodes, _ = makeFakeBulkOps(3, 1, 30*time.Millisecond)
+	ops[1].(*fakeBulkOp).delay = time.Second
+	d = newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != ErrTimeout {
+		t.Fatalf("Expected the batch to report the timed out operation, got %v", err)
+	}
+	if ops[0].(*fakeBulkOp).Err != nil || ops[1].(*fakeBulkOp).Err != ErrTimeout || ops[2].(*fakeBulkOp).Err != nil {
+		t.Fatalf("Expected only the slow operation to time out, got %v, %v, %v",
+			ops[0].(*fakeBulkOp).Err, ops[1].(*fakeBulkOp).Err, ops[2].(*fakeBulkOp).Err)
+	}
+}
+
+func TestBulkTimeoutAbandonsBatch(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: time.Second, bulkInFlightPerNode: 1}
+	ops, keyNodes, _ := makeFakeBulkOps(5, 1, 50*time.Millisecond)
+
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(120 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Expected the batch to time out, got %v", err)
+	}
+	if ops[0].(*fakeBulkOp).Err != nil {
+		t.Fatalf("Expected the first operation to complete, got %v", ops[0].(*fakeBulkOp).Err)
+	}
+	for _, op := range ops[3:] {
+		if op.(*fakeBulkOp).Err != ErrTimeout {
+			t.Fatalf("Expected the remaining operations to time out, got %v", op.(*fakeBulkOp).Err)
+		}
+	}
+}
+
+func TestBulkDispatchInterleave(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: time.Second}
+	keyNodes := make(map[string]int)
+	nodes := newFakeBulkNodes()
+	var ops []BulkOp
+	for i := 0; i < 9; i++ {
+		// The operations for each node are grouped together in the batch.
+		key := fmt.Sprintf("key-%d", i)
+		keyNodes[key] = i / 3
+		ops = append(ops, &fakeBulkOp{key: key, node: i / 3, nodes: nodes})
+	}
+
+	b.SetBulkInterleave(true)
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+
+	nodes.lock.Lock()
+	defer nodes.lock.Unlock()
+	expected := []int{0, 1, 2, 0, 1, 2, 0, 1, 2}
+	for i, node := range nodes.order {
+		if node != expected[i] {
+			t.Fatalf("Expected operations to be dispatched to each node in turn, got %v", nodes.order)
+		}
+	}
+}
+
+func TestBulkFailFastOnNodeDown(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: 30 * time.Millisecond, bulkInFlightPerNode: 1, nodeHealth: newNodeHealth()}
+	b.SetBulkFailFastOnNodeDown(true)
+
+	// Operations to node 2 never complete, while one of the keys on node 0 is missing.
+	ops, keyNodes, _ := makeFakeBulkOps(30, 3, time.Millisecond)
+	for i, op := range ops {
+		if i%3 == 2 {
+			op.(*fakeBulkOp).delay = time.Hour
+		}
+	}
+	ops[0].(*fakeBulkOp).result = ErrKeyNotFound
+
+	start := time.Now()
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != ErrTimeout {
+		t.Fatalf("Expected the batch to report the operations which timed out, got %v", err)
+	}
+	if time.Since(start) > 250*time.Millisecond {
+		t.Fatalf("Expected the batch to stop waiting for the down node, took %s", time.Since(start))
+	}
+
+	report := NewBulkReport(ops)
+	expected := BulkReport{Succeeded: 19, Missing: 1, Failed: nodeFailureThreshold, NotAttempted: 10 - nodeFailureThreshold}
+	if report != expected || !report.Degraded() {
+		t.Fatalf("Expected %+v, got %+v", expected, report)
+	}
+
+	// Later batches fail operations for the node without attempting them.
+	ops, keyNodes, nodes := makeFakeBulkOps(9, 3, time.Millisecond)
+	start = time.Now()
+	d = newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+	if time.Since(start) > 25*time.Millisecond || nodes.maxSeen[2] != 0 {
+		t.Fatalf("Expected operations for the down node to fail immediately, took %s", time.Since(start))
+	}
+	if report := NewBulkReport(ops); report.Succeeded != 6 || report.NotAttempted != 3 {
+		t.Fatalf("Expected 6 successes and 3 unattempted operations, got %+v", report)
+	}
+
+	// A node recovers once an operation to it succeeds.
+	b.nodeHealth.record(2, nil)
+	if b.nodeHealth.isDown(2) {
+		t.Fatalf("Expected the node to be available after a success")
+	}
+}
+
+func TestBulkFailFastUnmappedNode(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: time.Second}
+	ops, _, nodes := makeFakeBulkOps(4, 1, time.Millisecond)
+	nodeOf := func(key string) int {
+		if key == "key-1" {
+			return -1
+		}
+		return 0
+	}
+
+	b.SetBulkFailFastOnNodeDown(true)
+	d := newBulkDispatcher(b, ops, nodeOf, PriorityNormal)
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+	if ops[1].(*fakeBulkOp).Err != ErrNodeUnavailable || nodes.maxSeen[-1] != 0 {
+		t.Fatalf("Expected the key with no node to be failed without being attempted, got %v", ops[1].(*fakeBulkOp).Err)
+	}
+	if report := NewBulkReport(ops); report.Succeeded != 3 || report.NotAttempted != 1 {
+		t.Fatalf("Expected 3 successes and 1 unattempted operation, got %+v", report)
+	}
+}
+
+func TestBulkDispatchWithOperationQueueLimits(t *testing.T) {
+	b := &Bucket{
+		ops:                 newOpTracker(),
+		bulkOpTimeout:       100 * time.Millisecond,
+		bulkInFlightPerNode: 2,
+		nodeHealth:          newNodeHealth(),
+	}
+	b.SetOperationQueueLimits(3, 100)
+	b.SetBulkFailFastOnNodeDown(true)
+	b.nodeHealth.downUntil[2] = time.Now().Add(time.Hour)
+
+	// The batch takes longer than the operation timeout as a whole, while no operation
+	// takes longer than it from when it was admitted and dispatched.
+	ops, keyNodes, nodes := makeFakeBulkOps(30, 3, 30*time.Millisecond)
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	start := time.Now()
+	if err := d.run(0); err != nil {
+		t.Fatalf("Expected the batch to complete, got %v", err)
+	}
+	if time.Since(start) < b.bulkOpTimeout {
+		t.Fatalf("Expected operations to be admitted a few at a time, took %s", time.Since(start))
+	}
+
+	nodes.lock.Lock()
+	defer nodes.lock.Unlock()
+	if nodes.maxSeen[0] > 2 || nodes.maxSeen[1] > 2 || nodes.maxSeen[2] != 0 {
+		t.Fatalf("Expected the per node limits and fail fast to apply, saw %v", nodes.maxSeen)
+	}
+	if report := NewBulkReport(ops); report.Succeeded != 20 || report.NotAttempted != 10 {
+		t.Fatalf("Expected 20 successes and 10 unattempted operations, got %+v", report)
+	}
+	if b.opScheduler().inFlight != 0 || b.opScheduler().queued != 0 {
+		t.Fatalf("Expected every admission to be released, %d in flight and %d queued", b.opScheduler().inFlight, b.opScheduler().queued)
+	}
+}
+
+func TestBulkTimeoutReleasesAdmissions(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), bulkOpTimeout: time.Second}
+	b.SetOperationQueueLimits(2, 100)
+	ops, keyNodes, _ := makeFakeBulkOps(6, 1, time.Hour)
+
+	d := newBulkDispatcher(b, ops, func(key string) int { return keyNodes[key] }, PriorityNormal)
+	if err := d.run(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Expected the batch to time out, got %v", err)
+	}
+	for _, op := range ops {
+		if op.(*fakeBulkOp).Err != ErrTimeout {
+			t.Fatalf("Expected every operation to time out, got %v", op.(*fakeBulkOp).Err)
+		}
+	}
+	if b.opScheduler().inFlight != 0 || b.opScheduler().queued != 0 {
+		t.Fatalf("Expected every admission to be released, %d in flight and %d queued", b.opScheduler().inFlight, b.opScheduler().queued)
+	}
+}
@@ -0,0 +1,439 @@
+package gocb
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BulkLoaderOptions are the options available to NewBulkLoader.  Zero values select
+// the defaults described for each option.
+type BulkLoaderOptions struct {
+	// OpsPerSecond is the target rate of writes, including retries.  Zero means
+	// writes are not rate limited.
+	OpsPerSecond int
+	// MaxInFlight is the maximum number of writes dispatched at once.  Defaults to 256.
+	MaxInFlight int
+	// BatchSize is the number of documents queued by Add before they are written
+	// together.  Defaults to 128.
+	BatchSize int
+	// MaxRetries is the maximum number of times a document whose write failed with a
+	// transient error is retried.  Defaults to 5.
+	MaxRetries int
+	// Backoff calculates the wait before retrying a failed write.  Defaults to an
+	// exponential backoff from 10ms to 1s.
+	Backoff BackoffFn
+	// Progress, if set, is invoked every ProgressInterval and once more when the
+	// load has been flushed.
+	Progress func(BulkLoaderProgress)
+	// ProgressInterval is the interval at which Progress is invoked.  Defaults to 1s.
+	ProgressInterval time.Duration
+}
+
+// BulkLoaderProgress describes the progress of a BulkLoader.
+type BulkLoaderProgress struct {
+	// Completed is the number of documents which have been stored.
+	Completed uint64
+	// Failed is the number of documents which permanently failed to be stored.
+	Failed uint64
+	// Rate is the number of documents stored per second since the previous report.
+	Rate float64
+}
+
+// BulkLoadError is returned by BulkLoader.Flush when documents could not be stored.
+type BulkLoadError struct {
+	// Failures holds the error which prevented each document from being stored,
+	// keyed by the key of the document.
+	Failures map[string]error
+}
+
+func (e *BulkLoadError) Error() string {
+	keys := make([]string, 0, len(e.Failures))
+	for key := range e.Failures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	const maxListed = 5
+	var failures []string
+	for i, key := range keys {
+		if i == maxListed {
+			failures = append(failures, "...")
+			break
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", key, e.Failures[key]))
+	}
+	return fmt.Sprintf("%d documents failed to load (%s)", len(e.Failures), strings.Join(failures, ", "))
+}
+
+type bulkLoadItem struct {
+	key      string
+	bytes    []byte
+	flags    uint32
+	expiry   uint32
+	attempts uint32
+}
+
+type bulkLoadStoreFn func(item *bulkLoadItem, cb func(error)) (pendingOp, error)
+
+// BulkLoader loads large numbers of documents into a bucket, limiting the rate and
+// concurrency of writes and backing off when the cluster reports that it is
+// temporarily unable to keep up.  A BulkLoader is not safe for concurrent use.
+//
+// Experimental: This API is subject to change at any time.
+type BulkLoader struct {
+	bucket  *Bucket
+	ctx     context.Context
+	opts    BulkLoaderOptions
+	store   bulkLoadStoreFn
+	limiter *tokenBucket
+	slots   chan struct{}
+	batch   []*bulkLoadItem
+	pending sync.WaitGroup
+
+	completed uint64
+	failed    uint64
+
+	lock       sync.Mutex
+	failures   map[string]error
+	pauseUntil time.Time
+	pressure   uint32
+
+	progressStop chan struct{}
+	progressDone chan struct{}
+}
+
+// NewBulkLoader creates a BulkLoader which upserts documents into this bucket.  Once
+// ctx is done no further writes are dispatched, and every document which was not
+// stored is reported by Flush.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) NewBulkLoader(ctx context.Context, opts BulkLoaderOptions) *BulkLoader {
+	l := newBulkLoader(ctx, b, opts)
+	l.store = func(item *bulkLoadItem, cb func(error)) (pendingOp, error) {
+		return b.client.Set([]byte(item.key), item.bytes, item.flags, item.expiry,
+			func(cas gocbcore.Cas, mt gocbcore.MutationToken, err error) {
+				cb(err)
+			})
+	}
+	return l
+}
+
+func newBulkLoader(ctx context.Context, b *Bucket, opts BulkLoaderOptions) *BulkLoader {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 256
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 128
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(10*time.Millisecond, 1*time.Second, 2)
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 1 * time.Second
+	}
+
+	l := &BulkLoader{
+		bucket:   b,
+		ctx:      ctx,
+		opts:     opts,
+		slots:    make(chan struct{}, opts.MaxInFlight),
+		failures: make(map[string]error),
+	}
+	if opts.OpsPerSecond > 0 {
+		l.limiter = newTokenBucket(opts.OpsPerSecond)
+	}
+	if opts.Progress != nil {
+		l.progressStop = make(chan struct{})
+		l.progressDone = make(chan struct{})
+		go l.reportProgress()
+	}
+	return l
+}
+
+// Add queues a document to be upserted.  Add blocks while the loader is at its limits,
+// applying back-pressure to the caller.  An error is returned if the value cannot be
+// encoded, or once the context of the loader is done.
+func (l *BulkLoader) Add(key string, value interface{}, expiry uint32) error {
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+
+	bytes, flags, err := l.bucket.encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	l.pending.Add(1)
+	l.batch = append(l.batch, &bulkLoadItem{
+		key:    key,
+		bytes:  bytes,
+		flags:  flags,
+		expiry: expiry,
+	})
+	if len(l.batch) >= l.opts.BatchSize {
+		l.dispatchBatch()
+	}
+	return nil
+}
+
+// Flush writes any queued documents and waits for every write to complete, returning
+// a BulkLoadError describing the documents which could not be stored.  The loader
+// must not be used after Flush.
+func (l *BulkLoader) Flush() error {
+	l.dispatchBatch()
+	l.pending.Wait()
+
+	if l.progressStop != nil {
+		close(l.progressStop)
+		<-l.progressDone
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.failures) == 0 {
+		return nil
+	}
+	return &BulkLoadError{Failures: l.failures}
+}
+
+// Progress returns the current progress of the loader.
+func (l *BulkLoader) Progress() BulkLoaderProgress {
+	return BulkLoaderProgress{
+		Completed: atomic.LoadUint64(&l.completed),
+		Failed:    atomic.LoadUint64(&l.failed),
+	}
+}
+
+func (l *BulkLoader) reportProgress() {
+	defer close(l.progressDone)
+
+	ticker := time.NewTicker(l.opts.ProgressInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	var lastCompleted uint64
+	report := func() {
+		now := time.Now()
+		progress := l.Progress()
+		if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+			progress.Rate = float64(progress.Completed-lastCompleted) / elapsed
+		}
+		last, lastCompleted = now, progress.Completed
+		l.opts.Progress(progress)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			report()
+		case <-l.progressStop:
+			report()
+			return
+		}
+	}
+}
+
+func (l *BulkLoader) dispatchBatch() {
+	batch := l.batch
+	l.batch = nil
+	for _, item := range batch {
+		l.dispatch(item)
+	}
+}
+
+// isBulkLoadBackpressure returns whether an error indicates that the cluster is
+// temporarily unable to keep up with the rate of writes.
+func isBulkLoadBackpressure(err error) bool {
+	switch ErrorCause(err) {
+	case ErrTmpFail, ErrOutOfMemory, ErrBusy:
+		return true
+	}
+	return false
+}
+
+func isBulkLoadTransient(err error) bool {
+	switch ErrorCause(err) {
+	case ErrTimeout, ErrNetwork, ErrOverload:
+		return true
+	}
+	return isBulkLoadBackpressure(err)
+}
+
+// waitToDispatch blocks until a write may be dispatched, returning with a slot held.
+func (l *BulkLoader) waitToDispatch() error {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
+
+	l.lock.Lock()
+	pause := time.Until(l.pauseUntil)
+	l.lock.Unlock()
+
+	err := sleepContext(l.ctx, pause)
+	if err == nil && l.limiter != nil {
+		err = l.limiter.wait(l.ctx)
+	}
+	if err != nil {
+		<-l.slots
+		return err
+	}
+	return nil
+}
+
+func (l *BulkLoader) dispatch(item *bulkLoadItem) {
+	err := l.waitToDispatch()
+	if err != nil {
+		l.fail(item, err)
+		return
+	}
+
+	var finished int32
+	var timerLock sync.Mutex
+	var timeoutTmr *time.Timer
+	finish := func(err error) {
+		if !atomic.CompareAndSwapInt32(&finished, 0, 1) {
+			return
+		}
+		timerLock.Lock()
+		if timeoutTmr != nil {
+			timeoutTmr.Stop()
+		}
+		timerLock.Unlock()
+		l.handleResult(item, err)
+	}
+
+	op, err := l.store(item, finish)
+	if err != nil {
+		finish(err)
+		return
+	}
+
+	timerLock.Lock()
+	if atomic.LoadInt32(&finished) == 0 {
+		timeoutTmr = time.AfterFunc(l.bucket.opTimeout, func() {
+			if op.Cancel() {
+				finish(ErrTimeout)
+			}
+		})
+	}
+	timerLock.Unlock()
+}
+
+func (l *BulkLoader) handleResult(item *bulkLoadItem, err error) {
+	<-l.slots
+
+	if err == nil {
+		l.lock.Lock()
+		l.pressure = 0
+		l.lock.Unlock()
+
+		l.bucket.invalidateLocalCache(item.key)
+		atomic.AddUint64(&l.completed, 1)
+		l.pending.Done()
+		return
+	}
+
+	if isBulkLoadBackpressure(err) {
+		l.lock.Lock()
+		pause := l.opts.Backoff(l.pressure)
+		l.pressure++
+		if until := time.Now().Add(pause); until.After(l.pauseUntil) {
+			l.pauseUntil = until
+		}
+		l.lock.Unlock()
+	}
+
+	if !isBulkLoadTransient(err) || item.attempts >= uint32(l.opts.MaxRetries) || l.ctx.Err() != nil {
+		l.fail(item, err)
+		return
+	}
+
+	delay := l.opts.Backoff(item.attempts)
+	item.attempts++
+	time.AfterFunc(delay, func() {
+		l.dispatch(item)
+	})
+}
+
+func (l *BulkLoader) fail(item *bulkLoadItem, err error) {
+	l.lock.Lock()
+	l.failures[item.key] = err
+	l.lock.Unlock()
+
+	atomic.AddUint64(&l.failed, 1)
+	l.pending.Done()
+}
+
+// tokenBucket limits a rate of events, allowing bursts of up to a tenth of a second's
+// worth of events.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perSecond int) *tokenBucket {
+	burst := float64(perSecond) / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   float64(perSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	for {
+		tb.lock.Lock()
+		now := time.Now()
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.lock.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		tb.lock.Unlock()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for the specified duration, returning early if ctx is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	waitTmr := time.NewTimer(delay)
+	select {
+	case <-waitTmr.C:
+		return nil
+	case <-ctx.Done():
+		waitTmr.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,185 @@
+package gocb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeBulkStore struct {
+	lock     sync.Mutex
+	attempts map[string]int
+	inFlight int32
+	maxSeen  int32
+	respond  func(key string, attempt int) error
+}
+
+type fakeBulkPendingOp struct{}
+
+func (op fakeBulkPendingOp) Cancel() bool {
+	return false
+}
+
+func (s *fakeBulkStore) store(item *bulkLoadItem, cb func(error)) (pendingOp, error) {
+	s.lock.Lock()
+	s.attempts[item.key]++
+	attempt := s.attempts[item.key]
+	s.lock.Unlock()
+
+	inFlight := atomic.AddInt32(&s.inFlight, 1)
+	for {
+		maxSeen := atomic.LoadInt32(&s.maxSeen)
+		if inFlight <= maxSeen || atomic.CompareAndSwapInt32(&s.maxSeen, maxSeen, inFlight) {
+			break
+		}
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&s.inFlight, -1)
+		cb(s.respond(item.key, attempt))
+	}()
+	return fakeBulkPendingOp{}, nil
+}
+
+func newTestBulkLoader(ctx context.Context, opts BulkLoaderOptions, respond func(string, int) error) (*BulkLoader, *fakeBulkStore) {
+	b := &Bucket{
+		cluster:    &Cluster{},
+		transcoder: &DefaultTranscoder{},
+		opTimeout:  time.Second,
+	}
+	s := &fakeBulkStore{attempts: make(map[string]int), respond: respond}
+	l := newBulkLoader(ctx, b, opts)
+	l.store = s.store
+	return l, s
+}
+
+func TestBulkLoaderRetriesAndReportsFailures(t *testing.T) {
+	var progressLock sync.Mutex
+	var lastProgress BulkLoaderProgress
+	l, s := newTestBulkLoader(context.Background(), BulkLoaderOptions{
+		MaxInFlight: 8,
+		BatchSize:   10,
+		Backoff:     ExponentialBackoff(time.Millisecond, 5*time.Millisecond, 2),
+		Progress: func(p BulkLoaderProgress) {
+			progressLock.Lock()
+			lastProgress = p
+			progressLock.Unlock()
+		},
+		ProgressInterval: 5 * time.Millisecond,
+	}, func(key string, attempt int) error {
+		switch {
+		case key == "doc-7":
+			return ErrKeyExists
+		case key == "doc-13" && attempt < 3:
+			return ErrTmpFail
+		case key == "doc-21":
+			return ErrOutOfMemory
+		}
+		return nil
+	})
+
+	for i := 0; i < 100; i++ {
+		if err := l.Add(fmt.Sprintf("doc-%d", i), map[string]int{"i": i}, 0); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	err := l.Flush()
+	loadErr, ok := err.(*BulkLoadError)
+	if !ok {
+		t.Fatalf("Expected a BulkLoadError, got %v", err)
+	}
+	if len(loadErr.Failures) != 2 || loadErr.Failures["doc-7"] != ErrKeyExists || loadErr.Failures["doc-21"] != ErrOutOfMemory {
+		t.Fatalf("Unexpected failures reported: %v", loadErr.Failures)
+	}
+	if s.attempts["doc-7"] != 1 {
+		t.Fatalf("Expected permanent failures not to be retried, got %d attempts", s.attempts["doc-7"])
+	}
+	if s.attempts["doc-13"] != 3 {
+		t.Fatalf("Expected transient failure to be retried until success, got %d attempts", s.attempts["doc-13"])
+	}
+	if s.attempts["doc-21"] != 6 {
+		t.Fatalf("Expected transient failure to be retried 5 times, got %d attempts", s.attempts["doc-21"])
+	}
+	if maxSeen := atomic.LoadInt32(&s.maxSeen); maxSeen > 8 {
+		t.Fatalf("Expected at most 8 writes in flight, saw %d", maxSeen)
+	}
+
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	if lastProgress.Completed != 98 || lastProgress.Failed != 2 {
+		t.Fatalf("Unexpected final progress: %+v", lastProgress)
+	}
+}
+
+func TestBulkLoaderRateLimit(t *testing.T) {
+	l, _ := newTestBulkLoader(context.Background(), BulkLoaderOptions{
+		OpsPerSecond: 500,
+		BatchSize:    10,
+	}, func(string, int) error {
+		return nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 200; i++ {
+		l.Add(fmt.Sprintf("doc-%d", i), i, 0)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	// 200 writes at 500/s with a burst of 50 should take at least 300ms.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("Expected writes to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestBulkLoaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l, _ := newTestBulkLoader(ctx, BulkLoaderOptions{
+		OpsPerSecond: 100,
+		BatchSize:    50,
+	}, func(string, int) error {
+		return nil
+	})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	added := 0
+	for i := 0; i < 1000; i++ {
+		if err := l.Add(fmt.Sprintf("doc-%d", i), i, 0); err != nil {
+			if err != context.Canceled {
+				t.Fatalf("Expected context.Canceled, got %v", err)
+			}
+			break
+		}
+		added++
+	}
+
+	start := time.Now()
+	err := l.Flush()
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Expected cancelled load to stop promptly")
+	}
+
+	loadErr, ok := err.(*BulkLoadError)
+	if !ok {
+		t.Fatalf("Expected a BulkLoadError, got %v", err)
+	}
+	progress := l.Progress()
+	if progress.Completed+progress.Failed != uint64(added) || int(progress.Failed) != len(loadErr.Failures) {
+		t.Fatalf("Expected every added document to be accounted for, got %+v of %d", progress, added)
+	}
+	for key, err := range loadErr.Failures {
+		if err != context.Canceled {
+			t.Fatalf("Expected %s to fail with context.Canceled, got %v", key, err)
+		}
+	}
+}
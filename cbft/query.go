@@ -2,6 +2,7 @@ package cbft
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // FtsQuery represents an FTS query for a search query.
@@ -199,6 +200,18 @@ func (q *DateRangeQuery) End(end string, inclusive bool) *DateRangeQuery {
 	return q
 }
 
+// StartTime specifies the start time and inclusiveness for this range query, which is
+// sent in RFC 3339 format.
+func (q *DateRangeQuery) StartTime(start time.Time, inclusive bool) *DateRangeQuery {
+	return q.Start(start.Format(time.RFC3339Nano), inclusive)
+}
+
+// EndTime specifies the end time and inclusiveness for this range query, which is sent
+// in RFC 3339 format.
+func (q *DateRangeQuery) EndTime(end time.Time, inclusive bool) *DateRangeQuery {
+	return q.End(end.Format(time.RFC3339Nano), inclusive)
+}
+
 // DateTimeParser specifies which date time string parser to use.
 func (q *DateRangeQuery) DateTimeParser(parser string) *DateRangeQuery {
 	q.options["datetime_parser"] = parser
@@ -511,20 +524,40 @@ func (q *PrefixQuery) Boost(boost float32) *PrefixQuery {
 
 // MatchAllQuery represents a FTS match all query.
 type MatchAllQuery struct {
+	ftsQueryBase
 }
 
-// NewMatchAllQuery creates a new MatchAllQuery.
+// NewMatchAllQuery creates a new MatchAllQuery, which matches every document.  The
+// prefix is unused and only accepted for compatibility.
 func NewMatchAllQuery(prefix string) *MatchAllQuery {
-	return &MatchAllQuery{}
+	q := &MatchAllQuery{newFtsQueryBase()}
+	q.options["match_all"] = struct{}{}
+	return q
+}
+
+// Boost specifies the boost for this query.
+func (q *MatchAllQuery) Boost(boost float32) *MatchAllQuery {
+	q.options["boost"] = boost
+	return q
 }
 
 // MatchNoneQuery represents a FTS match none query.
 type MatchNoneQuery struct {
+	ftsQueryBase
 }
 
-// NewMatchNoneQuery creates a new MatchNoneQuery.
+// NewMatchNoneQuery creates a new MatchNoneQuery, which matches no documents.  The
+// prefix is unused and only accepted for compatibility.
 func NewMatchNoneQuery(prefix string) *MatchNoneQuery {
-	return &MatchNoneQuery{}
+	q := &MatchNoneQuery{newFtsQueryBase()}
+	q.options["match_none"] = struct{}{}
+	return q
+}
+
+// Boost specifies the boost for this query.
+func (q *MatchNoneQuery) Boost(boost float32) *MatchNoneQuery {
+	q.options["boost"] = boost
+	return q
 }
 
 // TermRangeQuery represents a FTS term range query.
@@ -532,10 +565,11 @@ type TermRangeQuery struct {
 	ftsQueryBase
 }
 
-// NewTermRangeQuery creates a new TermRangeQuery.
+// NewTermRangeQuery creates a new TermRangeQuery, matching the terms between the bounds
+// specified by Min and Max.  The term is unused and only accepted for compatibility, as
+// the query service would otherwise interpret the query as a TermQuery.
 func NewTermRangeQuery(term string) *TermRangeQuery {
 	q := &TermRangeQuery{newFtsQueryBase()}
-	q.options["term"] = term
 	return q
 }
 
@@ -614,3 +648,40 @@ func (q *GeoBoundingBoxQuery) Boost(boost float32) *GeoBoundingBoxQuery {
 	q.options["boost"] = boost
 	return q
 }
+
+// GeoPoint is a geographical location, used to specify the vertices of a
+// GeoPolygonQuery.
+type GeoPoint struct {
+	Lat float64
+	Lon float64
+}
+
+// GeoPolygonQuery represents a FTS geographical polygon query.
+type GeoPolygonQuery struct {
+	ftsQueryBase
+}
+
+// NewGeoPolygonQuery creates a new GeoPolygonQuery, which matches locations within
+// the polygon formed by joining the points in order.  This requires Couchbase
+// Server 6.5.1 or later.
+func NewGeoPolygonQuery(points []GeoPoint) *GeoPolygonQuery {
+	q := &GeoPolygonQuery{newFtsQueryBase()}
+	polygon := make([][]float64, len(points))
+	for i, point := range points {
+		polygon[i] = []float64{point.Lon, point.Lat}
+	}
+	q.options["polygon_points"] = polygon
+	return q
+}
+
+// Field specifies the field for this query.
+func (q *GeoPolygonQuery) Field(field string) *GeoPolygonQuery {
+	q.options["field"] = field
+	return q
+}
+
+// Boost specifies the boost for this query.
+func (q *GeoPolygonQuery) Boost(boost float32) *GeoPolygonQuery {
+	q.options["boost"] = boost
+	return q
+}
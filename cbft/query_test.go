@@ -0,0 +1,45 @@
+package cbft
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testMarshalQuery(t *testing.T, query FtsQuery, expected string) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatalf("Failed to marshal query: %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Expected query %s, got %s", expected, data)
+	}
+}
+
+func TestMatchAllQuery(t *testing.T) {
+	testMarshalQuery(t, NewMatchAllQuery(""), `{"match_all":{}}`)
+	testMarshalQuery(t, NewMatchAllQuery("unused").Boost(1.5), `{"boost":1.5,"match_all":{}}`)
+}
+
+func TestMatchNoneQuery(t *testing.T) {
+	testMarshalQuery(t, NewMatchNoneQuery(""), `{"match_none":{}}`)
+	testMarshalQuery(t, NewMatchNoneQuery("unused").Boost(2), `{"boost":2,"match_none":{}}`)
+}
+
+func TestTermRangeQuery(t *testing.T) {
+	testMarshalQuery(t, NewTermRangeQuery("unused"), `{}`)
+
+	query := NewTermRangeQuery("unused").Field("name").Min("a", true).Max("m", false).Boost(3)
+	testMarshalQuery(t, query, `{"boost":3,"field":"name","inclusive_max":false,"inclusive_min":true,"max":"m","min":"a"}`)
+}
+
+func TestDateRangeQueryTimes(t *testing.T) {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	end := time.Date(2018, 2, 3, 4, 5, 6, 700000000, time.FixedZone("", 2*60*60))
+
+	query := NewDateRangeQuery().StartTime(start, true).EndTime(end, false)
+	testMarshalQuery(t, query, `{"end":"2018-02-03T04:05:06.7+02:00","inclusive_end":false,"inclusive_start":true,"start":"2018-01-02T03:04:05Z"}`)
+
+	query = NewDateRangeQuery().Start("2018-01-02", false).End("2018-02-03", true)
+	testMarshalQuery(t, query, `{"end":"2018-02-03","inclusive_end":true,"inclusive_start":false,"start":"2018-01-02"}`)
+}
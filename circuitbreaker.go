@@ -0,0 +1,273 @@
+package gocb
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitBreakerConfig configures the circuit breakers which stop requests from being
+// sent to a data node, or to a view, N1QL, FTS or analytics endpoint, while too many
+// of the recent requests to it have failed.
+//
+// Each breaker counts the requests completed within its rolling window.  Once at least
+// VolumeThreshold requests have completed and ErrorThresholdPercentage of them failed
+// with network errors or timeouts, the breaker opens and further requests fail
+// immediately with ErrCircuitOpen.  After SleepWindow a single canary request is let
+// through; the breaker closes again if it succeeds and reopens if it fails.
+//
+// Experimental: This API is subject to change at any time.
+type CircuitBreakerConfig struct {
+	// Enabled specifies whether circuit breakers are used.  They are disabled by default.
+	Enabled bool
+	// VolumeThreshold is the number of requests which must complete within the rolling
+	// window before the breaker can open.  Zero uses a threshold of 20.
+	VolumeThreshold int
+	// ErrorThresholdPercentage is the percentage of the requests within the rolling
+	// window which must fail for the breaker to open.  Zero uses 50 percent.
+	ErrorThresholdPercentage float64
+	// RollingWindow is the period requests are counted over.  Zero uses 1 minute.
+	RollingWindow time.Duration
+	// SleepWindow is how long the breaker stays open before a canary request is let
+	// through, and how long the canary may take before another is.  Zero uses 5 seconds.
+	SleepWindow time.Duration
+}
+
+func (config CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if config.VolumeThreshold <= 0 {
+		config.VolumeThreshold = 20
+	}
+	if config.ErrorThresholdPercentage <= 0 {
+		config.ErrorThresholdPercentage = 50
+	}
+	if config.RollingWindow <= 0 {
+		config.RollingWindow = 1 * time.Minute
+	}
+	if config.SleepWindow <= 0 {
+		config.SleepWindow = 5 * time.Second
+	}
+	return config
+}
+
+type circuitState int
+
+const (
+	circuitClosed = circuitState(iota)
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	state       circuitState
+	windowStart time.Time
+	total       int
+	failed      int
+	openedAt    time.Time
+	canaryAt    time.Time
+}
+
+// circuitBreakers holds the circuit breakers of the nodes and endpoints of a cluster,
+// keyed by endpoint for HTTP services and by bucket and node index for data nodes.
+type circuitBreakers struct {
+	// isEnabled mirrors config.Enabled, so that operations can skip the breakers
+	// without taking the lock while they are disabled.
+	isEnabled int32
+
+	lock     sync.Mutex
+	config   CircuitBreakerConfig
+	breakers map[string]*circuitBreaker
+	now      func() time.Time
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{
+		breakers: make(map[string]*circuitBreaker),
+		now:      time.Now,
+	}
+}
+
+func (cb *circuitBreakers) enabled() bool {
+	return cb != nil && atomic.LoadInt32(&cb.isEnabled) == 1
+}
+
+// configure replaces the configuration of the breakers, closing every breaker.
+func (cb *circuitBreakers) configure(config CircuitBreakerConfig) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.config = config.withDefaults()
+	cb.breakers = make(map[string]*circuitBreaker)
+	if cb.config.Enabled {
+		atomic.StoreInt32(&cb.isEnabled, 1)
+	} else {
+		atomic.StoreInt32(&cb.isEnabled, 0)
+	}
+}
+
+func (cb *circuitBreakers) breaker(key string) *circuitBreaker {
+	breaker := cb.breakers[key]
+	if breaker == nil {
+		breaker = &circuitBreaker{windowStart: cb.now()}
+		cb.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// canary lets a canary request through an open breaker once its sleep window has
+// elapsed, or through a half open breaker whose canary has not completed in time.
+func (cb *circuitBreakers) canary(breaker *circuitBreaker) bool {
+	now := cb.now()
+	switch breaker.state {
+	case circuitOpen:
+		if now.Sub(breaker.openedAt) < cb.config.SleepWindow {
+			return false
+		}
+	case circuitHalfOpen:
+		if now.Sub(breaker.canaryAt) < cb.config.SleepWindow {
+			return false
+		}
+	default:
+		return false
+	}
+	breaker.state = circuitHalfOpen
+	breaker.canaryAt = now
+	return true
+}
+
+// allow returns whether a request may be sent to the node or endpoint identified by key.
+func (cb *circuitBreakers) allow(key string) bool {
+	if cb == nil {
+		return true
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.config.Enabled {
+		return true
+	}
+
+	breaker := cb.breaker(key)
+	return breaker.state == circuitClosed || cb.canary(breaker)
+}
+
+// available returns the endpoints whose breakers allow a request.  If any is due a
+// canary request, only that endpoint is returned so that the canary is sent to it.
+func (cb *circuitBreakers) available(eps []string) []string {
+	if cb == nil {
+		return eps
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.config.Enabled {
+		return eps
+	}
+
+	var closed []string
+	for _, ep := range eps {
+		breaker := cb.breaker(ep)
+		if breaker.state == circuitClosed {
+			closed = append(closed, ep)
+		} else if cb.canary(breaker) {
+			return []string{ep}
+		}
+	}
+	return closed
+}
+
+// record notes the outcome of a request sent to the node or endpoint identified by key.
+func (cb *circuitBreakers) record(key string, failed bool) {
+	if cb == nil {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.config.Enabled {
+		return
+	}
+
+	now := cb.now()
+	breaker := cb.breaker(key)
+	switch breaker.state {
+	case circuitHalfOpen:
+		if failed {
+			breaker.state = circuitOpen
+			breaker.openedAt = now
+			return
+		}
+		*breaker = circuitBreaker{windowStart: now}
+	case circuitClosed:
+		if now.Sub(breaker.windowStart) >= cb.config.RollingWindow {
+			*breaker = circuitBreaker{windowStart: now}
+		}
+		breaker.total++
+		if failed {
+			breaker.failed++
+		}
+		if breaker.total >= cb.config.VolumeThreshold &&
+			float64(breaker.failed)*100 >= cb.config.ErrorThresholdPercentage*float64(breaker.total) {
+			breaker.state = circuitOpen
+			breaker.openedAt = now
+		}
+	}
+}
+
+// CircuitBreakerConfig returns the configuration of the circuit breakers of the cluster.
+func (c *Cluster) CircuitBreakerConfig() CircuitBreakerConfig {
+	if c.breakers == nil {
+		return CircuitBreakerConfig{}
+	}
+	c.breakers.lock.Lock()
+	defer c.breakers.lock.Unlock()
+	return c.breakers.config
+}
+
+// SetCircuitBreakerConfig configures the circuit breakers of the cluster.  Zero fields
+// of the configuration are given their defaults.  Changing the configuration closes
+// every breaker.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetCircuitBreakerConfig(config CircuitBreakerConfig) {
+	if c.breakers == nil {
+		return
+	}
+	c.breakers.configure(config)
+}
+
+// closedEps returns the endpoints of a service whose circuit breakers allow a request,
+// or ErrCircuitOpen if eps is not empty but none of them do.
+func (c *Cluster) closedEps(eps []string) ([]string, error) {
+	if c == nil || len(eps) == 0 {
+		return eps, nil
+	}
+	allowed := c.breakers.available(eps)
+	if len(allowed) == 0 {
+		return nil, ErrCircuitOpen
+	}
+	return allowed, nil
+}
+
+// withKvCircuitBreaker performs a memcached operation on key, subject to the circuit
+// breaker of the node the key is active on.  An empty key bypasses the breakers, such as
+// for operations on replicas.
+func (b *Bucket) withKvCircuitBreaker(key string, fn func() error) error {
+	if key == "" || b.cluster == nil || !b.cluster.breakers.enabled() {
+		return fn()
+	}
+	return b.withNodeCircuitBreaker(b.bulkOpNode(key), fn)
+}
+
+// withNodeCircuitBreaker performs a memcached operation dispatched to a data node,
+// subject to the circuit breaker of the node.
+func (b *Bucket) withNodeCircuitBreaker(nodeIdx int, fn func() error) error {
+	node := kvBreakerKey(b.name, nodeIdx)
+	if !b.cluster.breakers.allow(node) {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.cluster.breakers.record(node, isNodeFailure(err))
+	return err
+}
+
+// kvBreakerKey returns the key of the circuit breaker of a data node of a bucket.
+func kvBreakerKey(bucket string, node int) string {
+	return fmt.Sprintf("kv/%s/%d", bucket, node)
+}
@@ -0,0 +1,145 @@
+package gocb
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestCircuitBreakers(now *time.Time) *circuitBreakers {
+	cb := newCircuitBreakers()
+	cb.now = func() time.Time { return *now }
+	cb.configure(CircuitBreakerConfig{
+		Enabled:         true,
+		VolumeThreshold: 4,
+		SleepWindow:     time.Second,
+	})
+	return cb
+}
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	now := time.Unix(0, 0)
+	cb := newTestCircuitBreakers(&now)
+
+	cb.record("ep", false)
+	cb.record("ep", true)
+	cb.record("ep", true)
+	if !cb.allow("ep") {
+		t.Fatalf("Expected the breaker to stay closed below the volume threshold")
+	}
+	cb.record("ep", false)
+	if cb.allow("ep") {
+		t.Fatalf("Expected the breaker to open at the error threshold")
+	}
+
+	now = now.Add(time.Second)
+	if !cb.allow("ep") {
+		t.Fatalf("Expected a canary after the sleep window")
+	}
+	if cb.allow("ep") {
+		t.Fatalf("Expected only a single canary")
+	}
+	cb.record("ep", true)
+	if cb.allow("ep") {
+		t.Fatalf("Expected a failed canary to reopen the breaker")
+	}
+
+	now = now.Add(time.Second)
+	if !cb.allow("ep") {
+		t.Fatalf("Expected another canary after the sleep window")
+	}
+	cb.record("ep", false)
+	if !cb.allow("ep") || !cb.allow("ep") {
+		t.Fatalf("Expected a successful canary to close the breaker")
+	}
+}
+
+func TestCircuitBreakerRollingWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	cb := newTestCircuitBreakers(&now)
+
+	for i := 0; i < 3; i++ {
+		cb.record("ep", true)
+	}
+	now = now.Add(time.Minute)
+	cb.record("ep", true)
+	if !cb.allow("ep") {
+		t.Fatalf("Expected failures outside the rolling window not to be counted")
+	}
+}
+
+func TestCircuitBreakerAvailableEps(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &Cluster{breakers: newTestCircuitBreakers(&now)}
+
+	for i := 0; i < 4; i++ {
+		c.recordEndpointLatency("http://a:8093", time.Millisecond, ErrTimeout)
+	}
+	eps, err := c.closedEps([]string{"http://a:8093", "http://b:8093"})
+	if err != nil || len(eps) != 1 || eps[0] != "http://b:8093" {
+		t.Fatalf("Expected only the closed endpoint, got %v (%v)", eps, err)
+	}
+	if _, err := c.closedEps([]string{"http://a:8093"}); err != ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	now = now.Add(5 * time.Second)
+	eps, _ = c.closedEps([]string{"http://a:8093", "http://b:8093"})
+	if len(eps) != 1 || eps[0] != "http://a:8093" {
+		t.Fatalf("Expected the canary to be sent to the open endpoint, got %v", eps)
+	}
+}
+
+func TestCircuitBreakerOnlyCountsEndpointFailures(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &Cluster{breakers: newTestCircuitBreakers(&now)}
+
+	for i := 0; i < 4; i++ {
+		c.recordEndpointLatency("http://a:8093", time.Millisecond, ErrNoResults)
+		c.recordEndpointLatency("http://b:8093", time.Millisecond, &url.Error{Op: "Post", URL: "http://b:8093", Err: errors.New("connection reset by peer")})
+	}
+	eps, err := c.closedEps([]string{"http://a:8093", "http://b:8093"})
+	if err != nil || len(eps) != 1 || eps[0] != "http://a:8093" {
+		t.Fatalf("Expected only the endpoint which did not respond to be opened, got %v (%v)", eps, err)
+	}
+
+	c.SetCircuitBreakerConfig(CircuitBreakerConfig{})
+	if c.breakers.enabled() {
+		t.Fatalf("Expected the breakers to be disabled")
+	}
+	if eps, _ := c.closedEps([]string{"http://b:8093"}); len(eps) != 1 {
+		t.Fatalf("Expected disabled breakers to allow every endpoint, got %v", eps)
+	}
+}
+
+func TestCircuitBreakerKv(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &Cluster{breakers: newTestCircuitBreakers(&now)}
+	b := &Bucket{cluster: c, name: "default", opTimeout: time.Second}
+
+	attempts := 0
+	for i := 0; i < 4; i++ {
+		b.withNodeCircuitBreaker(1, func() error {
+			attempts++
+			return ErrTimeout
+		})
+	}
+	err := b.withNodeCircuitBreaker(1, func() error {
+		attempts++
+		return nil
+	})
+	if err != ErrCircuitOpen || attempts != 4 {
+		t.Fatalf("Expected ErrCircuitOpen without dispatching, got %d attempts (%v)", attempts, err)
+	}
+	if err := b.withNodeCircuitBreaker(2, func() error { return nil }); err != nil {
+		t.Fatalf("Expected other nodes to be unaffected, got %v", err)
+	}
+
+	err = b.retryKv(nil, "", false, func(attempt *kvOpOptions) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected operations without a key to bypass the breakers, got %v", err)
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
 	"gopkg.in/couchbaselabs/gocbconnstr.v1"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,13 +22,44 @@ type Cluster struct {
 	n1qlTimeout      time.Duration
 	ftsTimeout       time.Duration
 	analyticsTimeout time.Duration
+	mgmtTimeout      time.Duration
+	maxValueSize     int
+	enrichedErrors   bool
+	strictStatements bool
+	retryBudget      RetryBudget
+	retryStrategy    RetryStrategy
+	keyGenerator     KeyGenerator
+	endpoints        *endpointSelector
+	breakers         *circuitBreakers
+	tracer           RequestTracer
+	connSpecOptions  map[string][]string
+	bucketOptions    bucketConnSpecOptions
 
 	clusterLock sync.RWMutex
 	queryCache  map[string]*n1qlCache
+	resultCache QueryCache
 	bucketList  []*Bucket
 	httpCli     *http.Client
+	meter       atomic.Value
+	userMeter   Meter
+	meterLock   sync.Mutex
 
 	analyticsHosts []string
+	services       clusterServices
+	n1qlBlacklist  endpointBlacklist
+	trust          *trustStore
+
+	drainLock sync.Mutex
+	drained   map[string]bool
+
+	compatLock    sync.Mutex
+	compatVersion int
+
+	closeOnce     sync.Once
+	closeErr      error
+	shutdownLock  sync.Mutex
+	shutdownHooks []func()
+	isShutdown    bool
 }
 
 // Connect creates a new Cluster object for a specific cluster.
@@ -51,6 +86,13 @@ func Connect(connSpecStr string) (*Cluster, error) {
 		ServerConnectTimeout: 7000 * time.Millisecond,
 		NmvRetryDelay:        100 * time.Millisecond,
 		UseKvErrorMaps:       true,
+		UseEnhancedErrors:    true,
+
+		UseCompression:      true,
+		CompressionMinSize:  defaultCompressionMinSize,
+		CompressionMinRatio: defaultCompressionMinRatio,
+
+		HttpMaxIdleConnsPerHost: defaultHttpMaxIdleConnsPerHost,
 	}
 	err = config.FromConnStr(connSpecStr)
 	if err != nil {
@@ -67,28 +109,137 @@ func Connect(connSpecStr string) (*Cluster, error) {
 		agentConfig: config,
 		n1qlTimeout: 75 * time.Second,
 		ftsTimeout:  75 * time.Second,
+		mgmtTimeout: 75 * time.Second,
+
+		enrichedErrors: true,
+
+		httpCli:         httpCli,
+		queryCache:      make(map[string]*n1qlCache),
+		connSpecOptions: spec.Options,
+		endpoints:       newEndpointSelector(),
+		breakers:        newCircuitBreakers(),
+		tracer:          NewThresholdLoggingTracer(),
+	}
+	httpCli.CheckRedirect = cluster.checkHttpRedirect
+
+	if config.TlsConfig != nil {
+		certPath, _ := fetchOption("certpath")
+		cluster.trust = newTrustStore(config.TlsConfig, certPath)
+	}
+
+	if err := cluster.applyConnSpecOptions(spec.Options); err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
 
-		httpCli:    httpCli,
-		queryCache: make(map[string]*n1qlCache),
+// checkHttpRedirect is used by the shared HTTP client to decide whether a redirect
+// issued by a service should be followed.  Redirects are only followed to known
+// cluster endpoints, and the credentials of the original request are re-applied
+// since net/http strips them when a redirect crosses hosts.
+func (c *Cluster) checkHttpRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxHttpRedirects {
+		return detailedError{ErrUnexpectedRedirect,
+			fmt.Sprintf("Stopped after %d redirects, the last to %s.", len(via), req.URL)}
 	}
+	if !c.isKnownHttpHost(normalizeHttpHost(req.URL.Scheme, req.URL.Host)) {
+		return detailedError{ErrUnexpectedRedirect,
+			fmt.Sprintf("Refusing to follow a redirect to %s, which is not a known cluster endpoint.", req.URL)}
+	}
+
+	if username, password, ok := via[0].BasicAuth(); ok {
+		req.SetBasicAuth(username, password)
+	}
+	return nil
+}
+
+// normalizeHttpHost returns host with the port it is contacted on, which for hosts
+// specified without a port is the default management port of the scheme, so that
+// "host" and "host:8091" compare equal.
+func normalizeHttpHost(scheme, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := csPlainHttp
+	if scheme == "https" {
+		port = csSslHttp
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(int(port)))
+}
 
-	if valStr, ok := fetchOption("n1ql_timeout"); ok {
-		val, err := strconv.ParseInt(valStr, 10, 64)
+// isKnownHttpHost returns whether host, normalized by normalizeHttpHost, is that of a
+// known cluster endpoint.
+func (c *Cluster) isKnownHttpHost(host string) bool {
+	endpointHost := func(ep string) string {
+		epUrl, err := url.Parse(ep)
 		if err != nil {
-			return nil, fmt.Errorf("n1ql_timeout option must be a number")
+			return ""
 		}
-		cluster.n1qlTimeout = time.Duration(val) * time.Millisecond
+		return normalizeHttpHost(epUrl.Scheme, epUrl.Host)
 	}
 
-	if valStr, ok := fetchOption("fts_timeout"); ok {
-		val, err := strconv.ParseInt(valStr, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("fts_timeout option must be a number")
+	addrScheme := "http"
+	if c.agentConfig.TlsConfig != nil {
+		addrScheme = "https"
+	}
+	for _, addr := range c.agentConfig.HttpAddrs {
+		if normalizeHttpHost(addrScheme, addr) == host {
+			return true
+		}
+	}
+	for _, ep := range c.analyticsHosts {
+		if endpointHost(ep) == host {
+			return true
 		}
-		cluster.ftsTimeout = time.Duration(val) * time.Millisecond
 	}
 
-	return cluster, nil
+	c.clusterLock.RLock()
+	buckets := append([]*Bucket{}, c.bucketList...)
+	c.clusterLock.RUnlock()
+	for _, bucket := range buckets {
+		var eps []string
+		eps = append(eps, bucket.client.CapiEps()...)
+		eps = append(eps, bucket.client.MgmtEps()...)
+		eps = append(eps, bucket.client.N1qlEps()...)
+		eps = append(eps, bucket.client.FtsEps()...)
+		for _, ep := range eps {
+			if endpointHost(ep) == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetryBudget returns the budget limiting the retries of each operation.
+func (c *Cluster) RetryBudget() RetryBudget {
+	return c.retryBudget
+}
+
+// SetRetryBudget sets the budget limiting the retries performed during each operation,
+// shared between every reason the operation is retried for.  The default budget has no
+// limits.
+func (c *Cluster) SetRetryBudget(budget RetryBudget) {
+	c.retryBudget = budget
+}
+
+// QueryCache returns the cache used to serve repeated N1QL and view queries, if any.
+func (c *Cluster) QueryCache() QueryCache {
+	return c.resultCache
+}
+
+// SetQueryCache sets the cache used to serve repeated N1QL and view queries.  Only
+// SELECT statements and view queries without consistency requirements are cached.
+// Passing nil disables caching, which is the default.  Lookups are recorded in the
+// metrics of the cluster, as are the evictions of a MemoryQueryCache.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetQueryCache(cache QueryCache) {
+	if memCache, ok := cache.(*MemoryQueryCache); ok {
+		memCache.onEvict.Store(c.recordQueryCacheEviction)
+	}
+	c.resultCache = cache
 }
 
 // EnhancedErrors returns the current enhanced error message state.
@@ -96,11 +247,24 @@ func (c *Cluster) EnhancedErrors() bool {
 	return c.agentConfig.UseEnhancedErrors
 }
 
-// SetEnhancedErrors sets the current enhanced error message state.
+// SetEnhancedErrors sets the current enhanced error message state.  Enhanced errors
+// carry the context and reference the server gives an error, see KvError.  They are
+// enabled by default, and apply to the buckets opened after they are set.
 func (c *Cluster) SetEnhancedErrors(enabled bool) {
 	c.agentConfig.UseEnhancedErrors = enabled
 }
 
+// EnrichedErrors returns whether errors returned by operations are wrapped in an OperationError.
+func (c *Cluster) EnrichedErrors() bool {
+	return c.enrichedErrors
+}
+
+// SetEnrichedErrors sets whether errors returned by operations are wrapped in an OperationError
+// describing the operation which failed.  This is enabled by default.
+func (c *Cluster) SetEnrichedErrors(enabled bool) {
+	c.enrichedErrors = enabled
+}
+
 // ConnectTimeout returns the maximum time to wait when attempting to connect to a bucket.
 func (c *Cluster) ConnectTimeout() time.Duration {
 	return c.agentConfig.ConnectTimeout
@@ -151,6 +315,16 @@ func (c *Cluster) SetAnalyticsTimeout(timeout time.Duration) {
 	c.analyticsTimeout = timeout
 }
 
+// ManagementTimeout returns the maximum time to wait for a management request to complete.
+func (c *Cluster) ManagementTimeout() time.Duration {
+	return c.mgmtTimeout
+}
+
+// SetManagementTimeout sets the maximum time to wait for a management request to complete.
+func (c *Cluster) SetManagementTimeout(timeout time.Duration) {
+	c.mgmtTimeout = timeout
+}
+
 // NmvRetryDelay returns the time to wait between retrying an operation due to not my vbucket.
 func (c *Cluster) NmvRetryDelay() time.Duration {
 	return c.agentConfig.NmvRetryDelay
@@ -161,6 +335,34 @@ func (c *Cluster) SetNmvRetryDelay(delay time.Duration) {
 	c.agentConfig.NmvRetryDelay = delay
 }
 
+// MaxValueSize returns the largest encoded document value, in bytes, that mutations will send to the server.
+func (c *Cluster) MaxValueSize() int {
+	if c.maxValueSize <= 0 || c.maxValueSize > maxServerValueSize {
+		return maxServerValueSize
+	}
+	return c.maxValueSize
+}
+
+// SetMaxValueSize sets the largest encoded document value, in bytes, that mutations will send
+// to the server.  Values larger than this fail with ErrTooBig before being dispatched.  Sizes
+// above the server limit of 20MB, or of zero, restore the server limit.
+func (c *Cluster) SetMaxValueSize(size int) {
+	c.maxValueSize = size
+}
+
+// HttpTransport returns the transport used for requests to the view, N1QL, FTS,
+// analytics and management services of the cluster.
+func (c *Cluster) HttpTransport() http.RoundTripper {
+	return c.httpCli.Transport
+}
+
+// SetHttpTransport sets the transport used for requests to the view, N1QL, FTS,
+// analytics and management services of the cluster, such as one wrapping HttpTransport
+// to record or modify requests.  This must be set before the cluster is used.
+func (c *Cluster) SetHttpTransport(transport http.RoundTripper) {
+	c.httpCli.Transport = transport
+}
+
 // InvalidateQueryCache forces the internal cache of prepared queries to be cleared.
 func (c *Cluster) InvalidateQueryCache() {
 	c.clusterLock.Lock()
@@ -184,6 +386,14 @@ func (c *Cluster) makeAgentConfig(bucket, username, password string, forceMt boo
 
 // Authenticate specifies an Authenticator interface to use to authenticate with cluster services.
 func (c *Cluster) Authenticate(auth Authenticator) error {
+	if certAuth, ok := auth.(CertAuthenticator); ok {
+		if c.agentConfig.TlsConfig == nil {
+			return clientError{"Certificate authentication requires a connection string which uses TLS."}
+		}
+		// The TLS configuration is shared by the memcached and HTTP clients of every
+		// bucket and by the cluster HTTP client, so each presents the certificate.
+		c.agentConfig.TlsConfig.GetClientCertificate = certAuth.clientCertificate
+	}
 	c.auth = auth
 	return nil
 }
@@ -207,6 +417,7 @@ func (c *Cluster) openBucket(bucket, password string, forceMt bool) (*Bucket, er
 	if err != nil {
 		return nil, err
 	}
+	b.applyConnSpecOptions(c.bucketOptions)
 
 	c.clusterLock.Lock()
 	c.bucketList = append(c.bucketList, b)
@@ -256,16 +467,13 @@ func (c *Cluster) Manager(username, password string) *ClusterManager {
 		}
 	}
 
-	tlsConfig := c.agentConfig.TlsConfig
 	return &ClusterManager{
-		hosts:    mgmtHosts,
-		username: userPass.Username,
-		password: userPass.Password,
-		httpCli: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-			},
-		},
+		cluster:     c,
+		hosts:       mgmtHosts,
+		username:    userPass.Username,
+		password:    userPass.Password,
+		httpCli:     c.httpCli,
+		mgmtTimeout: c.mgmtTimeout,
 	}
 }
 
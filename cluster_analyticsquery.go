@@ -2,10 +2,12 @@ package gocb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,12 +20,26 @@ func (e *analyticsError) Error() string {
 	return fmt.Sprintf("[%d] %s", e.Code, e.Message)
 }
 
+type analyticsResponseMetrics struct {
+	ElapsedTime      string `json:"elapsedTime"`
+	ExecutionTime    string `json:"executionTime"`
+	ResultCount      uint   `json:"resultCount"`
+	ResultSize       uint   `json:"resultSize"`
+	MutationCount    uint   `json:"mutationCount,omitempty"`
+	SortCount        uint   `json:"sortCount,omitempty"`
+	ErrorCount       uint   `json:"errorCount,omitempty"`
+	WarningCount     uint   `json:"warningCount,omitempty"`
+	ProcessedObjects uint   `json:"processedObjects,omitempty"`
+}
+
 type analyticsResponse struct {
-	RequestId       string            `json:"requestID"`
-	ClientContextId string            `json:"clientContextID"`
-	Results         []json.RawMessage `json:"results,omitempty"`
-	Errors          []analyticsError  `json:"errors,omitempty"`
-	Status          string            `json:"status"`
+	RequestId       string                   `json:"requestID"`
+	ClientContextId string                   `json:"clientContextID"`
+	Results         []json.RawMessage        `json:"results,omitempty"`
+	Errors          []analyticsError         `json:"errors,omitempty"`
+	Status          string                   `json:"status"`
+	Metrics         analyticsResponseMetrics `json:"metrics"`
+	Handle          string                   `json:"handle,omitempty"`
 }
 
 type analyticsMultiError []analyticsError
@@ -36,6 +52,20 @@ func (e *analyticsMultiError) Code() uint32 {
 	return (*e)[0].Code
 }
 
+// AnalyticsResultMetrics encapsulates various metrics gathered during the execution of
+// an analytics query.
+type AnalyticsResultMetrics struct {
+	ElapsedTime      time.Duration
+	ExecutionTime    time.Duration
+	ResultCount      uint
+	ResultSize       uint
+	MutationCount    uint
+	SortCount        uint
+	ErrorCount       uint
+	WarningCount     uint
+	ProcessedObjects uint
+}
+
 // AnalyticsResults allows access to the results of a Analytics query.
 type AnalyticsResults interface {
 	One(valuePtr interface{}) error
@@ -47,6 +77,35 @@ type AnalyticsResults interface {
 	ClientContextId() string
 }
 
+// AnalyticsResultMetadata allows access to the status and metrics from the analytics
+// response, and to the handle of a deferred query.  This is implemented as an additional
+// interface to maintain ABI compatibility for the 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type AnalyticsResultMetadata interface {
+	Status() string
+	Metrics() AnalyticsResultMetrics
+	// Handle returns the handle to the results of a deferred query, or nil if the
+	// query was not deferred.
+	Handle() AnalyticsDeferredResultHandle
+}
+
+// AnalyticsDeferredResultHandle allows access to the results of a deferred analytics
+// query once the server has finished running it.  Iterating over the results waits
+// for the query to finish, up to the analytics timeout.
+//
+// Experimental: This API is subject to change at any time.
+type AnalyticsDeferredResultHandle interface {
+	One(valuePtr interface{}) error
+	Next(valuePtr interface{}) bool
+	NextBytes() []byte
+	Close() error
+
+	// Status returns the status of the query on the server, such as "running" or
+	// "success".
+	Status() (string, error)
+}
+
 type analyticsResults struct {
 	closed          bool
 	index           int
@@ -54,6 +113,9 @@ type analyticsResults struct {
 	err             error
 	requestId       string
 	clientContextId string
+	status          string
+	metrics         AnalyticsResultMetrics
+	handle          AnalyticsDeferredResultHandle
 }
 
 func (r *analyticsResults) Next(valuePtr interface{}) bool {
@@ -128,9 +190,179 @@ func (r *analyticsResults) ClientContextId() string {
 	return r.clientContextId
 }
 
-func (c *Cluster) executeAnalyticsQuery(analyticsEp string, opts map[string]interface{}, timeout time.Duration, client *http.Client) (AnalyticsResults, error) {
-	reqUri := fmt.Sprintf("%s/query/service", analyticsEp)
+func (r *analyticsResults) Status() string {
+	return r.status
+}
+
+func (r *analyticsResults) Metrics() AnalyticsResultMetrics {
+	if !r.closed {
+		panic("Result must be closed before accessing meta-data")
+	}
+
+	return r.metrics
+}
+
+func (r *analyticsResults) Handle() AnalyticsDeferredResultHandle {
+	return r.handle
+}
+
+// analyticsRequest holds what is needed to send requests to an analytics endpoint.
+type analyticsRequest struct {
+	ep      string
+	creds   []userPassPair
+	timeout time.Duration
+	client  *http.Client
+}
+
+// resolve returns the URI of a handle returned by the analytics service, which may be
+// relative to the endpoint.
+func (ar *analyticsRequest) resolve(handle string) string {
+	if strings.HasPrefix(handle, "/") {
+		return ar.ep + handle
+	}
+	return handle
+}
 
+// get retrieves the JSON document at uri into valuePtr.
+func (ar *analyticsRequest) get(uri string, valuePtr interface{}) error {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	if len(ar.creds) > 0 {
+		req.SetBasicAuth(ar.creds[0].Username, ar.creds[0].Password)
+	}
+
+	resp, err := doHttpWithTimeout(ar.client, req, ar.timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
+
+	if resp.StatusCode != 200 {
+		return &viewError{
+			Message: "HTTP Error",
+			Reason:  fmt.Sprintf("Status code was %d.", resp.StatusCode),
+		}
+	}
+	return json.NewDecoder(resp.Body).Decode(valuePtr)
+}
+
+// analyticsDeferredPollBackoff calculates the wait between checks of whether a
+// deferred query has finished.
+var analyticsDeferredPollBackoff = ExponentialBackoff(10*time.Millisecond, 500*time.Millisecond, 2)
+
+type analyticsDeferredResultHandle struct {
+	request    *analyticsRequest
+	handleUri  string
+	resultsUri string
+	status     string
+	index      int
+	rows       []json.RawMessage
+	fetched    bool
+	err        error
+}
+
+func (h *analyticsDeferredResultHandle) Status() (string, error) {
+	var statusResp struct {
+		Status string `json:"status"`
+		Handle string `json:"handle"`
+	}
+	if err := h.request.get(h.handleUri, &statusResp); err != nil {
+		return "", err
+	}
+
+	h.status = statusResp.Status
+	if h.status == "success" && statusResp.Handle != "" {
+		h.resultsUri = h.request.resolve(statusResp.Handle)
+	}
+	return h.status, nil
+}
+
+// fetch waits for the query to finish and then retrieves its results.
+func (h *analyticsDeferredResultHandle) fetch() error {
+	deadline := time.Now().Add(h.request.timeout)
+	for attempt := uint32(0); h.resultsUri == ""; attempt++ {
+		status, err := h.Status()
+		if err != nil {
+			return err
+		}
+		switch status {
+		case "success":
+			if h.resultsUri == "" {
+				return &clientError{"The deferred analytics query finished without a results handle."}
+			}
+		case "queued", "running":
+			if h.request.timeout > 0 && time.Now().After(deadline) {
+				return ErrTimeout
+			}
+			time.Sleep(analyticsDeferredPollBackoff(attempt))
+		default:
+			return &clientError{fmt.Sprintf("The deferred analytics query finished with status %s.", status)}
+		}
+	}
+
+	return h.request.get(h.resultsUri, &h.rows)
+}
+
+func (h *analyticsDeferredResultHandle) Next(valuePtr interface{}) bool {
+	if h.err != nil {
+		return false
+	}
+
+	row := h.NextBytes()
+	if row == nil {
+		return false
+	}
+
+	h.err = json.Unmarshal(row, valuePtr)
+	return h.err == nil
+}
+
+func (h *analyticsDeferredResultHandle) NextBytes() []byte {
+	if h.err != nil {
+		return nil
+	}
+
+	if !h.fetched {
+		h.fetched = true
+		h.err = h.fetch()
+		if h.err != nil {
+			return nil
+		}
+	}
+
+	if h.index+1 >= len(h.rows) {
+		return nil
+	}
+	h.index++
+
+	return h.rows[h.index]
+}
+
+func (h *analyticsDeferredResultHandle) Close() error {
+	return h.err
+}
+
+func (h *analyticsDeferredResultHandle) One(valuePtr interface{}) error {
+	if !h.Next(valuePtr) {
+		err := h.Close()
+		if err != nil {
+			return err
+		}
+		return ErrNoResults
+	}
+	return nil
+}
+
+func (c *Cluster) executeAnalyticsQuery(ar *analyticsRequest, opts map[string]interface{}, priority int) (AnalyticsResults, error) {
+	reqUri := fmt.Sprintf("%s/query/service", ar.ep)
+
+	timeout := ar.timeout
 	tmostr, castok := opts["timeout"].(string)
 	if castok {
 		var err error
@@ -143,6 +375,14 @@ func (c *Cluster) executeAnalyticsQuery(analyticsEp string, opts map[string]inte
 		opts["timeout"] = timeout.String()
 	}
 
+	if clientContextId, _ := opts["client_context_id"].(string); clientContextId == "" {
+		opts["client_context_id"] = newClientContextId()
+	}
+
+	if len(ar.creds) > 1 {
+		opts["creds"] = ar.creds
+	}
+
 	reqJson, err := json.Marshal(opts)
 	if err != nil {
 		return nil, err
@@ -153,8 +393,17 @@ func (c *Cluster) executeAnalyticsQuery(analyticsEp string, opts map[string]inte
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if priority != 0 {
+		req.Header.Set("Analytics-Priority", strconv.Itoa(priority))
+	}
 
-	resp, err := doHttpWithTimeout(client, req, timeout)
+	if len(ar.creds) == 1 {
+		req.SetBasicAuth(ar.creds[0].Username, ar.creds[0].Password)
+	}
+
+	reqStart := time.Now()
+	resp, err := doHttpWithTimeout(ar.client, req, timeout)
+	c.recordEndpointLatency(ar.ep, time.Since(reqStart), err)
 	if err != nil {
 		return nil, err
 	}
@@ -182,15 +431,46 @@ func (c *Cluster) executeAnalyticsQuery(analyticsEp string, opts map[string]inte
 		}
 	}
 
-	return &analyticsResults{
+	elapsedTime, err := time.ParseDuration(analyticsResp.Metrics.ElapsedTime)
+	if err != nil {
+		logDebugf("Failed to parse elapsed time duration (%s)", err)
+	}
+
+	executionTime, err := time.ParseDuration(analyticsResp.Metrics.ExecutionTime)
+	if err != nil {
+		logDebugf("Failed to parse execution time duration (%s)", err)
+	}
+
+	results := &analyticsResults{
 		requestId:       analyticsResp.RequestId,
 		clientContextId: analyticsResp.ClientContextId,
 		index:           -1,
 		rows:            analyticsResp.Results,
-	}, nil
+		status:          analyticsResp.Status,
+		metrics: AnalyticsResultMetrics{
+			ElapsedTime:      elapsedTime,
+			ExecutionTime:    executionTime,
+			ResultCount:      analyticsResp.Metrics.ResultCount,
+			ResultSize:       analyticsResp.Metrics.ResultSize,
+			MutationCount:    analyticsResp.Metrics.MutationCount,
+			SortCount:        analyticsResp.Metrics.SortCount,
+			ErrorCount:       analyticsResp.Metrics.ErrorCount,
+			WarningCount:     analyticsResp.Metrics.WarningCount,
+			ProcessedObjects: analyticsResp.Metrics.ProcessedObjects,
+		},
+	}
+	if analyticsResp.Handle != "" {
+		results.handle = &analyticsDeferredResultHandle{
+			request:   ar,
+			handleUri: ar.resolve(analyticsResp.Handle),
+			index:     -1,
+		}
+	}
+	return results, nil
 }
 
 // EnableAnalytics allows you to specify Analytics hosts to perform queries against.
+// Queries are otherwise sent to the analytics nodes of the cluster.
 //
 // Experimental: This API is only needed temporarily until full integration of the
 // Analytics service into Couchbase Server has been completed.
@@ -198,21 +478,82 @@ func (c *Cluster) EnableAnalytics(hosts []string) {
 	c.analyticsHosts = hosts
 }
 
-// Performs a spatial query and returns a list of rows or an error.
-func (c *Cluster) doAnalyticsQuery(q *AnalyticsQuery) (AnalyticsResults, error) {
-	numHosts := len(c.analyticsHosts)
-	if numHosts == 0 {
-		return nil, fmt.Errorf("must specify analytics hosts with EnableAnalytics first")
+// Performs an analytics query and returns a list of rows or an error.
+func (c *Cluster) doAnalyticsQuery(b *Bucket, q *AnalyticsQuery) (results AnalyticsResults, errOut error) {
+	start := time.Now()
+	defer func() {
+		c.recordOperation("analytics", bucketName(b), "ExecuteAnalyticsQuery", errOut, time.Since(start))
+		c.traceQuery(context.Background(), b, "ExecuteAnalyticsQuery", "analytics", "", start, errOut)
+	}()
+	opErr := &OperationError{
+		Operation: "ExecuteAnalyticsQuery",
+	}
+	if b != nil {
+		opErr.Bucket = b.name
+	}
+	if statement, ok := q.options["statement"].(string); ok {
+		opErr.StatementHash = statementHash(statement)
+	}
+
+	ar := &analyticsRequest{
+		timeout: c.analyticsTimeout,
+		client:  c.httpCli,
+	}
+	if q.timeout > 0 {
+		ar.timeout = q.timeout
 	}
 
-	analyticsEp := c.analyticsHosts[rand.Intn(numHosts)]
+	eps := c.serviceEps(CbasService, b)
+	if len(eps) == 0 {
+		opErr.Elapsed = time.Since(start)
+		return nil, c.wrapOperationError(&clientError{"No analytics nodes are known, specify them with EnableAnalytics or open a bucket first."}, opErr)
+	}
 
-	return c.executeAnalyticsQuery(analyticsEp, q.options, c.analyticsTimeout, c.httpCli)
+	analyticsHosts := c.availableEps(eps)
+	if len(analyticsHosts) == 0 {
+		opErr.Elapsed = time.Since(start)
+		return nil, c.wrapOperationError(&clientError{"No available analytics nodes."}, opErr)
+	}
+	analyticsHosts, err := c.closedEps(analyticsHosts)
+	if err != nil {
+		opErr.Elapsed = time.Since(start)
+		return nil, c.wrapOperationError(err, opErr)
+	}
+	ar.ep = c.selectEndpoint("analytics", analyticsHosts)
+
+	if b != nil {
+		ar.client = b.httpClient()
+		if c.auth != nil {
+			ar.creds = c.auth.bucketN1ql(b.name)
+		} else {
+			ar.creds = []userPassPair{
+				{
+					Username: b.name,
+					Password: b.password,
+				},
+			}
+		}
+	} else if c.auth != nil {
+		ar.creds = c.auth.clusterN1ql()
+	}
+
+	execOpts := make(map[string]interface{})
+	for k, v := range q.options {
+		execOpts[k] = v
+	}
+
+	results, err = c.executeAnalyticsQuery(ar, execOpts, q.priority)
+	if err != nil {
+		opErr.Endpoint = ar.ep
+		opErr.Elapsed = time.Since(start)
+		return nil, c.wrapOperationError(err, opErr)
+	}
+	return results, nil
 }
 
 // ExecuteAnalyticsQuery performs an analytics query and returns a list of rows or an error.
 //
 // Experimental: This API is subject to change at any time.
 func (c *Cluster) ExecuteAnalyticsQuery(q *AnalyticsQuery) (AnalyticsResults, error) {
-	return c.doAnalyticsQuery(q)
+	return c.doAnalyticsQuery(nil, q)
 }
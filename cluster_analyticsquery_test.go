@@ -0,0 +1,162 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsQueryOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var opts map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&opts)
+		if req.Header.Get("Analytics-Priority") != "-1" {
+			t.Errorf("Expected a priority header, got %q", req.Header.Get("Analytics-Priority"))
+		}
+		if user, pass, _ := req.BasicAuth(); user != "default" || pass != "secret" {
+			t.Errorf("Expected the bucket credentials, got %s:%s", user, pass)
+		}
+		fmt.Fprintf(w, `{"requestID":"req1","clientContextID":"%s","results":[{"n":1}],"status":"success","metrics":{"elapsedTime":"2ms","executionTime":"1ms","resultCount":1,"processedObjects":7}}`,
+			opts["client_context_id"])
+	}))
+	defer server.Close()
+
+	c := &Cluster{httpCli: http.DefaultClient, analyticsTimeout: 5 * time.Second}
+	c.EnableAnalytics([]string{server.URL})
+	b := &Bucket{cluster: c, name: "default", password: "secret"}
+
+	q := NewAnalyticsQuery("SELECT 1").ClientContextId("ctx-1").Priority(true)
+	results, err := b.ExecuteAnalyticsQuery(q)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	var row map[string]int
+	if err := results.One(&row); err != nil || row["n"] != 1 {
+		t.Fatalf("Expected the row, got %v (%v)", row, err)
+	}
+	meta, ok := results.(AnalyticsResultMetadata)
+	if !ok {
+		t.Fatal("Expected the results to implement AnalyticsResultMetadata")
+	}
+	if results.ClientContextId() != "ctx-1" || results.RequestId() != "req1" || meta.Status() != "success" {
+		t.Fatalf("Unexpected identifiers %s %s %s", results.ClientContextId(), results.RequestId(), meta.Status())
+	}
+	metrics := meta.Metrics()
+	if metrics.ElapsedTime != 2*time.Millisecond || metrics.ProcessedObjects != 7 {
+		t.Fatalf("Unexpected metrics %+v", metrics)
+	}
+	if meta.Handle() != nil {
+		t.Fatal("Expected no handle for a query which was not deferred")
+	}
+}
+
+func TestAnalyticsDeferredQuery(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/query/service":
+			var opts map[string]interface{}
+			json.NewDecoder(req.Body).Decode(&opts)
+			if opts["mode"] != "async" {
+				t.Errorf("Expected an async query, got %v", opts["mode"])
+			}
+			fmt.Fprint(w, `{"requestID":"req1","status":"running","handle":"/analytics/service/status/1","metrics":{}}`)
+		case "/analytics/service/status/1":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				fmt.Fprint(w, `{"status":"running"}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","handle":"/analytics/service/result/1"}`)
+		case "/analytics/service/result/1":
+			fmt.Fprint(w, `[{"n":1},{"n":2}]`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	c := &Cluster{httpCli: http.DefaultClient, analyticsTimeout: 5 * time.Second}
+	c.EnableAnalytics([]string{server.URL})
+
+	results, err := c.ExecuteAnalyticsQuery(NewAnalyticsQuery("SELECT 1").Deferred(true))
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	meta := results.(AnalyticsResultMetadata)
+	handle := meta.Handle()
+	if handle == nil || meta.Status() != "running" {
+		t.Fatalf("Expected a handle to the running query, got %v with status %s", handle, meta.Status())
+	}
+
+	var row map[string]int
+	var ns []int
+	for handle.Next(&row) {
+		ns = append(ns, row["n"])
+	}
+	if err := handle.Close(); err != nil {
+		t.Fatalf("Failed to read deferred results: %v", err)
+	}
+	if len(ns) != 2 || ns[0] != 1 || ns[1] != 2 {
+		t.Fatalf("Unexpected rows %v", ns)
+	}
+	if atomic.LoadInt32(&polls) != 3 {
+		t.Fatalf("Expected the status to be polled until success, got %d polls", polls)
+	}
+}
+
+func TestAnalyticsDeferredQueryFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"status":"failed"}`)
+	}))
+	defer server.Close()
+
+	handle := &analyticsDeferredResultHandle{
+		request:   &analyticsRequest{ep: server.URL, timeout: time.Second, client: http.DefaultClient},
+		handleUri: server.URL + "/analytics/service/status/1",
+		index:     -1,
+	}
+	var row interface{}
+	if handle.Next(&row) {
+		t.Fatal("Expected no rows from a failed query")
+	}
+	if handle.Close() == nil {
+		t.Fatal("Expected the failure to be returned by Close")
+	}
+}
+
+func TestAnalyticsQueryTimeoutOverride(t *testing.T) {
+	sent := make(chan interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var opts map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&opts)
+		sent <- opts["timeout"]
+		fmt.Fprint(w, `{"requestID":"req1","results":[],"status":"success","metrics":{}}`)
+	}))
+	defer server.Close()
+
+	c := &Cluster{httpCli: http.DefaultClient, analyticsTimeout: 5 * time.Second}
+	c.EnableAnalytics([]string{server.URL})
+
+	results, err := c.ExecuteAnalyticsQuery(NewAnalyticsQuery("SELECT 1"))
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	results.Close()
+	if timeout := <-sent; timeout != "5s" {
+		t.Fatalf("Expected the analytics timeout of the cluster, got %v", timeout)
+	}
+
+	results, err = c.ExecuteAnalyticsQuery(NewAnalyticsQuery("SELECT 1").Timeout(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	results.Close()
+	if timeout := <-sent; timeout != "1m0s" || c.analyticsTimeout != 5*time.Second {
+		t.Fatalf("Expected the query timeout to override the cluster timeout for the query only, got %v", timeout)
+	}
+}
@@ -2,9 +2,15 @@ package gocb
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -14,8 +20,10 @@ type n1qlCache struct {
 }
 
 type n1qlError struct {
-	Code    uint32 `json:"code"`
-	Message string `json:"msg"`
+	Code    uint32          `json:"code"`
+	Message string          `json:"msg"`
+	Cause   json.RawMessage `json:"cause,omitempty"`
+	Reason  json.RawMessage `json:"reason,omitempty"`
 }
 
 func (e *n1qlError) Error() string {
@@ -40,6 +48,7 @@ type n1qlResponse struct {
 	Errors          []n1qlError         `json:"errors,omitempty"`
 	Status          string              `json:"status"`
 	Metrics         n1qlResponseMetrics `json:"metrics"`
+	Profile         json.RawMessage     `json:"profile,omitempty"`
 }
 
 type n1qlMultiError []n1qlError
@@ -62,9 +71,14 @@ type QueryResultMetrics struct {
 	SortCount     uint
 	ErrorCount    uint
 	WarningCount  uint
+	// Profile is the profiling information returned by the query service, if it was
+	// requested using the Profile method of N1qlQuery.
+	Profile json.RawMessage
 }
 
-// QueryResults allows access to the results of a N1QL query.
+// QueryResults allows access to the results of a N1QL query.  Rows are read from the
+// response as they are iterated over rather than being held in memory, and metrics
+// become available once the results have been closed.
 type QueryResults interface {
 	One(valuePtr interface{}) error
 	Next(valuePtr interface{}) bool
@@ -76,14 +90,36 @@ type QueryResults interface {
 	Metrics() QueryResultMetrics
 }
 
+// ResultMetadataErr allows callers to check whether the metadata of query or view
+// results was received.  Err returns ErrAborted if One aborted the response before its
+// metadata was received, in which case the metadata accessors return zero values.  This
+// is implemented as an additional interface to maintain ABI compatibility for the 1.x
+// series.
+//
+// Experimental: This API is subject to change at any time.
+type ResultMetadataErr interface {
+	Err() error
+}
+
 type n1qlResults struct {
 	closed          bool
 	index           int
 	rows            []json.RawMessage
 	err             error
+	endErr          error
 	requestId       string
 	clientContextId string
 	metrics         QueryResultMetrics
+	cached          bool
+	onClose         func()
+	projection      *rowProjection
+	stream          *resultStream
+	complete        func(stream *resultStream) error
+	// retainRows indicates rows read from the stream are kept once they have been
+	// iterated over, so that the results can be stored in the QueryCache.
+	retainRows bool
+	onAbort    func()
+	aborted    bool
 }
 
 func (r *n1qlResults) Next(valuePtr interface{}) bool {
@@ -109,20 +145,100 @@ func (r *n1qlResults) NextBytes() []byte {
 		return nil
 	}
 
+	if r.index+1 >= len(r.rows) && r.stream != nil {
+		if !r.retainRows {
+			r.rows = r.rows[:0]
+			r.index = -1
+		}
+		row, err := r.stream.nextRow()
+		if err != nil {
+			r.stream.abort()
+			r.stream = nil
+			r.err = err
+			return nil
+		}
+		if row != nil {
+			r.rows = append(r.rows, row)
+		} else {
+			r.completeStream()
+		}
+	}
+
 	if r.index+1 >= len(r.rows) {
 		r.closed = true
+		r.err = r.endErr
 		return nil
 	}
 	r.index++
 
+	if r.projection != nil {
+		var row []byte
+		row, r.err = r.projection.project(r.rows[r.index])
+		return row
+	}
 	return r.rows[r.index]
 }
 
+// finishStream reads the rest of the response, discarding the rows which were not
+// iterated over unless they are to be retained.
+func (r *n1qlResults) finishStream() error {
+	for {
+		row, err := r.stream.nextRow()
+		if err != nil {
+			r.stream.abort()
+			r.stream = nil
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if r.retainRows {
+			r.rows = append(r.rows, row)
+		}
+	}
+	r.completeStream()
+	return nil
+}
+
+// completeStream decodes the fields which followed the rows of a response which has
+// been read in full.  Any errors which the query service reported after the rows are
+// returned once the rows have been iterated.
+func (r *n1qlResults) completeStream() {
+	stream := r.stream
+	r.stream = nil
+	r.endErr = r.complete(stream)
+}
+
 func (r *n1qlResults) Close() error {
+	if r.stream != nil {
+		if r.err == nil {
+			r.err = r.finishStream()
+		} else {
+			r.stream.abort()
+			r.stream = nil
+		}
+	}
+	if r.err == nil {
+		r.err = r.endErr
+	}
+
 	r.closed = true
+	if r.err == nil && r.onClose != nil {
+		r.onClose()
+		r.onClose = nil
+	}
 	return r.err
 }
 
+// Cached returns whether these results were served from the cluster's QueryCache.
+func (r *n1qlResults) Cached() bool {
+	return r.cached
+}
+
+// One decodes the first row of the results and closes them.  If the query service is
+// still sending the results, the response is aborted so that no further rows are
+// transferred, and the query is cancelled on the server.  Metrics are not available
+// for aborted results, so Metrics returns zero values and Err returns ErrAborted.
 func (r *n1qlResults) One(valuePtr interface{}) error {
 	if !r.Next(valuePtr) {
 		err := r.Close()
@@ -132,6 +248,18 @@ func (r *n1qlResults) One(valuePtr interface{}) error {
 		return ErrNoResults
 	}
 
+	if r.stream != nil {
+		r.stream.abort()
+		r.stream = nil
+		r.aborted = true
+		r.closed = true
+		r.onClose = nil
+		if r.onAbort != nil {
+			r.onAbort()
+		}
+		return nil
+	}
+
 	// Ignore any errors occurring after we already have our result
 	err := r.Close()
 	if err != nil {
@@ -162,15 +290,85 @@ func (r *n1qlResults) Metrics() QueryResultMetrics {
 	if !r.closed {
 		panic("Result must be closed before accessing meta-data")
 	}
+	if r.aborted {
+		return QueryResultMetrics{}
+	}
 
 	return r.metrics
 }
 
+// Err returns ErrAborted if One aborted the response before its metrics were received.
+func (r *n1qlResults) Err() error {
+	if r.aborted {
+		return ErrAborted
+	}
+	return nil
+}
+
+// newClientContextId generates a random client context id, which identifies a query
+// to the query service so that it can be cancelled.
+func newClientContextId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// The longest query string with which a readonly query is sent using a GET request.
+// Longer queries are sent in the body of a POST request instead.
+var n1qlMaxGetQueryLength = 4096
+
+// newN1qlRequest creates the request for a N1QL query.  Readonly queries are sent as
+// GET requests with their options in the query string, as the query service only
+// allows readonly statements to be executed that way, so that they can be safely sent
+// again.  Statements are prepared using POST requests, as preparing one is not itself
+// readonly.  Queries carrying credentials are also sent using POST requests, so that
+// passwords do not appear in URLs, which are logged by proxies and included in errors.
+// Strings are sent as they are, except for parameters, which like every other value
+// are encoded as JSON.
+func newN1qlRequest(reqUri string, opts map[string]interface{}) (*http.Request, error) {
+	statement, _ := opts["statement"].(string)
+	readOnly, _ := opts["readonly"].(bool)
+	_, hasCreds := opts["creds"]
+	if readOnly && !hasCreds && !strings.HasPrefix(statement, "PREPARE ") {
+		values := url.Values{}
+		for name, value := range opts {
+			if str, ok := value.(string); ok && !strings.HasPrefix(name, "$") {
+				values.Set(name, str)
+				continue
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			values.Set(name, string(data))
+		}
+		if query := values.Encode(); len(query) <= n1qlMaxGetQueryLength {
+			return http.NewRequest("GET", reqUri+"?"+query, nil)
+		}
+	}
+
+	reqJson, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", reqUri, bytes.NewBuffer(reqJson))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
 // Executes the N1QL query (in opts) on the server n1qlEp.
 // This function assumes that `opts` already contains all the required
 // settings. This function will inject any additional connection or request-level
-// settings into the `opts` map (currently this is only the timeout).
-func (c *Cluster) executeN1qlQuery(n1qlEp string, opts map[string]interface{}, creds []userPassPair, timeout time.Duration, client *http.Client) (QueryResults, error) {
+// settings into the `opts` map (currently the timeout and client context id).
+// The response is only read up to its first row before the results are returned,
+// with the remainder being read as the results are iterated.
+func (c *Cluster) executeN1qlQuery(ctx context.Context, n1qlEp string, opts map[string]interface{}, creds []userPassPair, timeout time.Duration, client *http.Client, tracker *retryTracker) (QueryResults, error) {
 	reqUri := fmt.Sprintf("%s/query/service", n1qlEp)
 
 	tmostr, castok := opts["timeout"].(string)
@@ -180,74 +378,103 @@ func (c *Cluster) executeN1qlQuery(n1qlEp string, opts map[string]interface{}, c
 		if err != nil {
 			return nil, err
 		}
+
+		// A timeout set on the query cannot extend beyond the deadline of ctx.
+		if ctxTimeout := contextTimeout(ctx, timeout); ctxTimeout != timeout {
+			timeout = ctxTimeout
+			opts["timeout"] = timeout.String()
+		}
 	} else {
 		// Set the timeout string to its default variant
 		opts["timeout"] = timeout.String()
 	}
 
-	if len(creds) > 1 {
-		opts["creds"] = creds
+	clientContextId, _ := opts["client_context_id"].(string)
+	if clientContextId == "" {
+		clientContextId = newClientContextId()
+		opts["client_context_id"] = clientContextId
 	}
 
-	reqJson, err := json.Marshal(opts)
-	if err != nil {
-		return nil, err
+	if len(creds) > 1 {
+		opts["creds"] = creds
 	}
 
-	req, err := http.NewRequest("POST", reqUri, bytes.NewBuffer(reqJson))
+	req, err := newN1qlRequest(reqUri, opts)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	if len(creds) == 1 {
 		req.SetBasicAuth(creds[0].Username, creds[0].Password)
 	}
 
-	resp, err := doHttpWithTimeout(client, req, timeout)
+	trace := newN1qlRequestTrace()
+	reqCtx, cancel := context.WithCancel(ctx)
+	req = req.WithContext(httptrace.WithClientTrace(reqCtx, trace.clientTrace()))
+
+	reqStart := time.Now()
+	resp, err := c.doHttpWithRetry(tracker, c.retryStrategy, client, req, timeout)
+	if err != nil && ctx.Err() != nil {
+		cancel()
+		go c.cancelN1qlQuery(n1qlEp, clientContextId, creds, c.n1qlTimeout, client)
+		return nil, ctx.Err()
+	}
+	c.recordEndpointLatency(n1qlEp, time.Since(reqStart), err)
 	if err != nil {
+		cancel()
+		if timeoutErr := trace.classifyClientTimeout(err, timeout); timeoutErr != nil {
+			return nil, timeoutErr
+		}
 		return nil, err
 	}
 
-	n1qlResp := n1qlResponse{}
-	jsonDec := json.NewDecoder(resp.Body)
-	err = jsonDec.Decode(&n1qlResp)
+	stream := newResultStream(resp.Body, cancel, "results")
+	err = stream.readFirstRow()
 	if err != nil {
+		stream.abort()
 		return nil, err
 	}
 
-	err = resp.Body.Close()
-	if err != nil {
-		logDebugf("Failed to close socket (%s)", err)
+	results := &n1qlResults{
+		index: -1,
+		rows:  stream.rows,
 	}
+	complete := func(stream *resultStream) error {
+		n1qlResp := n1qlResponse{}
+		err := stream.decodeFields(&n1qlResp)
+		if err != nil {
+			return err
+		}
 
-	if len(n1qlResp.Errors) > 0 {
-		return nil, (*n1qlMultiError)(&n1qlResp.Errors)
-	}
+		if len(n1qlResp.Errors) > 0 {
+			if timeoutErr := classifyN1qlTimeout(n1qlResp.Errors, n1qlResp.Status); timeoutErr != nil {
+				timeoutErr.Timeout = timeout
+				timeoutErr.Elapsed = time.Since(trace.start)
+				return timeoutErr
+			}
+			return (*n1qlMultiError)(&n1qlResp.Errors)
+		}
 
-	if resp.StatusCode != 200 {
-		return nil, &viewError{
-			Message: "HTTP Error",
-			Reason:  fmt.Sprintf("Status code was %d.", resp.StatusCode),
+		if resp.StatusCode != 200 {
+			return &viewError{
+				Message: "HTTP Error",
+				Reason:  fmt.Sprintf("Status code was %d.", resp.StatusCode),
+			}
 		}
-	}
 
-	elapsedTime, err := time.ParseDuration(n1qlResp.Metrics.ElapsedTime)
-	if err != nil {
-		logDebugf("Failed to parse elapsed time duration (%s)", err)
-	}
+		elapsedTime, err := time.ParseDuration(n1qlResp.Metrics.ElapsedTime)
+		if err != nil {
+			logDebugf("Failed to parse elapsed time duration (%s)", err)
+		}
 
-	executionTime, err := time.ParseDuration(n1qlResp.Metrics.ExecutionTime)
-	if err != nil {
-		logDebugf("Failed to parse execution time duration (%s)", err)
-	}
+		executionTime, err := time.ParseDuration(n1qlResp.Metrics.ExecutionTime)
+		if err != nil {
+			logDebugf("Failed to parse execution time duration (%s)", err)
+		}
 
-	return &n1qlResults{
-		requestId:       n1qlResp.RequestId,
-		clientContextId: n1qlResp.ClientContextId,
-		index:           -1,
-		rows:            n1qlResp.Results,
-		metrics: QueryResultMetrics{
+		results.requestId = n1qlResp.RequestId
+		results.clientContextId = n1qlResp.ClientContextId
+		results.metrics = QueryResultMetrics{
 			ElapsedTime:   elapsedTime,
 			ExecutionTime: executionTime,
 			ResultCount:   n1qlResp.Metrics.ResultCount,
@@ -256,27 +483,76 @@ func (c *Cluster) executeN1qlQuery(n1qlEp string, opts map[string]interface{}, c
 			SortCount:     n1qlResp.Metrics.SortCount,
 			ErrorCount:    n1qlResp.Metrics.ErrorCount,
 			WarningCount:  n1qlResp.Metrics.WarningCount,
-		},
-	}, nil
+			Profile:       n1qlResp.Profile,
+		}
+		return nil
+	}
+
+	if stream.done {
+		err = complete(stream)
+		if err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	// The identifiers of the query precede its results, so are already available.
+	prefix := n1qlResponse{}
+	err = stream.decodeFields(&prefix)
+	if err != nil {
+		stream.abort()
+		return nil, err
+	}
+	results.requestId = prefix.RequestId
+	results.clientContextId = prefix.ClientContextId
+	results.stream = stream
+	results.complete = complete
+	results.onAbort = func() {
+		go c.cancelN1qlQuery(n1qlEp, clientContextId, creds, timeout, client)
+	}
+	return results, nil
 }
 
-func (c *Cluster) prepareN1qlQuery(n1qlEp string, opts map[string]interface{}, creds []userPassPair, timeout time.Duration, client *http.Client) (*n1qlCache, error) {
+// cancelN1qlQuery makes a best-effort attempt to stop the query with the specified
+// client context id from running on the query service.
+func (c *Cluster) cancelN1qlQuery(n1qlEp, clientContextId string, creds []userPassPair, timeout time.Duration, client *http.Client) {
+	opts := map[string]interface{}{
+		"statement": "DELETE FROM system:active_requests WHERE clientContextID = $1",
+		"args":      []interface{}{clientContextId},
+	}
+	results, err := c.executeN1qlQuery(context.Background(), n1qlEp, opts, creds, timeout, client, nil)
+	if err == nil {
+		err = results.Close()
+	}
+	if err != nil {
+		logDebugf("Failed to cancel query %s (%s)", clientContextId, err)
+	}
+}
+
+func (c *Cluster) prepareN1qlQuery(ctx context.Context, n1qlEp string, opts map[string]interface{}, creds []userPassPair, timeout time.Duration, client *http.Client, tracker *retryTracker) (*n1qlCache, error) {
 	prepOpts := make(map[string]interface{})
 	for k, v := range opts {
 		prepOpts[k] = v
 	}
 	prepOpts["statement"] = "PREPARE " + opts["statement"].(string)
 
-	prepRes, err := c.executeN1qlQuery(n1qlEp, prepOpts, creds, timeout, client)
+	prepRes, err := c.executeN1qlQuery(ctx, n1qlEp, prepOpts, creds, timeout, client, tracker)
 	if err != nil {
 		return nil, err
 	}
 
+	// The results are read in full rather than using One, which would abort the
+	// response and cancel the statement on the server.
 	var preped n1qlPrepData
-	err = prepRes.One(&preped)
-	if err != nil {
+	if !prepRes.Next(&preped) {
+		err = prepRes.Close()
+		if err == nil {
+			err = ErrNoResults
+		}
 		return nil, err
 	}
+	// Ignore any errors occurring after we already have our result
+	prepRes.Close()
 
 	return &n1qlCache{
 		name:        preped.Name,
@@ -290,25 +566,62 @@ type n1qlPrepData struct {
 }
 
 // Performs a spatial query and returns a list of rows or an error.
-func (c *Cluster) doN1qlQuery(b *Bucket, q *N1qlQuery, params interface{}) (QueryResults, error) {
+func (c *Cluster) doN1qlQuery(ctx context.Context, b *Bucket, q *N1qlQuery, params interface{}) (results QueryResults, errOut error) {
 	var err error
 	var n1qlEp string
+
+	start := time.Now()
+	tracker := newClusterRetryTracker(c)
+	defer func() {
+		c.recordOperation("n1ql", bucketName(b), "ExecuteN1qlQuery", errOut, time.Since(start))
+		c.traceQuery(ctx, b, "ExecuteN1qlQuery", "n1ql", n1qlEp, start, errOut)
+		if errOut != nil {
+			opErr := &OperationError{
+				Operation:   "ExecuteN1qlQuery",
+				Endpoint:    n1qlEp,
+				Elapsed:     time.Since(start),
+				RetryReport: tracker.retryReport(),
+			}
+			if b != nil {
+				opErr.Bucket = b.name
+			}
+			if statement, ok := q.options["statement"].(string); ok {
+				opErr.StatementHash = statementHash(statement)
+			}
+			errOut = c.wrapOperationError(errOut, opErr)
+		}
+	}()
+
+	if q.err != nil {
+		return nil, q.err
+	}
+	params, err = normalizeN1qlParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if c.strictStatements {
+		if statement, ok := q.options["statement"].(string); ok {
+			if err := checkN1qlStatement(statement, params); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var timeout time.Duration
 	var client *http.Client
 	var creds []userPassPair
 
+	var getN1qlEp func() (string, error)
+
 	if b != nil {
-		n1qlEp, err = b.getN1qlEp()
-		if err != nil {
-			return nil, err
-		}
+		getN1qlEp = b.getN1qlEp
 
 		if b.n1qlTimeout < c.n1qlTimeout {
 			timeout = b.n1qlTimeout
 		} else {
 			timeout = c.n1qlTimeout
 		}
-		client = b.client.HttpClient()
+		client = b.httpClient()
 		if c.auth != nil {
 			creds = c.auth.bucketN1ql(b.name)
 		} else {
@@ -324,43 +637,153 @@ func (c *Cluster) doN1qlQuery(b *Bucket, q *N1qlQuery, params interface{}) (Quer
 			panic("Cannot perform cluster level queries without Cluster Authenticator.")
 		}
 
-		tmpB, err := c.randomBucket()
-		if err != nil {
-			return nil, err
-		}
-
-		n1qlEp, err = tmpB.getN1qlEp()
-		if err != nil {
-			return nil, err
+		// Without an open bucket, the query nodes are discovered from the management
+		// service instead, so that cross-bucket queries do not need one.
+		if tmpB, err := c.randomBucket(); err == nil {
+			getN1qlEp = tmpB.getN1qlEp
+			client = tmpB.httpClient()
+		} else {
+			getN1qlEp = c.getClusterN1qlEp
+			client = c.httpCli
 		}
 
 		timeout = c.n1qlTimeout
-		client = tmpB.client.HttpClient()
 		creds = c.auth.clusterN1ql()
 	}
 
+	n1qlEp, err = getN1qlEp()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timeout = contextTimeout(ctx, timeout)
+
 	execOpts := make(map[string]interface{})
 	for k, v := range q.options {
 		execOpts[k] = v
 	}
-	if params != nil {
-		args, isArray := params.([]interface{})
-		if isArray {
-			execOpts["args"] = args
-		} else {
-			mapArgs, isMap := params.(map[string]interface{})
-			if isMap {
-				for key, value := range mapArgs {
-					execOpts["$"+key] = value
+	switch args := params.(type) {
+	case []interface{}:
+		execOpts["args"] = args
+	case map[string]interface{}:
+		for key, value := range args {
+			execOpts["$"+key] = value
+		}
+	}
+	if err := checkN1qlParams(execOpts); err != nil {
+		return nil, err
+	}
+
+	var bucketName string
+	if b != nil {
+		bucketName = b.name
+	}
+
+	queryCache := c.resultCache
+	cacheKey, cacheable := "", false
+	if queryCache != nil {
+		cacheKey, cacheable = n1qlQueryCacheKey(bucketName, execOpts)
+	}
+	if cacheable {
+		if cached, ok := c.lookupQueryCache(queryCache, "n1ql", bucketName, cacheKey); ok {
+			return &n1qlResults{
+				requestId:       cached.RequestId,
+				clientContextId: cached.ClientContextId,
+				index:           -1,
+				rows:            cached.Rows,
+				metrics:         cached.Metrics,
+				cached:          true,
+				projection:      q.projection,
+			}, nil
+		}
+	}
+
+	// Queries which could not be sent as the node could not be connected to are sent to
+	// another node.  The first node cannot have received the query, so this is safe for
+	// any statement.  Every attempt shares the timeout of the query.
+	dispatchStart := time.Now()
+	tried := map[string]bool{n1qlEp: true}
+	var pendingEp string
+	err = retryWithTracker(ctx, tracker, func() error {
+		if pendingEp != "" {
+			tried[pendingEp] = true
+			n1qlEp, pendingEp = pendingEp, ""
+		}
+
+		remaining := timeout
+		if timeout > 0 {
+			remaining = timeout - time.Since(dispatchStart)
+			if remaining <= 0 {
+				if err == nil {
+					return ErrTimeout
 				}
-			} else {
-				panic("Invalid params argument passed")
+				return err
 			}
 		}
+		results, err = c.dispatchN1qlQuery(ctx, q, n1qlEp, execOpts, creds, remaining, client, tracker)
+		return err
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		if !isConnectError(err) {
+			return "", 0, false
+		}
+		c.blacklistN1qlEp(n1qlEp)
+
+		nextEp, epErr := getN1qlEp()
+		if epErr != nil || tried[nextEp] {
+			return "", 0, false
+		}
+		pendingEp = nextEp
+		return "n1ql_connect", 0, true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n1qlRes, ok := results.(*n1qlResults)
+	if ok {
+		n1qlRes.projection = q.projection
+	}
+	if ok && cacheable {
+		n1qlRes.retainRows = true
+		n1qlRes.onClose = func() {
+			queryCache.Set(cacheKey, &CachedQueryResult{
+				Rows:            n1qlRes.rows,
+				RequestId:       n1qlRes.requestId,
+				ClientContextId: n1qlRes.clientContextId,
+				Metrics:         n1qlRes.metrics,
+			})
+		}
 	}
+	return results, nil
+}
+
+// n1qlPlanKey is the key of the prepared statement cache entry holding the plan of a
+// statement prepared on a particular query node.  Plans are cached per node, as each
+// node only knows of the statements which were prepared on it.
+func n1qlPlanKey(n1qlEp, statement string) string {
+	return n1qlEp + " " + statement
+}
+
+// isN1qlReprepareCode returns whether a query which failed with the specified code
+// when executing a cached plan should be prepared again.  These codes indicate that
+// the node does not know of the prepared statement, could not decode its plan, or
+// could not use an index the plan refers to, such as one which was rebuilt.
+func isN1qlReprepareCode(code uint32) bool {
+	switch code {
+	case 4040, 4050, 4070, 5000, 12016:
+		return true
+	}
+	return false
+}
 
+// dispatchN1qlQuery sends a N1QL query to the server, preparing it first when the
+// query is not adhoc.
+func (c *Cluster) dispatchN1qlQuery(ctx context.Context, q *N1qlQuery, n1qlEp string, execOpts map[string]interface{}, creds []userPassPair, timeout time.Duration, client *http.Client, tracker *retryTracker) (QueryResults, error) {
 	if q.adHoc {
-		return c.executeN1qlQuery(n1qlEp, execOpts, creds, timeout, client)
+		return c.executeN1qlQuery(ctx, n1qlEp, execOpts, creds, timeout, client, tracker)
 	}
 
 	// Do Prepared Statement Logic
@@ -370,9 +793,10 @@ func (c *Cluster) doN1qlQuery(b *Bucket, q *N1qlQuery, params interface{}) (Quer
 	if !isStr {
 		return nil, ErrCliInternalError
 	}
+	planKey := n1qlPlanKey(n1qlEp, stmtStr)
 
 	c.clusterLock.RLock()
-	cachedStmt = c.queryCache[stmtStr]
+	cachedStmt = c.queryCache[planKey]
 	c.clusterLock.RUnlock()
 
 	if cachedStmt != nil {
@@ -381,31 +805,42 @@ func (c *Cluster) doN1qlQuery(b *Bucket, q *N1qlQuery, params interface{}) (Quer
 		execOpts["prepared"] = cachedStmt.name
 		execOpts["encoded_plan"] = cachedStmt.encodedPlan
 
-		results, err := c.executeN1qlQuery(n1qlEp, execOpts, creds, timeout, client)
+		results, err := c.executeN1qlQuery(ctx, n1qlEp, execOpts, creds, timeout, client, tracker)
 		if err == nil {
 			return results, nil
 		}
 
-		// If we get error 4050, 4070 or 5000, we should attempt
+		// If the cached plan could not be used, we should attempt
 		//   to reprepare the statement immediately before failing.
 		n1qlErr, isN1qlErr := err.(*n1qlMultiError)
 		if !isN1qlErr {
 			return nil, err
 		}
-		if n1qlErr.Code() != 4050 && n1qlErr.Code() != 4070 && n1qlErr.Code() != 5000 {
+		if !isN1qlReprepareCode(n1qlErr.Code()) {
+			return nil, err
+		}
+
+		c.clusterLock.Lock()
+		if c.queryCache[planKey] == cachedStmt {
+			delete(c.queryCache, planKey)
+		}
+		c.clusterLock.Unlock()
+
+		if !tracker.allow("n1ql_reprepare", 0) {
 			return nil, err
 		}
+		logDebugf("Preparing statement again on %s after error %d", redactSystemData(n1qlEp), n1qlErr.Code())
 	}
 
 	// Prepare the query
-	cachedStmt, err = c.prepareN1qlQuery(n1qlEp, q.options, creds, timeout, client)
+	cachedStmt, err := c.prepareN1qlQuery(ctx, n1qlEp, q.options, creds, timeout, client, tracker)
 	if err != nil {
 		return nil, err
 	}
 
 	// Save new cached statement
 	c.clusterLock.Lock()
-	c.queryCache[stmtStr] = cachedStmt
+	c.queryCache[planKey] = cachedStmt
 	c.clusterLock.Unlock()
 
 	// Update with new prepared data
@@ -413,10 +848,12 @@ func (c *Cluster) doN1qlQuery(b *Bucket, q *N1qlQuery, params interface{}) (Quer
 	execOpts["prepared"] = cachedStmt.name
 	execOpts["encoded_plan"] = cachedStmt.encodedPlan
 
-	return c.executeN1qlQuery(n1qlEp, execOpts, creds, timeout, client)
+	return c.executeN1qlQuery(ctx, n1qlEp, execOpts, creds, timeout, client, tracker)
 }
 
-// ExecuteN1qlQuery performs a n1ql query and returns a list of rows or an error.
+// ExecuteN1qlQuery performs a n1ql query using the cluster credentials and returns a
+// list of rows or an error.  No bucket needs to be open, so queries may join any of the
+// buckets the credentials allow access to.
 func (c *Cluster) ExecuteN1qlQuery(q *N1qlQuery, params interface{}) (QueryResults, error) {
-	return c.doN1qlQuery(nil, q, params)
+	return c.doN1qlQuery(context.Background(), nil, q, params)
 }
@@ -2,6 +2,7 @@ package gocb
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gopkg.in/couchbaselabs/jsonx.v1"
@@ -42,11 +43,12 @@ type SearchResultNumericFacet struct {
 	Count int     `json:"count,omitempty"`
 }
 
-// SearchResultDateFacet holds the results of a date facet in search results.
+// SearchResultDateFacet holds the results of a date facet in search results.  Min and
+// Max hold the start and end of the range.
 type SearchResultDateFacet struct {
 	Name  string `json:"name,omitempty"`
-	Min   string `json:"min,omitempty"`
-	Max   string `json:"max,omitempty"`
+	Min   string `json:"start,omitempty"`
+	Max   string `json:"end,omitempty"`
 	Count int    `json:"count,omitempty"`
 }
 
@@ -62,10 +64,20 @@ type SearchResultFacet struct {
 }
 
 // SearchResultStatus holds the status information for an executed search query.
+// Total, Failed and Successful count the index partitions the query was performed
+// against, and Errors holds the error of each partition which failed.
 type SearchResultStatus struct {
-	Total      int `json:"total,omitempty"`
-	Failed     int `json:"failed,omitempty"`
-	Successful int `json:"successful,omitempty"`
+	Total      int               `json:"total,omitempty"`
+	Failed     int               `json:"failed,omitempty"`
+	Successful int               `json:"successful,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// Partial returns whether some index partitions failed to answer the query, meaning
+// the results are incomplete.  Callers may prefer to retry the query rather than use
+// such results, particularly when a large proportion of partitions failed.
+func (s SearchResultStatus) Partial() bool {
+	return s.Failed > 0
 }
 
 // SearchResults allows access to the results of a search query.
@@ -79,6 +91,15 @@ type SearchResults interface {
 	MaxScore() float64
 }
 
+// SearchResultDuplicates allows access to the number of hits dropped by DeduplicateHits.
+// This is implemented as an additional interface to maintain ABI compatibility for the
+// 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type SearchResultDuplicates interface {
+	DuplicateHits() int
+}
+
 type searchResponse struct {
 	Status    SearchResultStatus           `json:"status,omitempty"`
 	Errors    []string                     `json:"errors,omitempty"`
@@ -90,7 +111,8 @@ type searchResponse struct {
 }
 
 type searchResults struct {
-	data *searchResponse
+	data       *searchResponse
+	duplicates int
 }
 
 func (r searchResults) Status() SearchResultStatus {
@@ -115,10 +137,91 @@ func (r searchResults) MaxScore() float64 {
 	return r.data.MaxScore
 }
 
-// Performs a spatial query and returns a list of rows or an error.
-func (c *Cluster) doSearchQuery(b *Bucket, q *SearchQuery) (SearchResults, error) {
+// DuplicateHits returns the number of hits which were dropped from the results as
+// duplicates of earlier hits, when DeduplicateHits was specified for the query.
+func (r searchResults) DuplicateHits() int {
+	return r.duplicates
+}
+
+// deduplicateSearchHits removes hits for documents which have already been hit,
+// keeping the first and so highest ranked, and returns the number removed.
+func deduplicateSearchHits(hits []SearchResultHit) ([]SearchResultHit, int) {
+	seen := make(map[string]struct{}, len(hits))
+	unique := hits[:0]
+	for _, hit := range hits {
+		if _, ok := seen[hit.Id]; ok {
+			continue
+		}
+		seen[hit.Id] = struct{}{}
+		unique = append(unique, hit)
+	}
+	return unique, len(hits) - len(unique)
+}
+
+// Performs a search query and returns its results or an error.
+func (c *Cluster) doSearchQuery(ctx context.Context, b *Bucket, q *SearchQuery) (SearchResults, error) {
+	var results SearchResults
+	err := c.executeSearchQuery(ctx, b, q, func(resp *http.Response, cancel context.CancelFunc) error {
+		defer cancel()
+
+		ftsResp := searchResponse{}
+		jsonDec := json.NewDecoder(resp.Body)
+		err := jsonDec.Decode(&ftsResp)
+		if err != nil {
+			return err
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+
+		logPartialSearchResults(q, ftsResp.Status)
+
+		searchRes := searchResults{
+			data: &ftsResp,
+		}
+		if q.deduplicate {
+			ftsResp.Hits, searchRes.duplicates = deduplicateSearchHits(ftsResp.Hits)
+		}
+		results = searchRes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func logPartialSearchResults(q *SearchQuery, status SearchResultStatus) {
+	if status.Failed > 0 {
+		logDebugf("Search query against %s was answered by %d of %d index partitions",
+			redactMetaData(q.indexName()), status.Successful, status.Total)
+	}
+}
+
+// executeSearchQuery sends a search query to the server, passing a successful response
+// to handle, which must call cancel once it has finished with the response.
+func (c *Cluster) executeSearchQuery(ctx context.Context, b *Bucket, q *SearchQuery, handle func(resp *http.Response, cancel context.CancelFunc) error) (errOut error) {
 	var err error
 	var ftsEp string
+
+	start := time.Now()
+	defer func() {
+		c.recordOperation("fts", bucketName(b), "ExecuteSearchQuery", errOut, time.Since(start))
+		c.traceQuery(ctx, b, "ExecuteSearchQuery", "fts", ftsEp, start, errOut)
+		if errOut != nil {
+			opErr := &OperationError{
+				Operation: "ExecuteSearchQuery",
+				Endpoint:  ftsEp,
+				Elapsed:   time.Since(start),
+			}
+			if b != nil {
+				opErr.Bucket = b.name
+			}
+			errOut = c.wrapOperationError(errOut, opErr)
+		}
+	}()
 	var timeout time.Duration
 	var client *http.Client
 	var creds []userPassPair
@@ -126,7 +229,7 @@ func (c *Cluster) doSearchQuery(b *Bucket, q *SearchQuery) (SearchResults, error
 	if b != nil {
 		ftsEp, err = b.getFtsEp()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if b.ftsTimeout < c.ftsTimeout {
@@ -134,7 +237,7 @@ func (c *Cluster) doSearchQuery(b *Bucket, q *SearchQuery) (SearchResults, error
 		} else {
 			timeout = c.ftsTimeout
 		}
-		client = b.client.HttpClient()
+		client = b.httpClient()
 		if c.auth != nil {
 			creds = c.auth.bucketFts(b.name)
 		} else {
@@ -152,113 +255,121 @@ func (c *Cluster) doSearchQuery(b *Bucket, q *SearchQuery) (SearchResults, error
 
 		tmpB, err := c.randomBucket()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		ftsEp, err = tmpB.getFtsEp()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		timeout = c.ftsTimeout
-		client = tmpB.client.HttpClient()
+		client = tmpB.httpClient()
 		creds = c.auth.clusterFts()
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout = contextTimeout(ctx, timeout)
+
 	qIndexName := q.indexName()
 	qBytes, err := json.Marshal(q.queryData())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var queryData jsonx.DelayedObject
 	err = json.Unmarshal(qBytes, &queryData)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	var ctlData jsonx.DelayedObject
 	if queryData.Has("ctl") {
 		err = queryData.Get("ctl", &ctlData)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
+	// A timeout specified for the query overrides the FTS timeout, although not the
+	// deadline of ctx.
 	qTimeout := jsonMillisecondDuration(timeout)
 	if ctlData.Has("timeout") {
 		err := ctlData.Get("timeout", &qTimeout)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if qTimeout <= 0 || time.Duration(qTimeout) > timeout {
-			qTimeout = jsonMillisecondDuration(timeout)
+		if qTimeout > 0 {
+			timeout = contextTimeout(ctx, time.Duration(qTimeout))
 		}
+		qTimeout = jsonMillisecondDuration(timeout)
 	}
 	err = ctlData.Set("timeout", qTimeout)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	err = queryData.Set("ctl", ctlData)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if len(creds) > 1 {
 		err = queryData.Set("creds", creds)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	qBytes, err = json.Marshal(queryData)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	reqUri := fmt.Sprintf("%s/api/index/%s/query", ftsEp, qIndexName)
 
 	req, err := http.NewRequest("POST", reqUri, bytes.NewBuffer(qBytes))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	if len(creds) == 1 {
 		req.SetBasicAuth(creds[0].Username, creds[0].Password)
 	}
+	reqCtx, cancel := context.WithCancel(ctx)
+	req = req.WithContext(reqCtx)
 
+	reqStart := time.Now()
 	resp, err := doHttpWithTimeout(client, req, timeout)
-	if err != nil {
-		return nil, err
-	}
-
-	ftsResp := searchResponse{}
-	jsonDec := json.NewDecoder(resp.Body)
-	err = jsonDec.Decode(&ftsResp)
-	if err != nil {
-		return nil, err
+	if err != nil && ctx.Err() != nil {
+		cancel()
+		return ctx.Err()
 	}
-
-	err = resp.Body.Close()
+	c.recordEndpointLatency(ftsEp, time.Since(reqStart), err)
 	if err != nil {
-		logDebugf("Failed to close socket (%s)", err)
+		cancel()
+		return err
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, &viewError{
+		cancel()
+		err = resp.Body.Close()
+		if err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+		return &viewError{
 			Message: "HTTP Error",
 			Reason:  fmt.Sprintf("Status code was %d.", resp.StatusCode),
 		}
 	}
 
-	return searchResults{
-		data: &ftsResp,
-	}, nil
+	return handle(resp, cancel)
 }
 
 // ExecuteSearchQuery performs a n1ql query and returns a list of rows or an error.
 func (c *Cluster) ExecuteSearchQuery(q *SearchQuery) (SearchResults, error) {
-	return c.doSearchQuery(nil, q)
+	return c.doSearchQuery(context.Background(), nil, q)
 }
@@ -0,0 +1,122 @@
+package gocb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeduplicateSearchHits(t *testing.T) {
+	hits := []SearchResultHit{
+		{Id: "a", Index: "idx_p1", Score: 3},
+		{Id: "b", Index: "idx_p2", Score: 2},
+		{Id: "a", Index: "idx_p1_replica", Score: 2},
+		{Id: "c", Index: "idx_p2", Score: 1},
+		{Id: "b", Index: "idx_p2_replica", Score: 1},
+	}
+
+	unique, dropped := deduplicateSearchHits(hits)
+	if dropped != 2 {
+		t.Fatalf("Expected 2 duplicates, got %d", dropped)
+	}
+	if len(unique) != 3 || unique[0].Id != "a" || unique[1].Id != "b" || unique[2].Id != "c" {
+		t.Fatalf("Unexpected hits %v", unique)
+	}
+	if unique[0].Score != 3 {
+		t.Fatalf("Expected the first hit for a document to be kept")
+	}
+
+	var results SearchResults = searchResults{data: &searchResponse{Hits: unique}, duplicates: dropped}
+	duplicates, ok := results.(SearchResultDuplicates)
+	if !ok || duplicates.DuplicateHits() != 2 {
+		t.Fatalf("Expected the duplicates to be available from the results")
+	}
+}
+
+func TestSearchResultStatusPartial(t *testing.T) {
+	var status SearchResultStatus
+	err := json.Unmarshal([]byte(`{"total":6,"failed":2,"successful":4,"errors":{"idx_p3":"context deadline exceeded"}}`), &status)
+	if err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if !status.Partial() {
+		t.Fatalf("Expected status to be partial")
+	}
+	if status.Errors["idx_p3"] != "context deadline exceeded" {
+		t.Fatalf("Unexpected partition errors %v", status.Errors)
+	}
+
+	if (SearchResultStatus{Total: 6, Successful: 6}).Partial() {
+		t.Fatalf("Expected status not to be partial")
+	}
+}
+
+func TestSearchResponseFacetsAndFragments(t *testing.T) {
+	var resp searchResponse
+	err := json.Unmarshal([]byte(`{
+		"total_hits": 1,
+		"hits": [{"id": "hotel_1", "score": 1.5, "fragments": {"name": ["The <mark>Grand</mark> Hotel"]}}],
+		"facets": {
+			"types": {"field": "type", "total": 3, "terms": [{"term": "hotel", "count": 3}]},
+			"prices": {"field": "price", "total": 2, "numeric_ranges": [{"name": "cheap", "min": 0, "max": 100, "count": 2}]},
+			"opened": {"field": "opened", "total": 1, "date_ranges": [{"name": "old", "start": "1900-01-01", "end": "2000-01-01", "count": 1}]}
+		}
+	}`), &resp)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	results := searchResults{data: &resp}
+
+	if fragments := results.Hits()[0].Fragments["name"]; len(fragments) != 1 || fragments[0] != "The <mark>Grand</mark> Hotel" {
+		t.Fatalf("Unexpected fragments %v", fragments)
+	}
+	facets := results.Facets()
+	if terms := facets["types"].Terms; len(terms) != 1 || terms[0].Term != "hotel" || terms[0].Count != 3 {
+		t.Fatalf("Unexpected term facet %+v", facets["types"])
+	}
+	if ranges := facets["prices"].NumericRanges; len(ranges) != 1 || ranges[0].Max != 100 || ranges[0].Count != 2 {
+		t.Fatalf("Unexpected numeric facet %+v", facets["prices"])
+	}
+	if ranges := facets["opened"].DateRanges; len(ranges) != 1 || ranges[0].Min != "1900-01-01" || ranges[0].Max != "2000-01-01" {
+		t.Fatalf("Unexpected date facet %+v", facets["opened"])
+	}
+}
+
+func TestSearchHitStream(t *testing.T) {
+	body := `{"status":{"total":2,"failed":1,"successful":1,"errors":{"p1":"timeout"}},` +
+		`"hits":[{"id":"a","score":2},{"id":"b","score":1},{"id":"a","score":0.5}],` +
+		`"total_hits":3,"max_score":2,"took":1500000}`
+	q := NewSearchQuery("idx", map[string]string{"match": "x"}).DeduplicateHits(true)
+	results, err := newSearchHitStream(q, newResultStream(ioutil.NopCloser(strings.NewReader(body)), nil, "hits"))
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	var ids []string
+	var hit SearchResultHit
+	for results.NextHit(&hit) {
+		ids = append(ids, hit.Id)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to close results: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" || results.DuplicateHits() != 1 {
+		t.Fatalf("Unexpected hits %v with %d duplicates", ids, results.DuplicateHits())
+	}
+	if results.TotalHits() != 3 || results.MaxScore() != 2 || results.Took() != 1500*time.Microsecond {
+		t.Fatalf("Unexpected metrics %d %v %v", results.TotalHits(), results.MaxScore(), results.Took())
+	}
+	if status := results.Status(); !status.Partial() || status.Errors["p1"] != "timeout" {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	empty, err := newSearchHitStream(q, newResultStream(ioutil.NopCloser(strings.NewReader(`{"hits":null,"total_hits":0}`)), nil, "hits"))
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if empty.NextHit(&hit) || empty.Close() != nil || empty.TotalHits() != 0 {
+		t.Fatalf("Expected no hits")
+	}
+}
@@ -10,14 +10,17 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // ClusterManager provides methods for performing cluster management operations.
 type ClusterManager struct {
-	hosts    []string
-	username string
-	password string
-	httpCli  *http.Client
+	cluster     *Cluster
+	hosts       []string
+	username    string
+	password    string
+	httpCli     *http.Client
+	mgmtTimeout time.Duration
 }
 
 // BucketType specifies the kind of bucket
@@ -70,7 +73,11 @@ func (cm *ClusterManager) mgmtRequest(method, uri string, contentType string, bo
 		panic("Content-type must be specified for non-null body.")
 	}
 
-	reqUri := cm.getMgmtEp() + uri
+	return cm.httpRequest(cm.getMgmtEp(), method, uri, contentType, body)
+}
+
+func (cm *ClusterManager) httpRequest(ep, method, uri string, contentType string, body io.Reader) (*http.Response, error) {
+	reqUri := ep + uri
 	req, err := http.NewRequest(method, reqUri, body)
 	if err != nil {
 		return nil, err
@@ -83,10 +90,101 @@ func (cm *ClusterManager) mgmtRequest(method, uri string, contentType string, bo
 		req.SetBasicAuth(cm.username, cm.password)
 	}
 
-	return cm.httpCli.Do(req)
+	return doHttpWithTimeout(cm.httpCli, req, cm.mgmtTimeout)
+}
+
+func (cm *ClusterManager) getServiceEp(service ServiceType) (string, error) {
+	switch service {
+	case MgmtService:
+		if len(cm.hosts) == 0 {
+			return "", &clientError{"No available management nodes."}
+		}
+		return cm.getMgmtEp(), nil
+	case CapiService:
+		b, err := cm.cluster.randomBucket()
+		if err != nil {
+			return "", err
+		}
+		return b.getViewEp()
+	case N1qlService:
+		b, err := cm.cluster.randomBucket()
+		if err != nil {
+			return "", err
+		}
+		return b.getN1qlEp()
+	case FtsService:
+		b, err := cm.cluster.randomBucket()
+		if err != nil {
+			return "", err
+		}
+		return b.getFtsEp()
+	case CbasService:
+		analyticsHosts := cm.cluster.availableEps(cm.cluster.analyticsHosts)
+		if len(analyticsHosts) == 0 {
+			return "", &clientError{"No available analytics nodes, specify them with EnableAnalytics first."}
+		}
+		analyticsHosts, err := cm.cluster.closedEps(analyticsHosts)
+		if err != nil {
+			return "", err
+		}
+		return cm.cluster.selectEndpoint("analytics", analyticsHosts), nil
+	}
+
+	return "", &clientError{"The specified service does not accept HTTP requests."}
+}
+
+// HttpResponse holds the result of a raw HTTP request made against the cluster.
+type HttpResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do performs a raw HTTP request against a management endpoint of the cluster,
+// using the credentials, TLS settings and management timeout of this manager.
+// The response is returned regardless of its status code.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) Do(method, path string, contentType string, body io.Reader) (*HttpResponse, error) {
+	return cm.DoService(MgmtService, method, path, contentType, body)
+}
+
+// DoService performs a raw HTTP request against an endpoint of the specified service,
+// in the same manner as Do.  Views, N1QL and FTS endpoints are discovered from the
+// configuration of an open bucket, analytics endpoints from EnableAnalytics.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) DoService(service ServiceType, method, path string, contentType string, body io.Reader) (*HttpResponse, error) {
+	if contentType == "" && body != nil {
+		return nil, clientError{"Content-type must be specified for non-null body."}
+	}
+
+	ep, err := cm.getServiceEp(service)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cm.httpRequest(ep, method, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		logDebugf("Failed to close socket (%s)", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &HttpResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+	}, nil
 }
 
-func bucketDataInToSettings(bucketData *bucketDataIn) *BucketSettings {
+func bucketDataInToSettings(bucketData *bucketDataIn) (*BucketSettings, error) {
 	settings := &BucketSettings{
 		FlushEnabled:  bucketData.Controllers.Flush != "",
 		IndexReplicas: bucketData.ReplicaIndex,
@@ -102,12 +200,12 @@ func bucketDataInToSettings(bucketData *bucketDataIn) *BucketSettings {
 	} else if bucketData.BucketType == "ephemeral" {
 		settings.Type = Ephemeral
 	} else {
-		panic("Unrecognized bucket type string.")
+		return nil, clientError{fmt.Sprintf("Unrecognized bucket type %s for bucket %s.", bucketData.BucketType, bucketData.Name)}
 	}
 	if bucketData.AuthType != "sasl" {
 		settings.Password = ""
 	}
-	return settings
+	return settings, nil
 }
 
 // GetBuckets returns a list of all active buckets on the cluster.
@@ -116,16 +214,17 @@ func (cm *ClusterManager) GetBuckets() ([]*BucketSettings, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return nil, clientError{string(data)}
 	}
 
@@ -138,24 +237,32 @@ func (cm *ClusterManager) GetBuckets() ([]*BucketSettings, error) {
 
 	var buckets []*BucketSettings
 	for _, bucketData := range bucketsData {
-		buckets = append(buckets, bucketDataInToSettings(bucketData))
+		settings, err := bucketDataInToSettings(bucketData)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, settings)
 	}
 
 	return buckets, nil
 }
 
-// InsertBucket creates a new bucket on the cluster.
-func (cm *ClusterManager) InsertBucket(settings *BucketSettings) error {
+// bucketSettingsToPosts encodes the settings of a bucket for the bucket management
+// endpoints.  The name and type of a bucket cannot be changed, so are only included
+// when the bucket is being created.
+func bucketSettingsToPosts(settings *BucketSettings, create bool) (url.Values, error) {
 	posts := url.Values{}
-	posts.Add("name", settings.Name)
-	if settings.Type == Couchbase {
-		posts.Add("bucketType", "couchbase")
-	} else if settings.Type == Memcached {
-		posts.Add("bucketType", "memcached")
-	} else if settings.Type == Ephemeral {
-		posts.Add("bucketType", "ephemeral")
-	} else {
-		panic("Unrecognized bucket type.")
+	if create {
+		posts.Add("name", settings.Name)
+		if settings.Type == Couchbase {
+			posts.Add("bucketType", "couchbase")
+		} else if settings.Type == Memcached {
+			posts.Add("bucketType", "memcached")
+		} else if settings.Type == Ephemeral {
+			posts.Add("bucketType", "ephemeral")
+		} else {
+			return nil, clientError{"Unrecognized bucket type."}
+		}
 	}
 	if settings.FlushEnabled {
 		posts.Add("flushEnabled", "1")
@@ -166,32 +273,49 @@ func (cm *ClusterManager) InsertBucket(settings *BucketSettings) error {
 	posts.Add("authType", "sasl")
 	posts.Add("saslPassword", settings.Password)
 	posts.Add("ramQuotaMB", fmt.Sprintf("%d", settings.Quota))
+	return posts, nil
+}
 
+func (cm *ClusterManager) postBucket(uri string, posts url.Values, expectedStatus int) error {
 	data := []byte(posts.Encode())
-	resp, err := cm.mgmtRequest("POST", "/pools/default/buckets", "application/x-www-form-urlencoded", bytes.NewReader(data))
+	resp, err := cm.mgmtRequest("POST", uri, "application/x-www-form-urlencoded", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
-	if resp.StatusCode != 202 {
+	if resp.StatusCode != expectedStatus {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return clientError{string(data)}
 	}
 
 	return nil
 }
 
-// UpdateBucket will update the settings for a specific bucket on the cluster.
+// InsertBucket creates a new bucket on the cluster.
+func (cm *ClusterManager) InsertBucket(settings *BucketSettings) error {
+	posts, err := bucketSettingsToPosts(settings, true)
+	if err != nil {
+		return err
+	}
+	return cm.postBucket("/pools/default/buckets", posts, 202)
+}
+
+// UpdateBucket will update the settings for a specific bucket on the cluster.  The name
+// of the settings identifies the bucket, and its type cannot be changed.
 func (cm *ClusterManager) UpdateBucket(settings *BucketSettings) error {
-	// Cluster-side, updates are the same as creates.
-	return cm.InsertBucket(settings)
+	posts, err := bucketSettingsToPosts(settings, false)
+	if err != nil {
+		return err
+	}
+	return cm.postBucket(fmt.Sprintf("/pools/default/buckets/%s", url.PathEscape(settings.Name)), posts, 200)
 }
 
 // RemoveBucket will delete a bucket from the cluster by name.
@@ -202,16 +326,17 @@ func (cm *ClusterManager) RemoveBucket(name string) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
 
 	if resp.StatusCode != 200 {
 		data, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		err = resp.Body.Close()
-		if err != nil {
-			logDebugf("Failed to close socket (%s)", err)
-		}
 		return clientError{string(data)}
 	}
 
@@ -230,6 +355,9 @@ type User struct {
 	Name  string
 	Type  string
 	Roles []UserRole
+	// Groups are the names of the groups the user belongs to, whose roles the user
+	// also holds.
+	Groups []string
 }
 
 // AuthDomain specifies the user domain of a specific user
@@ -249,6 +377,19 @@ type UserSettings struct {
 	Name     string
 	Password string
 	Roles    []UserRole
+	// Groups are the names of the groups the user belongs to.  Groups require
+	// Couchbase Server 6.5 or later.
+	Groups []string
+}
+
+// Group represents a group of users which was retrieved from the server.  Each member
+// of the group holds the roles of the group.
+type Group struct {
+	Name        string
+	Description string
+	Roles       []UserRole
+	// LdapGroupReference is the LDAP group the group is mapped to, if any.
+	LdapGroupReference string
 }
 
 type userRoleJson struct {
@@ -257,10 +398,18 @@ type userRoleJson struct {
 }
 
 type userJson struct {
-	Id    string         `json:"id"`
-	Name  string         `json:"name"`
-	Type  string         `json:"type"`
-	Roles []userRoleJson `json:"roles"`
+	Id     string         `json:"id"`
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Roles  []userRoleJson `json:"roles"`
+	Groups []string       `json:"groups"`
+}
+
+type groupJson struct {
+	Id           string         `json:"id"`
+	Description  string         `json:"description"`
+	Roles        []userRoleJson `json:"roles"`
+	LdapGroupRef string         `json:"ldap_group_ref"`
 }
 
 type userSettingsJson struct {
@@ -274,13 +423,34 @@ func transformUserJson(userData *userJson) User {
 	user.Id = userData.Id
 	user.Name = userData.Name
 	user.Type = userData.Type
-	for _, roleData := range userData.Roles {
-		user.Roles = append(user.Roles, UserRole{
+	user.Roles = transformUserRolesJson(userData.Roles)
+	user.Groups = userData.Groups
+	return user
+}
+
+func transformUserRolesJson(rolesData []userRoleJson) []UserRole {
+	var roles []UserRole
+	for _, roleData := range rolesData {
+		roles = append(roles, UserRole{
 			Role:       roleData.Role,
 			BucketName: roleData.BucketName,
 		})
 	}
-	return user
+	return roles
+}
+
+// encodeUserRoles encodes roles in the form accepted by the RBAC endpoints, where the
+// bucket of a role which applies to a bucket follows the role in brackets.
+func encodeUserRoles(roles []UserRole) string {
+	var roleStrs []string
+	for _, roleData := range roles {
+		if roleData.BucketName == "" {
+			roleStrs = append(roleStrs, roleData.Role)
+		} else {
+			roleStrs = append(roleStrs, fmt.Sprintf("%s[%s]", roleData.Role, roleData.BucketName))
+		}
+	}
+	return strings.Join(roleStrs, ",")
 }
 
 // GetUsers returns a list of all users on the cluster.
@@ -352,15 +522,13 @@ func (cm *ClusterManager) GetUser(domain AuthDomain, name string) (*User, error)
 
 // UpsertUser updates a built-in RBAC user on the cluster.
 func (cm *ClusterManager) UpsertUser(domain AuthDomain, name string, settings *UserSettings) error {
-	var reqRoleStrs []string
-	for _, roleData := range settings.Roles {
-		reqRoleStrs = append(reqRoleStrs, fmt.Sprintf("%s[%s]", roleData.Role, roleData.BucketName))
-	}
-
 	reqForm := make(url.Values)
 	reqForm.Add("name", settings.Name)
 	reqForm.Add("password", settings.Password)
-	reqForm.Add("roles", strings.Join(reqRoleStrs, ","))
+	reqForm.Add("roles", encodeUserRoles(settings.Roles))
+	if len(settings.Groups) > 0 {
+		reqForm.Add("groups", strings.Join(settings.Groups, ","))
+	}
 
 	uri := fmt.Sprintf("/settings/rbac/users/%s/%s", domain, name)
 	reqBody := bytes.NewReader([]byte(reqForm.Encode()))
@@ -406,3 +574,90 @@ func (cm *ClusterManager) RemoveUser(domain AuthDomain, name string) error {
 
 	return nil
 }
+
+func (cm *ClusterManager) rbacRequest(method, uri, contentType string, body io.Reader, out interface{}) error {
+	resp, err := cm.mgmtRequest(method, uri, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return clientError{string(data)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func transformGroupJson(groupData *groupJson) *Group {
+	return &Group{
+		Name:               groupData.Id,
+		Description:        groupData.Description,
+		Roles:              transformUserRolesJson(groupData.Roles),
+		LdapGroupReference: groupData.LdapGroupRef,
+	}
+}
+
+// GetGroups returns a list of all groups on the cluster.  Groups require Couchbase
+// Server 6.5 or later.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) GetGroups() ([]*Group, error) {
+	var groupsData []*groupJson
+	if err := cm.rbacRequest("GET", "/settings/rbac/groups", "", nil, &groupsData); err != nil {
+		return nil, err
+	}
+
+	var groups []*Group
+	for _, groupData := range groupsData {
+		groups = append(groups, transformGroupJson(groupData))
+	}
+	return groups, nil
+}
+
+// GetGroup returns the data for a particular group.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) GetGroup(name string) (*Group, error) {
+	var groupData groupJson
+	uri := fmt.Sprintf("/settings/rbac/groups/%s", url.PathEscape(name))
+	if err := cm.rbacRequest("GET", uri, "", nil, &groupData); err != nil {
+		return nil, err
+	}
+	return transformGroupJson(&groupData), nil
+}
+
+// UpsertGroup creates a group on the cluster, or updates the group with the same name.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) UpsertGroup(group *Group) error {
+	reqForm := make(url.Values)
+	reqForm.Add("description", group.Description)
+	reqForm.Add("roles", encodeUserRoles(group.Roles))
+	if group.LdapGroupReference != "" {
+		reqForm.Add("ldap_group_ref", group.LdapGroupReference)
+	}
+
+	uri := fmt.Sprintf("/settings/rbac/groups/%s", url.PathEscape(group.Name))
+	reqBody := bytes.NewReader([]byte(reqForm.Encode()))
+	return cm.rbacRequest("PUT", uri, "application/x-www-form-urlencoded", reqBody, nil)
+}
+
+// RemoveGroup removes a group from the cluster.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) RemoveGroup(name string) error {
+	uri := fmt.Sprintf("/settings/rbac/groups/%s", url.PathEscape(name))
+	return cm.rbacRequest("DELETE", uri, "", nil, nil)
+}
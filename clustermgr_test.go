@@ -0,0 +1,231 @@
+package gocb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedMgmtRequest struct {
+	method string
+	path   string
+	form   url.Values
+}
+
+func newTestClusterManager(status int, body string) (*ClusterManager, func() []recordedMgmtRequest, *httptest.Server) {
+	var lock sync.Mutex
+	var requests []recordedMgmtRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		form, _ := url.ParseQuery(string(data))
+		lock.Lock()
+		requests = append(requests, recordedMgmtRequest{req.Method, req.URL.Path, form})
+		lock.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	cm := &ClusterManager{hosts: []string{server.URL}, httpCli: http.DefaultClient}
+	return cm, func() []recordedMgmtRequest {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]recordedMgmtRequest{}, requests...)
+	}, server
+}
+
+func TestClusterManagerUpdateBucket(t *testing.T) {
+	cm, requests, server := newTestClusterManager(200, "")
+	defer server.Close()
+	err := cm.UpdateBucket(&BucketSettings{Name: "travel", Type: Couchbase, Quota: 256, Replicas: 2, FlushEnabled: true})
+	if err != nil {
+		t.Fatalf("Failed to update bucket: %v", err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 1 || reqs[0].method != "POST" || reqs[0].path != "/pools/default/buckets/travel" {
+		t.Fatalf("Expected the settings to be posted to the bucket, got %+v", reqs)
+	}
+	form := reqs[0].form
+	if form.Get("ramQuotaMB") != "256" || form.Get("replicaNumber") != "2" || form.Get("flushEnabled") != "1" {
+		t.Fatalf("Unexpected settings %v", form)
+	}
+	if _, ok := form["name"]; ok {
+		t.Fatalf("Expected the name not to be sent for an update")
+	}
+	if _, ok := form["bucketType"]; ok {
+		t.Fatalf("Expected the type not to be sent for an update")
+	}
+}
+
+func TestClusterManagerInsertBucket(t *testing.T) {
+	cm, requests, server := newTestClusterManager(202, "")
+	defer server.Close()
+	if err := cm.InsertBucket(&BucketSettings{Name: "cache", Type: Ephemeral, Quota: 100}); err != nil {
+		t.Fatalf("Failed to insert bucket: %v", err)
+	}
+	reqs := requests()
+	if len(reqs) != 1 || reqs[0].path != "/pools/default/buckets" {
+		t.Fatalf("Expected the bucket to be created, got %+v", reqs)
+	}
+	if reqs[0].form.Get("name") != "cache" || reqs[0].form.Get("bucketType") != "ephemeral" {
+		t.Fatalf("Unexpected settings %v", reqs[0].form)
+	}
+
+	if err := cm.InsertBucket(&BucketSettings{Name: "bad", Type: BucketType(7)}); err == nil {
+		t.Fatalf("Expected an error for an unrecognized bucket type")
+	}
+}
+
+func TestClusterManagerGetBucketsUnrecognizedType(t *testing.T) {
+	cm, _, server := newTestClusterManager(200, `[{"name":"travel","bucketType":"membase"},{"name":"odd","bucketType":"magma"}]`)
+	defer server.Close()
+	if _, err := cm.GetBuckets(); err == nil {
+		t.Fatalf("Expected an error for an unrecognized bucket type")
+	}
+}
+
+func TestClusterManagerDoServiceRouting(t *testing.T) {
+	cm, requests, mgmt := newTestClusterManager(500, `{"error":"internal"}`)
+	defer mgmt.Close()
+	cm.username, cm.password = "admin", "password"
+
+	var analyticsAuth string
+	analytics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		analyticsAuth = req.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok","path":"` + req.URL.Path + `"}`))
+	}))
+	defer analytics.Close()
+	cm.cluster = &Cluster{}
+	cm.cluster.EnableAnalytics([]string{analytics.URL})
+
+	// The response is returned regardless of its status.
+	resp, err := cm.Do("POST", "/settings/indexes", "application/x-www-form-urlencoded", strings.NewReader("storageMode=plasma"))
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	if resp.StatusCode != 500 || string(resp.Body) != `{"error":"internal"}` {
+		t.Fatalf("Unexpected response %d %s", resp.StatusCode, resp.Body)
+	}
+	reqs := requests()
+	if len(reqs) != 1 || reqs[0].method != "POST" || reqs[0].path != "/settings/indexes" || reqs[0].form.Get("storageMode") != "plasma" {
+		t.Fatalf("Expected the request to be sent to the management endpoint, got %+v", reqs)
+	}
+
+	resp, err = cm.DoService(CbasService, "GET", "/analytics/cluster", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to perform analytics request: %v", err)
+	}
+	if resp.StatusCode != 200 || string(resp.Body) != `{"status":"ok","path":"/analytics/cluster"}` ||
+		resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected analytics response %d %s", resp.StatusCode, resp.Body)
+	}
+	expectedReq, _ := http.NewRequest("GET", analytics.URL, nil)
+	expectedReq.SetBasicAuth("admin", "password")
+	if analyticsAuth != expectedReq.Header.Get("Authorization") {
+		t.Fatalf("Expected the credentials of the manager to be sent, got %q", analyticsAuth)
+	}
+	if len(requests()) != 1 {
+		t.Fatalf("Expected the analytics request not to be sent to the management endpoint")
+	}
+
+	if _, err := cm.DoService(N1qlService, "GET", "/admin/ping", "", nil); err != ErrNoOpenBuckets {
+		t.Fatalf("Expected ErrNoOpenBuckets without an open bucket, got %v", err)
+	}
+	if _, err := cm.DoService(MemdService, "GET", "/", "", nil); err == nil {
+		t.Fatalf("Expected an error for a service which does not accept HTTP requests")
+	}
+	if _, err := cm.Do("POST", "/settings/indexes", "", strings.NewReader("storageMode=plasma")); err == nil {
+		t.Fatalf("Expected an error for a body without a content type")
+	}
+	if len(requests()) != 1 {
+		t.Fatalf("Expected failed requests not to be sent")
+	}
+}
+
+func TestClusterManagerDoErrors(t *testing.T) {
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://unknown.example.com:8091/pools", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	c, err := Connect("couchbase://localhost")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c.agentConfig.HttpAddrs = []string{strings.TrimPrefix(redirector.URL, "http://")}
+	cm := c.Manager("admin", "password")
+
+	if _, err := cm.Do("GET", "/pools", "", nil); ErrorCause(err) != ErrUnexpectedRedirect {
+		t.Fatalf("Expected ErrUnexpectedRedirect for a redirect to an unknown host, got %v", err)
+	}
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	cm = &ClusterManager{hosts: []string{slow.URL}, httpCli: &http.Client{Transport: &http.Transport{}}, mgmtTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	if _, err := cm.Do("GET", "/pools", "", nil); err == nil {
+		t.Fatalf("Expected the request to fail once the management timeout elapsed")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the request to give up after the management timeout, took %s", elapsed)
+	}
+}
+
+func TestClusterManagerGroups(t *testing.T) {
+	cm, requests, server := newTestClusterManager(200, `[{"id":"admins","description":"Admins","roles":[{"role":"admin"},{"role":"bucket_admin","bucket_name":"travel"}]}]`)
+	defer server.Close()
+
+	groups, err := cm.GetGroups()
+	if err != nil {
+		t.Fatalf("Failed to get groups: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != "admins" || len(groups[0].Roles) != 2 || groups[0].Roles[1].BucketName != "travel" {
+		t.Fatalf("Unexpected groups %+v", groups)
+	}
+
+	err = cm.UpsertGroup(&Group{
+		Name:        "readers",
+		Description: "Readers",
+		Roles:       []UserRole{{Role: "ro_admin"}, {Role: "data_reader", BucketName: "travel"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert group: %v", err)
+	}
+	reqs := requests()
+	upsert := reqs[len(reqs)-1]
+	if upsert.method != "PUT" || upsert.path != "/settings/rbac/groups/readers" {
+		t.Fatalf("Unexpected request %+v", upsert)
+	}
+	if upsert.form.Get("roles") != "ro_admin,data_reader[travel]" || upsert.form.Get("description") != "Readers" {
+		t.Fatalf("Unexpected group settings %v", upsert.form)
+	}
+}
+
+func TestClusterManagerUpsertUserGroups(t *testing.T) {
+	cm, requests, server := newTestClusterManager(200, "")
+	defer server.Close()
+
+	err := cm.UpsertUser(LocalDomain, "alice", &UserSettings{
+		Password: "password",
+		Roles:    []UserRole{{Role: "admin"}},
+		Groups:   []string{"admins", "readers"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to upsert user: %v", err)
+	}
+	form := requests()[0].form
+	if form.Get("roles") != "admin" || form.Get("groups") != "admins,readers" {
+		t.Fatalf("Unexpected user settings %v", form)
+	}
+}
@@ -0,0 +1,63 @@
+package gocb
+
+// The smallest document value, in bytes, which is compressed by default, and the
+// largest ratio of the compressed to the original size at which the compressed value
+// is sent.
+const (
+	defaultCompressionMinSize  = 32
+	defaultCompressionMinRatio = 0.83
+)
+
+// CompressionConfig configures the snappy compression of document values sent to and
+// received from the data nodes.  When the server negotiates the snappy feature, values
+// of at least MinSize bytes are compressed before they are sent, and are only sent
+// compressed if that reduces them to at most MinRatio of their original size.
+// Compressed values received from the server are decompressed before they are decoded,
+// so compression is transparent to the transcoder.
+//
+// Experimental: This API is subject to change at any time.
+type CompressionConfig struct {
+	// Disabled specifies that values are never compressed.  Compression is enabled by
+	// default.
+	Disabled bool
+	// MinSize is the size, in bytes, below which values are sent uncompressed.  Zero
+	// uses a size of 32 bytes.
+	MinSize int
+	// MinRatio is the largest ratio of the compressed size of a value to its original
+	// size at which the compressed value is sent.  Zero uses a ratio of 0.83.
+	MinRatio float64
+}
+
+func (config CompressionConfig) withDefaults() CompressionConfig {
+	if config.MinSize <= 0 {
+		config.MinSize = defaultCompressionMinSize
+	}
+	if config.MinRatio <= 0 || config.MinRatio > 1 {
+		config.MinRatio = defaultCompressionMinRatio
+	}
+	return config
+}
+
+// CompressionConfig returns the configuration of the compression of document values.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) CompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Disabled: !c.agentConfig.UseCompression,
+		MinSize:  c.agentConfig.CompressionMinSize,
+		MinRatio: c.agentConfig.CompressionMinRatio,
+	}
+}
+
+// SetCompressionConfig configures the compression of document values by the buckets
+// opened from the cluster after it is set.  Zero fields of the configuration are given
+// their defaults.  It can also be specified by the compression, compression_min_size
+// and compression_min_ratio options of the connection string.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetCompressionConfig(config CompressionConfig) {
+	config = config.withDefaults()
+	c.agentConfig.UseCompression = !config.Disabled
+	c.agentConfig.CompressionMinSize = config.MinSize
+	c.agentConfig.CompressionMinRatio = config.MinRatio
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type connSpecScheme int
@@ -181,8 +182,15 @@ func parseConnSpec(connStr string) (out connSpec, err error) {
 	return
 }
 
+// lookupSRV performs DNS SRV lookups, and is replaced in tests.
+var lookupSRV = net.LookupSRV
+
+// csResolveDnsSrv replaces the single host of a connection string with the nodes listed
+// by its _couchbase._tcp or _couchbases._tcp SRV record, if it has one.  Hosts with an
+// explicit port, IP addresses and HTTP connection strings are never looked up.  Returns
+// whether the hosts were replaced.
 func csResolveDnsSrv(spec *connSpec) bool {
-	if len(spec.MemcachedHosts) > 1 || len(spec.HttpHosts) > 1 {
+	if len(spec.MemcachedHosts) != 1 || len(spec.HttpHosts) > 1 {
 		return false
 	}
 
@@ -190,25 +198,25 @@ func csResolveDnsSrv(spec *connSpec) bool {
 		return false
 	}
 
-	srvHostname := spec.HttpHosts[0].Host
-	_, addrs, err := net.LookupSRV(spec.Scheme.String(), "tcp", srvHostname)
+	srvHostname := spec.MemcachedHosts[0].Host
+	if net.ParseIP(srvHostname) != nil {
+		return false
+	}
+
+	_, addrs, err := lookupSRV(spec.Scheme.String(), "tcp", srvHostname)
 	if err != nil || len(addrs) == 0 {
 		return false
 	}
 
 	var hostList []*connSpecAddr
 	for _, srvRecord := range addrs {
-		hostList = append(hostList, &connSpecAddr{srvRecord.Target, srvRecord.Port})
+		// SRV targets are fully qualified, and so end with the root label.
+		hostList = append(hostList, &connSpecAddr{strings.TrimSuffix(srvRecord.Target, "."), srvRecord.Port})
 	}
 
+	// The records only list memcached ports, so the cluster is bootstrapped using them.
 	spec.HttpHosts = nil
-	spec.MemcachedHosts = nil
-
-	if spec.Scheme.IsHTTP() {
-		spec.HttpHosts = hostList
-	} else {
-		spec.MemcachedHosts = hostList
-	}
+	spec.MemcachedHosts = hostList
 
 	return true
 }
@@ -1,6 +1,7 @@
 package gocb
 
 import (
+	"net"
 	"runtime"
 	"testing"
 )
@@ -209,3 +210,36 @@ func TestOptionsPassthrough(t *testing.T) {
 
 	// TODO: bootstrap_on
 }
+
+func TestResolveDnsSrv(t *testing.T) {
+	var lookups []string
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		lookups = append(lookups, "_"+service+"._"+proto+"."+name)
+		return "", []*net.SRV{
+			{Target: "node1.example.com.", Port: 11207},
+			{Target: "node2.example.com.", Port: 11207},
+		}, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	cs := parseOrDie("couchbases://example.com", t)
+	if !csResolveDnsSrv(&cs) {
+		t.Fatalf("Expected the SRV record to be used")
+	}
+	if len(lookups) != 1 || lookups[0] != "_couchbases._tcp.example.com" {
+		t.Fatalf("Unexpected lookups %v", lookups)
+	}
+	if len(cs.HttpHosts) != 0 || len(cs.MemcachedHosts) != 2 || cs.MemcachedHosts[1].HostPort() != "node2.example.com:11207" {
+		t.Fatalf("Unexpected hosts %+v", cs.MemcachedHosts)
+	}
+
+	for _, connstr := range []string{"couchbase://1.2.3.4", "couchbase://foo.com:11210", "couchbase://foo.com,bar.com", "http://foo.com"} {
+		cs = parseOrDie(connstr, t)
+		if csResolveDnsSrv(&cs) {
+			t.Fatalf("Expected no SRV lookup for %s", connstr)
+		}
+	}
+	if len(lookups) != 1 {
+		t.Fatalf("Unexpected lookups %v", lookups)
+	}
+}
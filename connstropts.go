@@ -0,0 +1,124 @@
+package gocb
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// bucketConnSpecOptions holds the bucket settings specified in the connection string,
+// which are applied to every bucket opened from the cluster.  Zero values are left at
+// the defaults of the bucket.
+type bucketConnSpecOptions struct {
+	opTimeout       time.Duration
+	bulkOpTimeout   time.Duration
+	duraTimeout     time.Duration
+	duraPollTimeout time.Duration
+	viewTimeout     time.Duration
+}
+
+// parseConnSpecDuration parses a duration from the connection string, which may either
+// be a Go duration such as "5s", or a number of milliseconds for compatibility.
+func parseConnSpecDuration(name, value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s option must be a duration", name)
+	}
+	return duration, nil
+}
+
+func parseConnSpecBool(name, value string) (bool, error) {
+	val, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s option must be a boolean", name)
+	}
+	return val, nil
+}
+
+// applyConnSpecOptions applies the options of the connection string which configure the
+// cluster and the buckets opened from it.  Options handled by gocbcore are ignored.
+func (c *Cluster) applyConnSpecOptions(options map[string][]string) error {
+	fetchOption := func(name string) (string, bool) {
+		optValue := options[name]
+		if len(optValue) == 0 {
+			return "", false
+		}
+		return optValue[len(optValue)-1], true
+	}
+
+	durations := map[string]*time.Duration{
+		"n1ql_timeout":            &c.n1qlTimeout,
+		"fts_timeout":             &c.ftsTimeout,
+		"analytics_timeout":       &c.analyticsTimeout,
+		"management_timeout":      &c.mgmtTimeout,
+		"operation_timeout":       &c.bucketOptions.opTimeout,
+		"bulk_operation_timeout":  &c.bucketOptions.bulkOpTimeout,
+		"durability_timeout":      &c.bucketOptions.duraTimeout,
+		"durability_poll_timeout": &c.bucketOptions.duraPollTimeout,
+		"view_timeout":            &c.bucketOptions.viewTimeout,
+		"http_idle_conn_timeout":  &c.agentConfig.HttpIdleConnectionTimeout,
+	}
+	for name, target := range durations {
+		if valStr, ok := fetchOption(name); ok {
+			val, err := parseConnSpecDuration(name, valStr)
+			if err != nil {
+				return err
+			}
+			*target = val
+		}
+	}
+
+	bools := map[string]*bool{
+		"fetch_mutation_tokens": &c.agentConfig.UseMutationTokens,
+		"enriched_errors":       &c.enrichedErrors,
+		"strict_statements":     &c.strictStatements,
+	}
+	for name, target := range bools {
+		if valStr, ok := fetchOption(name); ok {
+			val, err := parseConnSpecBool(name, valStr)
+			if err != nil {
+				return err
+			}
+			*target = val
+		}
+	}
+
+	ints := map[string]*int{
+		"http_max_idle_conns":          &c.agentConfig.HttpMaxIdleConns,
+		"http_max_idle_conns_per_host": &c.agentConfig.HttpMaxIdleConnsPerHost,
+	}
+	for name, target := range ints {
+		if valStr, ok := fetchOption(name); ok {
+			val, err := strconv.Atoi(valStr)
+			if err != nil {
+				return fmt.Errorf("%s option must be a number", name)
+			}
+			*target = val
+		}
+	}
+
+	c.applyHttpPoolConfig()
+	return nil
+}
+
+// applyConnSpecOptions applies the bucket settings specified in the connection string.
+func (b *Bucket) applyConnSpecOptions(opts bucketConnSpecOptions) {
+	timeouts := []struct {
+		value  time.Duration
+		target *time.Duration
+	}{
+		{opts.opTimeout, &b.opTimeout},
+		{opts.bulkOpTimeout, &b.bulkOpTimeout},
+		{opts.duraTimeout, &b.duraTimeout},
+		{opts.duraPollTimeout, &b.duraPollTimeout},
+		{opts.viewTimeout, &b.viewTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value > 0 {
+			*timeout.target = timeout.value
+		}
+	}
+}
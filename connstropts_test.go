@@ -0,0 +1,82 @@
+package gocb
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestApplyConnSpecOptions(t *testing.T) {
+	c := &Cluster{
+		n1qlTimeout: 75 * time.Second,
+		httpCli:     &http.Client{Transport: &http.Transport{}},
+	}
+	err := c.applyConnSpecOptions(map[string][]string{
+		"operation_timeout":            {"5s"},
+		"n1ql_timeout":                 {"2000"},
+		"http_idle_conn_timeout":       {"30s"},
+		"http_max_idle_conns_per_host": {"64"},
+		"fetch_mutation_tokens":        {"true"},
+		"enriched_errors":              {"false"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to apply options: %v", err)
+	}
+
+	if c.n1qlTimeout != 2*time.Second {
+		t.Fatalf("Expected a timeout in milliseconds to be accepted, got %v", c.n1qlTimeout)
+	}
+	if !c.agentConfig.UseMutationTokens || c.enrichedErrors {
+		t.Fatalf("Expected the boolean options to be applied")
+	}
+	if c.httpCli.Transport.(*http.Transport).IdleConnTimeout != 30*time.Second {
+		t.Fatalf("Expected the idle connection timeout to be applied to the HTTP client")
+	}
+	if c.httpCli.Transport.(*http.Transport).MaxIdleConnsPerHost != 64 {
+		t.Fatalf("Expected the per host idle connection limit to be applied to the HTTP client")
+	}
+
+	b := &Bucket{opTimeout: 2500 * time.Millisecond, viewTimeout: 75 * time.Second}
+	b.applyConnSpecOptions(c.bucketOptions)
+	if b.opTimeout != 5*time.Second || b.viewTimeout != 75*time.Second {
+		t.Fatalf("Unexpected bucket timeouts %v %v", b.opTimeout, b.viewTimeout)
+	}
+}
+
+func TestApplyConnSpecOptionsInvalid(t *testing.T) {
+	c := &Cluster{httpCli: &http.Client{}}
+	if err := c.applyConnSpecOptions(map[string][]string{"operation_timeout": {"soon"}}); err == nil {
+		t.Fatalf("Expected an invalid duration to be rejected")
+	}
+	if err := c.applyConnSpecOptions(map[string][]string{"fetch_mutation_tokens": {"maybe"}}); err == nil {
+		t.Fatalf("Expected an invalid boolean to be rejected")
+	}
+}
+
+func TestSetHttpPoolConfig(t *testing.T) {
+	transport := &http.Transport{}
+	c := &Cluster{httpCli: &http.Client{Transport: transport}}
+	config := HttpPoolConfig{
+		MaxIdleConns:        512,
+		MaxIdleConnsPerHost: 128,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if err := c.SetHttpPoolConfig(config); err != nil {
+		t.Fatalf("Failed to set the pool limits: %v", err)
+	}
+	if c.HttpPoolConfig() != config {
+		t.Fatalf("Unexpected pool limits %+v", c.HttpPoolConfig())
+	}
+	if transport.MaxIdleConns != 512 || transport.MaxIdleConnsPerHost != 128 || transport.IdleConnTimeout != 90*time.Second {
+		t.Fatalf("Expected the pool limits to be applied to the transport")
+	}
+
+	c.SetHttpTransport(wrappedTransport{transport})
+	if err := c.SetHttpPoolConfig(config); err == nil {
+		t.Fatalf("Expected the pool limits to be rejected for a custom transport")
+	}
+}
+
+type wrappedTransport struct {
+	http.RoundTripper
+}
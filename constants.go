@@ -26,6 +26,35 @@ const (
 
 	// Common flags compression for disabled compression.
 	cfCmprNone = 0 << 29
+
+	// The largest document value the server will accept.
+	maxServerValueSize = 20 * 1024 * 1024
+
+	// The maximum number of redirects followed for a single HTTP request.
+	maxHttpRedirects = 5
+)
+
+// ServiceType specifies a particular Couchbase service type.
+type ServiceType int
+
+const (
+	// MemdService represents a memcached service.
+	MemdService = ServiceType(1)
+
+	// MgmtService represents a management service (typically ns_server).
+	MgmtService = ServiceType(2)
+
+	// CapiService represents a CouchAPI service (typically for views).
+	CapiService = ServiceType(3)
+
+	// N1qlService represents a N1QL service (typically for query).
+	N1qlService = ServiceType(4)
+
+	// FtsService represents a full-text-search service.
+	FtsService = ServiceType(5)
+
+	// CbasService represents an analytics service.
+	CbasService = ServiceType(6)
 )
 
 // IndexType provides information on the type of indexer used for an index.
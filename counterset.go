@@ -0,0 +1,170 @@
+package gocb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CounterSet is a collection of counter documents sharing a key prefix, which are
+// incremented and read together using bulk operations.
+//
+// Experimental: This API is subject to change at any time.
+type CounterSet struct {
+	b              *Bucket
+	prefix         string
+	defaultInitial int64
+	initials       map[string]int64
+	expiry         uint32
+}
+
+// Counters returns a CounterSet for the counter documents whose keys begin with prefix.
+// Counters are named without the prefix.  Missing counters are created with an initial
+// value of zero unless configured otherwise.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Counters(prefix string) *CounterSet {
+	return &CounterSet{
+		b:        b,
+		prefix:   prefix,
+		initials: make(map[string]int64),
+	}
+}
+
+// DefaultInitial sets the value with which missing counters are created.  A negative
+// value causes increments of missing counters to fail with ErrKeyNotFound instead.
+func (s *CounterSet) DefaultInitial(initial int64) *CounterSet {
+	s.defaultInitial = initial
+	return s
+}
+
+// Initial sets the value with which a particular counter is created if it is missing,
+// overriding DefaultInitial.
+func (s *CounterSet) Initial(name string, initial int64) *CounterSet {
+	s.initials[name] = initial
+	return s
+}
+
+// Expiry sets the expiry time with which missing counters are created.
+func (s *CounterSet) Expiry(expiry uint32) *CounterSet {
+	s.expiry = expiry
+	return s
+}
+
+// CounterSetError occurs when some of the counters of a CounterSet operation fail, and
+// describes the error for each of them.
+type CounterSetError struct {
+	Errors map[string]error
+}
+
+func (e *CounterSetError) Error() string {
+	var names []string
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	for _, name := range names {
+		failures = append(failures, fmt.Sprintf("%s: %s", name, e.Errors[name]))
+	}
+	return fmt.Sprintf("%d counters failed (%s)", len(names), strings.Join(failures, ", "))
+}
+
+// counterSetOp is a CounterOp which creates missing counters with an initial value of
+// zero.  CounterOp treats an Initial of zero as not creating the counter.
+type counterSetOp struct {
+	CounterOp
+}
+
+func (item *counterSetOp) execute(b *Bucket, signal chan BulkOp) {
+	realInitial := uint64(0xFFFFFFFFFFFFFFFF)
+	if item.Initial >= 0 {
+		realInitial = uint64(item.Initial)
+	}
+	item.dispatch(b, signal, item, realInitial)
+}
+
+func (s *CounterSet) incrementOps(deltas map[string]int64) ([]string, []BulkOp) {
+	var names []string
+	for name := range deltas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ops := make([]BulkOp, len(names))
+	for i, name := range names {
+		initial, ok := s.initials[name]
+		if !ok {
+			initial = s.defaultInitial
+		}
+		ops[i] = &counterSetOp{CounterOp{
+			Key:     s.prefix + name,
+			Delta:   deltas[name],
+			Initial: initial,
+			Expiry:  s.expiry,
+		}}
+	}
+	return names, ops
+}
+
+// counterSetResults collects the values of the operations which succeeded, and the
+// errors of those which did not.  Missing counters are omitted without error when
+// ignoreMissing is set.
+func counterSetResults(names []string, ops []BulkOp, ignoreMissing bool) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	errs := make(map[string]error)
+	for i, op := range ops {
+		name := names[i]
+		switch item := op.(type) {
+		case *counterSetOp:
+			if item.Err == nil {
+				values[name] = item.Value
+			} else {
+				errs[name] = item.Err
+			}
+		case *GetOp:
+			if item.Err == nil {
+				values[name] = *item.Value.(*uint64)
+			} else if !ignoreMissing || ErrorCause(item.Err) != ErrKeyNotFound {
+				errs[name] = item.Err
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return values, &CounterSetError{Errors: errs}
+	}
+	return values, nil
+}
+
+// IncrementMany adds the specified delta to each of the named counters, which may be
+// negative, creating those which are missing.  The counters are incremented
+// concurrently in a single bulk request.  The new value of each counter which was
+// incremented successfully is returned, while the failures of any others are described
+// by a CounterSetError.
+//
+// Experimental: This API is subject to change at any time.
+func (s *CounterSet) IncrementMany(deltas map[string]int64) (map[string]uint64, error) {
+	names, ops := s.incrementOps(deltas)
+	if err := s.b.Do(ops); err != nil {
+		logDebugf("Counter set increment did not complete (%s)", err)
+	}
+	return counterSetResults(names, ops, false)
+}
+
+// Snapshot reads the current values of the named counters in a single bulk request.
+// Counters which do not exist are omitted from the result, while the failures of any
+// others are described by a CounterSetError.
+//
+// Experimental: This API is subject to change at any time.
+func (s *CounterSet) Snapshot(names ...string) (map[string]uint64, error) {
+	ops := make([]BulkOp, len(names))
+	for i, name := range names {
+		ops[i] = &GetOp{Key: s.prefix + name, Value: new(uint64)}
+	}
+	if err := s.b.Do(ops); err != nil {
+		logDebugf("Counter set snapshot did not complete (%s)", err)
+	}
+	return counterSetResults(names, ops, true)
+}
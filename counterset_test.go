@@ -0,0 +1,58 @@
+package gocb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterSetIncrementOps(t *testing.T) {
+	s := (&Bucket{}).Counters("rate:").DefaultInitial(10).Initial("b", 0).Initial("c", -1).Expiry(60)
+	names, ops := s.incrementOps(map[string]int64{"c": 1, "a": 2, "b": -3})
+
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Fatalf("Expected the counters in name order, got %v", names)
+	}
+	expected := []CounterOp{
+		{Key: "rate:a", Delta: 2, Initial: 10, Expiry: 60},
+		{Key: "rate:b", Delta: -3, Initial: 0, Expiry: 60},
+		{Key: "rate:c", Delta: 1, Initial: -1, Expiry: 60},
+	}
+	for i, op := range ops {
+		item := op.(*counterSetOp)
+		if item.Key != expected[i].Key || item.Delta != expected[i].Delta ||
+			item.Initial != expected[i].Initial || item.Expiry != expected[i].Expiry {
+			t.Fatalf("Expected %+v, got %+v", expected[i], item.CounterOp)
+		}
+	}
+}
+
+func TestCounterSetResults(t *testing.T) {
+	ops := []BulkOp{
+		&counterSetOp{CounterOp{Key: "rate:a", Value: 5}},
+		&counterSetOp{CounterOp{Key: "rate:b", Err: ErrTimeout}},
+		&counterSetOp{CounterOp{Key: "rate:c", Value: 7}},
+	}
+	values, err := counterSetResults([]string{"a", "b", "c"}, ops, false)
+	if len(values) != 2 || values["a"] != 5 || values["c"] != 7 {
+		t.Fatalf("Expected only the successful counters, got %v", values)
+	}
+	setErr, ok := err.(*CounterSetError)
+	if !ok || len(setErr.Errors) != 1 || setErr.Errors["b"] != ErrTimeout {
+		t.Fatalf("Expected a CounterSetError for b, got %v", err)
+	}
+	if !strings.Contains(setErr.Error(), "1 counters failed (b: ") {
+		t.Fatalf("Unexpected error message %s", setErr.Error())
+	}
+
+	// Missing counters are omitted from snapshots without error.
+	one, two := uint64(1), uint64(2)
+	ops = []BulkOp{
+		&GetOp{Key: "rate:a", Value: &one},
+		&GetOp{Key: "rate:b", Value: new(uint64), Err: ErrKeyNotFound},
+		&GetOp{Key: "rate:c", Value: &two},
+	}
+	values, err = counterSetResults([]string{"a", "b", "c"}, ops, true)
+	if err != nil || len(values) != 2 || values["a"] != 1 || values["c"] != 2 {
+		t.Fatalf("Expected the existing counters, got %v (%v)", values, err)
+	}
+}
@@ -0,0 +1,186 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EndpointState describes the state of an endpoint as last observed by the library.
+//
+// Experimental: This API is subject to change at any time.
+type EndpointState string
+
+const (
+	// EndpointStateConnected indicates that the last request to the endpoint succeeded.
+	EndpointStateConnected = EndpointState("connected")
+	// EndpointStateIdle indicates that no request has been sent to the endpoint yet.
+	EndpointStateIdle = EndpointState("idle")
+	// EndpointStateError indicates that the last requests to the endpoint failed.
+	EndpointStateError = EndpointState("error")
+	// EndpointStateCircuitOpen indicates that requests are not being sent to the
+	// endpoint as its circuit breaker is open.
+	EndpointStateCircuitOpen = EndpointState("circuit_open")
+	// EndpointStateDrained indicates that requests are not being sent to the endpoint
+	// as its node is being drained.
+	EndpointStateDrained = EndpointState("drained")
+)
+
+// DiagnosticEntry describes the state of a single endpoint of a service.
+//
+// Experimental: This API is subject to change at any time.
+type DiagnosticEntry struct {
+	Service ServiceType
+	// Endpoint is the address of an HTTP service endpoint.  It is empty for data
+	// nodes, which are identified by Node.
+	Endpoint string
+	// Node is the index of a data node in the cluster map of the bucket.
+	Node  int
+	State EndpointState
+	// LastActivity is when the last request to the endpoint completed, or zero if it
+	// is not known.
+	LastActivity time.Time
+}
+
+// DiagnosticsReport describes the state of the endpoints of a bucket, as passively
+// observed from the requests sent to them.  Unlike Ping, no requests are sent to
+// produce it.  It can be encoded to JSON for support tickets and dashboards.
+//
+// Experimental: This API is subject to change at any time.
+type DiagnosticsReport struct {
+	Bucket    string
+	CreatedAt time.Time
+	Services  []DiagnosticEntry
+}
+
+type jsonDiagnosticEntry struct {
+	Remote         string `json:"remote"`
+	State          string `json:"state"`
+	LastActivityUs uint64 `json:"last_activity_us,omitempty"`
+}
+
+// MarshalJSON encodes the report as a version 1 diagnostics report, in which the
+// endpoints are grouped by service and their last activity is given as the time since
+// the report was created.
+func (report *DiagnosticsReport) MarshalJSON() ([]byte, error) {
+	services := make(map[string][]jsonDiagnosticEntry)
+	for _, entry := range report.Services {
+		jsonEntry := jsonDiagnosticEntry{
+			Remote: entry.Endpoint,
+			State:  string(entry.State),
+		}
+		if entry.Service == MemdService {
+			jsonEntry.Remote = fmt.Sprintf("node/%d", entry.Node)
+		}
+		if !entry.LastActivity.IsZero() {
+			jsonEntry.LastActivityUs = uint64(report.CreatedAt.Sub(entry.LastActivity) / time.Microsecond)
+		}
+		name := serviceName(entry.Service)
+		services[name] = append(services[name], jsonEntry)
+	}
+
+	return json.Marshal(struct {
+		Version  int                              `json:"version"`
+		Bucket   string                           `json:"bucket"`
+		Services map[string][]jsonDiagnosticEntry `json:"services"`
+	}{
+		Version:  1,
+		Bucket:   report.Bucket,
+		Services: services,
+	})
+}
+
+// isOpen returns whether the breaker of the node or endpoint identified by key is
+// preventing requests from being sent to it.
+func (cb *circuitBreakers) isOpen(key string) bool {
+	if cb == nil {
+		return false
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	breaker := cb.breakers[key]
+	return cb.config.Enabled && breaker != nil && breaker.state != circuitClosed
+}
+
+// httpDiagnostics returns the state of an endpoint of an HTTP service.
+func (c *Cluster) httpDiagnostics(service ServiceType, ep string) DiagnosticEntry {
+	entry := DiagnosticEntry{
+		Service:  service,
+		Endpoint: ep,
+		State:    EndpointStateIdle,
+	}
+
+	if c.endpoints != nil {
+		c.endpoints.lock.Lock()
+		if estimate := c.endpoints.estimates[ep]; estimate != nil && !estimate.LastSample.IsZero() {
+			entry.LastActivity = estimate.LastSample
+			entry.State = EndpointStateConnected
+			if !estimate.Healthy {
+				entry.State = EndpointStateError
+			}
+		}
+		c.endpoints.lock.Unlock()
+	}
+
+	if c.breakers.isOpen(ep) {
+		entry.State = EndpointStateCircuitOpen
+	}
+	if c.isDrained(drainedHost(ep)) {
+		entry.State = EndpointStateDrained
+	}
+	return entry
+}
+
+// Diagnostics returns the state of every data node and view endpoint of the bucket, as
+// observed from the requests recently sent to them.  The last activity of data nodes is
+// not tracked, so their state only reflects failures.  The N1QL, FTS and analytics
+// endpoints shared with the other buckets of the cluster are reported by
+// Cluster.Diagnostics.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Diagnostics() (*DiagnosticsReport, error) {
+	report := &DiagnosticsReport{
+		Bucket:    b.name,
+		CreatedAt: time.Now(),
+	}
+
+	for node := 0; node < b.client.NumServers(); node++ {
+		entry := DiagnosticEntry{
+			Service: MemdService,
+			Node:    node,
+			State:   EndpointStateConnected,
+		}
+		if b.nodeHealth.isDown(node) {
+			entry.State = EndpointStateError
+		}
+		if b.cluster.breakers.isOpen(kvBreakerKey(b.name, node)) {
+			entry.State = EndpointStateCircuitOpen
+		}
+		report.Services = append(report.Services, entry)
+	}
+
+	for _, ep := range b.client.CapiEps() {
+		report.Services = append(report.Services, b.cluster.httpDiagnostics(CapiService, ep))
+	}
+	return report, nil
+}
+
+// Diagnostics returns the state of every endpoint of the N1QL, FTS and analytics
+// services, as observed from the requests recently sent to them.  These endpoints are
+// shared by every bucket opened from the cluster, so they are reported once here
+// rather than by the diagnostics of each bucket.  No endpoints are reported before a
+// bucket has been opened, unless analytics endpoints were specified by EnableAnalytics.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) Diagnostics() (*DiagnosticsReport, error) {
+	report := &DiagnosticsReport{
+		CreatedAt: time.Now(),
+	}
+
+	for _, service := range []ServiceType{N1qlService, FtsService, CbasService} {
+		for _, ep := range c.serviceEps(service, nil) {
+			report.Services = append(report.Services, c.httpDiagnostics(service, ep))
+		}
+	}
+	return report, nil
+}
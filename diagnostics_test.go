@@ -0,0 +1,61 @@
+package gocb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHttpDiagnostics(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &Cluster{endpoints: newEndpointSelector(), breakers: newTestCircuitBreakers(&now)}
+
+	if entry := c.httpDiagnostics(N1qlService, "http://a:8093"); entry.State != EndpointStateIdle || !entry.LastActivity.IsZero() {
+		t.Fatalf("Expected an unused endpoint to be idle, got %+v", entry)
+	}
+
+	c.recordEndpointLatency("http://a:8093", time.Millisecond, nil)
+	if entry := c.httpDiagnostics(N1qlService, "http://a:8093"); entry.State != EndpointStateConnected || entry.LastActivity.IsZero() {
+		t.Fatalf("Expected a connected endpoint, got %+v", entry)
+	}
+
+	c.recordEndpointLatency("http://a:8093", time.Millisecond, errors.New("refused"))
+	if entry := c.httpDiagnostics(N1qlService, "http://a:8093"); entry.State != EndpointStateError {
+		t.Fatalf("Expected a failed endpoint, got %+v", entry)
+	}
+
+	for i := 0; i < 4; i++ {
+		c.recordEndpointLatency("http://a:8093", time.Millisecond, ErrTimeout)
+	}
+	if entry := c.httpDiagnostics(N1qlService, "http://a:8093"); entry.State != EndpointStateCircuitOpen {
+		t.Fatalf("Expected an endpoint with an open breaker, got %+v", entry)
+	}
+}
+
+func TestDiagnosticsReportJSON(t *testing.T) {
+	now := time.Now()
+	report := &DiagnosticsReport{
+		Bucket:    "default",
+		CreatedAt: now,
+		Services: []DiagnosticEntry{
+			{Service: MemdService, Node: 1, State: EndpointStateConnected},
+			{Service: FtsService, Endpoint: "http://a:8094", State: EndpointStateConnected, LastActivity: now.Add(-time.Millisecond)},
+		},
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to encode report: %v", err)
+	}
+	var decoded struct {
+		Bucket   string                           `json:"bucket"`
+		Services map[string][]jsonDiagnosticEntry `json:"services"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if decoded.Bucket != "default" || decoded.Services["kv"][0].Remote != "node/1" || decoded.Services["fts"][0].LastActivityUs != 1000 {
+		t.Fatalf("Unexpected report %s", data)
+	}
+}
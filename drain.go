@@ -0,0 +1,193 @@
+package gocb
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// drainedHost returns the host a node is identified by when draining it, from either
+// a host:port pair or an endpoint URL.
+func drainedHost(hostport string) string {
+	if strings.Contains(hostport, "://") {
+		if epUrl, err := url.Parse(hostport); err == nil {
+			return epUrl.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
+
+// DrainNode marks a node as drained ahead of its planned removal from the cluster, so
+// that new work is sent elsewhere.  View, N1QL, FTS, analytics and management requests
+// are no longer sent to the node.  KV operations for vbuckets active on the node are
+// still sent to it, as the node remains the only place they can be performed until it
+// is rebalanced out, while replica reads skip the replicas held by the node unless
+// every replica is held by drained nodes.  The node is identified by its host, and any port is ignored.
+// Nodes remain drained until UndrainNode is called, or until the node is no longer part
+// of the cluster configuration.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) DrainNode(hostport string) error {
+	host := drainedHost(hostport)
+	if host == "" {
+		return clientError{"A node to drain must be specified."}
+	}
+
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	if c.drained == nil {
+		c.drained = make(map[string]bool)
+	}
+	c.drained[host] = true
+	return nil
+}
+
+// UndrainNode returns a node drained by DrainNode to service.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) UndrainNode(hostport string) {
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	delete(c.drained, drainedHost(hostport))
+}
+
+// DrainedNodes returns the hosts of the nodes which are currently drained.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) DrainedNodes() []string {
+	c.pruneDrainedNodes()
+
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	var hosts []string
+	for host := range c.drained {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (c *Cluster) isDrained(host string) bool {
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	return c.drained[host]
+}
+
+func (c *Cluster) hasDrainedNodes() bool {
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	return len(c.drained) > 0
+}
+
+// undrainedReplicas returns the indexes of the replicas from 1 to numReplicas which are
+// not held by drained nodes, or of every replica if all of them are.  replicaHost
+// returns the host of the node holding a replica, or an empty string if it is not known.
+func (c *Cluster) undrainedReplicas(numReplicas int, replicaHost func(replicaIdx int) string) []int {
+	var all, undrained []int
+	for replicaIdx := 1; replicaIdx <= numReplicas; replicaIdx++ {
+		all = append(all, replicaIdx)
+		if c == nil || !c.isDrained(replicaHost(replicaIdx)) {
+			undrained = append(undrained, replicaIdx)
+		}
+	}
+	if len(undrained) == 0 {
+		return all
+	}
+	return undrained
+}
+
+// replicaOrder returns the indexes of the replicas of key to read from, skipping those
+// held by drained nodes.
+func (b *Bucket) replicaOrder(key string) []int {
+	numReplicas := b.client.NumReplicas()
+	if b.cluster == nil || !b.cluster.hasDrainedNodes() {
+		return b.cluster.undrainedReplicas(numReplicas, func(int) string { return "" })
+	}
+
+	hosts := b.kvNodeHosts()
+	vbID := b.client.KeyToVbucket([]byte(key))
+	return b.cluster.undrainedReplicas(numReplicas, func(replicaIdx int) string {
+		node := b.client.VbucketToServer(vbID, uint32(replicaIdx))
+		if node < 0 || node >= len(hosts) {
+			return ""
+		}
+		return hosts[node]
+	})
+}
+
+// kvNodeHosts returns the host of each data node of the bucket, indexed as by
+// VbucketToServer, or nil if they are not known.  The agent reports the connections of
+// each data node in turn, with the same number of connections to every node.
+func (b *Bucket) kvNodeHosts() []string {
+	numServers := b.client.NumServers()
+	info, err := b.client.Diagnostics()
+	if err != nil || numServers <= 0 || len(info.MemdConns) == 0 || len(info.MemdConns)%numServers != 0 {
+		return nil
+	}
+
+	perNode := len(info.MemdConns) / numServers
+	hosts := make([]string, numServers)
+	for node := range hosts {
+		hosts[node] = drainedHost(info.MemdConns[node*perNode].RemoteAddr)
+	}
+	return hosts
+}
+
+// pruneDrainedNodes undrains nodes which are no longer part of the configuration of any
+// open bucket.  Every node runs the management service, so the management endpoints
+// list every node of the cluster.
+func (c *Cluster) pruneDrainedNodes() {
+	if !c.hasDrainedNodes() {
+		return
+	}
+
+	c.clusterLock.RLock()
+	buckets := append([]*Bucket{}, c.bucketList...)
+	c.clusterLock.RUnlock()
+
+	present := make(map[string]bool)
+	for _, bucket := range buckets {
+		for _, ep := range bucket.client.MgmtEps() {
+			present[drainedHost(ep)] = true
+		}
+	}
+	c.undrainAbsentNodes(present)
+}
+
+func (c *Cluster) undrainAbsentNodes(present map[string]bool) {
+	if len(present) == 0 {
+		// The configuration is not known, so nodes cannot be said to have left it.
+		return
+	}
+
+	c.drainLock.Lock()
+	defer c.drainLock.Unlock()
+	for host := range c.drained {
+		if !present[host] {
+			logDebugf("Undraining %s, which has left the cluster", redactSystemData(host))
+			delete(c.drained, host)
+		}
+	}
+}
+
+// availableEps returns the endpoints which are not on drained nodes, and which use TLS
+// if the connection string does.
+func (c *Cluster) availableEps(eps []string) []string {
+	eps = c.secureEps(eps)
+	if c == nil || !c.hasDrainedNodes() {
+		return eps
+	}
+	c.pruneDrainedNodes()
+
+	var available []string
+	for _, ep := range eps {
+		if !c.isDrained(drainedHost(ep)) {
+			available = append(available, ep)
+		}
+	}
+	return available
+}
@@ -0,0 +1,76 @@
+package gocb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDrainNode(t *testing.T) {
+	c := &Cluster{}
+	eps := []string{"http://10.0.0.1:8093", "http://10.0.0.2:8093", "https://[fd00::3]:18093"}
+
+	if err := c.DrainNode("10.0.0.2:11210"); err != nil {
+		t.Fatalf("Failed to drain node: %v", err)
+	}
+	if err := c.DrainNode("fd00::3"); err != nil {
+		t.Fatalf("Failed to drain node: %v", err)
+	}
+	if err := c.DrainNode(""); err == nil {
+		t.Fatalf("Expected an error draining no node")
+	}
+
+	available := c.availableEps(eps)
+	if !reflect.DeepEqual(available, []string{"http://10.0.0.1:8093"}) {
+		t.Fatalf("Expected drained endpoints to be skipped, got %v", available)
+	}
+	if !reflect.DeepEqual(c.DrainedNodes(), []string{"10.0.0.2", "fd00::3"}) {
+		t.Fatalf("Expected drained nodes to be reported, got %v", c.DrainedNodes())
+	}
+	if !reflect.DeepEqual(c.Metrics().DrainedNodes, c.DrainedNodes()) {
+		t.Fatalf("Expected drained nodes in the metrics, got %v", c.Metrics().DrainedNodes)
+	}
+
+	// Nodes stay drained through configurations which still include them, and are
+	// undrained once they leave.
+	c.undrainAbsentNodes(map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "fd00::3": true})
+	if len(c.DrainedNodes()) != 2 {
+		t.Fatalf("Expected nodes to remain drained, got %v", c.DrainedNodes())
+	}
+	c.undrainAbsentNodes(nil)
+	if len(c.DrainedNodes()) != 2 {
+		t.Fatalf("Expected nodes to remain drained without a configuration, got %v", c.DrainedNodes())
+	}
+	c.undrainAbsentNodes(map[string]bool{"10.0.0.1": true, "10.0.0.2": true})
+	if !reflect.DeepEqual(c.DrainedNodes(), []string{"10.0.0.2"}) {
+		t.Fatalf("Expected the removed node to be undrained, got %v", c.DrainedNodes())
+	}
+
+	c.UndrainNode("10.0.0.2:8091")
+	if len(c.DrainedNodes()) != 0 || len(c.availableEps(eps)) != 3 {
+		t.Fatalf("Expected every node to be undrained, got %v", c.DrainedNodes())
+	}
+}
+
+func TestUndrainedReplicas(t *testing.T) {
+	c := &Cluster{}
+	hosts := map[int]string{1: "10.0.0.1", 2: "10.0.0.2", 3: "10.0.0.3"}
+	replicaHost := func(replicaIdx int) string {
+		return hosts[replicaIdx]
+	}
+
+	if replicas := c.undrainedReplicas(3, replicaHost); !reflect.DeepEqual(replicas, []int{1, 2, 3}) {
+		t.Fatalf("Expected every replica without drained nodes, got %v", replicas)
+	}
+	c.DrainNode("10.0.0.2:11210")
+	if replicas := c.undrainedReplicas(3, replicaHost); !reflect.DeepEqual(replicas, []int{1, 3}) {
+		t.Fatalf("Expected the replica on the drained node to be skipped, got %v", replicas)
+	}
+	c.DrainNode("10.0.0.1")
+	c.DrainNode("10.0.0.3")
+	if replicas := c.undrainedReplicas(3, replicaHost); !reflect.DeepEqual(replicas, []int{1, 2, 3}) {
+		t.Fatalf("Expected every replica when all are drained, got %v", replicas)
+	}
+	if replicas := c.undrainedReplicas(0, replicaHost); len(replicas) != 0 {
+		t.Fatalf("Expected no replicas, got %v", replicas)
+	}
+}
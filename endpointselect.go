@@ -0,0 +1,263 @@
+package gocb
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EndpointSelectionPolicy specifies how the endpoint a view, N1QL, FTS or analytics
+// request is sent to is chosen from those available.
+//
+// Experimental: This API is subject to change at any time.
+type EndpointSelectionPolicy int
+
+const (
+	// EndpointRoundRobin sends requests to each available endpoint in turn.  This is
+	// the default.
+	EndpointRoundRobin = EndpointSelectionPolicy(iota)
+	// EndpointLatencyWeighted sends requests to endpoints at random, weighted by the
+	// inverse of their estimated latency so that nearer endpoints receive more.
+	EndpointLatencyWeighted
+	// EndpointNearestOnly sends requests to the endpoint with the lowest estimated
+	// latency, falling back to EndpointRoundRobin when no endpoint is healthy.
+	EndpointNearestOnly
+)
+
+func (p EndpointSelectionPolicy) String() string {
+	switch p {
+	case EndpointRoundRobin:
+		return "RoundRobin"
+	case EndpointLatencyWeighted:
+		return "LatencyWeighted"
+	case EndpointNearestOnly:
+		return "NearestOnly"
+	}
+	return "Unknown"
+}
+
+// The weight given to each new request duration in the latency estimate of an
+// endpoint, how long an endpoint may go without a request before it is sent one to
+// refresh its estimate or to detect its recovery if its last request failed, and the
+// lowest latency an endpoint is weighted as having.
+var (
+	endpointLatencyWeight  = 0.2
+	endpointProbeInterval  = 10 * time.Second
+	endpointLatencyMinimum = 100 * time.Microsecond
+)
+
+// EndpointLatency describes the latency estimated for an endpoint.
+//
+// Experimental: This API is subject to change at any time.
+type EndpointLatency struct {
+	// Estimate is the exponentially weighted moving average of the durations of
+	// requests to the endpoint, until their response headers were received.
+	Estimate time.Duration `json:"estimate"`
+	// Samples is the number of requests the estimate was calculated from.
+	Samples uint64 `json:"samples"`
+	// Healthy is false when the last request to the endpoint failed.
+	Healthy bool `json:"healthy"`
+	// LastSample is when the last request to the endpoint completed.
+	LastSample time.Time `json:"last_sample"`
+}
+
+type endpointEstimate struct {
+	EndpointLatency
+	lastProbe time.Time
+}
+
+// endpointSelector tracks the latency of the HTTP service endpoints of a cluster and
+// chooses between them according to the selection policy.
+type endpointSelector struct {
+	lock      sync.Mutex
+	policy    EndpointSelectionPolicy
+	estimates map[string]*endpointEstimate
+	next      map[string]int
+	now       func() time.Time
+	random    func() float64
+}
+
+func newEndpointSelector() *endpointSelector {
+	return &endpointSelector{
+		estimates: make(map[string]*endpointEstimate),
+		next:      make(map[string]int),
+		now:       time.Now,
+		random:    rand.Float64,
+	}
+}
+
+// EndpointSelectionPolicy returns the policy used to choose the endpoint of view,
+// N1QL, FTS and analytics requests.
+func (c *Cluster) EndpointSelectionPolicy() EndpointSelectionPolicy {
+	if c.endpoints == nil {
+		return EndpointRoundRobin
+	}
+	c.endpoints.lock.Lock()
+	defer c.endpoints.lock.Unlock()
+	return c.endpoints.policy
+}
+
+// SetEndpointSelectionPolicy sets the policy used to choose the endpoint of view,
+// N1QL, FTS and analytics requests.  The latency of each endpoint is estimated from
+// the requests sent to it regardless of the policy.  Under the latency based policies,
+// endpoints which have not been sent a request recently, including those whose last
+// request failed, are still sent one occasionally so that their estimates remain
+// current and their recovery is detected.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetEndpointSelectionPolicy(policy EndpointSelectionPolicy) {
+	if c.endpoints == nil {
+		return
+	}
+	c.endpoints.lock.Lock()
+	defer c.endpoints.lock.Unlock()
+	c.endpoints.policy = policy
+}
+
+// EndpointLatencies returns the latency estimated for each HTTP service endpoint which
+// has been chosen for a request, keyed by endpoint.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) EndpointLatencies() map[string]EndpointLatency {
+	latencies := make(map[string]EndpointLatency)
+	if c.endpoints == nil {
+		return latencies
+	}
+	c.endpoints.lock.Lock()
+	defer c.endpoints.lock.Unlock()
+	for ep, estimate := range c.endpoints.estimates {
+		latencies[ep] = estimate.EndpointLatency
+	}
+	return latencies
+}
+
+// selectEndpoint chooses the endpoint of a request to a service from eps, which must
+// not be empty.
+func (c *Cluster) selectEndpoint(service string, eps []string) string {
+	if c == nil || c.endpoints == nil {
+		return eps[rand.Intn(len(eps))]
+	}
+	return c.endpoints.selectEndpoint(service, eps)
+}
+
+// recordEndpointLatency records the duration of a request to an endpoint, and whether
+// it failed to receive a response, which is also counted by its circuit breaker.
+func (c *Cluster) recordEndpointLatency(ep string, elapsed time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.breakers.record(ep, isNodeFailure(err))
+	if c.endpoints == nil {
+		return
+	}
+	c.endpoints.record(ep, elapsed, err)
+}
+
+func (s *endpointSelector) record(ep string, elapsed time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	estimate := s.estimates[ep]
+	if estimate == nil {
+		estimate = &endpointEstimate{}
+		s.estimates[ep] = estimate
+	}
+	estimate.LastSample = s.now()
+	estimate.Healthy = err == nil
+	if err != nil {
+		return
+	}
+
+	if estimate.Samples == 0 {
+		estimate.Estimate = elapsed
+	} else {
+		estimate.Estimate += time.Duration(endpointLatencyWeight * float64(elapsed-estimate.Estimate))
+	}
+	estimate.Samples++
+}
+
+func (s *endpointSelector) selectEndpoint(service string, eps []string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.policy != EndpointRoundRobin {
+		if ep, ok := s.probe(eps); ok {
+			return ep
+		}
+
+		var healthy []string
+		for _, ep := range eps {
+			if estimate := s.estimates[ep]; estimate != nil && estimate.Healthy && estimate.Samples > 0 {
+				healthy = append(healthy, ep)
+			}
+		}
+		if len(healthy) > 0 {
+			if s.policy == EndpointNearestOnly {
+				return s.nearest(healthy)
+			}
+			return s.weighted(healthy)
+		}
+	}
+
+	i := s.next[service] % len(eps)
+	s.next[service] = i + 1
+	return eps[i]
+}
+
+// probe returns an endpoint which has not been sent a request recently, if there is
+// one which has not already been probed recently.
+func (s *endpointSelector) probe(eps []string) (string, bool) {
+	now := s.now()
+	for _, ep := range eps {
+		estimate := s.estimates[ep]
+		if estimate == nil {
+			estimate = &endpointEstimate{}
+			s.estimates[ep] = estimate
+		}
+		stale := estimate.LastSample.IsZero() || now.Sub(estimate.LastSample) >= endpointProbeInterval
+		if estimate.Healthy && !stale {
+			continue
+		}
+		if now.Sub(estimate.lastProbe) < endpointProbeInterval {
+			continue
+		}
+		estimate.lastProbe = now
+		return ep, true
+	}
+	return "", false
+}
+
+func (s *endpointSelector) nearest(eps []string) string {
+	sort.Strings(eps)
+	nearest := eps[0]
+	for _, ep := range eps[1:] {
+		if s.estimates[ep].Estimate < s.estimates[nearest].Estimate {
+			nearest = ep
+		}
+	}
+	return nearest
+}
+
+func (s *endpointSelector) weighted(eps []string) string {
+	sort.Strings(eps)
+	weights := make([]float64, len(eps))
+	total := 0.0
+	for i, ep := range eps {
+		latency := s.estimates[ep].Estimate
+		if latency < endpointLatencyMinimum {
+			latency = endpointLatencyMinimum
+		}
+		weights[i] = 1 / float64(latency)
+		total += weights[i]
+	}
+
+	target := s.random() * total
+	for i, weight := range weights {
+		if target < weight {
+			return eps[i]
+		}
+		target -= weight
+	}
+	return eps[len(eps)-1]
+}
@@ -0,0 +1,114 @@
+package gocb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestEndpointSelector(now *time.Time) *endpointSelector {
+	s := newEndpointSelector()
+	s.now = func() time.Time { return *now }
+	s.random = func() float64 { return 0.5 }
+	return s
+}
+
+func TestEndpointSelectionPolicies(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newTestEndpointSelector(&now)
+	eps := []string{"http://near:8093", "http://far:8093"}
+
+	// Round robin alternates regardless of latency.
+	s.record(eps[0], 2*time.Millisecond, nil)
+	s.record(eps[1], 40*time.Millisecond, nil)
+	for i := 0; i < 4; i++ {
+		if ep := s.selectEndpoint("n1ql", eps); ep != eps[i%2] {
+			t.Fatalf("Round robin %d: expected %s, got %s", i, eps[i%2], ep)
+		}
+	}
+
+	s.policy = EndpointNearestOnly
+	for i := 0; i < 4; i++ {
+		if ep := s.selectEndpoint("n1ql", eps); ep != eps[0] {
+			t.Fatalf("Nearest only %d: expected %s, got %s", i, eps[0], ep)
+		}
+	}
+
+	// The nearer endpoint has 20 times the weight of the farther one, which is
+	// weighted first as it sorts first.
+	s.policy = EndpointLatencyWeighted
+	s.random = func() float64 { return 0.06 }
+	if ep := s.selectEndpoint("n1ql", eps); ep != eps[0] {
+		t.Fatalf("Expected weighted selection of %s, got %s", eps[0], ep)
+	}
+	s.random = func() float64 { return 0.04 }
+	if ep := s.selectEndpoint("n1ql", eps); ep != eps[1] {
+		t.Fatalf("Expected weighted selection of %s, got %s", eps[1], ep)
+	}
+}
+
+func TestEndpointSelectionProbes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newTestEndpointSelector(&now)
+	s.policy = EndpointNearestOnly
+	eps := []string{"http://a:8093", "http://b:8093", "http://c:8093"}
+
+	latencies := map[string]time.Duration{eps[0]: 30 * time.Millisecond, eps[1]: 5 * time.Millisecond}
+	failing := map[string]bool{eps[2]: true}
+	send := func() string {
+		ep := s.selectEndpoint("n1ql", eps)
+		if failing[ep] {
+			s.record(ep, time.Second, errors.New("connection refused"))
+		} else {
+			s.record(ep, latencies[ep], nil)
+		}
+		return ep
+	}
+
+	// Cold endpoints are each probed once before the nearest is used.
+	for i, expected := range []string{eps[0], eps[1], eps[2], eps[1], eps[1]} {
+		if ep := send(); ep != expected {
+			t.Fatalf("Request %d: expected %s, got %s", i, expected, ep)
+		}
+	}
+	if latency := s.estimates[eps[2]]; latency.Healthy {
+		t.Fatalf("Expected %s to be unhealthy", eps[2])
+	}
+
+	// Once the probe interval has elapsed, the idle and unhealthy endpoints are probed
+	// again, and the recovered endpoint is then preferred.
+	now = now.Add(endpointProbeInterval)
+	failing = nil
+	latencies[eps[2]] = time.Millisecond
+	for i, expected := range []string{eps[0], eps[1], eps[2], eps[2], eps[2]} {
+		if ep := send(); ep != expected {
+			t.Fatalf("Request %d after probe interval: expected %s, got %s", i, expected, ep)
+		}
+	}
+
+	// Without any healthy endpoints, requests fall back to round robin.
+	now = now.Add(time.Second)
+	for _, ep := range eps {
+		s.record(ep, time.Second, errors.New("connection refused"))
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(eps); i++ {
+		seen[s.selectEndpoint("n1ql", eps)] = true
+	}
+	if len(seen) != len(eps) {
+		t.Fatalf("Expected every endpoint to be used, got %v", seen)
+	}
+}
+
+func TestEndpointLatencyEstimate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newTestEndpointSelector(&now)
+	s.record("http://a:8093", 10*time.Millisecond, nil)
+	s.record("http://a:8093", 20*time.Millisecond, nil)
+
+	c := &Cluster{endpoints: s}
+	latency := c.EndpointLatencies()["http://a:8093"]
+	if latency.Estimate != 12*time.Millisecond || latency.Samples != 2 || !latency.Healthy {
+		t.Fatalf("Unexpected latency %+v", latency)
+	}
+}
@@ -1,9 +1,15 @@
 package gocb
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // MultiError encapsulates multiple errors that may be returned by one method.
@@ -45,11 +51,161 @@ func (e clientError) Error() string {
 	return e.message
 }
 
+// detailedError attaches operation-specific detail to one of the error values
+// exported by this package.  ErrorCause returns the exported value.
+type detailedError struct {
+	cause   error
+	message string
+}
+
+func (e detailedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the exported error value which this error describes.
+func (e detailedError) Unwrap() error {
+	return e.cause
+}
+
+// ValueTooLargeError occurs when a document is larger than the size limit specified for
+// retrieving it.  Its cause is ErrValueTooLarge.
+type ValueTooLargeError struct {
+	// Size is the size of the document value in bytes.
+	Size uint32
+	// MaxBytes is the limit which the document exceeded.
+	MaxBytes uint32
+}
+
+func (e ValueTooLargeError) Error() string {
+	return fmt.Sprintf("The document is %d bytes, which is larger than the limit of %d bytes.", e.Size, e.MaxBytes)
+}
+
+// Unwrap returns ErrValueTooLarge.
+func (e ValueTooLargeError) Unwrap() error {
+	return ErrValueTooLarge
+}
+
+// OperationError wraps an error returned by an operation with the context in which
+// it occurred.  The error returned by the operation itself is available through
+// Unwrap or ErrorCause.  OperationErrors are only returned while enriched errors are
+// enabled on the Cluster.
+type OperationError struct {
+	// Operation is the name of the method which failed, such as "Get".
+	Operation string
+	// Bucket is the name of the bucket the operation was performed against.
+	Bucket string
+	// Key is the document key the operation was performed against, if any.
+	Key string
+	// StatementHash is a digest of the query statement which failed, if any.
+	StatementHash string
+	// Endpoint is the address of the HTTP endpoint the request was sent to.  This
+	// is only known for view, query and search operations.
+	Endpoint string
+	// Elapsed is the time between the operation being started and it failing.
+	Elapsed time.Duration
+	// RetryReport describes the retries performed by the operation, if any.
+	RetryReport *RetryReport
+	// Err is the error returned by the operation.
+	Err error
+
+	// cas is the CAS the operation was performed with, if it is not implied by the
+	// operation itself.
+	cas Cas
+}
+
+// Error formats the error as a single line of key=value pairs, with user data redacted.
+func (e *OperationError) Error() string {
+	fields := []string{"operation=" + e.Operation}
+	if e.Bucket != "" {
+		fields = append(fields, "bucket="+redactMetaData(e.Bucket))
+	}
+	if e.Key != "" {
+		fields = append(fields, "key="+redactKey(e.Key))
+	}
+	if e.StatementHash != "" {
+		fields = append(fields, "statement="+e.StatementHash)
+	}
+	if e.Endpoint != "" {
+		fields = append(fields, "endpoint="+redactSystemData(e.Endpoint))
+	}
+	fields = append(fields, "elapsed="+e.Elapsed.String())
+	if e.RetryReport != nil {
+		fields = append(fields, "retries="+strconv.Itoa(e.RetryReport.Retries))
+	}
+	fields = append(fields, "error="+strconv.Quote(e.Err.Error()))
+	return strings.Join(fields, " ")
+}
+
+// Unwrap returns the error returned by the operation.
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+func statementHash(statement string) string {
+	digest := sha256.Sum256([]byte(statement))
+	return hex.EncodeToString(digest[:8])
+}
+
+// wrapOperationError attaches operation context to an error when enriched errors
+// are enabled.  Errors which already carry context are returned unchanged, so the
+// innermost public operation is the one reported.
+func (c *Cluster) wrapOperationError(err error, opErr *OperationError) error {
+	if err == nil || c == nil || !c.enrichedErrors {
+		return err
+	}
+	if _, ok := err.(*OperationError); ok {
+		return err
+	}
+
+	opErr.Err = err
+	return opErr
+}
+
+func (b *Bucket) wrapError(err error, operation, key string, start time.Time) error {
+	return b.wrapOpError(nil, err, operation, key, start)
+}
+
+// wrapOpError behaves as wrapError for an operation performed using the specified
+// options, tracing it as a child of their parent span.
+func (b *Bucket) wrapOpError(opts *kvOpOptions, err error, operation, key string, start time.Time) error {
+	err, retryReport := unwrapRetriedError(err)
+	b.cluster.recordOperation("kv", b.name, operation, err, time.Since(start))
+	b.traceOperation(operation, opts.span(), start, err)
+	if err == nil {
+		return nil
+	}
+	return b.cluster.wrapOperationError(err, &OperationError{
+		Operation:   operation,
+		Bucket:      b.name,
+		Key:         key,
+		Elapsed:     time.Since(start),
+		RetryReport: retryReport,
+	})
+}
+
+// unwrapOperationError returns the error returned by an operation, without the
+// context added by an OperationError.
+func unwrapOperationError(err error) error {
+	if opErr, ok := err.(*OperationError); ok {
+		return opErr.Err
+	}
+	err, _ = unwrapRetriedError(err)
+	return err
+}
+
 var (
 	// ErrNotEnoughReplicas occurs when not enough replicas exist to match the specified durability requirements.
+	// See DurabilityError.
 	ErrNotEnoughReplicas = errors.New("Not enough replicas to match durability requirements.")
 	// ErrDurabilityTimeout occurs when the server took too long to meet the specified durability requirements.
+	// See DurabilityError.
 	ErrDurabilityTimeout = errors.New("Failed to meet durability requirements in time.")
+	// ErrVbucketUUIDChanged occurs when the vbucket a mutation was performed on has failed over
+	// since the mutation was performed, meaning the mutation may have been rolled back.
+	ErrVbucketUUIDChanged = errors.New("The vbucket has failed over since the mutation was performed.")
+	// ErrMutationLost occurs when the vbucket a mutation was performed on has failed over or
+	// rolled back to a point before the mutation, meaning the mutation was lost.
+	ErrMutationLost = errors.New("The mutation was rolled back and has been lost.")
 	// ErrNoResults occurs when no results are available to a query.
 	ErrNoResults = errors.New("No results returned.")
 	// ErrNoOpenBuckets occurs when a cluster-level operation is performed before any buckets are opened.
@@ -62,8 +218,54 @@ var (
 	ErrIndexNotFound = errors.New("The index specified does not exist.")
 	// ErrIndexAlreadyExists occurs when an operation expects an index not to exist, but it was found.
 	ErrIndexAlreadyExists = errors.New("The index specified already exists.")
+	// ErrDesignDocumentNotFound occurs when an operation expects a design document but it was not found.
+	ErrDesignDocumentNotFound = errors.New("The design document specified does not exist.")
+	// ErrDesignDocumentAlreadyExists occurs when an operation expects a design document not to exist, but it was found.
+	ErrDesignDocumentAlreadyExists = errors.New("The design document specified already exists.")
+	// ErrFlushDisabled occurs when a bucket is flushed but flush is not enabled in its settings.
+	ErrFlushDisabled = errors.New("Flush is not enabled for the bucket.")
+	// ErrSearchIndexNotFound occurs when an operation expects a search index but it was not found.
+	ErrSearchIndexNotFound = errors.New("The search index specified does not exist.")
 	// ErrFacetNoRanges occurs when a range-based facet is specified but no ranges were indicated.
 	ErrFacetNoRanges = errors.New("At least one range must be specified on a facet.")
+	// ErrInvalidValue occurs when a value of a type which cannot be encoded is passed to a mutation.
+	ErrInvalidValue = errors.New("The value specified cannot be encoded for storage.")
+	// ErrCountNotSupported occurs when the number of rows a query would return cannot be
+	// determined without executing it in full.
+	ErrCountNotSupported = errors.New("The number of rows cannot be counted for this query.")
+	// ErrUnexpectedRedirect occurs when an HTTP service redirects a request to a host which is not
+	// a known cluster endpoint, or redirects too many times.
+	ErrUnexpectedRedirect = errors.New("The request was redirected to an unexpected location.")
+	// ErrNetworkAmbiguous occurs when the connection a mutation was dispatched on is lost
+	// before a response is received, so the mutation may or may not have been applied.
+	ErrNetworkAmbiguous = errors.New("The connection was lost before the outcome of the operation was known.")
+	// ErrValueTooLarge occurs when a document is larger than the size limit specified for
+	// retrieving it.  The error returned is a ValueTooLargeError describing its size.
+	ErrValueTooLarge = errors.New("The document is larger than the specified limit.")
+	// ErrTLSVerification occurs when the certificate presented by a server cannot be verified
+	// against the trusted certificates, even after they are reloaded.
+	ErrTLSVerification = errors.New("The server certificate could not be verified.")
+	// ErrAborted is reported by the Err method of query results when One aborted the
+	// rest of the response, so the metadata was never received.  See ResultMetadataErr.
+	ErrAborted = errors.New("The query response was aborted before its metadata was received.")
+	// ErrPotentiallyUnsafeStatement occurs when strict statements are enabled and a N1QL
+	// statement appears to contain interpolated user data.  The error describes the reason.
+	ErrPotentiallyUnsafeStatement = errors.New("The statement appears to contain interpolated user data.")
+	// ErrNodeUnavailable occurs when an operation of a bulk request is not attempted because
+	// the node it would be sent to is known to be unavailable.
+	ErrNodeUnavailable = errors.New("The operation was not attempted as its node is unavailable.")
+	// ErrSnapshotContention occurs when GetConsistentSnapshot cannot observe a stable snapshot
+	// because some of its documents kept changing.  See SnapshotContentionError.
+	ErrSnapshotContention = errors.New("The documents kept changing while the snapshot was being read.")
+	// ErrSubDocPathNotRequested occurs when the content of a path is requested from a
+	// DocumentFragment whose operations did not include that path.
+	ErrSubDocPathNotRequested = errors.New("The path was not part of the sub-document operations.")
+	// ErrSyncDurabilityNotSupported occurs when a mutation requests a DurabilityLevel from a
+	// cluster which does not support synchronous replication.  See SyncDurabilityNotSupportedError.
+	ErrSyncDurabilityNotSupported = errors.New("The cluster does not support synchronous replication.")
+	// ErrCircuitOpen occurs when an operation is not attempted because the circuit breaker of
+	// the node or endpoint it would be sent to is open.  See CircuitBreakerConfig.
+	ErrCircuitOpen = errors.New("The operation was not attempted as the circuit breaker of its node is open.")
 
 	// ErrDispatchFail occurs when we failed to execute an operation due to internal routing issues.
 	ErrDispatchFail = gocbcore.ErrDispatchFail
@@ -188,12 +390,35 @@ var (
 	ErrSubDocMultiPathFailureDeleted = gocbcore.ErrSubDocMultiPathFailureDeleted
 )
 
+// matchErrorChain returns whether match is true for err, or for any error it wraps
+// through an Unwrap method, so that the predicates below see through the errors which
+// wrap those returned by the server, including those of applications.
+func matchErrorChain(err error, match func(err error) bool) bool {
+	for err != nil {
+		if match(err) {
+			return true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+func isKvStatusError(err error, code gocbcore.StatusCode) bool {
+	return matchErrorChain(err, func(err error) bool {
+		return gocbcore.IsErrorStatus(err, code)
+	})
+}
+
 // IsKeyExistsError indicates whether the passed error is a
 // key-value "Key Already Exists" error.
 //
 // Experimental: This API is subject to change at any time.
 func IsKeyExistsError(err error) bool {
-	return gocbcore.IsErrorStatus(err, gocbcore.StatusKeyExists)
+	return isKvStatusError(err, gocbcore.StatusKeyExists)
 }
 
 // IsKeyNotFoundError indicates whether the passed error is a
@@ -201,10 +426,116 @@ func IsKeyExistsError(err error) bool {
 //
 // Experimental: This API is subject to change at any time.
 func IsKeyNotFoundError(err error) bool {
-	return gocbcore.IsErrorStatus(err, gocbcore.StatusKeyNotFound)
+	return isKvStatusError(err, gocbcore.StatusKeyNotFound)
 }
 
-// ErrorCause returns the underlying error for an enhanced error.
+// IsKeyLockedError indicates whether the passed error is a key-value
+// "Locked" error, which occurs when a document locked with GetAndLock is
+// accessed or unlocked without the CAS returned by GetAndLock.
+//
+// Experimental: This API is subject to change at any time.
+func IsKeyLockedError(err error) bool {
+	return isKvStatusError(err, gocbcore.StatusLocked)
+}
+
+// IsCasMismatchError indicates whether the passed error occurred because the CAS
+// given to a mutation no longer matches that of the document, as it has been modified
+// since the CAS was read.  The server reports a CAS mismatch with the same status as
+// an Insert of a key which already exists, so it is only distinguished from one by the
+// operation which failed: a Replace, a Remove or a MutateIn given a CAS.  The operation
+// is only known while enriched errors are enabled on the Cluster, otherwise this always
+// returns false.
+//
+// Experimental: This API is subject to change at any time.
+func IsCasMismatchError(err error) bool {
+	return IsKeyExistsError(err) && isCasOperationError(err)
+}
+
+// isCasOperationError indicates whether the passed error was returned by an operation
+// which can only fail with a "Key Already Exists" status because of its CAS.
+func isCasOperationError(err error) bool {
+	return matchErrorChain(err, func(err error) bool {
+		opErr, ok := err.(*OperationError)
+		if !ok {
+			return false
+		}
+		switch opErr.Operation {
+		case "Replace", "ReplaceDura", "ReplaceMt", "Remove", "RemoveDura", "RemoveMt", "SoftRemove":
+			return true
+		case "MutateIn":
+			return opErr.cas != 0
+		}
+		return false
+	})
+}
+
+// IsTempFailError indicates whether the passed error is a key-value error which the
+// server reports as a temporary condition, such as "Temporary Failure" or "Busy", in
+// which case the operation may succeed if it is performed again after a delay.
+//
+// Experimental: This API is subject to change at any time.
+func IsTempFailError(err error) bool {
+	kvErr, ok := AsKvError(err)
+	return ok && kvErr.Temporary()
+}
+
+// IsTimeoutError indicates whether the passed error is the result of an operation
+// timing out, including N1QL queries timing out within the query service or indexer.
+//
+// Experimental: This API is subject to change at any time.
+func IsTimeoutError(err error) bool {
+	return matchErrorChain(err, func(err error) bool {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return true
+		}
+		return ErrorCause(err) == ErrTimeout
+	})
+}
+
+// QueryErrorCode returns the code of the error reported by the N1QL or analytics
+// service which caused a query to fail, or false if it did not fail with an error
+// reported by the service.  If the service reported several errors, the code of the
+// first is returned.
+//
+// Experimental: This API is subject to change at any time.
+func QueryErrorCode(err error) (uint32, bool) {
+	var code uint32
+	found := matchErrorChain(err, func(err error) bool {
+		switch typedErr := err.(type) {
+		case interface{ Code() uint32 }:
+			code = typedErr.Code()
+			return true
+		case *N1qlTimeoutError:
+			code = typedErr.Code
+			return code != 0
+		}
+		return false
+	})
+	return code, found
+}
+
+// IsQueryError indicates whether the passed error was reported by the N1QL or
+// analytics service with the specified error code.
+//
+// Experimental: This API is subject to change at any time.
+func IsQueryError(err error, code uint32) bool {
+	errCode, ok := QueryErrorCode(err)
+	return ok && errCode == code
+}
+
+// ErrorCause returns the underlying error for an enhanced error.  The chain of errors
+// wrapped by err is followed through their Unwrap methods to the last error in it.
 func ErrorCause(err error) error {
+	for {
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		cause := wrapper.Unwrap()
+		if cause == nil {
+			break
+		}
+		err = cause
+	}
 	return gocbcore.ErrorCause(err)
 }
@@ -0,0 +1,192 @@
+package gocb
+
+import (
+	"errors"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOperationErrorWrapping(t *testing.T) {
+	errTest := errors.New("something failed")
+	fakeBucket := &Bucket{
+		cluster: &Cluster{enrichedErrors: true},
+		name:    "frank",
+	}
+
+	err := fakeBucket.wrapError(errTest, "Get", "key1", time.Now())
+	opErr, ok := err.(*OperationError)
+	if !ok {
+		t.Fatalf("Expected an OperationError, got %T", err)
+	}
+	if opErr.Operation != "Get" || opErr.Bucket != "frank" || opErr.Key != "key1" {
+		t.Fatalf("Unexpected operation error context %+v", opErr)
+	}
+	if ErrorCause(err) != errTest || opErr.Unwrap() != errTest {
+		t.Fatalf("Expected the cause to be recoverable from the operation error")
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, "\n") {
+		t.Fatalf("Expected a single line error message, got %s", msg)
+	}
+	if !strings.HasPrefix(msg, "operation=Get bucket=frank key=<ud>key1</ud> elapsed=") ||
+		!strings.HasSuffix(msg, " error=\"something failed\"") {
+		t.Fatalf("Unexpected error message %s", msg)
+	}
+
+	if fakeBucket.wrapError(err, "MapGet", "key1", time.Now()) != err {
+		t.Fatalf("Expected existing operation errors not to be wrapped again")
+	}
+
+	fakeBucket.cluster.SetEnrichedErrors(false)
+	if fakeBucket.wrapError(errTest, "Get", "key1", time.Now()) != errTest {
+		t.Fatalf("Expected errors to be returned unwrapped when enriched errors are disabled")
+	}
+}
+
+func TestValueTooLargeError(t *testing.T) {
+	err := error(ValueTooLargeError{Size: 4096, MaxBytes: 1024})
+	if ErrorCause(err) != ErrValueTooLarge {
+		t.Fatalf("Expected cause to be ErrValueTooLarge, got %v", ErrorCause(err))
+	}
+	if !strings.Contains(err.Error(), "4096") || !strings.Contains(err.Error(), "1024") {
+		t.Fatalf("Expected error to describe the sizes, got %s", err)
+	}
+
+	wrapped := &OperationError{Operation: "GetIfSmaller", Err: err}
+	if ErrorCause(wrapped) != ErrValueTooLarge {
+		t.Fatalf("Expected cause of wrapped error to be ErrValueTooLarge, got %v", ErrorCause(wrapped))
+	}
+	if tooLarge, ok := wrapped.Err.(ValueTooLargeError); !ok || tooLarge.Size != 4096 {
+		t.Fatalf("Expected the actual size to be available, got %v", wrapped.Err)
+	}
+}
+
+func TestDecodeValueBytes(t *testing.T) {
+	size, err := decodeValueBytes([]gocbcore.SubDocResult{{Value: []byte("1234")}}, nil)
+	if err != nil || size != 1234 {
+		t.Fatalf("Expected size 1234, got %d (%v)", size, err)
+	}
+
+	_, err = decodeValueBytes([]gocbcore.SubDocResult{{Err: ErrSubDocPathNotFound}}, ErrSubDocBadMulti)
+	if err != ErrSubDocBadMulti {
+		t.Fatalf("Expected operation error to be returned, got %v", err)
+	}
+
+	_, err = decodeValueBytes([]gocbcore.SubDocResult{{Err: ErrSubDocPathNotFound}}, nil)
+	if err != ErrSubDocPathNotFound {
+		t.Fatalf("Expected path error to be returned, got %v", err)
+	}
+}
+
+func TestAsKvError(t *testing.T) {
+	coreErr := &gocbcore.KvError{
+		Code:        gocbcore.StatusTmpFail,
+		Name:        "ETMPFAIL",
+		Description: "Temporary failure",
+		Context:     "The node is warming up",
+		Ref:         "a1b2",
+	}
+	c := &Cluster{enrichedErrors: true}
+	err := c.wrapOperationError(&retriedError{err: coreErr}, &OperationError{Operation: "Get"})
+
+	kvErr, ok := AsKvError(err)
+	if !ok {
+		t.Fatalf("Expected a KvError to be found in %v", err)
+	}
+	if kvErr.StatusCode != uint16(gocbcore.StatusTmpFail) || kvErr.Name != "ETMPFAIL" || kvErr.Context != "The node is warming up" || kvErr.Ref != "a1b2" {
+		t.Fatalf("Unexpected KvError %+v", kvErr)
+	}
+	if !kvErr.Temporary() || !kvErr.Retryable() {
+		t.Fatalf("Expected a temporary failure to be retryable")
+	}
+
+	kvErr, ok = AsKvError(gocbcore.KvError{Code: gocbcore.StatusKeyExists})
+	if !ok || kvErr.Retryable() || !kvErr.HasAttribute("item-only") {
+		t.Fatalf("Unexpected KvError %+v", kvErr)
+	}
+
+	kvErr, ok = AsKvError(gocbcore.KvError{Code: gocbcore.StatusCode(0x7f)})
+	if !ok || kvErr.DefaultAttributes != nil || kvErr.Temporary() || kvErr.Retryable() {
+		t.Fatalf("Expected an unknown status to be unclassified, got %+v", kvErr)
+	}
+
+	if _, ok := AsKvError(ErrTimeout); ok {
+		t.Fatalf("Expected no KvError for a client error")
+	}
+	if _, ok := AsKvError(nil); ok {
+		t.Fatalf("Expected no KvError for a nil error")
+	}
+}
+
+func TestErrorCauseFollowsUnwrap(t *testing.T) {
+	err := &appError{&OperationError{
+		Operation: "Get",
+		Err: &ReadFallbackError{
+			Err: &DurabilityError{Err: ErrDurabilityTimeout},
+		},
+	}}
+	if ErrorCause(err) != ErrDurabilityTimeout {
+		t.Fatalf("Expected the cause at the end of the chain, got %v", ErrorCause(err))
+	}
+	if ErrorCause(&ReadFallbackError{}) == nil {
+		t.Fatalf("Expected an error wrapping nothing to be its own cause")
+	}
+}
+
+type appError struct {
+	err error
+}
+
+func (e *appError) Error() string { return "saving the order failed: " + e.err.Error() }
+func (e *appError) Unwrap() error { return e.err }
+
+func TestErrorPredicatesThroughWrapping(t *testing.T) {
+	c := &Cluster{enrichedErrors: true}
+	tmpFail := c.wrapOperationError(&gocbcore.KvError{Code: gocbcore.StatusTmpFail}, &OperationError{Operation: "Upsert"})
+	if !IsTempFailError(&appError{tmpFail}) {
+		t.Fatalf("Expected a wrapped temporary failure to be recognised")
+	}
+	if IsTempFailError(&appError{&gocbcore.KvError{Code: gocbcore.StatusKeyNotFound}}) {
+		t.Fatalf("Expected a missing key not to be a temporary failure")
+	}
+
+	if !IsTimeoutError(&appError{c.wrapOperationError(ErrTimeout, &OperationError{Operation: "Get"})}) {
+		t.Fatalf("Expected a wrapped timeout to be recognised")
+	}
+	if IsTimeoutError(&appError{ErrNetwork}) {
+		t.Fatalf("Expected a network error not to be a timeout")
+	}
+
+	queryErr := &appError{c.wrapOperationError(&n1qlMultiError{{Code: 4300, Message: "Index already exists"}}, &OperationError{Operation: "ExecuteN1qlQuery"})}
+	if code, ok := QueryErrorCode(queryErr); !ok || code != 4300 {
+		t.Fatalf("Unexpected query error code %d %v", code, ok)
+	}
+	if !IsQueryError(queryErr, 4300) || IsQueryError(queryErr, 5000) {
+		t.Fatalf("Expected the query error to only match its own code")
+	}
+	if !IsQueryError(&N1qlTimeoutError{Code: n1qlCodeTimeout}, n1qlCodeTimeout) {
+		t.Fatalf("Expected a query timeout to match its code")
+	}
+	if _, ok := QueryErrorCode(tmpFail); ok {
+		t.Fatalf("Expected no query error code for a key-value error")
+	}
+	keyExists := &gocbcore.KvError{Code: gocbcore.StatusKeyExists}
+	if !isCasOperationError(&appError{c.wrapOperationError(keyExists, &OperationError{Operation: "Replace"})}) {
+		t.Fatalf("Expected a failed Replace to be a CAS operation error")
+	}
+	if !isCasOperationError(c.wrapOperationError(keyExists, &OperationError{Operation: "MutateIn", cas: 1})) {
+		t.Fatalf("Expected a failed MutateIn with a CAS to be a CAS operation error")
+	}
+	if isCasOperationError(c.wrapOperationError(keyExists, &OperationError{Operation: "MutateIn"})) {
+		t.Fatalf("Expected a failed MutateIn without a CAS not to be a CAS operation error")
+	}
+	if isCasOperationError(c.wrapOperationError(keyExists, &OperationError{Operation: "Insert"})) || isCasOperationError(keyExists) {
+		t.Fatalf("Expected a failed Insert not to be a CAS operation error")
+	}
+	if IsCasMismatchError(c.wrapOperationError(keyExists, &OperationError{Operation: "Insert"})) {
+		t.Fatalf("Expected a failed Insert not to be a CAS mismatch")
+	}
+}
@@ -0,0 +1,76 @@
+package gocb
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/couchbase/gocb.v1/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestViewQueryFromGolden(t *testing.T) {
+	if _, err := globalBucket.getViewEp(); err != nil {
+		t.Skipf("Skipping as no view nodes are available (%s)", err)
+	}
+
+	// Run with GOCB_RECORD_GOLDEN=1 against a cluster with the beers design document
+	// to record the golden file again.
+	cluster := globalBucket.cluster
+	transport := cluster.HttpTransport()
+	cluster.SetHttpTransport(testutil.Golden(t, "testdata/golden/view_query.json", transport, testutil.ScrubTimestamps))
+	defer cluster.SetHttpTransport(transport)
+
+	results, err := globalBucket.ExecuteViewQuery(NewViewQuery("beers", "by_name").Limit(2))
+	if err != nil {
+		t.Fatalf("Failed to execute view query: %v", err)
+	}
+
+	var ids []string
+	var row struct {
+		Id string `json:"id"`
+	}
+	for results.Next(&row) {
+		ids = append(ids, row.Id)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to read view results: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "beer-1" || ids[1] != "beer-2" {
+		t.Fatalf("Unexpected rows %v", ids)
+	}
+	if results.(ViewResultMetrics).TotalRows() != 3 {
+		t.Fatalf("Expected the total rows from the golden file")
+	}
+}
+
+func TestReadOnlyN1qlQueryFromGolden(t *testing.T) {
+	// The golden file is recorded from a stand-in for the query service, as the
+	// random client context id sent in the query string must not prevent the GET
+	// request from matching it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"requestID":"r1","clientContextID":"%s","results":[{"n":1}],"status":"success","metrics":{"resultCount":1}}`,
+			req.URL.Query().Get("client_context_id"))
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	client := &http.Client{Transport: testutil.Golden(t, "testdata/golden/n1ql_readonly_query.json", http.DefaultTransport, testutil.ScrubRequestIDs)}
+	q := NewN1qlQuery("SELECT 1 AS n").ReadOnly(true)
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, q.options, nil, 5*time.Second, client, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	var row struct {
+		N int `json:"n"`
+	}
+	if !results.Next(&row) || row.N != 1 {
+		t.Fatalf("Unexpected row %+v", row)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to read query results: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package gocb
+
+import (
+	"net/http"
+	"time"
+)
+
+// The number of idle connections kept to each node by default.  This is larger than
+// the net/http default of 2, which causes connections to be repeatedly closed and
+// reopened when many queries are performed concurrently.
+const defaultHttpMaxIdleConnsPerHost = 256
+
+// HttpPoolConfig specifies the limits of the pool of connections used for requests to
+// the view, N1QL, FTS, analytics and management services of the cluster.
+//
+// Experimental: This API is subject to change at any time.
+type HttpPoolConfig struct {
+	// MaxIdleConns is the maximum number of idle connections kept across every node.
+	// Zero means no limit.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept to each node.
+	// Zero means the net/http default of 2.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before it is closed.  Zero
+	// means that idle connections are kept until the server closes them.
+	IdleConnTimeout time.Duration
+}
+
+// HttpPoolConfig returns the limits of the pool of connections used for HTTP requests.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) HttpPoolConfig() HttpPoolConfig {
+	return HttpPoolConfig{
+		MaxIdleConns:        c.agentConfig.HttpMaxIdleConns,
+		MaxIdleConnsPerHost: c.agentConfig.HttpMaxIdleConnsPerHost,
+		IdleConnTimeout:     c.agentConfig.HttpIdleConnectionTimeout,
+	}
+}
+
+// SetHttpPoolConfig sets the limits of the pool of connections used for HTTP requests.
+// These can also be specified by the http_max_idle_conns, http_max_idle_conns_per_host
+// and http_idle_conn_timeout options of the connection string.  The limits also apply
+// to the buckets opened after they are set.  They cannot be applied once a transport
+// other than an *http.Transport has been set by SetHttpTransport.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetHttpPoolConfig(config HttpPoolConfig) error {
+	if _, ok := c.httpCli.Transport.(*http.Transport); !ok {
+		return clientError{"Connection pool limits can only be applied to an *http.Transport."}
+	}
+	c.agentConfig.HttpMaxIdleConns = config.MaxIdleConns
+	c.agentConfig.HttpMaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	c.agentConfig.HttpIdleConnectionTimeout = config.IdleConnTimeout
+	c.applyHttpPoolConfig()
+	return nil
+}
+
+// applyHttpPoolConfig applies the connection pool limits to the shared HTTP client.
+func (c *Cluster) applyHttpPoolConfig() {
+	if transport, ok := c.httpCli.Transport.(*http.Transport); ok {
+		transport.MaxIdleConns = c.agentConfig.HttpMaxIdleConns
+		transport.MaxIdleConnsPerHost = c.agentConfig.HttpMaxIdleConnsPerHost
+		transport.IdleConnTimeout = c.agentConfig.HttpIdleConnectionTimeout
+	}
+}
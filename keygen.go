@@ -0,0 +1,177 @@
+package gocb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// KeyGenerator generates a new document key.  Generators must be safe to call
+// concurrently.
+type KeyGenerator func() (string, error)
+
+// The number of keys tried by an insert with a generated key before it fails with
+// ErrKeyExists.
+const generatedKeyAttempts = 5
+
+func formatUuid(uuid []byte) string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf)
+}
+
+// UUIDv4Key is a KeyGenerator which generates random version 4 UUIDs.
+func UUIDv4Key() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", err
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUuid(uuid), nil
+}
+
+// TimeOrderedKey is a KeyGenerator which generates version 7 UUIDs, which begin with
+// the time in milliseconds so that keys generated later sort after those generated
+// earlier.
+func TimeOrderedKey() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		return "", err
+	}
+	var millis [8]byte
+	binary.BigEndian.PutUint64(millis[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(uuid[0:6], millis[2:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x70
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUuid(uuid), nil
+}
+
+// KeyGenerator returns the generator used for inserts with an empty key by buckets
+// opened from this cluster which do not have their own.
+func (c *Cluster) KeyGenerator() KeyGenerator {
+	return c.keyGenerator
+}
+
+// SetKeyGenerator sets the generator used to create a key for inserts with an empty
+// key, for every bucket opened from this cluster which does not have its own.  When no
+// generator is set, inserts with an empty key fail as before.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetKeyGenerator(generator KeyGenerator) {
+	c.keyGenerator = generator
+}
+
+// KeyGenerator returns the generator used for inserts with an empty key.
+func (b *Bucket) KeyGenerator() KeyGenerator {
+	if b.keyGenerator != nil {
+		return b.keyGenerator
+	}
+	if b.cluster != nil {
+		return b.cluster.keyGenerator
+	}
+	return nil
+}
+
+// SetKeyGenerator sets the generator used to create a key for Insert, InsertEx and
+// InsertOp when they are given an empty key, such as UUIDv4Key or TimeOrderedKey.  The
+// generated key is returned by InsertEx, and set as the Key of an InsertOp.  An insert
+// which fails with ErrKeyExists is retried with a new key a few times before failing.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetKeyGenerator(generator KeyGenerator) {
+	b.keyGenerator = generator
+}
+
+// withGeneratedKey calls fn with generated keys until it succeeds, fails with an error
+// other than ErrKeyExists, or generatedKeyAttempts keys have been tried, returning the
+// last key tried.
+func withGeneratedKey(generator KeyGenerator, fn func(key string) error) (string, error) {
+	for attempt := 1; ; attempt++ {
+		key, err := generator()
+		if err != nil {
+			return "", err
+		}
+
+		err = fn(key)
+		if ErrorCause(err) != ErrKeyExists || attempt >= generatedKeyAttempts {
+			return key, err
+		}
+		logDebugFields("Generated key already exists, retrying with a new key", keyField(key))
+	}
+}
+
+// insertGenerated inserts a document, generating its key if it is empty and a
+// generator is set, and returns the key which was used.
+func (b *Bucket) insertGenerated(opts *kvOpOptions, key string, value interface{}, expiry uint32) (string, Cas, MutationToken, error) {
+	generator := b.KeyGenerator()
+	if key != "" || generator == nil {
+		cas, mt, err := b.insert(opts, key, value, expiry)
+		return key, cas, mt, err
+	}
+
+	var cas Cas
+	var mt MutationToken
+	key, err := withGeneratedKey(generator, func(key string) error {
+		var err error
+		cas, mt, err = b.insert(opts, key, value, expiry)
+		return err
+	})
+	return key, cas, mt, err
+}
+
+// doGenerated executes bulk operations, generating the keys of inserts with an empty
+// key and retrying those which collide with existing documents.
+func (b *Bucket) doGenerated(ops []BulkOp, generator KeyGenerator, priority OpPriority) error {
+	generated := make(map[BulkOp]bool)
+	var pending []BulkOp
+	for _, op := range ops {
+		if item, ok := op.(*InsertOp); ok && item.Key == "" {
+			key, err := generator()
+			if err != nil {
+				item.Err = err
+				continue
+			}
+			item.Key = key
+			generated[op] = true
+		}
+		pending = append(pending, op)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := b.doBatch(pending, priority)
+		if err != nil || len(generated) == 0 || attempt >= generatedKeyAttempts {
+			return err
+		}
+
+		var retry []BulkOp
+		for _, op := range pending {
+			item, ok := op.(*InsertOp)
+			if !ok || !generated[op] || ErrorCause(item.Err) != ErrKeyExists {
+				continue
+			}
+
+			key, err := generator()
+			if err != nil {
+				item.Err = err
+				continue
+			}
+			item.Key = key
+			item.Err = nil
+			retry = append(retry, op)
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		pending = retry
+	}
+}
@@ -0,0 +1,106 @@
+package gocb
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidExp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([0-9a-f])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestKeyGenerators(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key, err := UUIDv4Key()
+		if err != nil {
+			t.Fatalf("Failed to generate a key: %v", err)
+		}
+		if match := uuidExp.FindStringSubmatch(key); match == nil || match[1] != "4" {
+			t.Fatalf("Expected a version 4 UUID, got %s", key)
+		}
+		if seen[key] {
+			t.Fatalf("Generated %s twice", key)
+		}
+		seen[key] = true
+	}
+
+	first, err := TimeOrderedKey()
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, _ := TimeOrderedKey()
+	if match := uuidExp.FindStringSubmatch(first); match == nil || match[1] != "7" {
+		t.Fatalf("Expected a version 7 UUID, got %s", first)
+	}
+	if second <= first {
+		t.Fatalf("Expected later keys to sort after earlier ones, got %s then %s", first, second)
+	}
+}
+
+func TestWithGeneratedKeyRetriesCollisions(t *testing.T) {
+	next := 0
+	generator := func() (string, error) {
+		next++
+		return "key-" + string(rune('0'+next)), nil
+	}
+
+	var tried []string
+	key, err := withGeneratedKey(generator, func(key string) error {
+		tried = append(tried, key)
+		if len(tried) < 3 {
+			return ErrKeyExists
+		}
+		return nil
+	})
+	if err != nil || key != "key-3" || len(tried) != 3 {
+		t.Fatalf("Expected success with the third key, got %s (%v) after %v", key, err, tried)
+	}
+
+	tried = nil
+	_, err = withGeneratedKey(generator, func(key string) error {
+		tried = append(tried, key)
+		return ErrKeyExists
+	})
+	if err != ErrKeyExists || len(tried) != generatedKeyAttempts {
+		t.Fatalf("Expected ErrKeyExists after %d attempts, got %v after %d", generatedKeyAttempts, err, len(tried))
+	}
+
+	// Other errors are not retried.
+	tried = nil
+	_, err = withGeneratedKey(generator, func(key string) error {
+		tried = append(tried, key)
+		return ErrTimeout
+	})
+	if err != ErrTimeout || len(tried) != 1 {
+		t.Fatalf("Expected ErrTimeout after one attempt, got %v after %d", err, len(tried))
+	}
+
+	genErr := errors.New("no entropy")
+	_, err = withGeneratedKey(func() (string, error) { return "", genErr }, func(key string) error {
+		t.Fatalf("Expected no insert when the generator fails")
+		return nil
+	})
+	if err != genErr {
+		t.Fatalf("Expected the generator error, got %v", err)
+	}
+}
+
+func TestKeyGeneratorInheritance(t *testing.T) {
+	c := &Cluster{}
+	b := &Bucket{cluster: c}
+	if b.KeyGenerator() != nil {
+		t.Fatalf("Expected no generator by default")
+	}
+
+	c.SetKeyGenerator(UUIDv4Key)
+	if b.KeyGenerator() == nil {
+		t.Fatalf("Expected the cluster generator to be used")
+	}
+
+	b.SetKeyGenerator(func() (string, error) { return "bucket", nil })
+	if key, _ := b.KeyGenerator()(); key != "bucket" {
+		t.Fatalf("Expected the bucket generator to be used, got %s", key)
+	}
+}
@@ -0,0 +1,125 @@
+package gocb
+
+import (
+	"gopkg.in/couchbase/gocbcore.v7"
+)
+
+// The error map attributes of the statuses which are retried.
+const (
+	kvErrorAttrTemp       = "temp"
+	kvErrorAttrRetryNow   = "retry-now"
+	kvErrorAttrRetryLater = "retry-later"
+)
+
+// kvErrorDefaultAttributes holds the error map attributes the client assumes for the
+// statuses which applications commonly handle.  They are not read from the error map
+// the server provides, so statuses which are not listed have no attributes.
+var kvErrorDefaultAttributes = map[gocbcore.StatusCode][]string{
+	gocbcore.StatusKeyNotFound:  {"item-only"},
+	gocbcore.StatusKeyExists:    {"item-only"},
+	gocbcore.StatusTooBig:       {"item-only", "invalid-input"},
+	gocbcore.StatusNotStored:    {"item-only"},
+	gocbcore.StatusNotMyVBucket: {"fetch-config", kvErrorAttrRetryNow},
+	gocbcore.StatusLocked:       {"item-locked", "item-only", kvErrorAttrRetryLater},
+	gocbcore.StatusAuthError:    {"conn-state-invalidated", "auth"},
+	gocbcore.StatusAccessError:  {"auth"},
+	gocbcore.StatusOutOfMemory:  {kvErrorAttrTemp, kvErrorAttrRetryLater},
+	gocbcore.StatusBusy:         {kvErrorAttrTemp, kvErrorAttrRetryLater},
+	gocbcore.StatusTmpFail:      {kvErrorAttrTemp, kvErrorAttrRetryLater},
+}
+
+// KvError describes an error status returned by a data node for a key-value operation.
+// The name and description of the status are those of the error map provided by the
+// server, and the context and reference are those of its enhanced error information.
+// Both are negotiated with the server by default, see SetEnhancedErrors.  The
+// attributes of the status are client-side defaults rather than those of the error map.
+//
+// Experimental: This API is subject to change at any time.
+type KvError struct {
+	// StatusCode is the status the server responded with.
+	StatusCode uint16
+	// Name is the name of the status, such as "KEY_ENOENT".
+	Name string
+	// Description describes the status.
+	Description string
+	// Context describes the cause of the error, if the server provided one.
+	Context string
+	// Ref identifies the error in the logs of the server, if the server provided one.
+	Ref string
+	// DefaultAttributes are the error map attributes the client assumes for the
+	// status, such as "temp" or "retry-later".  Only well known statuses are
+	// classified, any other status has no attributes.
+	DefaultAttributes []string
+	// Err is the error returned by the operation.
+	Err error
+}
+
+// Error returns the message of the error returned by the operation.
+func (e *KvError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error returned by the operation.
+func (e *KvError) Unwrap() error {
+	return e.Err
+}
+
+// HasAttribute returns whether attr is one of the default attributes of the status.
+// Statuses which are not well known have no attributes, so this returns false for them.
+func (e *KvError) HasAttribute(attr string) bool {
+	for _, attribute := range e.DefaultAttributes {
+		if attribute == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// Temporary returns whether the status describes a temporary condition of the server.
+func (e *KvError) Temporary() bool {
+	return e.HasAttribute(kvErrorAttrTemp)
+}
+
+// Retryable returns whether the operation may succeed if it is performed again without
+// changing it, either immediately or after a delay.
+func (e *KvError) Retryable() bool {
+	return e.HasAttribute(kvErrorAttrRetryNow) || e.HasAttribute(kvErrorAttrRetryLater)
+}
+
+// AsKvError returns the KvError describing the server status a key-value operation
+// failed with, or false if err is nil or did not come from a data node.
+//
+// Experimental: This API is subject to change at any time.
+func AsKvError(err error) (*KvError, bool) {
+	for cause := err; cause != nil; {
+		var coreErr gocbcore.KvError
+		switch typedErr := cause.(type) {
+		case *gocbcore.KvError:
+			if typedErr == nil {
+				return nil, false
+			}
+			coreErr = *typedErr
+		case gocbcore.KvError:
+			coreErr = typedErr
+		case interface{ Unwrap() error }:
+			cause = typedErr.Unwrap()
+			continue
+		default:
+			return nil, false
+		}
+		return newKvError(coreErr, cause), true
+	}
+	return nil, false
+}
+
+func newKvError(coreErr gocbcore.KvError, err error) *KvError {
+	return &KvError{
+		StatusCode:        uint16(coreErr.Code),
+		Name:              coreErr.Name,
+		Description:       coreErr.Description,
+		Context:           coreErr.Context,
+		Ref:               coreErr.Ref,
+		DefaultAttributes: kvErrorDefaultAttributes[coreErr.Code],
+		Err:               err,
+	}
+}
@@ -0,0 +1,35 @@
+package gocb
+
+// KvPoolSize returns the number of memcached connections opened to each node by the
+// buckets opened from the cluster.
+func (c *Cluster) KvPoolSize() int {
+	if c.agentConfig.KvPoolSize <= 0 {
+		return 1
+	}
+	return c.agentConfig.KvPoolSize
+}
+
+// SetKvPoolSize sets the number of memcached connections opened to each node by the
+// buckets opened from the cluster after it is set.  Operations are distributed across
+// the connections to a node, which improves throughput when many operations are
+// performed concurrently and a single connection is saturated.  It can also be
+// specified by the kv_pool_size option of the connection string.  Sizes of zero or
+// less restore the default of a single connection.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetKvPoolSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	c.agentConfig.KvPoolSize = size
+}
+
+// KvPoolSize returns the number of memcached connections opened to each node.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) KvPoolSize() int {
+	if b.kvPoolSize <= 0 {
+		return 1
+	}
+	return b.kvPoolSize
+}
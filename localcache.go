@@ -0,0 +1,151 @@
+package gocb
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LocalCacheOptions specifies the behaviour of a bucket's local document cache.
+type LocalCacheOptions struct {
+	// MaxEntries is the maximum number of documents held in the cache.
+	MaxEntries int
+	// MaxSize is the maximum total size in bytes of the documents held in the
+	// cache.  This defaults to 16MB.
+	MaxSize int
+	// TTL is the length of time a document is served from the cache before it is
+	// fetched from the server again.
+	TTL time.Duration
+	// Keys restricts caching to the specified document keys.
+	Keys []string
+	// KeyPrefix restricts caching to document keys beginning with the prefix.
+	KeyPrefix string
+}
+
+// LocalCacheStats holds statistics about the usage of a bucket's local document cache.
+type LocalCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// HitRate returns the fraction of cache lookups which were served from the cache.
+func (s LocalCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type localCacheEntry struct {
+	bytes []byte
+	flags uint32
+	cas   Cas
+}
+
+type localCache struct {
+	opts    LocalCacheOptions
+	keys    map[string]bool
+	cache   *lruCache
+	version uint64
+	hits    uint64
+	misses  uint64
+}
+
+func newLocalCache(opts LocalCacheOptions) *localCache {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 16 * 1024 * 1024
+	}
+
+	lc := &localCache{
+		opts:  opts,
+		cache: newLruCache(opts.MaxSize, opts.TTL),
+	}
+	lc.cache.maxItems = opts.MaxEntries
+	if len(opts.Keys) > 0 {
+		lc.keys = make(map[string]bool)
+		for _, key := range opts.Keys {
+			lc.keys[key] = true
+		}
+	}
+	return lc
+}
+
+func (lc *localCache) matches(key string) bool {
+	if lc.keys != nil && !lc.keys[key] {
+		return false
+	}
+	return strings.HasPrefix(key, lc.opts.KeyPrefix)
+}
+
+func (lc *localCache) get(key string) (*localCacheEntry, bool) {
+	value, ok := lc.cache.get(key)
+	if !ok {
+		atomic.AddUint64(&lc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&lc.hits, 1)
+	return value.(*localCacheEntry), true
+}
+
+// store caches a document fetched from the server, provided no invalidation has
+// occurred since the fetch was started at the specified version.
+func (lc *localCache) store(key string, version uint64, bytes []byte, flags uint32, cas Cas) {
+	if atomic.LoadUint64(&lc.version) != version {
+		return
+	}
+	lc.cache.set(key, &localCacheEntry{
+		bytes: append([]byte(nil), bytes...),
+		flags: flags,
+		cas:   cas,
+	}, len(bytes))
+}
+
+func (lc *localCache) invalidate(key string) {
+	atomic.AddUint64(&lc.version, 1)
+	lc.cache.remove(key)
+}
+
+// EnableLocalCache enables a local cache of documents for this bucket.  Get operations
+// for matching keys are served from the cache until the TTL has elapsed, and any
+// mutation of a key performed through this bucket removes it from the cache.
+//
+// Changes made by other clients are not observed until the TTL elapses or
+// InvalidateLocalCache is called, so only documents which can tolerate being stale for
+// the TTL should be cached.  The Cas of a cached document is preserved, so CAS-based
+// writes against a stale document fail with ErrKeyExists as usual.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) EnableLocalCache(opts LocalCacheOptions) {
+	b.localCache = newLocalCache(opts)
+}
+
+// DisableLocalCache disables and clears the local document cache.
+func (b *Bucket) DisableLocalCache() {
+	b.localCache = nil
+}
+
+// InvalidateLocalCache removes a document from the local document cache, for
+// instance after it has been changed by another client.
+func (b *Bucket) InvalidateLocalCache(key string) {
+	b.invalidateLocalCache(key)
+}
+
+// LocalCacheStats returns statistics about the usage of the local document cache.
+func (b *Bucket) LocalCacheStats() LocalCacheStats {
+	lc := b.localCache
+	if lc == nil {
+		return LocalCacheStats{}
+	}
+	return LocalCacheStats{
+		Hits:    atomic.LoadUint64(&lc.hits),
+		Misses:  atomic.LoadUint64(&lc.misses),
+		Entries: lc.cache.len(),
+	}
+}
+
+func (b *Bucket) invalidateLocalCache(key string) {
+	if lc := b.localCache; lc != nil {
+		lc.invalidate(key)
+	}
+}
@@ -0,0 +1,49 @@
+package gocb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalCache(t *testing.T) {
+	lc := newLocalCache(LocalCacheOptions{
+		MaxEntries: 2,
+		TTL:        time.Minute,
+		KeyPrefix:  "config::",
+	})
+
+	if lc.matches("user::1") || !lc.matches("config::a") {
+		t.Fatalf("Expected only keys with the prefix to match")
+	}
+
+	version := lc.version
+	lc.store("config::a", version, []byte(`{"a":1}`), cfFmtJson, 10)
+	entry, ok := lc.get("config::a")
+	if !ok || entry.cas != 10 || string(entry.bytes) != `{"a":1}` {
+		t.Fatalf("Expected cached entry to preserve the value and cas")
+	}
+
+	// A fetch started before an invalidation must not repopulate the cache.
+	lc.invalidate("config::a")
+	lc.store("config::a", version, []byte(`{"a":1}`), cfFmtJson, 10)
+	if _, ok := lc.get("config::a"); ok {
+		t.Fatalf("Expected stale fetch not to be cached")
+	}
+
+	version = lc.version
+	lc.store("config::a", version, []byte("1"), cfFmtJson, 1)
+	lc.store("config::b", version, []byte("2"), cfFmtJson, 2)
+	lc.store("config::c", version, []byte("3"), cfFmtJson, 3)
+	if _, ok := lc.get("config::a"); ok {
+		t.Fatalf("Expected the least recently used entry to be evicted")
+	}
+
+	fakeBucket := &Bucket{localCache: lc}
+	stats := fakeBucket.LocalCacheStats()
+	if stats.Entries != 2 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("Unexpected cache stats %+v", stats)
+	}
+	if stats.HitRate() != 1.0/3 {
+		t.Fatalf("Unexpected hit rate %f", stats.HitRate())
+	}
+}
@@ -1,10 +1,14 @@
 package gocb
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
 	"log"
+	"os"
 	"strings"
+	"sync/atomic"
 )
 
 // LogLevel specifies the severity of a log message.
@@ -33,8 +37,43 @@ type Logger interface {
 	Log(level LogLevel, offset int, format string, v ...interface{}) error
 }
 
+// LogField is a key/value pair describing the context of a log message.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// FieldLogger is a Logger which also accepts messages accompanied by key/value fields,
+// rather than formatted into the message.  Messages the library logs with fields are
+// passed to LogFields if the logger set with SetLogger implements it, and are otherwise
+// formatted as the message followed by each field as key=value.
+type FieldLogger interface {
+	Logger
+	LogFields(level LogLevel, offset int, message string, fields []LogField) error
+}
+
+// LogRedactLevel specifies the degree with which user data is redacted from logs
+// and from descriptions produced by the library.
+type LogRedactLevel int
+
+const (
+	// RedactNone indicates that no data should be redacted.
+	RedactNone = LogRedactLevel(iota)
+
+	// RedactPartial indicates that user data should be marked or redacted.  User data,
+	// such as document keys, values and usernames, is wrapped in <ud></ud> tags.
+	RedactPartial
+
+	// RedactFull indicates that all potentially sensitive data should be redacted.  In
+	// addition to user data, metadata such as bucket names is wrapped in <md></md> tags,
+	// and system data such as hostnames and endpoints in <sd></sd> tags.
+	RedactFull
+)
+
 var (
-	globalLogger Logger
+	globalLogger         Logger
+	globalLogRedactLevel = RedactPartial
+	globalLogKeyHashing  int32
 )
 
 type coreLogWrapper struct {
@@ -90,6 +129,17 @@ func SetLogger(logger Logger) {
 	gocbcore.SetLogger(getCoreLogger(logger))
 }
 
+// LogRedactionLevel returns the current log redaction level.
+func LogRedactionLevel() LogRedactLevel {
+	return globalLogRedactLevel
+}
+
+// SetLogRedactionLevel specifies the level with which user data is redacted.
+// This defaults to RedactPartial.
+func SetLogRedactionLevel(level LogRedactLevel) {
+	globalLogRedactLevel = level
+}
+
 func logExf(level LogLevel, offset int, format string, v ...interface{}) {
 	if globalLogger != nil {
 		err := globalLogger.Log(level, offset+1, format, v...)
@@ -107,6 +157,10 @@ func logSchedf(format string, v ...interface{}) {
 	logExf(LogSched, 1, format, v...)
 }
 
+func logInfof(format string, v ...interface{}) {
+	logExf(LogInfo, 1, format, v...)
+}
+
 func logWarnf(format string, v ...interface{}) {
 	logExf(LogWarn, 1, format, v...)
 }
@@ -115,6 +169,137 @@ func logErrorf(format string, v ...interface{}) {
 	logExf(LogError, 1, format, v...)
 }
 
+// redactUserData marks a piece of user data, such as a document key or a username, so
+// that it can be identified and scrubbed from logs before they are shared.
+func redactUserData(data string) string {
+	if globalLogRedactLevel == RedactNone {
+		return data
+	}
+	return "<ud>" + data + "</ud>"
+}
+
+// redactMetaData marks a piece of metadata, such as a bucket or design document name,
+// when all potentially sensitive data is redacted.
+func redactMetaData(data string) string {
+	if globalLogRedactLevel != RedactFull {
+		return data
+	}
+	return "<md>" + data + "</md>"
+}
+
+// redactSystemData marks a piece of system data, such as a hostname, endpoint or file
+// path, when all potentially sensitive data is redacted.
+func redactSystemData(data string) string {
+	if globalLogRedactLevel != RedactFull {
+		return data
+	}
+	return "<sd>" + data + "</sd>"
+}
+
+// SetLogKeyHashing specifies whether document keys are replaced by a hash of the key
+// in logs and in the descriptions of errors, so that occurrences of the same key can be
+// correlated without the key itself being disclosed.  This is disabled by default.
+//
+// Experimental: This API is subject to change at any time.
+func SetLogKeyHashing(enabled bool) {
+	var val int32
+	if enabled {
+		val = 1
+	}
+	atomic.StoreInt32(&globalLogKeyHashing, val)
+}
+
+// redactKey prepares a document key for logging, either hashing it or marking it as
+// user data according to the redaction settings.
+func redactKey(key string) string {
+	if atomic.LoadInt32(&globalLogKeyHashing) != 0 {
+		hash := sha256.Sum256([]byte(key))
+		return "sha256:" + hex.EncodeToString(hash[:8])
+	}
+	return redactUserData(key)
+}
+
+func keyField(key string) LogField {
+	return LogField{Key: "key", Value: redactKey(key)}
+}
+
+func formatLogFields(message string, fields []LogField) string {
+	parts := []string{message}
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s=%v", field.Key, field.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func logExFields(level LogLevel, offset int, message string, fields ...LogField) {
+	if globalLogger == nil {
+		return
+	}
+
+	var err error
+	if fieldLogger, ok := globalLogger.(FieldLogger); ok {
+		err = fieldLogger.LogFields(level, offset+1, message, fields)
+	} else {
+		err = globalLogger.Log(level, offset+1, "%s", formatLogFields(message, fields))
+	}
+	if err != nil {
+		log.Printf("Logger error occurred (%s)\n", err)
+	}
+}
+
+func logDebugFields(message string, fields ...LogField) {
+	logExFields(LogDebug, 1, message, fields...)
+}
+
+func logLevelName(level LogLevel) string {
+	switch level {
+	case LogError:
+		return "ERRO"
+	case LogWarn:
+		return "WARN"
+	case LogInfo:
+		return "INFO"
+	case LogDebug:
+		return "DEBU"
+	case LogTrace:
+		return "TRAC"
+	case LogSched:
+		return "SCHE"
+	}
+	return "UNKN"
+}
+
+type stdLogger struct {
+	logger   *log.Logger
+	maxLevel LogLevel
+}
+
+// NewStdLogger returns a Logger which writes messages up to the specified level of
+// verbosity to a standard library logger, or to standard error if logger is nil.
+// Fields are written after the message as key=value.
+//
+// Experimental: This API is subject to change at any time.
+func NewStdLogger(logger *log.Logger, maxLevel LogLevel) FieldLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdLogger{
+		logger:   logger,
+		maxLevel: maxLevel,
+	}
+}
+
+func (l *stdLogger) Log(level LogLevel, offset int, format string, v ...interface{}) error {
+	if level > l.maxLevel {
+		return nil
+	}
+	return l.logger.Output(offset+2, fmt.Sprintf("%s "+format, append([]interface{}{logLevelName(level)}, v...)...))
+}
+
+func (l *stdLogger) LogFields(level LogLevel, offset int, message string, fields []LogField) error {
+	return l.Log(level, offset+1, "%s", formatLogFields(message, fields))
+}
+
 func reindentLog(indent, message string) string {
 	reindentedMessage := strings.Replace(message, "\n", "\n"+indent, -1)
 	return fmt.Sprintf("%s%s", indent, reindentedMessage)
@@ -0,0 +1,77 @@
+package gocb
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Log(level LogLevel, offset int, format string, v ...interface{}) error {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+	return nil
+}
+
+func TestLogFields(t *testing.T) {
+	defer SetLogger(globalLogger)
+
+	logger := &testLogger{}
+	SetLogger(logger)
+	SetLogRedactionLevel(RedactNone)
+	defer SetLogRedactionLevel(RedactPartial)
+
+	logDebugFields("Failed to archive document", keyField("user::1"), LogField{"error", "timeout"})
+	if len(logger.messages) != 1 || logger.messages[0] != "Failed to archive document key=user::1 error=timeout" {
+		t.Fatalf("Unexpected messages %q", logger.messages)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	defer SetLogger(globalLogger)
+
+	var buf bytes.Buffer
+	SetLogger(NewStdLogger(log.New(&buf, "", 0), LogInfo))
+
+	logDebugFields("Hidden")
+	logExFields(LogWarn, 0, "Shown", LogField{"attempt", 2})
+	if buf.String() != "WARN Shown attempt=2\n" {
+		t.Fatalf("Unexpected output %q", buf.String())
+	}
+}
+
+func TestLogKeyHashing(t *testing.T) {
+	SetLogKeyHashing(true)
+	defer SetLogKeyHashing(false)
+
+	err := &OperationError{Operation: "Get", Key: "user::1", Err: ErrTimeout}
+	if strings.Contains(err.Error(), "user::1") || !strings.Contains(err.Error(), "key=sha256:") {
+		t.Fatalf("Expected the key to be hashed, got %s", err.Error())
+	}
+	if redactKey("user::1") != redactKey("user::1") || redactKey("user::1") == redactKey("user::2") {
+		t.Fatalf("Expected hashes to identify keys")
+	}
+}
+
+func TestLogRedactionLevels(t *testing.T) {
+	defer SetLogRedactionLevel(RedactPartial)
+	err := &OperationError{Operation: "Get", Bucket: "default", Key: "user::1", Endpoint: "http://a:8093", Err: ErrTimeout}
+
+	expected := map[LogRedactLevel][]string{
+		RedactNone:    {"bucket=default", "key=user::1", "endpoint=http://a:8093"},
+		RedactPartial: {"bucket=default", "key=<ud>user::1</ud>", "endpoint=http://a:8093"},
+		RedactFull:    {"bucket=<md>default</md>", "key=<ud>user::1</ud>", "endpoint=<sd>http://a:8093</sd>"},
+	}
+	for level, fields := range expected {
+		SetLogRedactionLevel(level)
+		for _, field := range fields {
+			if !strings.Contains(err.Error(), field) {
+				t.Fatalf("Expected %s at redaction level %d, got %s", field, level, err.Error())
+			}
+		}
+	}
+}
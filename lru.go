@@ -0,0 +1,112 @@
+package gocb
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type lruEntry struct {
+	key    string
+	value  interface{}
+	size   int
+	expiry time.Time
+}
+
+// lruCache is a size-bounded least-recently-used cache whose entries expire
+// after a fixed time-to-live.  It is safe for concurrent use.
+type lruCache struct {
+	lock     sync.Mutex
+	maxSize  int
+	maxItems int
+	ttl      time.Duration
+	size     int
+	items    map[string]*list.Element
+	order    *list.List
+	// onEvict, if set, is called with the key of each entry evicted to make room for
+	// another, while the cache is locked.
+	onEvict func(key string)
+}
+
+func newLruCache(maxSize int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiry) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set stores a value of the given size, evicting the least recently used entries
+// until the cache fits within its maximum size and, if set, its maximum number of
+// items.  Values larger than the cache itself are not stored.
+func (c *lruCache) set(key string, value interface{}, size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+
+	if size > c.maxSize {
+		return
+	}
+
+	entry := &lruEntry{
+		key:    key,
+		value:  value,
+		size:   size,
+		expiry: time.Now().Add(c.ttl),
+	}
+	c.items[key] = c.order.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxSize || (c.maxItems > 0 && c.order.Len() > c.maxItems) {
+		evicted := c.order.Back()
+		c.removeElement(evicted)
+		if c.onEvict != nil {
+			c.onEvict(evicted.Value.(*lruEntry).key)
+		}
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
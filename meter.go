@@ -0,0 +1,162 @@
+package gocb
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Meter receives the outcome of every operation performed through a Cluster, so that
+// latencies and errors can be recorded by an external metrics system.  Service is one of
+// "kv", "views", "n1ql", "fts" or "analytics", bucket is empty for queries performed
+// without a bucket, and status is one of "success", "timeout" or "error".
+//
+// Experimental: This API is subject to change at any time.
+type Meter interface {
+	RecordOperation(service, bucket, operation, status string, elapsed time.Duration)
+}
+
+// QueryCacheMeter is implemented by a Meter which is also notified of the lookups of
+// the QueryCache of the cluster, and of the results a MemoryQueryCache evicts.  Kind is
+// "n1ql" or "view", bucket is empty for queries performed without a bucket, and event
+// is one of "hit", "miss" or "eviction".  This is implemented as an additional
+// interface to maintain ABI compatibility for the 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type QueryCacheMeter interface {
+	RecordQueryCache(kind, bucket, event string)
+}
+
+// SetMeter sets a meter which is notified of the outcome of every operation, in
+// addition to the metrics gathered by the cluster itself.  It should be set before
+// operations are performed.  A nil meter removes it.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetMeter(meter Meter) {
+	c.userMeter = meter
+}
+
+// PrometheusMetricsHandler returns an http.Handler which serves the metrics gathered
+// for a cluster in the Prometheus text exposition format.  It is safe to serve
+// concurrently.
+//
+// Experimental: This API is subject to change at any time.
+func PrometheusMetricsHandler(c *Cluster) http.Handler {
+	c.enableMeter()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, err := w.Write(prometheusMetrics(c.Metrics()))
+		if err != nil {
+			logDebugf("Failed to write metrics response (%s)", err)
+		}
+	})
+}
+
+func prometheusLabels(labels ...string) string {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(labels[i])
+		buf.WriteString("=")
+		buf.WriteString(strconv.Quote(labels[i+1]))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// sortedMetricNames returns the keys of a map of metrics in order, so that the output is
+// stable between scrapes.
+func sortedMetricNames(metrics map[string]OperationMetricsSnapshot) []string {
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writePrometheusHistogram(buf *bytes.Buffer, name, label, value string, latency LatencyHistogramSnapshot) {
+	var cumulative uint64
+	for _, bound := range latencyBucketBounds {
+		cumulative += latency.Buckets["<="+bound.String()]
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", name,
+			prometheusLabels(label, value, "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket%s %d\n", name, prometheusLabels(label, value, "le", "+Inf"), latency.Count)
+	fmt.Fprintf(buf, "%s_sum%s %g\n", name, prometheusLabels(label, value), latency.MeanMs*float64(latency.Count)/1000)
+	fmt.Fprintf(buf, "%s_count%s %d\n", name, prometheusLabels(label, value), latency.Count)
+}
+
+func writePrometheusGroups(buf *bytes.Buffer, prefix, label string, groups map[string]OperationMetricsSnapshot) {
+	names := sortedMetricNames(groups)
+
+	fmt.Fprintf(buf, "# TYPE %s_operations_total counter\n", prefix)
+	for _, name := range names {
+		var statuses []string
+		for status := range groups[name].Statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(buf, "%s_operations_total%s %d\n", prefix,
+				prometheusLabels(label, name, "status", status), groups[name].Statuses[status])
+		}
+	}
+
+	fmt.Fprintf(buf, "# TYPE %s_operation_duration_seconds histogram\n", prefix)
+	for _, name := range names {
+		writePrometheusHistogram(buf, prefix+"_operation_duration_seconds", label, name, groups[name].Latency)
+	}
+}
+
+func prometheusMetrics(snapshot MetricsSnapshot) []byte {
+	var buf bytes.Buffer
+
+	operations := make(map[string]OperationMetricsSnapshot)
+	for operation, statuses := range snapshot.Operations {
+		operations[operation] = OperationMetricsSnapshot{
+			Statuses: statuses,
+			Latency:  snapshot.Latencies[operation],
+		}
+	}
+	writePrometheusGroups(&buf, "gocb", "operation", operations)
+	writePrometheusGroups(&buf, "gocb_service", "service", snapshot.Services)
+	writePrometheusGroups(&buf, "gocb_bucket", "bucket", snapshot.Buckets)
+
+	buf.WriteString("# TYPE gocb_retries_total counter\n")
+	var reasons []string
+	for reason := range snapshot.Retries {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&buf, "gocb_retries_total%s %d\n", prometheusLabels("reason", reason), snapshot.Retries[reason])
+	}
+
+	buf.WriteString("# TYPE gocb_query_cache_total counter\n")
+	var kinds []string
+	for kind := range snapshot.QueryCache {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		var events []string
+		for event := range snapshot.QueryCache[kind] {
+			events = append(events, event)
+		}
+		sort.Strings(events)
+		for _, event := range events {
+			fmt.Fprintf(&buf, "gocb_query_cache_total%s %d\n", prometheusLabels("kind", kind, "event", event), snapshot.QueryCache[kind][event])
+		}
+	}
+
+	fmt.Fprintf(&buf, "# TYPE gocb_timeouts_total counter\ngocb_timeouts_total %d\n", snapshot.Timeouts)
+	fmt.Fprintf(&buf, "# TYPE gocb_open_buckets gauge\ngocb_open_buckets %d\n", snapshot.OpenBuckets)
+	return buf.Bytes()
+}
@@ -0,0 +1,114 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMeter struct {
+	records []string
+}
+
+func (m *testMeter) RecordOperation(service, bucket, operation, status string, elapsed time.Duration) {
+	m.records = append(m.records, service+"/"+bucket+"/"+operation+"/"+status)
+}
+
+func (m *testMeter) RecordQueryCache(kind, bucket, event string) {
+	m.records = append(m.records, "cache/"+kind+"/"+bucket+"/"+event)
+}
+
+func TestMeterRecordsOperations(t *testing.T) {
+	meter := &testMeter{}
+	c := &Cluster{}
+	c.SetMeter(meter)
+	fakeBucket := &Bucket{cluster: c, name: "default"}
+
+	fakeBucket.wrapError(ErrTimeout, "Get", "key", time.Now())
+	c.recordOperation("n1ql", "", "ExecuteN1qlQuery", nil, time.Millisecond)
+
+	if len(meter.records) != 2 || meter.records[0] != "kv/default/Get/timeout" || meter.records[1] != "n1ql//ExecuteN1qlQuery/success" {
+		t.Fatalf("Unexpected records %v", meter.records)
+	}
+}
+
+func TestMeterRecordsQueryCache(t *testing.T) {
+	meter := &testMeter{}
+	c := &Cluster{}
+	c.SetMeter(meter)
+	c.Metrics()
+	cache := NewMemoryQueryCache(10, 10, 0)
+	c.SetQueryCache(cache)
+
+	first, _ := n1qlQueryCacheKey("default", map[string]interface{}{"statement": "SELECT 1"})
+	second, _ := n1qlQueryCacheKey("default", map[string]interface{}{"statement": "SELECT 2"})
+	result := &CachedQueryResult{Rows: []json.RawMessage{json.RawMessage(`"12345678"`)}}
+
+	c.lookupQueryCache(cache, "n1ql", "default", first)
+	cache.Set(first, result)
+	c.lookupQueryCache(cache, "n1ql", "default", first)
+	cache.Set(second, result)
+
+	expected := "[cache/n1ql/default/miss cache/n1ql/default/hit cache/n1ql/default/eviction]"
+	if fmt.Sprint(meter.records) != expected {
+		t.Fatalf("Unexpected records %v", meter.records)
+	}
+	events := c.Metrics().QueryCache["n1ql"]
+	if events["hit"] != 1 || events["miss"] != 1 || events["eviction"] != 1 || cache.Evictions() != 1 {
+		t.Fatalf("Unexpected query cache metrics %v", events)
+	}
+}
+
+func TestBucketMetrics(t *testing.T) {
+	c := &Cluster{}
+	fakeBucket := &Bucket{cluster: c, name: "default"}
+	otherBucket := &Bucket{cluster: c, name: "other"}
+
+	fakeBucket.Metrics()
+	performFakeOperations(fakeBucket)
+	otherBucket.wrapError(nil, "Get", "key", time.Now())
+
+	metrics := fakeBucket.Metrics()
+	if metrics.Statuses["success"] != 2 || metrics.Statuses["timeout"] != 1 || metrics.Statuses["error"] != 1 || metrics.Latency.Count != 4 {
+		t.Fatalf("Unexpected bucket metrics %+v", metrics)
+	}
+	if c.Metrics().Services["kv"].Latency.Count != 5 {
+		t.Fatalf("Unexpected service metrics %+v", c.Metrics().Services)
+	}
+}
+
+func TestPrometheusMetricsHandler(t *testing.T) {
+	c := &Cluster{}
+	fakeBucket := &Bucket{cluster: c, name: "default"}
+
+	server := httptest.NewServer(PrometheusMetricsHandler(c))
+	defer server.Close()
+	performFakeOperations(fakeBucket)
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+
+	expected := []string{
+		`gocb_operations_total{operation="Get",status="success"} 2`,
+		`gocb_service_operations_total{service="kv",status="timeout"} 1`,
+		`gocb_bucket_operation_duration_seconds_bucket{bucket="default",le="+Inf"} 4`,
+		`gocb_service_operation_duration_seconds_count{service="kv"} 4`,
+		`gocb_timeouts_total 1`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Fatalf("Expected %s in metrics:\n%s", line, data)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package gocb
+
+import (
+	"encoding/json"
+	"expvar"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBucketBounds are the upper bounds of the buckets used by latency histograms.
+var latencyBucketBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type latencyHistogram struct {
+	count  uint64
+	total  time.Duration
+	counts []uint64
+}
+
+func (h *latencyHistogram) record(elapsed time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBucketBounds)+1)
+	}
+
+	h.count++
+	h.total += elapsed
+	for i, bound := range latencyBucketBounds {
+		if elapsed <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(latencyBucketBounds)]++
+}
+
+// LatencyHistogramSnapshot describes the distribution of latencies of an operation.
+type LatencyHistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	MeanMs  float64           `json:"mean_ms"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogramSnapshot {
+	snapshot := LatencyHistogramSnapshot{
+		Count:   h.count,
+		Buckets: make(map[string]uint64),
+	}
+	if h.count > 0 {
+		snapshot.MeanMs = float64(h.total) / float64(h.count) / float64(time.Millisecond)
+	}
+	for i, count := range h.counts {
+		name := "+Inf"
+		if i < len(latencyBucketBounds) {
+			name = "<=" + latencyBucketBounds[i].String()
+		}
+		snapshot.Buckets[name] = count
+	}
+	return snapshot
+}
+
+// operationMetrics gathers the outcomes and latencies of a group of operations, such
+// as those of a service or of a bucket.
+type operationMetrics struct {
+	statuses map[string]uint64
+	latency  latencyHistogram
+}
+
+func (m *operationMetrics) record(status string, elapsed time.Duration) {
+	if m.statuses == nil {
+		m.statuses = make(map[string]uint64)
+	}
+	m.statuses[status]++
+	m.latency.record(elapsed)
+}
+
+// OperationMetricsSnapshot describes the outcomes and latencies of a group of operations.
+type OperationMetricsSnapshot struct {
+	Statuses map[string]uint64        `json:"statuses"`
+	Latency  LatencyHistogramSnapshot `json:"latency"`
+}
+
+func (m *operationMetrics) snapshot() OperationMetricsSnapshot {
+	snapshot := OperationMetricsSnapshot{
+		Statuses: make(map[string]uint64),
+		Latency:  m.latency.snapshot(),
+	}
+	for status, count := range m.statuses {
+		snapshot.Statuses[status] = count
+	}
+	return snapshot
+}
+
+// MetricsSnapshot is a point in time view of the metrics gathered for a Cluster.
+type MetricsSnapshot struct {
+	Operations  map[string]map[string]uint64        `json:"operations"`
+	Retries     map[string]uint64                   `json:"retries"`
+	Timeouts    uint64                              `json:"timeouts"`
+	OpenBuckets int                                 `json:"open_buckets"`
+	QueueDepths map[string]map[string]int           `json:"queue_depths"`
+	// OpenConnections and ConfigRevisions hold, for each open bucket, the number of
+	// connections to data nodes which are open and the revision of the cluster
+	// configuration in use.
+	OpenConnections map[string]int   `json:"open_connections"`
+	ConfigRevisions map[string]int64 `json:"config_revisions"`
+	Latencies   map[string]LatencyHistogramSnapshot `json:"latencies"`
+	ReadSteps   map[string]uint64                   `json:"read_steps"`
+	// QueryCache counts the hits, misses and evictions of the QueryCache for each kind
+	// of query, "n1ql" or "view".
+	QueryCache map[string]map[string]uint64 `json:"query_cache"`
+
+	// Services and Buckets break the operations down by the service they were sent
+	// to, such as "kv" or "n1ql", and by the bucket they were performed on.
+	Services map[string]OperationMetricsSnapshot `json:"services"`
+	Buckets  map[string]OperationMetricsSnapshot `json:"buckets"`
+
+	TrustCertificates []TrustCertificate `json:"trust_certificates,omitempty"`
+	DrainedNodes      []string           `json:"drained_nodes,omitempty"`
+
+	EndpointPolicy    string                     `json:"endpoint_policy"`
+	EndpointLatencies map[string]EndpointLatency `json:"endpoint_latencies"`
+}
+
+// clusterMeter gathers metrics about the operations performed through a Cluster.  A
+// meter is only created once metrics are requested, so no metrics are gathered for
+// clusters which never expose them.
+type clusterMeter struct {
+	lock      sync.Mutex
+	ops       map[string]map[string]uint64
+	retries   map[string]uint64
+	timeouts  uint64
+	latencies map[string]*latencyHistogram
+	readSteps map[string]uint64
+	cache     map[string]map[string]uint64
+	services  map[string]*operationMetrics
+	buckets   map[string]*operationMetrics
+}
+
+func newClusterMeter() *clusterMeter {
+	return &clusterMeter{
+		ops:       make(map[string]map[string]uint64),
+		retries:   make(map[string]uint64),
+		latencies: make(map[string]*latencyHistogram),
+		readSteps: make(map[string]uint64),
+		cache:     make(map[string]map[string]uint64),
+		services:  make(map[string]*operationMetrics),
+		buckets:   make(map[string]*operationMetrics),
+	}
+}
+
+func operationStatus(err error) string {
+	if err == nil {
+		return "success"
+	}
+	if ErrorCause(err) == ErrTimeout {
+		return "timeout"
+	}
+	return "error"
+}
+
+func (m *clusterMeter) recordOperation(service, bucket, operation string, err error, elapsed time.Duration) {
+	status := operationStatus(err)
+
+	m.lock.Lock()
+	statuses := m.ops[operation]
+	if statuses == nil {
+		statuses = make(map[string]uint64)
+		m.ops[operation] = statuses
+	}
+	statuses[status]++
+	if status == "timeout" {
+		m.timeouts++
+	}
+
+	histogram := m.latencies[operation]
+	if histogram == nil {
+		histogram = &latencyHistogram{}
+		m.latencies[operation] = histogram
+	}
+	histogram.record(elapsed)
+
+	groups := []struct {
+		metrics map[string]*operationMetrics
+		name    string
+	}{
+		{m.services, service},
+		{m.buckets, bucket},
+	}
+	for _, group := range groups {
+		if group.name == "" {
+			continue
+		}
+		metrics := group.metrics[group.name]
+		if metrics == nil {
+			metrics = &operationMetrics{}
+			group.metrics[group.name] = metrics
+		}
+		metrics.record(status, elapsed)
+	}
+	m.lock.Unlock()
+}
+
+func (m *clusterMeter) recordRetry(reason string) {
+	m.lock.Lock()
+	m.retries[reason]++
+	m.lock.Unlock()
+}
+
+func (m *clusterMeter) recordReadStep(step string) {
+	m.lock.Lock()
+	m.readSteps[step]++
+	m.lock.Unlock()
+}
+
+func (m *clusterMeter) recordQueryCache(kind, event string) {
+	m.lock.Lock()
+	events := m.cache[kind]
+	if events == nil {
+		events = make(map[string]uint64)
+		m.cache[kind] = events
+	}
+	events[event]++
+	m.lock.Unlock()
+}
+
+func (m *clusterMeter) snapshot() MetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Operations: make(map[string]map[string]uint64),
+		Retries:    make(map[string]uint64),
+		Timeouts:   m.timeouts,
+		Latencies:  make(map[string]LatencyHistogramSnapshot),
+		ReadSteps:  make(map[string]uint64),
+		QueryCache: make(map[string]map[string]uint64),
+		Services:   make(map[string]OperationMetricsSnapshot),
+		Buckets:    make(map[string]OperationMetricsSnapshot),
+	}
+	for operation, statuses := range m.ops {
+		snapshot.Operations[operation] = make(map[string]uint64)
+		for status, count := range statuses {
+			snapshot.Operations[operation][status] = count
+		}
+	}
+	for reason, count := range m.retries {
+		snapshot.Retries[reason] = count
+	}
+	for operation, histogram := range m.latencies {
+		snapshot.Latencies[operation] = histogram.snapshot()
+	}
+	for step, count := range m.readSteps {
+		snapshot.ReadSteps[step] = count
+	}
+	for kind, events := range m.cache {
+		snapshot.QueryCache[kind] = make(map[string]uint64)
+		for event, count := range events {
+			snapshot.QueryCache[kind][event] = count
+		}
+	}
+	for service, metrics := range m.services {
+		snapshot.Services[service] = metrics.snapshot()
+	}
+	for bucket, metrics := range m.buckets {
+		snapshot.Buckets[bucket] = metrics.snapshot()
+	}
+	return snapshot
+}
+
+func (m *clusterMeter) bucketSnapshot(bucket string) OperationMetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metrics := m.buckets[bucket]
+	if metrics == nil {
+		metrics = &operationMetrics{}
+	}
+	return metrics.snapshot()
+}
+
+func (c *Cluster) getMeter() *clusterMeter {
+	if c == nil {
+		return nil
+	}
+	meter, _ := c.meter.Load().(*clusterMeter)
+	return meter
+}
+
+func (c *Cluster) enableMeter() *clusterMeter {
+	c.meterLock.Lock()
+	defer c.meterLock.Unlock()
+
+	meter := c.getMeter()
+	if meter == nil {
+		meter = newClusterMeter()
+		c.meter.Store(meter)
+	}
+	return meter
+}
+
+func (c *Cluster) recordOperation(service, bucket, operation string, err error, elapsed time.Duration) {
+	if meter := c.getMeter(); meter != nil {
+		meter.recordOperation(service, bucket, operation, err, elapsed)
+	}
+	if c != nil && c.userMeter != nil {
+		c.userMeter.RecordOperation(service, bucket, operation, operationStatus(err), elapsed)
+	}
+}
+
+func (c *Cluster) recordRetry(reason string) {
+	if meter := c.getMeter(); meter != nil {
+		meter.recordRetry(reason)
+	}
+}
+
+func (c *Cluster) recordQueryCache(kind, bucket, event string) {
+	if meter := c.getMeter(); meter != nil {
+		meter.recordQueryCache(kind, event)
+	}
+	if c != nil {
+		if cacheMeter, ok := c.userMeter.(QueryCacheMeter); ok {
+			cacheMeter.RecordQueryCache(kind, bucket, event)
+		}
+	}
+}
+
+func (c *Cluster) recordReadStep(step string) {
+	if meter := c.getMeter(); meter != nil {
+		meter.recordReadStep(step)
+	}
+}
+
+// Metrics returns a snapshot of the metrics gathered for this cluster.  Metrics are
+// only gathered from the first call to Metrics, MetricsHandler or PublishMetrics.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) Metrics() MetricsSnapshot {
+	snapshot := c.enableMeter().snapshot()
+
+	c.clusterLock.RLock()
+	buckets := append([]*Bucket{}, c.bucketList...)
+	c.clusterLock.RUnlock()
+
+	snapshot.OpenBuckets = len(buckets)
+	snapshot.QueueDepths = make(map[string]map[string]int)
+	snapshot.OpenConnections = make(map[string]int)
+	snapshot.ConfigRevisions = make(map[string]int64)
+	for _, bucket := range buckets {
+		depths := make(map[string]int)
+		for priority, depth := range bucket.OperationQueueDepths() {
+			depths[priority.String()] = depth
+		}
+		snapshot.QueueDepths[bucket.name] = depths
+
+		if info, err := bucket.client.Diagnostics(); err == nil {
+			snapshot.OpenConnections[bucket.name] = openMemdConns(info)
+			snapshot.ConfigRevisions[bucket.name] = info.ConfigRev
+		}
+	}
+	snapshot.TrustCertificates = c.TrustCertificates()
+	snapshot.DrainedNodes = c.DrainedNodes()
+	snapshot.EndpointPolicy = c.EndpointSelectionPolicy().String()
+	snapshot.EndpointLatencies = c.EndpointLatencies()
+
+	return snapshot
+}
+
+// openMemdConns returns the number of connections to data nodes reported by the agent
+// which are open.  Connections which are not open have no local address.
+func openMemdConns(info *gocbcore.DiagnosticInfo) int {
+	open := 0
+	for _, conn := range info.MemdConns {
+		if conn.LocalAddr != "" {
+			open++
+		}
+	}
+	return open
+}
+
+// Metrics returns the outcomes and latencies of the operations performed on this
+// bucket.  Metrics are only gathered from the first call to Metrics, or to the Metrics,
+// MetricsHandler or PublishMetrics of its cluster.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Metrics() OperationMetricsSnapshot {
+	return b.cluster.enableMeter().bucketSnapshot(b.name)
+}
+
+// MetricsHandler returns an http.Handler which serves the metrics gathered for a
+// cluster as JSON.  It is safe to serve concurrently.
+//
+// Experimental: This API is subject to change at any time.
+func MetricsHandler(c *Cluster) http.Handler {
+	c.enableMeter()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := json.Marshal(c.Metrics())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(data)
+		if err != nil {
+			logDebugf("Failed to write metrics response (%s)", err)
+		}
+	})
+}
+
+// PublishMetrics publishes the metrics gathered for a cluster through expvar under
+// the specified name.  Like expvar.Publish, this panics if the name is already in use.
+//
+// Experimental: This API is subject to change at any time.
+func PublishMetrics(name string, c *Cluster) {
+	c.enableMeter()
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Metrics()
+	}))
+}
+
+// bucketName returns the name of a bucket, or an empty string for a query performed
+// through the cluster without one.
+func bucketName(b *Bucket) string {
+	if b == nil {
+		return ""
+	}
+	return b.name
+}
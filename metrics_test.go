@@ -0,0 +1,88 @@
+package gocb
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	c := &Cluster{}
+	fakeBucket := &Bucket{cluster: c, name: "default", client: &gocbcore.Agent{}}
+	c.bucketList = []*Bucket{fakeBucket}
+
+	// Operations performed before metrics are requested are not gathered.
+	fakeBucket.wrapError(nil, "Get", "key", time.Now())
+
+	handler := MetricsHandler(c)
+	performFakeOperations(fakeBucket)
+	c.recordRetry("n1ql_reprepare")
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	var metrics MetricsSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		t.Fatalf("Failed to decode metrics: %v", err)
+	}
+
+	if metrics.Operations["Get"]["success"] != 2 || metrics.Operations["Get"]["timeout"] != 1 {
+		t.Fatalf("Unexpected Get counts %v", metrics.Operations["Get"])
+	}
+	if metrics.Operations["Upsert"]["error"] != 1 {
+		t.Fatalf("Unexpected Upsert counts %v", metrics.Operations["Upsert"])
+	}
+	if metrics.Timeouts != 1 || metrics.Retries["n1ql_reprepare"] != 1 {
+		t.Fatalf("Unexpected timeout or retry counts %+v", metrics)
+	}
+
+	latency := metrics.Latencies["Get"]
+	var bucketed uint64
+	for _, count := range latency.Buckets {
+		bucketed += count
+	}
+	if latency.Count != 3 || bucketed != 3 || len(latency.Buckets) != len(latencyBucketBounds)+1 {
+		t.Fatalf("Unexpected Get latency histogram %+v", latency)
+	}
+
+	if _, ok := metrics.OpenConnections["default"]; !ok {
+		t.Fatalf("Expected the open connections of the bucket, got %v", metrics.OpenConnections)
+	}
+	if _, ok := metrics.ConfigRevisions["default"]; !ok {
+		t.Fatalf("Expected the configuration revision of the bucket, got %v", metrics.ConfigRevisions)
+	}
+}
+
+func TestOpenMemdConns(t *testing.T) {
+	info := &gocbcore.DiagnosticInfo{
+		ConfigRev: 42,
+		MemdConns: []gocbcore.MemdConnInfo{
+			{LocalAddr: "10.0.0.9:50120", RemoteAddr: "10.0.0.1:11210"},
+			{RemoteAddr: "10.0.0.2:11210"},
+			{LocalAddr: "10.0.0.9:50121", RemoteAddr: "10.0.0.3:11210"},
+		},
+	}
+	if open := openMemdConns(info); open != 2 {
+		t.Fatalf("Expected connections without a local address not to be open, got %d", open)
+	}
+}
+
+func performFakeOperations(b *Bucket) {
+	b.wrapError(nil, "Get", "key", time.Now())
+	b.wrapError(nil, "Get", "key", time.Now())
+	b.wrapError(ErrTimeout, "Get", "key", time.Now())
+	b.wrapError(errors.New("failed"), "Upsert", "key", time.Now())
+}
@@ -0,0 +1,379 @@
+package gocb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationOptions are the options available to NewMigrationProxy.  Zero values select
+// the defaults described for each option.
+type MigrationOptions struct {
+	// QueueSize is the maximum number of mutations waiting to be replicated to the
+	// secondary bucket.  Mutations made while the queue is full are not replicated,
+	// and are reported to DeadLetter.  Defaults to 1024.
+	QueueSize int
+	// MaxRetries is the maximum number of times a replication which failed with a
+	// transient error is retried.  Defaults to 5.
+	MaxRetries int
+	// Backoff calculates the wait before retrying a failed replication.  Defaults to
+	// an exponential backoff from 10ms to 1s.
+	Backoff BackoffFn
+	// ReadFallback causes reads of documents which are not found in the primary
+	// bucket to be served from the secondary bucket, such as while the secondary is
+	// being backfilled from the primary.
+	ReadFallback bool
+	// DeadLetter, if set, is invoked with each mutation which could not be
+	// replicated to the secondary bucket.
+	DeadLetter func(MigrationFailure)
+}
+
+// MigrationFailure describes a mutation which could not be replicated to the
+// secondary bucket of a MigrationProxy.
+type MigrationFailure struct {
+	// Key is the key of the document which was mutated.
+	Key string
+	// Operation is the operation which was to be performed against the secondary
+	// bucket, such as "Upsert" or "Remove".
+	Operation string
+	// Value is the encoded value which was to be stored, for an Upsert.
+	Value []byte
+	// Flags are the flags of the encoded value which was to be stored, for an Upsert.
+	Flags uint32
+	// Expiry is the expiry which was to be set, for an Upsert or Touch.
+	Expiry uint32
+	// Err is the error of the last replication attempt.
+	Err error
+}
+
+// MigrationStats describes the progress of replication by a MigrationProxy.
+type MigrationStats struct {
+	// Queued is the number of mutations waiting to be replicated.
+	Queued int
+	// Replicated is the number of mutations which have been replicated.
+	Replicated uint64
+	// Retried is the number of replication attempts which have been retried.
+	Retried uint64
+	// Failed is the number of mutations which could not be replicated.
+	Failed uint64
+	// Lag is how long the oldest mutation waiting to be replicated has been waiting.
+	Lag time.Duration
+}
+
+const (
+	migrationUpsert = "Upsert"
+	migrationRemove = "Remove"
+	migrationTouch  = "Touch"
+)
+
+// migrationWrite is a mutation waiting to be replicated.  It deliberately holds no
+// CAS, as CAS values of the primary bucket mean nothing to the secondary.  Values are
+// held as encoded by the primary bucket, so that changes made by the caller to a value
+// after the mutation are not replicated, and its flags are preserved.
+type migrationWrite struct {
+	op       string
+	key      string
+	value    archivedValue
+	expiry   uint32
+	queuedAt time.Time
+}
+
+// MigrationProxy assists with the dual-write phase of migrating documents between
+// buckets.  It provides the KV operations of a Bucket, performing mutations against
+// the primary bucket and then replicating each successful mutation to the secondary
+// bucket in the background, in the order the mutations completed.  Reads are
+// performed against the primary bucket.
+//
+// CAS values apply only to the primary bucket.  CAS values passed to a MigrationProxy
+// are used for the primary bucket and are never forwarded to the secondary, so
+// mutations are replicated unconditionally: Insert and Replace are replicated as
+// Upsert, and Counter as an Upsert of the resulting value.  Mutations of the same
+// document made concurrently may be replicated in a different order from that in
+// which they were applied to the primary bucket.
+//
+// Experimental: This API is subject to change at any time.
+type MigrationProxy struct {
+	primary   *Bucket
+	secondary *Bucket
+	opts      MigrationOptions
+	insert    func(key string, value interface{}, expiry uint32) (string, Cas, error)
+	apply     func(w *migrationWrite) error
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []*migrationWrite
+	active   *migrationWrite
+	isClosed bool
+	done     chan struct{}
+
+	replicated uint64
+	retried    uint64
+	failed     uint64
+}
+
+// NewMigrationProxy creates a MigrationProxy which performs operations against the
+// primary bucket and replicates mutations to the secondary bucket.  Close must be
+// called once the proxy is no longer needed.
+//
+// Experimental: This API is subject to change at any time.
+func NewMigrationProxy(primary, secondary *Bucket, opts MigrationOptions) *MigrationProxy {
+	p := newMigrationProxy(primary, secondary, opts)
+	p.insert = p.insertIntoPrimary
+	p.apply = p.applyToSecondary
+	go p.replicate()
+	return p
+}
+
+func newMigrationProxy(primary, secondary *Bucket, opts MigrationOptions) *MigrationProxy {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(10*time.Millisecond, 1*time.Second, 2)
+	}
+
+	p := &MigrationProxy{
+		primary:   primary,
+		secondary: secondary,
+		opts:      opts,
+		done:      make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// Primary returns the bucket mutations are performed against.
+func (p *MigrationProxy) Primary() *Bucket {
+	return p.primary
+}
+
+// Secondary returns the bucket mutations are replicated to.
+func (p *MigrationProxy) Secondary() *Bucket {
+	return p.secondary
+}
+
+// Stats returns the progress of replication to the secondary bucket.
+func (p *MigrationProxy) Stats() MigrationStats {
+	p.lock.Lock()
+	stats := MigrationStats{Queued: len(p.queue)}
+	oldest := p.active
+	if oldest == nil && len(p.queue) > 0 {
+		oldest = p.queue[0]
+	}
+	if oldest != nil {
+		stats.Lag = time.Since(oldest.queuedAt)
+	}
+	p.lock.Unlock()
+
+	stats.Replicated = atomic.LoadUint64(&p.replicated)
+	stats.Retried = atomic.LoadUint64(&p.retried)
+	stats.Failed = atomic.LoadUint64(&p.failed)
+	return stats
+}
+
+// Close waits for every queued mutation to be replicated and stops replication.
+// Mutations made through the proxy after it is closed are still performed against
+// the primary bucket, but are reported to DeadLetter rather than replicated.
+func (p *MigrationProxy) Close() {
+	p.lock.Lock()
+	p.isClosed = true
+	p.cond.Broadcast()
+	p.lock.Unlock()
+	<-p.done
+}
+
+func (p *MigrationProxy) deadLetter(w *migrationWrite, err error) {
+	atomic.AddUint64(&p.failed, 1)
+	logDebugFields("Failed to replicate write to secondary bucket", LogField{"op", w.op}, keyField(w.key), LogField{"error", err})
+	if p.opts.DeadLetter != nil {
+		p.opts.DeadLetter(MigrationFailure{
+			Key:       w.key,
+			Operation: w.op,
+			Value:     w.value.bytes,
+			Flags:     w.value.flags,
+			Expiry:    w.expiry,
+			Err:       err,
+		})
+	}
+}
+
+// enqueueUpsert encodes the value of a mutation with the transcoder of the primary
+// bucket and queues it to be upserted to the secondary.
+func (p *MigrationProxy) enqueueUpsert(key string, value interface{}, expiry uint32) {
+	w := &migrationWrite{op: migrationUpsert, key: key, expiry: expiry}
+	bytes, flags, err := p.primary.encodeValue(value)
+	if err != nil {
+		p.deadLetter(w, err)
+		return
+	}
+	w.value = archivedValue{bytes: bytes, flags: flags}
+	p.enqueue(w)
+}
+
+func (p *MigrationProxy) enqueue(w *migrationWrite) {
+	w.queuedAt = time.Now()
+
+	p.lock.Lock()
+	if p.isClosed {
+		p.lock.Unlock()
+		p.deadLetter(w, ErrShutdown)
+		return
+	}
+	if len(p.queue) >= p.opts.QueueSize {
+		p.lock.Unlock()
+		p.deadLetter(w, ErrOverload)
+		return
+	}
+	p.queue = append(p.queue, w)
+	p.cond.Signal()
+	p.lock.Unlock()
+}
+
+// next waits for the next mutation to replicate, returning nil once the proxy has
+// been closed and the queue is empty.
+func (p *MigrationProxy) next() *migrationWrite {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.active = nil
+	for len(p.queue) == 0 {
+		if p.isClosed {
+			return nil
+		}
+		p.cond.Wait()
+	}
+	p.active = p.queue[0]
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	return p.active
+}
+
+func (p *MigrationProxy) replicate() {
+	defer close(p.done)
+	for w := p.next(); w != nil; w = p.next() {
+		err := p.apply(w)
+		for attempt := 0; err != nil && attempt < p.opts.MaxRetries; attempt++ {
+			if !isMigrationTransient(err) {
+				break
+			}
+			atomic.AddUint64(&p.retried, 1)
+			time.Sleep(p.opts.Backoff(uint32(attempt)))
+			err = p.apply(w)
+		}
+
+		if err != nil {
+			p.deadLetter(w, err)
+			continue
+		}
+		atomic.AddUint64(&p.replicated, 1)
+	}
+}
+
+func isMigrationTransient(err error) bool {
+	return isBulkLoadTransient(err) || ErrorCause(err) == ErrNetworkAmbiguous
+}
+
+func (p *MigrationProxy) applyToSecondary(w *migrationWrite) error {
+	var err error
+	switch w.op {
+	case migrationUpsert:
+		raw := *p.secondary
+		raw.transcoder = archiveTranscoder{}
+		start := time.Now()
+		_, _, err = raw.upsert(nil, w.key, &w.value, w.expiry)
+		err = raw.wrapError(err, "Upsert", w.key, start)
+	case migrationRemove:
+		_, err = p.secondary.Remove(w.key, 0)
+	case migrationTouch:
+		_, err = p.secondary.Touch(w.key, 0, w.expiry)
+	}
+	if w.op != migrationUpsert && ErrorCause(err) == ErrKeyNotFound {
+		// The document has not been backfilled to the secondary yet, or has already
+		// been removed from it.
+		return nil
+	}
+	return err
+}
+
+// Get retrieves a document from the primary bucket.  If ReadFallback is enabled and
+// the document is not found, it is retrieved from the secondary bucket instead, and a
+// Cas of zero is returned as the CAS of the secondary means nothing to the primary.
+func (p *MigrationProxy) Get(key string, valuePtr interface{}) (Cas, error) {
+	cas, err := p.primary.Get(key, valuePtr)
+	if !p.opts.ReadFallback || ErrorCause(err) != ErrKeyNotFound {
+		return cas, err
+	}
+	if _, err := p.secondary.Get(key, valuePtr); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Insert inserts a new document into the primary bucket, and replicates it to the
+// secondary.  If the key is empty and the primary bucket has a KeyGenerator, the
+// document is replicated with the key it was inserted with.
+func (p *MigrationProxy) Insert(key string, value interface{}, expiry uint32) (Cas, error) {
+	key, cas, err := p.insert(key, value, expiry)
+	if err == nil {
+		p.enqueueUpsert(key, value, expiry)
+	}
+	return cas, err
+}
+
+func (p *MigrationProxy) insertIntoPrimary(key string, value interface{}, expiry uint32) (string, Cas, error) {
+	start := time.Now()
+	key, cas, _, err := p.primary.insertGenerated(nil, key, value, expiry)
+	return key, cas, p.primary.wrapError(err, "Insert", key, start)
+}
+
+// Upsert inserts or replaces a document in the primary bucket, and replicates it to
+// the secondary.
+func (p *MigrationProxy) Upsert(key string, value interface{}, expiry uint32) (Cas, error) {
+	cas, err := p.primary.Upsert(key, value, expiry)
+	if err == nil {
+		p.enqueueUpsert(key, value, expiry)
+	}
+	return cas, err
+}
+
+// Replace replaces a document in the primary bucket, checking cas against the
+// primary only, and replicates it to the secondary.
+func (p *MigrationProxy) Replace(key string, value interface{}, cas Cas, expiry uint32) (Cas, error) {
+	cas, err := p.primary.Replace(key, value, cas, expiry)
+	if err == nil {
+		p.enqueueUpsert(key, value, expiry)
+	}
+	return cas, err
+}
+
+// Remove removes a document from the primary bucket, checking cas against the primary
+// only, and replicates the removal to the secondary.
+func (p *MigrationProxy) Remove(key string, cas Cas) (Cas, error) {
+	cas, err := p.primary.Remove(key, cas)
+	if err == nil {
+		p.enqueue(&migrationWrite{op: migrationRemove, key: key})
+	}
+	return cas, err
+}
+
+// Touch updates the expiry of a document in the primary bucket, checking cas against
+// the primary only, and replicates the new expiry to the secondary.
+func (p *MigrationProxy) Touch(key string, cas Cas, expiry uint32) (Cas, error) {
+	cas, err := p.primary.Touch(key, cas, expiry)
+	if err == nil {
+		p.enqueue(&migrationWrite{op: migrationTouch, key: key, expiry: expiry})
+	}
+	return cas, err
+}
+
+// Counter performs an atomic addition on a document in the primary bucket, and
+// replicates the resulting value to the secondary.
+func (p *MigrationProxy) Counter(key string, delta, initial int64, expiry uint32) (uint64, Cas, error) {
+	val, cas, err := p.primary.Counter(key, delta, initial, expiry)
+	if err == nil {
+		p.enqueueUpsert(key, val, expiry)
+	}
+	return val, cas, err
+}
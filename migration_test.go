@@ -0,0 +1,176 @@
+package gocb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMigrationProxy(opts MigrationOptions, apply func(w *migrationWrite) error) *MigrationProxy {
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(time.Millisecond, time.Millisecond, 1)
+	}
+	p := newMigrationProxy(nil, nil, opts)
+	p.apply = apply
+	go p.replicate()
+	return p
+}
+
+func TestMigrationProxyReplicatesInOrder(t *testing.T) {
+	var lock sync.Mutex
+	var applied []string
+	var attempts int
+	p := newTestMigrationProxy(MigrationOptions{}, func(w *migrationWrite) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts == 2 {
+			return ErrTmpFail
+		}
+		applied = append(applied, w.op+":"+w.key)
+		return nil
+	})
+
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "a"})
+	p.enqueue(&migrationWrite{op: migrationTouch, key: "a", expiry: 10})
+	p.enqueue(&migrationWrite{op: migrationRemove, key: "b"})
+	p.Close()
+
+	expected := []string{"Upsert:a", "Touch:a", "Remove:b"}
+	if len(applied) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, applied)
+	}
+	for i := range expected {
+		if applied[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, applied)
+		}
+	}
+
+	stats := p.Stats()
+	if stats.Replicated != 3 || stats.Retried != 1 || stats.Failed != 0 || stats.Queued != 0 || stats.Lag != 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestMigrationProxyEncodesAtEnqueue(t *testing.T) {
+	var lock sync.Mutex
+	var applied []*migrationWrite
+	var failures []MigrationFailure
+	opts := MigrationOptions{
+		DeadLetter: func(f MigrationFailure) {
+			failures = append(failures, f)
+		},
+	}
+	p := newTestMigrationProxy(opts, func(w *migrationWrite) error {
+		lock.Lock()
+		applied = append(applied, w)
+		lock.Unlock()
+		return nil
+	})
+	p.primary = &Bucket{cluster: &Cluster{}, transcoder: DefaultTranscoder{}}
+	p.insert = func(key string, value interface{}, expiry uint32) (string, Cas, error) {
+		if key == "" {
+			key = "generated"
+		}
+		return key, 1, nil
+	}
+
+	value := map[string]int{"n": 1}
+	_, jsonFlags, _ := DefaultTranscoder{}.Encode(value)
+	if _, err := p.Insert("", value, 10); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	value["n"] = 2
+	p.enqueueUpsert("invalid", make(chan int), 0)
+	p.Close()
+
+	if len(applied) != 1 || applied[0].key != "generated" || applied[0].expiry != 10 {
+		t.Fatalf("Expected the generated key to be replicated, got %v", applied)
+	}
+	if string(applied[0].value.bytes) != `{"n":1}` || applied[0].value.flags != jsonFlags {
+		t.Fatalf("Expected the value as encoded when inserted, got %s with flags %x",
+			applied[0].value.bytes, applied[0].value.flags)
+	}
+	if len(failures) != 1 || failures[0].Key != "invalid" || ErrorCause(failures[0].Err) != ErrInvalidValue {
+		t.Fatalf("Expected the unencodable value to be reported, got %v", failures)
+	}
+}
+
+func TestMigrationProxyDeadLetter(t *testing.T) {
+	errPermanent := errors.New("permanent failure")
+	var lock sync.Mutex
+	failures := make(map[string]error)
+	opts := MigrationOptions{
+		MaxRetries: 2,
+		DeadLetter: func(f MigrationFailure) {
+			lock.Lock()
+			failures[f.Key] = f.Err
+			lock.Unlock()
+		},
+	}
+	p := newTestMigrationProxy(opts, func(w *migrationWrite) error {
+		switch w.key {
+		case "permanent":
+			return errPermanent
+		case "transient":
+			return ErrTimeout
+		}
+		return nil
+	})
+
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "permanent"})
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "transient"})
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "ok"})
+	p.Close()
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "closed"})
+
+	if failures["permanent"] != errPermanent || failures["transient"] != ErrTimeout ||
+		failures["closed"] != ErrShutdown || len(failures) != 3 {
+		t.Fatalf("Unexpected failures %v", failures)
+	}
+	stats := p.Stats()
+	if stats.Replicated != 1 || stats.Failed != 3 || stats.Retried != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestMigrationProxyQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var overflowed []string
+	opts := MigrationOptions{
+		QueueSize: 2,
+		DeadLetter: func(f MigrationFailure) {
+			if f.Err == ErrOverload {
+				overflowed = append(overflowed, f.Key)
+			}
+		},
+	}
+	p := newTestMigrationProxy(opts, func(w *migrationWrite) error {
+		<-release
+		return nil
+	})
+
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "a"})
+	for p.Stats().Queued > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "b"})
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "c"})
+	p.enqueue(&migrationWrite{op: migrationUpsert, key: "d"})
+
+	time.Sleep(5 * time.Millisecond)
+	stats := p.Stats()
+	if stats.Queued != 2 || stats.Lag < 5*time.Millisecond {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if len(overflowed) != 1 || overflowed[0] != "d" {
+		t.Fatalf("Expected d to overflow, got %v", overflowed)
+	}
+
+	close(release)
+	p.Close()
+	if stats := p.Stats(); stats.Replicated != 3 || stats.Failed != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
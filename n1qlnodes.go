@@ -0,0 +1,79 @@
+package gocb
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// How long a query node which could not be connected to is avoided for.
+var n1qlBlacklistDuration = 10 * time.Second
+
+// endpointBlacklist tracks the endpoints which recently could not be connected to, so
+// that requests are sent to other endpoints until they are expected to have recovered.
+type endpointBlacklist struct {
+	lock  sync.Mutex
+	until map[string]time.Time
+}
+
+func (bl *endpointBlacklist) add(ep string, until time.Time) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	if bl.until == nil {
+		bl.until = make(map[string]time.Time)
+	}
+	bl.until[ep] = until
+}
+
+// filter returns the endpoints which are not blacklisted at now.  If every endpoint is
+// blacklisted, they are all returned, as a node which may have recovered is still
+// preferable to failing without trying any.
+func (bl *endpointBlacklist) filter(eps []string, now time.Time) []string {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	var allowed []string
+	for _, ep := range eps {
+		until, ok := bl.until[ep]
+		if ok && now.After(until) {
+			delete(bl.until, ep)
+			ok = false
+		}
+		if !ok {
+			allowed = append(allowed, ep)
+		}
+	}
+	if len(allowed) == 0 {
+		return eps
+	}
+	return allowed
+}
+
+// reachableN1qlEps returns the query endpoints which have not recently failed to be
+// connected to.
+func (c *Cluster) reachableN1qlEps(eps []string) []string {
+	if c == nil {
+		return eps
+	}
+	return c.n1qlBlacklist.filter(eps, time.Now())
+}
+
+// blacklistN1qlEp avoids sending queries to an endpoint which could not be connected to.
+func (c *Cluster) blacklistN1qlEp(ep string) {
+	if c == nil {
+		return
+	}
+	logDebugf("Avoiding query node %s after failing to connect to it", redactSystemData(ep))
+	c.n1qlBlacklist.add(ep, time.Now().Add(n1qlBlacklistDuration))
+}
+
+// isConnectError returns whether an HTTP request failed because a connection could not
+// be established, in which case it was never received by the server.
+func isConnectError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
@@ -0,0 +1,80 @@
+package gocb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointBlacklist(t *testing.T) {
+	now := time.Unix(100, 0)
+	var bl endpointBlacklist
+	bl.add("http://a:8093", now.Add(10*time.Second))
+
+	eps := []string{"http://a:8093", "http://b:8093"}
+	if allowed := bl.filter(eps, now); len(allowed) != 1 || allowed[0] != "http://b:8093" {
+		t.Fatalf("Expected the blacklisted endpoint to be avoided, got %v", allowed)
+	}
+	if allowed := bl.filter(eps[:1], now); len(allowed) != 1 {
+		t.Fatalf("Expected every endpoint when all are blacklisted, got %v", allowed)
+	}
+	if allowed := bl.filter(eps, now.Add(11*time.Second)); len(allowed) != 2 {
+		t.Fatalf("Expected the blacklisting to expire, got %v", allowed)
+	}
+}
+
+func TestN1qlRetryOnConnectFailure(t *testing.T) {
+	// Find a port which nothing is listening on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	deadPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/pools/default/nodeServices" {
+			livePort := server.Listener.Addr().(*net.TCPAddr).Port
+			fmt.Fprintf(w, `{"nodesExt":[{"hostname":"127.0.0.1","services":{"n1ql":%d}},{"hostname":"127.0.0.1","services":{"n1ql":%d}}]}`, deadPort, livePort)
+			return
+		}
+		w.Write([]byte(`{"requestID":"1","results":[{"a":1}],"status":"success","metrics":{"resultCount":1}}`))
+	}))
+	defer server.Close()
+
+	newCluster := func() *Cluster {
+		c := &Cluster{
+			httpCli:    http.DefaultClient,
+			queryCache: make(map[string]*n1qlCache),
+			endpoints:  newEndpointSelector(),
+		}
+		c.agentConfig.HttpAddrs = []string{strings.TrimPrefix(server.URL, "http://")}
+		c.auth = ClusterAuthenticator{Username: "admin", Password: "password"}
+		return c
+	}
+
+	c := newCluster()
+	results, err := c.ExecuteN1qlQuery(NewN1qlQuery("SELECT 1"), nil)
+	if err != nil {
+		t.Fatalf("Expected the query to be sent to another node, got %v", err)
+	}
+	results.Close()
+
+	deadEp := fmt.Sprintf("http://127.0.0.1:%d", deadPort)
+	if allowed := c.reachableN1qlEps([]string{deadEp, server.URL}); len(allowed) != 1 || allowed[0] != server.URL {
+		t.Fatalf("Expected the unreachable node to be blacklisted, got %v", allowed)
+	}
+
+	// The first node never received the query, so any statement is sent to another.
+	c = newCluster()
+	results, err = c.ExecuteN1qlQuery(NewN1qlQuery("DELETE FROM default"), nil)
+	if err != nil {
+		t.Fatalf("Expected a DELETE statement to also be sent to another node, got %v", err)
+	}
+	results.Close()
+}
@@ -0,0 +1,153 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// n1qlParamsFromValue converts named parameters given as a map or as a struct into a
+// map of parameter names to values.  The fields of a struct are named as they are
+// when it is encoded to JSON, so json tags may be used to name or omit them.
+func n1qlParamsFromValue(value interface{}) (map[string]interface{}, error) {
+	if params, ok := value.(map[string]interface{}); ok {
+		return params, nil
+	}
+
+	kind := reflect.Indirect(reflect.ValueOf(value)).Kind()
+	if kind != reflect.Struct && kind != reflect.Map {
+		return nil, clientError{fmt.Sprintf("Named parameters must be a map or a struct, not %T.", value)}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, clientError{fmt.Sprintf("Named parameters must encode to a JSON object, not %s.", data)}
+	}
+
+	params := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		params[name] = value
+	}
+	return params, nil
+}
+
+// normalizeN1qlParams converts the params passed to ExecuteN1qlQuery into either a
+// slice of positional parameters or a map of named parameters.
+func normalizeN1qlParams(params interface{}) (interface{}, error) {
+	switch args := params.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return args, nil
+	}
+	return n1qlParamsFromValue(params)
+}
+
+func isValidN1qlParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isN1qlIdentChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkN1qlParams validates the parameters of a query against the placeholders of its
+// statement before it is sent, so that a missing parameter is reported without a round
+// trip to the query service.
+func checkN1qlParams(execOpts map[string]interface{}) error {
+	var named []string
+	for option := range execOpts {
+		if !strings.HasPrefix(option, "$") {
+			continue
+		}
+		name := option[1:]
+		if !isValidN1qlParamName(name) {
+			return clientError{fmt.Sprintf("The parameter name %q may only contain letters, digits and underscores.", name)}
+		}
+		named = append(named, name)
+	}
+	args, _ := execOpts["args"].([]interface{})
+	if len(named) > 0 && len(args) > 0 {
+		return clientError{"A query cannot be given both named and positional parameters."}
+	}
+
+	statement, _ := execOpts["statement"].(string)
+	scan := scanN1qlStatement(statement)
+	if scan.problem != "" {
+		return nil
+	}
+
+	if scan.positional > len(args) {
+		return clientError{fmt.Sprintf("The statement has %d positional placeholders but %d parameters were provided.", scan.positional, len(args))}
+	}
+	for _, name := range scan.named {
+		if position, err := strconv.Atoi(name); err == nil {
+			if position < 1 || position > len(args) {
+				return clientError{fmt.Sprintf("The statement references the positional parameter $%s, which was not provided.", name)}
+			}
+			continue
+		}
+		if _, ok := execOpts["$"+name]; !ok {
+			return clientError{fmt.Sprintf("The statement references the named parameter $%s, which was not provided.", name)}
+		}
+	}
+	return nil
+}
+
+// NamedParameters sets the named parameters of the query from a map of names to values,
+// or from a struct whose fields are named as they are when it is encoded to JSON.  The
+// names are given without their $ prefix.  If the parameters are invalid, executing
+// the query fails with the error.
+//
+// Experimental: This API is subject to change at any time.
+func (nq *N1qlQuery) NamedParameters(params interface{}) *N1qlQuery {
+	if nq.err != nil {
+		return nq
+	}
+	named, err := n1qlParamsFromValue(params)
+	if err != nil {
+		nq.err = err
+		return nq
+	}
+	for name, value := range named {
+		nq.options["$"+name] = value
+	}
+	return nq
+}
+
+// PositionalParameters sets the positional parameters of the query, which are
+// referenced in the statement as ? or as $1, $2 and so on.
+//
+// Experimental: This API is subject to change at any time.
+func (nq *N1qlQuery) PositionalParameters(args ...interface{}) *N1qlQuery {
+	nq.options["args"] = args
+	return nq
+}
+
+// ExecuteN1qlQueryNamed performs a n1ql query with the specified named parameters, as
+// ExecuteN1qlQuery does.  The names are given without their $ prefix.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteN1qlQueryNamed(q *N1qlQuery, params map[string]interface{}) (QueryResults, error) {
+	return b.cluster.doN1qlQuery(context.Background(), b, q, params)
+}
+
+// ExecuteN1qlQueryNamed performs a n1ql query with the specified named parameters using
+// the cluster credentials, as ExecuteN1qlQuery does.  The names are given without their
+// $ prefix.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) ExecuteN1qlQueryNamed(q *N1qlQuery, params map[string]interface{}) (QueryResults, error) {
+	return c.doN1qlQuery(context.Background(), nil, q, params)
+}
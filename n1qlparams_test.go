@@ -0,0 +1,56 @@
+package gocb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestN1qlParamsFromStruct(t *testing.T) {
+	type userParams struct {
+		Name    string `json:"name"`
+		Age     int    `json:"age,omitempty"`
+		Ignored string `json:"-"`
+	}
+
+	params, err := n1qlParamsFromValue(&userParams{Name: "frank", Ignored: "x"})
+	if err != nil {
+		t.Fatalf("Failed to convert struct: %v", err)
+	}
+	if len(params) != 1 || string(params["name"].(json.RawMessage)) != `"frank"` {
+		t.Fatalf("Unexpected parameters %v", params)
+	}
+
+	if _, err := n1qlParamsFromValue(42); err == nil {
+		t.Fatalf("Expected a number to be rejected as named parameters")
+	}
+
+	q := NewN1qlQuery("SELECT * FROM default WHERE name = $name").NamedParameters(userParams{Name: "frank"})
+	if q.err != nil || q.options["$name"] == nil {
+		t.Fatalf("Expected the named parameter to be set, got %v (%v)", q.options, q.err)
+	}
+	if q := NewN1qlQuery("SELECT 1").NamedParameters("name"); q.err == nil {
+		t.Fatalf("Expected invalid named parameters to fail the query")
+	}
+}
+
+func TestCheckN1qlParams(t *testing.T) {
+	tests := []struct {
+		opts  map[string]interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE name = $name", "$name": "frank"}, true},
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE name = $name"}, false},
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE name = '$name'"}, true},
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE a = ? AND b = $2", "args": []interface{}{1, 2}}, true},
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE a = ? AND b = ?", "args": []interface{}{1}}, false},
+		{map[string]interface{}{"statement": "SELECT * FROM default WHERE a = $3", "args": []interface{}{1}}, false},
+		{map[string]interface{}{"statement": "SELECT 1", "$bad name": 1}, false},
+		{map[string]interface{}{"statement": "SELECT $a", "$a": 1, "args": []interface{}{1}}, false},
+	}
+	for _, test := range tests {
+		err := checkN1qlParams(test.opts)
+		if (err == nil) != test.valid {
+			t.Fatalf("Expected %v to be valid=%v, got %v", test.opts, test.valid, err)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package gocb
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,10 +21,26 @@ const (
 	StatementPlus = ConsistencyMode(3)
 )
 
+// QueryProfileType specifies the profiling information the query service returns
+// about the execution of a query.
+type QueryProfileType int
+
+const (
+	// ProfileNone indicates that no profiling information is returned.
+	ProfileNone = QueryProfileType(1)
+	// ProfilePhases indicates that the time spent in each phase of the query is returned.
+	ProfilePhases = QueryProfileType(2)
+	// ProfileTimings indicates that the time spent in each operator of the query plan
+	// is returned, in addition to the time spent in each phase.
+	ProfileTimings = QueryProfileType(3)
+)
+
 // N1qlQuery represents a pending N1QL query.
 type N1qlQuery struct {
-	options map[string]interface{}
-	adHoc   bool
+	options    map[string]interface{}
+	adHoc      bool
+	projection *rowProjection
+	err        error
 }
 
 // Consistency specifies the level of consistency required for this query.
@@ -50,7 +70,10 @@ func (nq *N1qlQuery) ConsistentWith(state *MutationState) *N1qlQuery {
 	return nq
 }
 
-// AdHoc specifies that this query is adhoc and should not be prepared.
+// AdHoc specifies that this query is adhoc and should not be prepared.  Queries which
+// are not adhoc are prepared on each query node the first time they are sent to it,
+// with the plan cached by the Cluster for that node.  The statement is prepared again
+// if the node no longer recognises the plan, such as after it restarts.
 func (nq *N1qlQuery) AdHoc(adhoc bool) *N1qlQuery {
 	nq.adHoc = adhoc
 	return nq
@@ -78,25 +101,130 @@ func (nq *N1qlQuery) PipelineCap(pipelineCap int) *N1qlQuery {
 	return nq
 }
 
+// MaxParallelism specifies the maximum number of index partitions the query service
+// may scan in parallel for this query.  Use 0 or a negative number to use the setting
+// of the query service.
+func (nq *N1qlQuery) MaxParallelism(maxParallelism int) *N1qlQuery {
+	nq.options["max_parallelism"] = strconv.Itoa(maxParallelism)
+	return nq
+}
+
 // ReadOnly controls whether a query can change a resulting recordset.  If
-// readonly is true, then only SELECT statements are permitted.
+// readonly is true, then only SELECT statements are permitted.  Readonly queries are
+// sent using GET requests, which the HTTP client may safely send again when a pooled
+// connection is found to have been closed, and are retried on another node if their
+// node cannot be connected to.
 func (nq *N1qlQuery) ReadOnly(readOnly bool) *N1qlQuery {
 	nq.options["readonly"] = readOnly
 	return nq
 }
 
+// Profile specifies the profiling information to return about the execution of this
+// query, which is available from the Profile field of the metrics of the results.
+func (nq *N1qlQuery) Profile(profile QueryProfileType) *N1qlQuery {
+	switch profile {
+	case ProfileNone:
+		nq.options["profile"] = "off"
+	case ProfilePhases:
+		nq.options["profile"] = "phases"
+	case ProfileTimings:
+		nq.options["profile"] = "timings"
+	default:
+		panic("Unexpected profile option")
+	}
+	return nq
+}
+
 // Custom allows specifying custom query options.
 func (nq *N1qlQuery) Custom(name string, value interface{}) *N1qlQuery {
 	nq.options[name] = value
 	return nq
 }
 
-// Timeout indicates the maximum time to wait for this query to complete.
+// Project specifies that only the values at the given JSON pointers are required from
+// each row.  Each row is then scanned for those values without the rest of the row being
+// decoded, and is returned as an object keyed by the pointers, in which absent values
+// are null.  Rows which are not valid JSON fail with a RowProjectionError.  If a path
+// is not a valid JSON pointer, executing the query fails with the error.
+//
+// Experimental: This API is subject to change at any time.
+func (nq *N1qlQuery) Project(paths ...string) *N1qlQuery {
+	projection, err := newRowProjection(paths)
+	if err != nil {
+		if nq.err == nil {
+			nq.err = err
+		}
+		return nq
+	}
+	nq.projection = projection
+	return nq
+}
+
+// Timeout indicates the maximum time to wait for this query to complete, overriding
+// the N1QL timeouts of the bucket and cluster.
 func (nq *N1qlQuery) Timeout(timeout time.Duration) *N1qlQuery {
 	nq.options["timeout"] = timeout.String()
 	return nq
 }
 
+// String returns a one-line description of the query statement and its options.
+// Parameter values are redacted unless the log redaction level is RedactNone.
+func (nq *N1qlQuery) String() string {
+	return formatQueryOptions(nq.options, "adhoc="+strconv.FormatBool(nq.adHoc))
+}
+
+func formatQueryValue(value interface{}) string {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(bytes)
+}
+
+func formatQueryOptions(opts map[string]interface{}, extra ...string) string {
+	var names []string
+	for name := range opts {
+		if name != "statement" && name != "client_context_id" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	statement, _ := opts["statement"].(string)
+	fields := append([]string{"statement=" + strconv.Quote(statement)}, extra...)
+
+	var params []string
+	for _, name := range names {
+		value := opts[name]
+		if name == "args" {
+			args, _ := value.([]interface{})
+			for i, arg := range args {
+				params = append(params, formatQueryParam("$"+strconv.Itoa(i+1), arg))
+			}
+			continue
+		}
+		if strings.HasPrefix(name, "$") {
+			params = append(params, formatQueryParam(name, value))
+			continue
+		}
+		fields = append(fields, name+"="+formatQueryValue(value))
+	}
+	if len(params) > 0 {
+		fields = append(fields, "params=["+strings.Join(params, " ")+"]")
+	}
+	if contextId, ok := opts["client_context_id"]; ok {
+		fields = append(fields, "client_context_id="+formatQueryValue(contextId))
+	}
+	return strings.Join(fields, " ")
+}
+
+func formatQueryParam(name string, value interface{}) string {
+	if globalLogRedactLevel == RedactNone {
+		return name + "=" + formatQueryValue(value)
+	}
+	return name
+}
+
 // NewN1qlQuery creates a new N1qlQuery object from a query string.
 func NewN1qlQuery(statement string) *N1qlQuery {
 	nq := &N1qlQuery{
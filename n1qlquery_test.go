@@ -0,0 +1,194 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestN1qlQueryString(t *testing.T) {
+	q := NewN1qlQuery("SELECT * FROM default WHERE name=$name").
+		AdHoc(false).
+		ReadOnly(true).
+		Custom("$name", "frank").
+		Custom("client_context_id", "ctx-1")
+
+	expected := `statement="SELECT * FROM default WHERE name=$name" adhoc=false readonly=true params=[$name] client_context_id="ctx-1"`
+	if q.String() != expected {
+		t.Fatalf("Unexpected query description:\n%s\n%s", q.String(), expected)
+	}
+	if len(q.options) != 4 {
+		t.Fatalf("Expected String not to modify the query options")
+	}
+
+	SetLogRedactionLevel(RedactNone)
+	defer SetLogRedactionLevel(RedactPartial)
+	expected = `statement="SELECT * FROM default WHERE name=$name" adhoc=false readonly=true params=[$name="frank"] client_context_id="ctx-1"`
+	if q.String() != expected {
+		t.Fatalf("Unexpected unredacted query description:\n%s\n%s", q.String(), expected)
+	}
+}
+
+func TestViewQueryString(t *testing.T) {
+	q := NewViewQuery("ddoc", "view").Limit(10).Key("frank").Range("a", "m", true).Stale(Before)
+
+	expected := `ddoc/view endkey=<ud>"m"</ud> inclusive_end=true key=<ud>"frank"</ud> limit=10 stale=false startkey=<ud>"a"</ud>`
+	if q.String() != expected {
+		t.Fatalf("Unexpected view query description:\n%s\n%s", q.String(), expected)
+	}
+
+	SetLogRedactionLevel(RedactNone)
+	defer SetLogRedactionLevel(RedactPartial)
+	expected = `ddoc/view endkey="m" inclusive_end=true key="frank" limit=10 stale=false startkey="a"`
+	if q.String() != expected {
+		t.Fatalf("Unexpected unredacted view query description:\n%s\n%s", q.String(), expected)
+	}
+}
+
+func TestViewQueryScanConsistency(t *testing.T) {
+	cases := map[ViewScanConsistency]string{
+		ViewScanConsistencyUpdateBefore: "false",
+		ViewScanConsistencyNone:         "ok",
+		ViewScanConsistencyUpdateAfter:  "update_after",
+	}
+	for consistency, stale := range cases {
+		q := NewViewQuery("ddoc", "view").ScanConsistency(consistency)
+		if q.options.Get("stale") != stale {
+			t.Fatalf("Expected stale=%s for %d, got %s", stale, consistency, q.options.Get("stale"))
+		}
+	}
+}
+
+func TestN1qlPreparedStatementReprepare(t *testing.T) {
+	var lock sync.Mutex
+	prepares := 0
+	rejectPlan := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var opts map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&opts)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if statement, _ := opts["statement"].(string); strings.HasPrefix(statement, "PREPARE ") {
+			prepares++
+			fmt.Fprintf(w, `{"results":[{"name":"p%d","encoded_plan":"plan%d"}],"status":"success","metrics":{}}`, prepares, prepares)
+			return
+		}
+
+		if opts["prepared"] == "p1" && rejectPlan {
+			fmt.Fprint(w, `{"results":[],"errors":[{"code":4050,"msg":"unrecognised prepared statement"}],"status":"errors","metrics":{}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"results":[{"plan":"%s"}],"status":"success","metrics":{}}`, opts["encoded_plan"])
+	}))
+	defer server.Close()
+
+	c := &Cluster{queryCache: make(map[string]*n1qlCache)}
+	run := func(ep string) string {
+		q := NewN1qlQuery("SELECT 1").AdHoc(false)
+		execOpts := map[string]interface{}{"statement": "SELECT 1"}
+		results, err := c.dispatchN1qlQuery(context.Background(), q, ep, execOpts, nil, 5*time.Second, http.DefaultClient, newRetryTracker(RetryBudget{}))
+		if err != nil {
+			t.Fatalf("Failed to execute query: %v", err)
+		}
+		var row map[string]string
+		if err := results.One(&row); err != nil {
+			t.Fatalf("Failed to read row: %v", err)
+		}
+		return row["plan"]
+	}
+
+	if plan := run(server.URL); plan != "plan1" || prepares != 1 {
+		t.Fatalf("Expected the statement to be prepared once, got plan %s after %d prepares", plan, prepares)
+	}
+	lock.Lock()
+	rejectPlan = true
+	lock.Unlock()
+	if plan := run(server.URL); plan != "plan2" || prepares != 2 {
+		t.Fatalf("Expected an unrecognised plan to be prepared again, got plan %s after %d prepares", plan, prepares)
+	}
+	if plan := run(server.URL); plan != "plan2" || prepares != 2 {
+		t.Fatalf("Expected the new plan to be cached, got plan %s after %d prepares", plan, prepares)
+	}
+
+	// A plan prepared on one node is not used for another.
+	if run(server.URL + "/"); prepares != 3 {
+		t.Fatalf("Expected the statement to be prepared for the other node, got %d prepares", prepares)
+	}
+	if len(c.queryCache) != 2 {
+		t.Fatalf("Expected a cached plan per node, got %d", len(c.queryCache))
+	}
+}
+
+func TestN1qlQueryProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var opts map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&opts)
+		fmt.Fprintf(w, `{"results":[{"a":1}],"status":"success","metrics":{"elapsedTime":"2ms","executionTime":"1ms","resultCount":1},"profile":{"mode":"%s","phaseTimes":{"run":"1ms"}}}`, opts["profile"])
+	}))
+	defer server.Close()
+
+	q := NewN1qlQuery("SELECT 1").Profile(ProfileTimings)
+	c := &Cluster{}
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, q.options, nil, 5*time.Second, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to close results: %v", err)
+	}
+
+	metrics := results.Metrics()
+	if metrics.ExecutionTime != time.Millisecond || metrics.ResultCount != 1 {
+		t.Fatalf("Unexpected metrics %+v", metrics)
+	}
+	var profile struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.Unmarshal(metrics.Profile, &profile); err != nil || profile.Mode != "timings" {
+		t.Fatalf("Unexpected profile %s (%v)", metrics.Profile, err)
+	}
+}
+
+func TestN1qlReadOnlyRequest(t *testing.T) {
+	q := NewN1qlQuery("SELECT * FROM default WHERE name = $name").ReadOnly(true).MaxParallelism(4)
+	opts := map[string]interface{}{"$name": "frank", "args": []interface{}{1}}
+	for k, v := range q.options {
+		opts[k] = v
+	}
+
+	req, err := newN1qlRequest("http://a:8093/query/service", opts)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if req.Method != "GET" || req.Body != nil {
+		t.Fatalf("Expected a readonly query to be sent with GET, got %s", req.Method)
+	}
+	values := req.URL.Query()
+	if values.Get("statement") != "SELECT * FROM default WHERE name = $name" || values.Get("$name") != `"frank"` ||
+		values.Get("args") != "[1]" || values.Get("readonly") != "true" || values.Get("max_parallelism") != "4" {
+		t.Fatalf("Unexpected query string %v", values)
+	}
+
+	opts["statement"] = "PREPARE " + opts["statement"].(string)
+	if req, _ := newN1qlRequest("http://a:8093/query/service", opts); req.Method != "POST" {
+		t.Fatalf("Expected statements to be prepared with POST, got %s", req.Method)
+	}
+
+	opts = map[string]interface{}{"statement": "SELECT 1", "readonly": true, "$value": strings.Repeat("x", n1qlMaxGetQueryLength)}
+	if req, _ := newN1qlRequest("http://a:8093/query/service", opts); req.Method != "POST" {
+		t.Fatalf("Expected long queries to be sent with POST, got %s", req.Method)
+	}
+
+	opts = map[string]interface{}{"statement": "SELECT 1", "readonly": true, "creds": []userPassPair{{"a", "secret"}, {"b", "secret"}}}
+	req, _ = newN1qlRequest("http://a:8093/query/service", opts)
+	if req.Method != "POST" || strings.Contains(req.URL.String(), "secret") {
+		t.Fatalf("Expected queries with credentials to be sent with POST, got %s %s", req.Method, req.URL)
+	}
+}
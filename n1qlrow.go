@@ -0,0 +1,172 @@
+package gocb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// QueryRowDecoder returns a function which decodes N1QL result rows into target,
+// which must be a pointer.  Rows produced by `SELECT * FROM bucket`, which wrap each
+// document in an object keyed by the bucket name, are unwrapped automatically.
+//
+// Struct fields may specify a `gocb:"name"` tag naming the field of the row they are
+// decoded from, which takes precedence over their json tag.  This allows a struct
+// used for KV documents to also decode query rows whose projections use different
+// aliases.
+func QueryRowDecoder(bucketName string, target interface{}) func(row []byte) error {
+	return newQueryRowDecoder(bucketName, target, DefaultTranscoder{})
+}
+
+// QueryRowDecoder returns a function which decodes N1QL result rows into target using
+// the transcoder of this bucket.  See the package-level QueryRowDecoder.
+func (b *Bucket) QueryRowDecoder(target interface{}) func(row []byte) error {
+	return newQueryRowDecoder(b.name, target, b.transcoder)
+}
+
+func newQueryRowDecoder(bucketName string, target interface{}, transcoder Transcoder) func(row []byte) error {
+	targetType := reflect.TypeOf(target)
+	remap := hasGocbTags(targetType, make(map[reflect.Type]bool))
+
+	return func(row []byte) error {
+		row = unwrapBucketRow(bucketName, row)
+		if remap {
+			remapped, err := remapQueryRow(row, targetType)
+			if err != nil {
+				return err
+			}
+			row = remapped
+		}
+		return transcoder.Decode(row, cfFmtJson, target)
+	}
+}
+
+// unwrapBucketRow returns the document within a row of the form {"bucket": {...}}.
+func unwrapBucketRow(bucketName string, row []byte) []byte {
+	var fields map[string]json.RawMessage
+	if bucketName == "" || json.Unmarshal(row, &fields) != nil || len(fields) != 1 {
+		return row
+	}
+
+	doc, ok := fields[bucketName]
+	if !ok || !strings.HasPrefix(strings.TrimSpace(string(doc)), "{") {
+		return row
+	}
+	return doc
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func hasGocbTags(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t == nil || seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasGocbTags(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("gocb") != "" || hasGocbTags(field.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remapQueryRow renames the fields of a JSON value named by `gocb` struct tags to the
+// names the json package expects for t, recursing into nested structs.  Every field is
+// read from the value as received, so that a field renamed to the name of another does
+// not take the place of the other's value.
+func remapQueryRow(data []byte, t reflect.Type) ([]byte, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(data, &fields) != nil {
+			// Leave values which are not objects for the decoder to report.
+			return data, nil
+		}
+
+		type fieldMapping struct {
+			srcName  string
+			jsonName string
+			t        reflect.Type
+		}
+		var mappings []fieldMapping
+		renamed := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			jsonName := jsonFieldName(field)
+			if field.PkgPath != "" || jsonName == "-" {
+				continue
+			}
+
+			srcName := jsonName
+			if tagName := field.Tag.Get("gocb"); tagName != "" {
+				srcName = tagName
+				renamed[srcName] = true
+			}
+			mappings = append(mappings, fieldMapping{srcName, jsonName, field.Type})
+		}
+
+		remappedFields := make(map[string]json.RawMessage, len(fields))
+		for name, value := range fields {
+			if !renamed[name] {
+				remappedFields[name] = value
+			}
+		}
+		for _, mapping := range mappings {
+			value, ok := fields[mapping.srcName]
+			if !ok {
+				continue
+			}
+			remapped, err := remapQueryRow(value, mapping.t)
+			if err != nil {
+				return nil, err
+			}
+			remappedFields[mapping.jsonName] = remapped
+		}
+		return json.Marshal(remappedFields)
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return data, nil
+		}
+		for i, item := range items {
+			remapped, err := remapQueryRow(item, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			items[i] = remapped
+		}
+		return json.Marshal(items)
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return data, nil
+		}
+		for key, item := range items {
+			remapped, err := remapQueryRow(item, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			items[key] = remapped
+		}
+		return json.Marshal(items)
+	default:
+		return data, nil
+	}
+}
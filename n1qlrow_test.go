@@ -0,0 +1,116 @@
+package gocb
+
+import (
+	"testing"
+)
+
+type testRowAddress struct {
+	City    string `json:"city" gocb:"town"`
+	Country string `json:"country"`
+}
+
+type testRowUser struct {
+	Name      string           `json:"name" gocb:"full_name"`
+	Age       int              `json:"age"`
+	Address   testRowAddress   `json:"address"`
+	Previous  []testRowAddress `json:"previous"`
+	Nickname  *string          `json:"nickname,omitempty"`
+	Untouched string
+}
+
+func TestQueryRowDecoderUnwrapsSelectStar(t *testing.T) {
+	var user testRowUser
+	decode := QueryRowDecoder("default", &user)
+
+	err := decode([]byte(`{"default":{"full_name":"Frank","age":32,"address":{"town":"Leeds","country":"UK"}}}`))
+	if err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if user.Name != "Frank" || user.Age != 32 {
+		t.Fatalf("Unexpected user decoded: %+v", user)
+	}
+	if user.Address.City != "Leeds" || user.Address.Country != "UK" {
+		t.Fatalf("Unexpected nested struct decoded: %+v", user.Address)
+	}
+}
+
+func TestQueryRowDecoderAliasedProjection(t *testing.T) {
+	var user testRowUser
+	decode := QueryRowDecoder("default", &user)
+
+	err := decode([]byte(`{"full_name":"Alice","Untouched":"x","previous":[{"town":"York"},{"town":"Hull"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if user.Name != "Alice" || user.Untouched != "x" {
+		t.Fatalf("Unexpected user decoded: %+v", user)
+	}
+	if len(user.Previous) != 2 || user.Previous[0].City != "York" || user.Previous[1].City != "Hull" {
+		t.Fatalf("Unexpected nested slice decoded: %+v", user.Previous)
+	}
+}
+
+func TestQueryRowDecoderRenamedFieldCollision(t *testing.T) {
+	// Each field is renamed to the name of the other, in either order.
+	var doc struct {
+		Display string `json:"name" gocb:"display"`
+		Name    string `json:"full_name" gocb:"name"`
+	}
+	decode := QueryRowDecoder("default", &doc)
+	if err := decode([]byte(`{"name":"Frank Smith","display":"Frank"}`)); err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if doc.Name != "Frank Smith" || doc.Display != "Frank" {
+		t.Fatalf("Unexpected document decoded: %+v", doc)
+	}
+
+	// A field without a gocb tag keeps its value when another is renamed from its name.
+	var user struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name" gocb:"name"`
+	}
+	decode = QueryRowDecoder("default", &user)
+	if err := decode([]byte(`{"name":"Alice"}`)); err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if user.Name != "Alice" || user.FullName != "Alice" {
+		t.Fatalf("Unexpected user decoded: %+v", user)
+	}
+}
+
+func TestQueryRowDecoderFallsBackToJsonTags(t *testing.T) {
+	var doc struct {
+		Name string `json:"name"`
+	}
+	decode := QueryRowDecoder("default", &doc)
+
+	if err := decode([]byte(`{"default":{"name":"Bob"}}`)); err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if doc.Name != "Bob" {
+		t.Fatalf("Unexpected name decoded: %s", doc.Name)
+	}
+
+	// A single projected field matching the bucket name must not be unwrapped
+	// unless it holds an object.
+	var value map[string]interface{}
+	decode = QueryRowDecoder("default", &value)
+	if err := decode([]byte(`{"default":12}`)); err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if value["default"] != float64(12) {
+		t.Fatalf("Unexpected value decoded: %v", value)
+	}
+}
+
+func TestQueryRowDecoderUsesTranscoder(t *testing.T) {
+	var user testRowUser
+	decode := newQueryRowDecoder("default", &user, CanonicalJsonTranscoder{})
+
+	if err := decode([]byte(`{"default":{"full_name":"Eve"}}`)); err != nil {
+		t.Fatalf("Failed to decode row: %v", err)
+	}
+	if user.Name != "Eve" {
+		t.Fatalf("Unexpected user decoded: %+v", user)
+	}
+}
@@ -0,0 +1,193 @@
+package gocb
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// n1qlStatementScan describes the lexical structure of a N1QL statement.
+type n1qlStatementScan struct {
+	literals     int
+	placeholders int
+	// identifierSlots are the offsets of the ?? identifier placeholders.
+	identifierSlots []int
+	// named are the names of the $ placeholders, including numbered ones such as $1.
+	named []string
+	// positional is the number of ? placeholders.
+	positional int
+	// problem describes why the statement looks unsafe, or is empty.
+	problem string
+}
+
+func isN1qlIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// skipN1qlQuoted returns the offset after the quoted string or identifier beginning at
+// start, or -1 if it is unterminated.  The quote may be escaped either by doubling it
+// or, within string literals, with a backslash.
+func skipN1qlQuoted(stmt string, start int) int {
+	quote := stmt[start]
+	for i := start + 1; i < len(stmt); i++ {
+		switch stmt[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(stmt) && stmt[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func scanN1qlStatement(stmt string) n1qlStatementScan {
+	var scan n1qlStatementScan
+	for i := 0; i < len(stmt); i++ {
+		switch c := stmt[i]; c {
+		case '\'', '"', '`':
+			end := skipN1qlQuoted(stmt, i)
+			if end < 0 {
+				scan.problem = fmt.Sprintf("the quote at offset %d is never closed", i)
+				return scan
+			}
+			if c != '`' {
+				scan.literals++
+			}
+			i = end - 1
+		case '-':
+			if i+1 < len(stmt) && stmt[i+1] == '-' {
+				scan.problem = fmt.Sprintf("it contains a comment at offset %d", i)
+				return scan
+			}
+		case '/':
+			if i+1 >= len(stmt) || stmt[i+1] != '*' {
+				continue
+			}
+			// Optimizer hints are written as comments beginning /*+ and are allowed.
+			end := strings.Index(stmt[i+2:], "*/")
+			if i+2 >= len(stmt) || stmt[i+2] != '+' || end < 0 {
+				scan.problem = fmt.Sprintf("it contains a comment at offset %d", i)
+				return scan
+			}
+			i += end + 3
+		case ';':
+			if strings.TrimLeft(stmt[i:], "; \t\r\n") != "" {
+				scan.problem = fmt.Sprintf("it contains a further statement after the ';' at offset %d", i)
+				return scan
+			}
+			return scan
+		case '$':
+			if i+1 < len(stmt) && isN1qlIdentChar(stmt[i+1]) {
+				scan.placeholders++
+				nameStart := i + 1
+				for i+1 < len(stmt) && isN1qlIdentChar(stmt[i+1]) {
+					i++
+				}
+				scan.named = append(scan.named, stmt[nameStart:i+1])
+			}
+		case '?':
+			if i+1 < len(stmt) && stmt[i+1] == '?' {
+				scan.identifierSlots = append(scan.identifierSlots, i)
+				i++
+				continue
+			}
+			scan.placeholders++
+			scan.positional++
+		}
+	}
+	return scan
+}
+
+// checkN1qlStatement returns ErrPotentiallyUnsafeStatement if a statement looks as
+// though values have been concatenated into it rather than passed as parameters.
+func checkN1qlStatement(stmt string, params interface{}) error {
+	scan := scanN1qlStatement(stmt)
+	if scan.problem == "" && scan.placeholders == 0 && scan.literals > 0 && hasN1qlParams(params) {
+		scan.problem = "it contains string literals but none of the parameters provided for it"
+	}
+	if scan.problem == "" {
+		return nil
+	}
+	return detailedError{ErrPotentiallyUnsafeStatement,
+		fmt.Sprintf("The statement was rejected as potentially unsafe because %s.", scan.problem)}
+}
+
+func hasN1qlParams(params interface{}) bool {
+	switch args := params.(type) {
+	case []interface{}:
+		return len(args) > 0
+	case map[string]interface{}:
+		return len(args) > 0
+	}
+	return false
+}
+
+// escapeN1qlIdentifier validates an identifier and quotes it so that it is interpreted
+// as a single identifier whatever characters it contains.
+func escapeN1qlIdentifier(name string) (string, error) {
+	if name == "" {
+		return "", clientError{"An identifier must not be empty."}
+	}
+	if !utf8.ValidString(name) {
+		return "", clientError{"An identifier must be valid UTF-8."}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", clientError{"An identifier must not contain control characters."}
+		}
+	}
+	return "`" + strings.Replace(name, "`", "``", -1) + "`", nil
+}
+
+// WithSafeIdentifier substitutes an identifier, such as the name of a bucket or field,
+// for the next ?? placeholder in the statement.  Identifiers cannot be passed as query
+// parameters, so they are instead validated and escaped so that they cannot alter the
+// meaning of the statement.  If the identifier is invalid, or no placeholder remains,
+// executing the query fails with the error.
+//
+// Experimental: This API is subject to change at any time.
+func (nq *N1qlQuery) WithSafeIdentifier(name string) *N1qlQuery {
+	if nq.err != nil {
+		return nq
+	}
+
+	statement, _ := nq.options["statement"].(string)
+	slots := scanN1qlStatement(statement).identifierSlots
+	if len(slots) == 0 {
+		nq.err = clientError{"The statement has no ?? placeholder left for the identifier."}
+		return nq
+	}
+
+	escaped, err := escapeN1qlIdentifier(name)
+	if err != nil {
+		nq.err = err
+		return nq
+	}
+	nq.options["statement"] = statement[:slots[0]] + escaped + statement[slots[0]+2:]
+	return nq
+}
+
+// StrictStatements returns whether N1QL statements which appear to contain
+// interpolated user data are rejected.
+func (c *Cluster) StrictStatements() bool {
+	return c.strictStatements
+}
+
+// SetStrictStatements sets whether N1QL statements which appear to contain interpolated
+// user data are rejected with ErrPotentiallyUnsafeStatement rather than executed.  A
+// statement is rejected if it contains a further statement after a semicolon, a comment
+// other than an optimizer hint, an unclosed quote, or string literals while parameters
+// are provided but none are referenced.  User data should be passed as parameters, or
+// with WithSafeIdentifier for identifiers.  This is disabled by default.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetStrictStatements(enabled bool) {
+	c.strictStatements = enabled
+}
@@ -0,0 +1,110 @@
+package gocb
+
+import (
+	"testing"
+)
+
+func TestStrictStatementsAllowsLegitimateStatements(t *testing.T) {
+	args := []interface{}{"value"}
+	named := map[string]interface{}{"name": "value"}
+	statements := []struct {
+		statement string
+		params    interface{}
+	}{
+		{"SELECT * FROM `travel-sample` WHERE type = $1", args},
+		{"SELECT * FROM `travel-sample` WHERE type = ?", args},
+		{"SELECT * FROM default WHERE name = $name AND type = 'user'", named},
+		{"SELECT * FROM default WHERE type = \"user\"", nil},
+		{"SELECT * FROM default WHERE type = \"user\"", []interface{}{}},
+		{"SELECT name FROM default WHERE name = 'it''s' AND id = $1", args},
+		{"SELECT name FROM default WHERE name = 'it\\'s' AND id = $1", args},
+		{"SELECT \"a;b\" AS x FROM default WHERE id = $1;", args},
+		{"SELECT * FROM default WHERE id = $1;  \n", args},
+		{"SELECT * FROM default WHERE email LIKE \"%--%\" AND id = $1", args},
+		{"SELECT * FROM default WHERE note = '/* not a comment */' AND id = $1", args},
+		{"SELECT `a;b`.`c--d` FROM default WHERE id = $1", args},
+		{"SELECT `a``b` FROM default", nil},
+		{"SELECT /*+ INDEX(default idx_type) */ * FROM default WHERE type = $1", args},
+		{"SELECT a - -1, b / 2, c * 3 FROM default WHERE id = $1", args},
+		{"SELECT {\"key\": $1, \"other\": 'x'} AS obj FROM default", args},
+		{"SELECT * FROM default WHERE ANY v IN tags SATISFIES v = $1 END", args},
+		{"SELECT RAW META().id FROM default WHERE type = $type_1", map[string]interface{}{"type_1": "a"}},
+		{"UPDATE default SET status = 'done' WHERE id = $1 RETURNING *", args},
+		{"SELECT 1", args},
+	}
+
+	for _, test := range statements {
+		if err := checkN1qlStatement(test.statement, test.params); err != nil {
+			t.Errorf("Expected %q to be allowed, got %v", test.statement, err)
+		}
+	}
+}
+
+func TestStrictStatementsRejectsInjection(t *testing.T) {
+	args := []interface{}{"value"}
+	statements := []struct {
+		statement string
+		params    interface{}
+	}{
+		{"SELECT * FROM default WHERE name = 'x'; DELETE FROM default", nil},
+		{"SELECT * FROM default WHERE name = 'x' ;DELETE FROM default WHERE id = $1", args},
+		{"SELECT * FROM default WHERE name = 'x' -- AND secret = $1", args},
+		{"SELECT * FROM default WHERE name = 'x' /* AND secret = $1 */", args},
+		{"SELECT * FROM default /*+ unterminated hint", nil},
+		{"SELECT * FROM default WHERE name = 'O'Brien'", nil},
+		{"SELECT * FROM default WHERE name = \"bob", nil},
+		{"SELECT * FROM `default WHERE 1", nil},
+		{"SELECT * FROM default WHERE name = 'bob'", args},
+		{"SELECT * FROM default WHERE name = \"bob\"", map[string]interface{}{"name": "bob"}},
+	}
+
+	for _, test := range statements {
+		err := checkN1qlStatement(test.statement, test.params)
+		if ErrorCause(err) != ErrPotentiallyUnsafeStatement {
+			t.Errorf("Expected %q to be rejected, got %v", test.statement, err)
+		}
+	}
+}
+
+func TestStrictStatementsExecute(t *testing.T) {
+	c := &Cluster{}
+	q := NewN1qlQuery("SELECT * FROM default WHERE name = 'x'; DELETE FROM default")
+
+	c.SetStrictStatements(true)
+	_, err := c.ExecuteN1qlQuery(q, nil)
+	if ErrorCause(err) != ErrPotentiallyUnsafeStatement {
+		t.Fatalf("Expected the statement to be rejected, got %v", err)
+	}
+}
+
+func TestWithSafeIdentifier(t *testing.T) {
+	q := NewN1qlQuery("SELECT ??.name FROM ?? WHERE note = '??' AND id = $1").
+		WithSafeIdentifier("beer-sample").
+		WithSafeIdentifier("weird`; DROP INDEX x --")
+	if q.err != nil {
+		t.Fatalf("Expected the identifiers to be accepted, got %v", q.err)
+	}
+	expected := "SELECT `beer-sample`.name FROM `weird``; DROP INDEX x --` WHERE note = '??' AND id = $1"
+	if q.options["statement"] != expected {
+		t.Fatalf("Expected %s, got %s", expected, q.options["statement"])
+	}
+	if err := checkN1qlStatement(expected, []interface{}{1}); err != nil {
+		t.Fatalf("Expected the escaped statement to pass strict checks, got %v", err)
+	}
+
+	if q.WithSafeIdentifier("extra").err == nil {
+		t.Fatalf("Expected an error with no placeholder left")
+	}
+	if NewN1qlQuery("SELECT * FROM ??").WithSafeIdentifier("").err == nil {
+		t.Fatalf("Expected an error for an empty identifier")
+	}
+	if NewN1qlQuery("SELECT * FROM ??").WithSafeIdentifier("a\nb").err == nil {
+		t.Fatalf("Expected an error for an identifier with control characters")
+	}
+
+	c := &Cluster{}
+	_, err := c.ExecuteN1qlQuery(NewN1qlQuery("SELECT 1").WithSafeIdentifier("x"), nil)
+	if err == nil {
+		t.Fatalf("Expected the identifier error to be returned on execution")
+	}
+}
@@ -0,0 +1,246 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutPhase identifies which part of a request was responsible for it timing out.
+type TimeoutPhase int
+
+const (
+	// TimeoutPhaseUnknown indicates the part of the request which timed out is not known.
+	TimeoutPhaseUnknown = TimeoutPhase(0)
+
+	// TimeoutPhaseIndexer indicates an index scan performed for the query timed out.
+	TimeoutPhaseIndexer = TimeoutPhase(1)
+
+	// TimeoutPhaseQueryService indicates the query service exceeded the query's timeout.
+	TimeoutPhaseQueryService = TimeoutPhase(2)
+
+	// TimeoutPhaseClient indicates the client gave up waiting for a response from the
+	// query service, which had accepted the request.
+	TimeoutPhaseClient = TimeoutPhase(3)
+
+	// TimeoutPhaseNetwork indicates the request could not be delivered to the query
+	// service in time.
+	TimeoutPhaseNetwork = TimeoutPhase(4)
+)
+
+// String returns the name of the phase.
+func (p TimeoutPhase) String() string {
+	switch p {
+	case TimeoutPhaseIndexer:
+		return "indexer"
+	case TimeoutPhaseQueryService:
+		return "query service"
+	case TimeoutPhaseClient:
+		return "client"
+	case TimeoutPhaseNetwork:
+		return "network"
+	}
+	return "unknown"
+}
+
+// N1qlTimeoutError occurs when a N1QL query times out, and describes which part of
+// the request was responsible.
+type N1qlTimeoutError struct {
+	// Phase is the part of the request which timed out.
+	Phase TimeoutPhase
+	// Timeout is the timeout which applied to the query.
+	Timeout time.Duration
+	// Elapsed is the time the client spent waiting for the query.
+	Elapsed time.Duration
+	// Code and Message describe the error returned by the query service, if the
+	// service reported the timeout.
+	Code    uint32
+	Message string
+
+	err error
+}
+
+func (e *N1qlTimeoutError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("N1QL query timed out in the %s after %s: [%d] %s", e.Phase, e.Elapsed, e.Code, e.Message)
+	}
+	return fmt.Sprintf("N1QL query timed out in the %s after %s: %s", e.Phase, e.Elapsed, e.err)
+}
+
+// Unwrap returns ErrTimeout.
+func (e *N1qlTimeoutError) Unwrap() error {
+	return ErrTimeout
+}
+
+// The error codes the query service uses to report timeouts.
+const (
+	n1qlCodeTimeout           = 1080
+	n1qlCodeIndexScanError    = 12008
+	n1qlCodeIndexScanTimedOut = 12015
+)
+
+// n1qlErrorCauses returns the codes and messages of an error, along with those of
+// every nested cause reported with it.
+func n1qlErrorCauses(e n1qlError) ([]uint32, []string) {
+	codes := []uint32{e.Code}
+	messages := []string{e.Message}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			for key, field := range typedValue {
+				switch key {
+				case "code":
+					if code, ok := field.(float64); ok {
+						codes = append(codes, uint32(code))
+					}
+				case "msg", "message":
+					if message, ok := field.(string); ok {
+						messages = append(messages, message)
+					}
+				default:
+					walk(field)
+				}
+			}
+		case []interface{}:
+			for _, item := range typedValue {
+				walk(item)
+			}
+		case string:
+			messages = append(messages, typedValue)
+		}
+	}
+
+	for _, nested := range []json.RawMessage{e.Cause, e.Reason} {
+		var value interface{}
+		if len(nested) > 0 && json.Unmarshal(nested, &value) == nil {
+			walk(value)
+		}
+	}
+	return codes, messages
+}
+
+func isIndexScanTimeoutMessage(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "index scan timed out") ||
+		strings.Contains(message, "index scan timeout")
+}
+
+func isTimeoutMessage(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "timed out") || strings.Contains(message, "timeout")
+}
+
+// classifyN1qlTimeout returns a N1qlTimeoutError if the errors returned by the query
+// service indicate the query timed out, or nil otherwise.  Indexer timeouts are
+// reported either with their own code or as the cause of a generic timeout.  The
+// generic index scan error code is used for every indexer failure, so it is only
+// treated as a timeout when reported with a message describing one.
+func classifyN1qlTimeout(errs []n1qlError, status string) *N1qlTimeoutError {
+	var timeoutErr *N1qlTimeoutError
+	for _, e := range errs {
+		codes, messages := n1qlErrorCauses(e)
+
+		indexer, scanError, timeoutMessage := false, false, false
+		for _, code := range codes {
+			switch code {
+			case n1qlCodeIndexScanTimedOut:
+				indexer = true
+			case n1qlCodeIndexScanError:
+				scanError = true
+			}
+		}
+		for _, message := range messages {
+			if isIndexScanTimeoutMessage(message) {
+				indexer = true
+			}
+			if isTimeoutMessage(message) {
+				timeoutMessage = true
+			}
+		}
+		if scanError && timeoutMessage {
+			indexer = true
+		}
+
+		if indexer {
+			return &N1qlTimeoutError{
+				Phase:   TimeoutPhaseIndexer,
+				Code:    e.Code,
+				Message: e.Message,
+			}
+		}
+		if e.Code == n1qlCodeTimeout && timeoutErr == nil {
+			timeoutErr = &N1qlTimeoutError{
+				Phase:   TimeoutPhaseQueryService,
+				Code:    e.Code,
+				Message: e.Message,
+			}
+		}
+	}
+
+	if timeoutErr == nil && status == "timeout" && len(errs) > 0 {
+		timeoutErr = &N1qlTimeoutError{
+			Phase:   TimeoutPhaseQueryService,
+			Code:    errs[0].Code,
+			Message: errs[0].Message,
+		}
+	}
+	return timeoutErr
+}
+
+// n1qlRequestTrace records when a query request reached each stage of being sent, so
+// that a client-side timeout can be attributed to the network or the query service.
+type n1qlRequestTrace struct {
+	lock         sync.Mutex
+	start        time.Time
+	wroteRequest time.Time
+}
+
+func newN1qlRequestTrace() *n1qlRequestTrace {
+	return &n1qlRequestTrace{
+		start: time.Now(),
+	}
+}
+
+func (t *n1qlRequestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.lock.Lock()
+			t.wroteRequest = time.Now()
+			t.lock.Unlock()
+		},
+	}
+}
+
+// classifyClientTimeout returns a N1qlTimeoutError if a failed request timed out, or
+// nil otherwise.  A timeout before the request had been written to a connection is
+// attributed to the network, and one afterwards to the client waiting for the
+// query service.
+func (t *n1qlRequestTrace) classifyClientTimeout(err error, timeout time.Duration) *N1qlTimeoutError {
+	elapsed := time.Since(t.start)
+	netErr, isNetErr := err.(net.Error)
+	deadlineExceeded := timeout > 0 && elapsed >= timeout
+	if !deadlineExceeded && !(isNetErr && netErr.Timeout()) {
+		return nil
+	}
+
+	t.lock.Lock()
+	written := !t.wroteRequest.IsZero()
+	t.lock.Unlock()
+
+	phase := TimeoutPhaseNetwork
+	if deadlineExceeded && written {
+		phase = TimeoutPhaseClient
+	}
+	return &N1qlTimeoutError{
+		Phase:   phase,
+		Timeout: timeout,
+		Elapsed: elapsed,
+		err:     err,
+	}
+}
@@ -0,0 +1,200 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveN1qlFixture(t *testing.T, name string) *httptest.Server {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "n1qltimeout", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}))
+}
+
+func TestN1qlTimeoutClassificationFixtures(t *testing.T) {
+	fixtures := []struct {
+		name  string
+		phase TimeoutPhase
+		code  uint32
+	}{
+		{"query_service_1080.json", TimeoutPhaseQueryService, 1080},
+		{"indexer_12015.json", TimeoutPhaseIndexer, 12015},
+		{"indexer_nested_cause.json", TimeoutPhaseIndexer, 1080},
+		{"indexer_5000_message.json", TimeoutPhaseIndexer, 5000},
+	}
+
+	c := &Cluster{}
+	for _, fixture := range fixtures {
+		server := serveN1qlFixture(t, fixture.name)
+		opts := map[string]interface{}{"statement": "SELECT 1"}
+		_, err := c.executeN1qlQuery(context.Background(), server.URL, opts, nil, 5*time.Second, http.DefaultClient, nil)
+		server.Close()
+
+		timeoutErr, ok := err.(*N1qlTimeoutError)
+		if !ok {
+			t.Fatalf("%s: expected a N1qlTimeoutError, got %v", fixture.name, err)
+		}
+		if timeoutErr.Phase != fixture.phase || timeoutErr.Code != fixture.code {
+			t.Fatalf("%s: expected %s timeout with code %d, got %s with code %d",
+				fixture.name, fixture.phase, fixture.code, timeoutErr.Phase, timeoutErr.Code)
+		}
+		if timeoutErr.Timeout != 5*time.Second {
+			t.Fatalf("%s: expected timeout to be recorded, got %s", fixture.name, timeoutErr.Timeout)
+		}
+		if !IsTimeoutError(err) || ErrorCause(err) != ErrTimeout {
+			t.Fatalf("%s: expected error to be a timeout error", fixture.name)
+		}
+	}
+
+	// Index scan errors only describe a timeout when their message says so.
+	for _, name := range []string{"not_a_timeout.json", "index_scan_error_12008.json"} {
+		server := serveN1qlFixture(t, name)
+		_, err := c.executeN1qlQuery(context.Background(), server.URL, map[string]interface{}{"statement": "SELECT 1"}, nil, 5*time.Second, http.DefaultClient, nil)
+		server.Close()
+		if _, ok := err.(*n1qlMultiError); !ok || IsTimeoutError(err) {
+			t.Fatalf("%s: expected a plain query error, got %v", name, err)
+		}
+	}
+
+	var phase TimeoutPhase
+	if phase != TimeoutPhaseUnknown || phase.String() != "unknown" {
+		t.Fatalf("Expected the zero phase to be unknown, got %s", phase)
+	}
+}
+
+func TestN1qlClientTimeoutClassification(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := &Cluster{}
+	opts := map[string]interface{}{"statement": "SELECT 1"}
+	_, err := c.executeN1qlQuery(context.Background(), server.URL, opts, nil, 50*time.Millisecond, &http.Client{Transport: &http.Transport{}}, nil)
+	timeoutErr, ok := err.(*N1qlTimeoutError)
+	if !ok {
+		t.Fatalf("Expected a N1qlTimeoutError, got %v", err)
+	}
+	if timeoutErr.Phase != TimeoutPhaseClient {
+		t.Fatalf("Expected client timeout once the request was sent, got %s", timeoutErr.Phase)
+	}
+	if !IsTimeoutError(err) {
+		t.Fatal("Expected IsTimeoutError to match client timeouts")
+	}
+
+	// A request which never made it onto a connection is a network timeout.
+	trace := &n1qlRequestTrace{start: time.Now().Add(-time.Second)}
+	timeoutErr = trace.classifyClientTimeout(errors.New("net/http: request canceled while waiting for connection"), 500*time.Millisecond)
+	if timeoutErr == nil || timeoutErr.Phase != TimeoutPhaseNetwork {
+		t.Fatalf("Expected network timeout, got %v", timeoutErr)
+	}
+
+	trace = &n1qlRequestTrace{start: time.Now()}
+	if trace.classifyClientTimeout(errors.New("connection refused"), time.Second) != nil {
+		t.Fatal("Expected errors before the deadline not to be classified as timeouts")
+	}
+}
+
+func TestN1qlContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if strings.Contains(string(body), "system:active_requests") {
+			cancelled <- struct{}{}
+			fmt.Fprint(w, `{"results":[],"status":"success","metrics":{}}`)
+			return
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	c := &Cluster{n1qlTimeout: 5 * time.Second}
+	opts := map[string]interface{}{"statement": "SELECT 1"}
+	_, err := c.executeN1qlQuery(ctx, server.URL, opts, nil, 5*time.Second, &http.Client{Transport: &http.Transport{}}, nil)
+	if err != context.Canceled {
+		t.Fatalf("Expected the context error, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Expected the query to be cancelled on the server")
+	}
+}
+
+func TestN1qlQueryTimeoutOverride(t *testing.T) {
+	sent := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Timeout string `json:"timeout"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		sent <- body.Timeout
+		fmt.Fprint(w, `{"results":[],"status":"success","metrics":{}}`)
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	q := NewN1qlQuery("SELECT 1").Timeout(10 * time.Second)
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, q.options, nil, 75*time.Second, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	results.Close()
+	if timeout := <-sent; timeout != "10s" {
+		t.Fatalf("Expected the query timeout to override the default, got %s", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	q = NewN1qlQuery("SELECT 1").Timeout(10 * time.Second)
+	results, err = c.executeN1qlQuery(ctx, server.URL, q.options, nil, contextTimeout(ctx, 75*time.Second), http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+	results.Close()
+	sentTimeout := <-sent
+	if timeout, err := time.ParseDuration(sentTimeout); err != nil || timeout > time.Second || timeout <= 0 {
+		t.Fatalf("Expected the query timeout to be limited by the context deadline, got %s", sentTimeout)
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	if contextTimeout(context.Background(), time.Second) != time.Second {
+		t.Fatal("Expected the timeout to be used without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if timeout := contextTimeout(ctx, time.Second); timeout > 100*time.Millisecond || timeout <= 0 {
+		t.Fatalf("Expected the deadline to shorten the timeout, got %s", timeout)
+	}
+	if timeout := contextTimeout(ctx, 0); timeout > 100*time.Millisecond || timeout <= 0 {
+		t.Fatalf("Expected the deadline to be used in place of no timeout, got %s", timeout)
+	}
+	if contextTimeout(ctx, time.Millisecond) != time.Millisecond {
+		t.Fatal("Expected a shorter timeout to be kept")
+	}
+}
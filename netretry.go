@@ -0,0 +1,81 @@
+package gocb
+
+import (
+	"time"
+)
+
+// The backoff between dispatches of an idempotent operation whose connection was
+// dropped, allowing time for the connection to be re-established.
+var networkRetryBackoff = ExponentialBackoff(1*time.Millisecond, 100*time.Millisecond, 2)
+
+// NetworkRetry returns whether idempotent operations are dispatched again when their
+// connection is dropped.
+func (b *Bucket) NetworkRetry() bool {
+	return !b.disableNetworkRetry
+}
+
+// SetNetworkRetry specifies whether operations are retried when the connection they were
+// dispatched on is dropped before a response is received.  By default, idempotent
+// operations (Get, GetReplica, GetLength and sub-document lookups) are dispatched again
+// for as long as their timeout allows, while all other operations fail with
+// ErrNetworkAmbiguous as they may or may not have been applied.  Disabling this causes
+// every such operation to fail immediately with ErrNetwork.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetNetworkRetry(enabled bool) {
+	b.disableNetworkRetry = !enabled
+}
+
+// retriedError carries the retries performed by an operation which ultimately failed,
+// until they are attached to the OperationError describing the failure.
+type retriedError struct {
+	err    error
+	report *RetryReport
+}
+
+func (e *retriedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error returned by the operation.
+func (e *retriedError) Unwrap() error {
+	return e.err
+}
+
+func withRetryReport(err error, tracker *retryTracker) error {
+	if err == nil {
+		return nil
+	}
+	report := tracker.retryReport()
+	if report == nil {
+		return err
+	}
+	return &retriedError{err, report}
+}
+
+// unwrapRetriedError splits an error returned by an operation into the error itself and
+// the retries which were performed, if any.
+func unwrapRetriedError(err error) (error, *RetryReport) {
+	if retriedErr, ok := err.(*retriedError); ok {
+		return retriedErr.err, retriedErr.report
+	}
+	return err, nil
+}
+
+// retryIdempotent performs an idempotent operation, dispatching it again whenever it
+// fails because its connection was dropped, within the remaining operation timeout.
+// Temporary failures are also retried according to the retry strategy.
+func (b *Bucket) retryIdempotent(opts *kvOpOptions, key string, fn func(opts *kvOpOptions) error) error {
+	return b.retryKv(opts, key, true, fn)
+}
+
+// ambiguousNetworkError converts the network error of a non-idempotent operation whose
+// connection was dropped into ErrNetworkAmbiguous, as the operation may have been
+// applied before the connection was lost.
+func (b *Bucket) ambiguousNetworkError(err error) error {
+	if err == nil || b.disableNetworkRetry || ErrorCause(err) != ErrNetwork {
+		return err
+	}
+	return detailedError{ErrNetworkAmbiguous,
+		"The connection was lost before a response was received, so the operation may or may not have been applied."}
+}
@@ -0,0 +1,97 @@
+package gocb
+
+import (
+	"gopkg.in/couchbase/gocbcore.v7"
+	"testing"
+	"time"
+)
+
+func TestNetworkRetryRedispatchesIdempotentOps(t *testing.T) {
+	c := &Cluster{}
+	c.SetEnrichedErrors(true)
+	b := &Bucket{cluster: c, name: "default", opTimeout: time.Second}
+
+	attempts := 0
+	err := b.retryIdempotent(nil, "", func(attempt *kvOpOptions) error {
+		attempts++
+		if b.kvTimeout(attempt) > time.Second {
+			t.Fatalf("Expected retries to use the remaining timeout, got %s", b.kvTimeout(attempt))
+		}
+		if attempts < 3 {
+			return ErrNetwork
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success after 3 attempts, got %d attempts (%v)", attempts, err)
+	}
+
+	attempts = 0
+	err = b.retryIdempotent(nil, "", func(attempt *kvOpOptions) error {
+		attempts++
+		if attempts < 2 {
+			return ErrNetwork
+		}
+		return ErrKeyNotFound
+	})
+	wrapped := b.wrapError(err, "Get", "key", time.Now())
+	opErr, ok := wrapped.(*OperationError)
+	if !ok || opErr.Err != ErrKeyNotFound {
+		t.Fatalf("Expected an OperationError wrapping ErrKeyNotFound, got %v", wrapped)
+	}
+	if opErr.RetryReport == nil || opErr.RetryReport.Reasons["kv_network"] != 1 {
+		t.Fatalf("Expected the retry to be reported, got %+v", opErr.RetryReport)
+	}
+	if ErrorCause(err) != ErrKeyNotFound {
+		t.Fatalf("Expected the cause to be visible through the retry report, got %v", ErrorCause(err))
+	}
+
+	// Retries stop once the operation timeout has been consumed.
+	b.opTimeout = 20 * time.Millisecond
+	start := time.Now()
+	err = b.retryIdempotent(nil, "", func(attempt *kvOpOptions) error {
+		return ErrNetwork
+	})
+	if ErrorCause(err) != ErrNetwork || time.Since(start) > 200*time.Millisecond {
+		t.Fatalf("Expected ErrNetwork within the operation timeout, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestNetworkRetryDisabled(t *testing.T) {
+	b := &Bucket{opTimeout: time.Second}
+	b.SetNetworkRetry(false)
+	if b.NetworkRetry() {
+		t.Fatal("Expected network retries to be disabled")
+	}
+
+	attempts := 0
+	err := b.retryIdempotent(nil, "", func(attempt *kvOpOptions) error {
+		attempts++
+		return ErrNetwork
+	})
+	if err != ErrNetwork || attempts != 1 {
+		t.Fatalf("Expected a single attempt failing with ErrNetwork, got %d (%v)", attempts, err)
+	}
+	if b.ambiguousNetworkError(ErrNetwork) != ErrNetwork {
+		t.Fatal("Expected network errors to be returned as-is when retries are disabled")
+	}
+}
+
+func TestNetworkErrorAmbiguousForMutations(t *testing.T) {
+	b := &Bucket{ops: newOpTracker(), opTimeout: time.Second}
+	_, _, err := b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+		cb(0, gocbcore.MutationToken{}, ErrNetwork)
+		return &fakePendingOp{newFakeConn(), 0}, nil
+	})
+	if ErrorCause(err) != ErrNetworkAmbiguous {
+		t.Fatalf("Expected ErrNetworkAmbiguous, got %v", err)
+	}
+
+	_, _, err = b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+		cb(0, gocbcore.MutationToken{}, ErrKeyExists)
+		return &fakePendingOp{newFakeConn(), 0}, nil
+	})
+	if err != ErrKeyExists {
+		t.Fatalf("Expected other errors to be returned as-is, got %v", err)
+	}
+}
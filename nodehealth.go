@@ -0,0 +1,121 @@
+package gocb
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The number of consecutive operations to a node which must fail with network errors
+// or timeouts before it is considered unavailable, and how long it is then considered
+// unavailable for before operations are attempted against it again.
+var (
+	nodeFailureThreshold = 3
+	nodeDownPeriod       = 5 * time.Second
+)
+
+// nodeHealth tracks the data nodes which recent operations suggest are unavailable.
+type nodeHealth struct {
+	lock      sync.Mutex
+	failures  map[int]int
+	downUntil map[int]time.Time
+}
+
+func newNodeHealth() *nodeHealth {
+	return &nodeHealth{
+		failures:  make(map[int]int),
+		downUntil: make(map[int]time.Time),
+	}
+}
+
+// isNodeFailure returns whether an operation failed because its node or endpoint did
+// not respond, rather than because of the response it gave.
+func isNodeFailure(err error) bool {
+	if _, ok := err.(*url.Error); ok {
+		return true
+	}
+	switch ErrorCause(err) {
+	case ErrNetwork, ErrNetworkAmbiguous, ErrTimeout, ErrDispatchFail:
+		return true
+	}
+	return false
+}
+
+// record notes the outcome of an operation dispatched to a node.
+func (h *nodeHealth) record(node int, err error) {
+	if h == nil || node < 0 {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !isNodeFailure(err) {
+		delete(h.failures, node)
+		delete(h.downUntil, node)
+		return
+	}
+
+	h.failures[node]++
+	if h.failures[node] >= nodeFailureThreshold {
+		h.downUntil[node] = time.Now().Add(nodeDownPeriod)
+	}
+}
+
+// isDown returns whether a node is known to be unavailable.  Nodes which no vbucket
+// maps to, such as when the server for a vbucket has been removed, are unavailable.
+func (h *nodeHealth) isDown(node int) bool {
+	if node < 0 {
+		return true
+	}
+	if h == nil {
+		return false
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	until, ok := h.downUntil[node]
+	return ok && time.Now().Before(until)
+}
+
+// BulkReport summarises the outcome of the operations of a bulk request, separating
+// operations which were never attempted because their node was unavailable from
+// those which failed.
+//
+// Experimental: This API is subject to change at any time.
+type BulkReport struct {
+	// Succeeded is the number of operations which succeeded.
+	Succeeded int
+	// Missing is the number of operations which failed with ErrKeyNotFound.
+	Missing int
+	// NotAttempted is the number of operations which failed with ErrNodeUnavailable,
+	// without being sent to the server.
+	NotAttempted int
+	// Failed is the number of operations which failed for any other reason.
+	Failed int
+}
+
+// NewBulkReport summarises the outcome of the operations of a completed bulk request.
+//
+// Experimental: This API is subject to change at any time.
+func NewBulkReport(ops []BulkOp) BulkReport {
+	var report BulkReport
+	for _, op := range ops {
+		switch err := op.bulkErr(); {
+		case err == nil:
+			report.Succeeded++
+		case ErrorCause(err) == ErrKeyNotFound:
+			report.Missing++
+		case ErrorCause(err) == ErrNodeUnavailable:
+			report.NotAttempted++
+		default:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// Degraded returns whether any operations were not attempted because their node was
+// unavailable.
+func (r BulkReport) Degraded() bool {
+	return r.NotAttempted > 0
+}
@@ -0,0 +1,123 @@
+package gocb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long the service endpoints discovered from the management service are used for
+// before being discovered again.
+var nodeServicesRefreshInterval = 10 * time.Second
+
+type nodeServicesResponse struct {
+	NodesExt []struct {
+		Hostname string         `json:"hostname"`
+		Services map[string]int `json:"services"`
+	} `json:"nodesExt"`
+}
+
+// nodeServiceEps returns the endpoints of a service listed in a nodeServices response
+// from the management endpoint mgmtEp.  Nodes without a hostname are the node which
+// served the response.
+func nodeServiceEps(data []byte, mgmtEp, service string, secure bool) ([]string, error) {
+	var services nodeServicesResponse
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+
+	mgmtUrl, err := url.Parse(mgmtEp)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, key := "http", service
+	if secure {
+		scheme, key = "https", service+"SSL"
+	}
+
+	var eps []string
+	for _, node := range services.NodesExt {
+		port, ok := node.Services[key]
+		if !ok {
+			continue
+		}
+		hostname := node.Hostname
+		if hostname == "" {
+			hostname = mgmtUrl.Hostname()
+		}
+		eps = append(eps, scheme+"://"+net.JoinHostPort(hostname, strconv.Itoa(port)))
+	}
+	return eps, nil
+}
+
+// clusterServices caches the service endpoints discovered from the management service.
+type clusterServices struct {
+	lock    sync.Mutex
+	eps     map[string][]string
+	fetched time.Time
+}
+
+// clusterServiceEps returns the endpoints of a service, discovered from the management
+// service using the cluster credentials, for queries performed before any bucket has
+// been opened.
+func (c *Cluster) clusterServiceEps(service string) ([]string, error) {
+	c.services.lock.Lock()
+	defer c.services.lock.Unlock()
+
+	if c.services.eps != nil && time.Since(c.services.fetched) < nodeServicesRefreshInterval {
+		return c.services.eps[service], nil
+	}
+
+	cm := c.Manager("", "")
+	if len(cm.hosts) == 0 {
+		return nil, ErrNoOpenBuckets
+	}
+	mgmtEp := cm.getMgmtEp()
+	resp, err := cm.httpRequest(mgmtEp, "GET", "/pools/default/nodeServices", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		logDebugf("Failed to close socket (%s)", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, clientError{string(data)}
+	}
+
+	eps := make(map[string][]string)
+	for _, name := range []string{"n1ql", "fts", "cbas"} {
+		eps[name], err = nodeServiceEps(data, mgmtEp, name, c.agentConfig.TlsConfig != nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.services.eps = eps
+	c.services.fetched = time.Now()
+	return eps[service], nil
+}
+
+// getClusterN1qlEp chooses the N1QL endpoint of a query performed without an open bucket.
+func (c *Cluster) getClusterN1qlEp() (string, error) {
+	eps, err := c.clusterServiceEps("n1ql")
+	if err != nil {
+		return "", err
+	}
+	n1qlEps := c.availableEps(eps)
+	if len(n1qlEps) == 0 {
+		return "", &clientError{"No available N1QL nodes."}
+	}
+	n1qlEps, err = c.closedEps(n1qlEps)
+	if err != nil {
+		return "", err
+	}
+	return c.selectEndpoint("n1ql", c.reachableN1qlEps(n1qlEps)), nil
+}
@@ -0,0 +1,56 @@
+package gocb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testNodeServices = `{"rev":32,"nodesExt":[
+	{"services":{"mgmt":8091,"kv":11210,"n1ql":8093,"n1qlSSL":18093}},
+	{"services":{"mgmt":8091,"kv":11210},"hostname":"10.0.0.2"},
+	{"services":{"mgmt":8091,"n1ql":8093,"fts":8094},"hostname":"fe80::1"}]}`
+
+func TestNodeServiceEps(t *testing.T) {
+	eps, err := nodeServiceEps([]byte(testNodeServices), "http://10.0.0.1:8091", "n1ql", false)
+	if err != nil {
+		t.Fatalf("Failed to parse node services: %v", err)
+	}
+	if len(eps) != 2 || eps[0] != "http://10.0.0.1:8093" || eps[1] != "http://[fe80::1]:8093" {
+		t.Fatalf("Unexpected endpoints %v", eps)
+	}
+
+	eps, _ = nodeServiceEps([]byte(testNodeServices), "https://10.0.0.1:18091", "n1ql", true)
+	if len(eps) != 1 || eps[0] != "https://10.0.0.1:18093" {
+		t.Fatalf("Unexpected secure endpoints %v", eps)
+	}
+}
+
+func TestClusterN1qlEpWithoutBucket(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if user, pass, _ := req.BasicAuth(); user != "admin" || pass != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(testNodeServices))
+	}))
+	defer server.Close()
+
+	c := &Cluster{httpCli: http.DefaultClient}
+	c.agentConfig.HttpAddrs = []string{strings.TrimPrefix(server.URL, "http://")}
+	c.auth = ClusterAuthenticator{Username: "admin", Password: "password"}
+
+	ep, err := c.getClusterN1qlEp()
+	if err != nil {
+		t.Fatalf("Failed to discover query nodes: %v", err)
+	}
+	if !strings.HasSuffix(ep, ":8093") {
+		t.Fatalf("Unexpected endpoint %s", ep)
+	}
+	if _, err := c.getClusterN1qlEp(); err != nil || requests != 1 {
+		t.Fatalf("Expected the discovered endpoints to be reused, got %d requests (%v)", requests, err)
+	}
+}
@@ -0,0 +1,203 @@
+package gocb
+
+import (
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// orphanedOpGracePeriod is how long we wait for the callback of an operation which
+// timed out but could no longer be cancelled before giving up on it.
+var orphanedOpGracePeriod = 1 * time.Second
+
+// opCompletionAssertions causes operations which are dropped without their callback
+// ever being invoked, or whose callback is invoked more than once, to panic.  This is
+// only intended to be enabled by tests.
+var opCompletionAssertions = false
+
+const (
+	opStatePending = int32(iota)
+	opStateCompleted
+)
+
+// opTracker tracks every operation dispatched on a bucket which is waiting for its
+// callback, so that each is guaranteed to complete exactly once even if the
+// callback is lost or the bucket is closed.
+type opTracker struct {
+	lock     sync.Mutex
+	pending  map[*opCompletion]struct{}
+	closed   chan struct{}
+	isClosed bool
+}
+
+func newOpTracker() *opTracker {
+	return &opTracker{
+		pending: make(map[*opCompletion]struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+// opCompletion represents the single completion of one operation.  The first of
+// complete or the waiter giving up wins, and every later attempt is ignored.
+type opCompletion struct {
+	tracker   *opTracker
+	state     int32
+	callbacks int32
+	done      chan struct{}
+}
+
+// begin registers a new operation with the tracker.  A nil tracker is valid and
+// behaves as one which is never shut down.
+func (t *opTracker) begin() *opCompletion {
+	c := &opCompletion{
+		tracker: t,
+		done:    make(chan struct{}),
+	}
+	if t != nil {
+		t.lock.Lock()
+		t.pending[c] = struct{}{}
+		t.lock.Unlock()
+	}
+	return c
+}
+
+func (t *opTracker) remove(c *opCompletion) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	delete(t.pending, c)
+	t.lock.Unlock()
+}
+
+func (t *opTracker) closedCh() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.closed
+}
+
+// shutdown causes every operation still waiting on this tracker to fail with
+// ErrShutdown.
+func (t *opTracker) shutdown() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	if !t.isClosed {
+		t.isClosed = true
+		close(t.closed)
+	}
+	t.lock.Unlock()
+}
+
+// numPending returns the number of operations which have not yet completed.
+func (t *opTracker) numPending() int {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.pending)
+}
+
+func (c *opCompletion) tryComplete() bool {
+	if !atomic.CompareAndSwapInt32(&c.state, opStatePending, opStateCompleted) {
+		return false
+	}
+	c.tracker.remove(c)
+	return true
+}
+
+// complete is called from an operation's callback.  It runs fn, which records the
+// results of the operation, unless the waiter has already given up on the operation.
+func (c *opCompletion) complete(fn func()) {
+	if atomic.AddInt32(&c.callbacks, 1) > 1 {
+		if opCompletionAssertions {
+			panic("gocb: operation callback invoked more than once")
+		}
+		logWarnf("Operation callback invoked more than once, ignoring")
+		return
+	}
+	if !c.tryComplete() {
+		return
+	}
+	fn()
+	close(c.done)
+}
+
+// discard marks an operation which failed to dispatch, and whose callback will
+// therefore never be invoked, as completed.
+func (c *opCompletion) discard() {
+	if c.tryComplete() {
+		close(c.done)
+	}
+}
+
+// abandon completes an operation on behalf of a callback which will not be invoked,
+// returning false if the callback has already completed it.  The callback must then
+// be waited for as it is in the midst of recording its results.
+func (c *opCompletion) abandon() bool {
+	if !c.tryComplete() {
+		<-c.done
+		return false
+	}
+	close(c.done)
+	return true
+}
+
+// wait blocks until the operation completes, returning nil once the callback has
+// recorded its results.  Otherwise ErrTimeout or ErrShutdown is returned and the
+// callback is guaranteed not to record anything.
+func (c *opCompletion) wait(op pendingOp, timeout time.Duration) error {
+	timeoutTmr := gocbcore.AcquireTimer(timeout)
+	select {
+	case <-c.done:
+		gocbcore.ReleaseTimer(timeoutTmr, false)
+		return nil
+	case <-c.tracker.closedCh():
+		gocbcore.ReleaseTimer(timeoutTmr, false)
+		op.Cancel()
+		if c.abandon() {
+			return ErrShutdown
+		}
+		return nil
+	case <-timeoutTmr.C:
+		gocbcore.ReleaseTimer(timeoutTmr, true)
+		if op.Cancel() {
+			if c.abandon() {
+				return ErrTimeout
+			}
+			return nil
+		}
+		return c.waitOrphaned(ErrTimeout)
+	}
+}
+
+// waitOrphaned waits for the callback of an operation which could not be cancelled,
+// giving up after orphanedOpGracePeriod rather than leaking the waiting goroutine.
+func (c *opCompletion) waitOrphaned(err error) error {
+	graceTmr := gocbcore.AcquireTimer(orphanedOpGracePeriod)
+	select {
+	case <-c.done:
+		gocbcore.ReleaseTimer(graceTmr, false)
+		return nil
+	case <-c.tracker.closedCh():
+		gocbcore.ReleaseTimer(graceTmr, false)
+		if c.abandon() {
+			return ErrShutdown
+		}
+		return nil
+	case <-graceTmr.C:
+		gocbcore.ReleaseTimer(graceTmr, true)
+		if !c.abandon() {
+			return nil
+		}
+		if opCompletionAssertions {
+			panic("gocb: operation dropped without its callback being invoked")
+		}
+		logWarnf("Operation callback was never invoked, abandoning operation")
+		return err
+	}
+}
@@ -0,0 +1,231 @@
+package gocb
+
+import (
+	"errors"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errFakeConnClosed = errors.New("fake connection closed")
+
+// fakeConn imitates a connection holding the callbacks of dispatched operations
+// which can be killed at any time, failing everything in flight.
+type fakeConn struct {
+	lock    sync.Mutex
+	nextId  int
+	pending map[int]ioCasCallback
+}
+
+type fakePendingOp struct {
+	conn *fakeConn
+	id   int
+}
+
+func (op *fakePendingOp) Cancel() bool {
+	return op.conn.take(op.id) != nil
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{pending: make(map[int]ioCasCallback)}
+}
+
+func (c *fakeConn) take(id int) ioCasCallback {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cb := c.pending[id]
+	delete(c.pending, id)
+	return cb
+}
+
+func (c *fakeConn) dispatch(cb ioCasCallback, delay time.Duration) pendingOp {
+	c.lock.Lock()
+	id := c.nextId
+	c.nextId++
+	c.pending[id] = cb
+	c.lock.Unlock()
+
+	go func() {
+		time.Sleep(delay)
+		if cb := c.take(id); cb != nil {
+			cb(1, gocbcore.MutationToken{}, nil)
+		}
+	}()
+	return &fakePendingOp{c, id}
+}
+
+func (c *fakeConn) kill() {
+	c.lock.Lock()
+	pending := c.pending
+	c.pending = make(map[int]ioCasCallback)
+	c.lock.Unlock()
+
+	for _, cb := range pending {
+		cb(0, gocbcore.MutationToken{}, errFakeConnClosed)
+	}
+}
+
+func enableOpCompletionAssertions(t *testing.T) func() {
+	oldAssertions, oldGrace := opCompletionAssertions, orphanedOpGracePeriod
+	opCompletionAssertions = true
+	orphanedOpGracePeriod = 50 * time.Millisecond
+	return func() {
+		opCompletionAssertions, orphanedOpGracePeriod = oldAssertions, oldGrace
+	}
+}
+
+func waitForGoroutines(t *testing.T, baseline int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("Leaked goroutines: have %d, expected at most %d", runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpCompletionStress(t *testing.T) {
+	defer enableOpCompletionAssertions(t)()
+
+	b := &Bucket{ops: newOpTracker(), opTimeout: 20 * time.Millisecond}
+	baseline := runtime.NumGoroutine()
+
+	var connLock sync.Mutex
+	conn := newFakeConn()
+	stopKiller := make(chan struct{})
+	killerDone := make(chan struct{})
+	go func() {
+		defer close(killerDone)
+		for {
+			select {
+			case <-stopKiller:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			connLock.Lock()
+			dead := conn
+			conn = newFakeConn()
+			connLock.Unlock()
+			dead.kill()
+		}
+	}()
+
+	const numOps = 4000
+	var wg sync.WaitGroup
+	var resultLock sync.Mutex
+	results := make(map[error]int)
+	for i := 0; i < numOps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			delay := time.Duration(rand.Intn(2000)) * time.Microsecond
+			if i%10 == 0 {
+				delay = 40 * time.Millisecond
+			}
+			_, _, err := b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+				connLock.Lock()
+				c := conn
+				connLock.Unlock()
+				return c.dispatch(cb, delay), nil
+			})
+			resultLock.Lock()
+			results[err]++
+			resultLock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	close(stopKiller)
+	<-killerDone
+
+	total := 0
+	for err, count := range results {
+		if err != nil && err != ErrTimeout && err != errFakeConnClosed {
+			t.Fatalf("Unexpected operation error: %v", err)
+		}
+		total += count
+	}
+	if total != numOps {
+		t.Fatalf("Expected %d completions, got %d", numOps, total)
+	}
+	if pending := b.ops.numPending(); pending != 0 {
+		t.Fatalf("Expected no pending operations, got %d", pending)
+	}
+	waitForGoroutines(t, baseline)
+}
+
+func TestOpCompletionShutdown(t *testing.T) {
+	defer enableOpCompletionAssertions(t)()
+
+	b := &Bucket{ops: newOpTracker(), opTimeout: 10 * time.Second}
+	conn := newFakeConn()
+	baseline := runtime.NumGoroutine()
+
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		go func() {
+			_, _, err := b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+				return conn.dispatch(cb, time.Hour), nil
+			})
+			errs <- err
+		}()
+	}
+
+	for b.ops.numPending() < 100 {
+		time.Sleep(time.Millisecond)
+	}
+	b.ops.shutdown()
+
+	for i := 0; i < 100; i++ {
+		if err := <-errs; err != ErrShutdown {
+			t.Fatalf("Expected ErrShutdown, got %v", err)
+		}
+	}
+	// Closing the bucket must not cause anything to be recorded by a late callback.
+	conn.kill()
+	if pending := b.ops.numPending(); pending != 0 {
+		t.Fatalf("Expected no pending operations, got %d", pending)
+	}
+	waitForGoroutines(t, baseline+100)
+}
+
+func TestOpCompletionDroppedCallback(t *testing.T) {
+	defer enableOpCompletionAssertions(t)()
+	opCompletionAssertions = false
+
+	b := &Bucket{ops: newOpTracker(), opTimeout: 10 * time.Millisecond}
+	_, _, err := b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+		// An operation which can never be cancelled, and whose callback is lost.
+		return &fakePendingOp{newFakeConn(), 0}, nil
+	})
+	if err != ErrTimeout {
+		t.Fatalf("Expected ErrTimeout for a dropped operation, got %v", err)
+	}
+
+	opCompletionAssertions = true
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Expected dropped operation to panic with assertions enabled")
+			}
+		}()
+		b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+			return &fakePendingOp{newFakeConn(), 0}, nil
+		})
+	}()
+}
+
+func TestOpCompletionDoubleCallback(t *testing.T) {
+	defer enableOpCompletionAssertions(t)()
+
+	c := newOpTracker().begin()
+	c.complete(func() {})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected completing an operation twice to panic with assertions enabled")
+		}
+	}()
+	c.complete(func() {})
+}
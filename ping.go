@@ -0,0 +1,231 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PingServiceEntry describes the outcome of pinging a single endpoint of a service.
+//
+// Experimental: This API is subject to change at any time.
+type PingServiceEntry struct {
+	Service  ServiceType
+	Endpoint string
+	Latency  time.Duration
+	Success  bool
+	// Error describes why the ping failed, if it did.
+	Error string
+}
+
+// PingReport describes the outcome of pinging the endpoints of the services of a bucket.
+// It can be encoded to JSON for health-check endpoints.
+//
+// Experimental: This API is subject to change at any time.
+type PingReport struct {
+	Services []PingServiceEntry
+}
+
+// serviceName returns the name of a service as used in reports.
+func serviceName(service ServiceType) string {
+	switch service {
+	case MemdService:
+		return "kv"
+	case MgmtService:
+		return "mgmt"
+	case CapiService:
+		return "views"
+	case N1qlService:
+		return "n1ql"
+	case FtsService:
+		return "fts"
+	case CbasService:
+		return "analytics"
+	}
+	return "unknown"
+}
+
+type jsonPingServiceEntry struct {
+	Remote    string `json:"remote"`
+	LatencyUs uint64 `json:"latency_us"`
+	State     string `json:"state"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the report as a version 1 ping report, in which the endpoints
+// are grouped by service.
+func (report *PingReport) MarshalJSON() ([]byte, error) {
+	services := make(map[string][]jsonPingServiceEntry)
+	for _, entry := range report.Services {
+		jsonEntry := jsonPingServiceEntry{
+			Remote:    entry.Endpoint,
+			LatencyUs: uint64(entry.Latency / time.Microsecond),
+			State:     "ok",
+			Error:     entry.Error,
+		}
+		if !entry.Success {
+			jsonEntry.State = "error"
+		}
+		name := serviceName(entry.Service)
+		services[name] = append(services[name], jsonEntry)
+	}
+
+	return json.Marshal(struct {
+		Version  int                               `json:"version"`
+		Services map[string][]jsonPingServiceEntry `json:"services"`
+	}{
+		Version:  1,
+		Services: services,
+	})
+}
+
+// The paths of the HTTP services which are requested to ping them.
+var pingPaths = map[ServiceType]string{
+	CapiService: "/",
+	N1qlService: "/admin/ping",
+	FtsService:  "/api/ping",
+	CbasService: "/admin/ping",
+}
+
+// Ping actively checks that each endpoint of the specified services is reachable, and
+// reports how long each took to respond.  If no services are specified, the data,
+// view, N1QL, FTS and analytics services are pinged.  Each ping is limited by the
+// timeout of the service.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Ping(services ...ServiceType) (*PingReport, error) {
+	if len(services) == 0 {
+		services = []ServiceType{MemdService, CapiService, N1qlService, FtsService, CbasService}
+	}
+	for _, service := range services {
+		if _, ok := pingPaths[service]; !ok && service != MemdService {
+			return nil, clientError{fmt.Sprintf("The %s service cannot be pinged.", serviceName(service))}
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	report := &PingReport{}
+	addEntries := func(entries ...PingServiceEntry) {
+		lock.Lock()
+		report.Services = append(report.Services, entries...)
+		lock.Unlock()
+		wg.Done()
+	}
+
+	for _, service := range services {
+		var eps []string
+		var timeout time.Duration
+		switch service {
+		case MemdService:
+			wg.Add(1)
+			go func() {
+				addEntries(b.pingKv()...)
+			}()
+			continue
+		case CapiService:
+			eps, timeout = b.client.CapiEps(), b.viewTimeout
+		case N1qlService:
+			eps, timeout = b.cluster.serviceEps(N1qlService, b), b.cluster.n1qlTimeout
+		case FtsService:
+			eps, timeout = b.cluster.serviceEps(FtsService, b), b.cluster.ftsTimeout
+		case CbasService:
+			eps, timeout = b.cluster.serviceEps(CbasService, b), b.cluster.analyticsTimeout
+		}
+
+		for _, ep := range eps {
+			wg.Add(1)
+			go func(service ServiceType, ep string, timeout time.Duration) {
+				addEntries(pingHttpEp(b.httpClient(), service, ep, timeout))
+			}(service, ep, timeout)
+		}
+	}
+	wg.Wait()
+
+	sort.Sort(pingEntriesByService(report.Services))
+	return report, nil
+}
+
+type pingEntriesByService []PingServiceEntry
+
+func (entries pingEntriesByService) Len() int      { return len(entries) }
+func (entries pingEntriesByService) Swap(i, j int) { entries[i], entries[j] = entries[j], entries[i] }
+func (entries pingEntriesByService) Less(i, j int) bool {
+	if entries[i].Service != entries[j].Service {
+		return entries[i].Service < entries[j].Service
+	}
+	return entries[i].Endpoint < entries[j].Endpoint
+}
+
+// pingKv pings every data node by sending it a stats request, which must complete
+// within the operation timeout.
+func (b *Bucket) pingKv() []PingServiceEntry {
+	var entries []PingServiceEntry
+	completion := b.ops.begin()
+	start := time.Now()
+	op, err := b.client.Stats("", func(stats map[string]gocbcore.SingleServerStats) {
+		completion.complete(func() {
+			latency := time.Since(start)
+			for server, serverStats := range stats {
+				entry := PingServiceEntry{
+					Service:  MemdService,
+					Endpoint: server,
+					Latency:  latency,
+					Success:  serverStats.Error == nil,
+				}
+				if serverStats.Error != nil {
+					entry.Error = serverStats.Error.Error()
+				}
+				entries = append(entries, entry)
+			}
+		})
+	})
+	if err == nil {
+		err = completion.wait(op, b.opTimeout)
+	} else {
+		completion.discard()
+	}
+	if err != nil {
+		return []PingServiceEntry{{
+			Service: MemdService,
+			Latency: time.Since(start),
+			Error:   err.Error(),
+		}}
+	}
+	return entries
+}
+
+func pingHttpEp(cli *http.Client, service ServiceType, ep string, timeout time.Duration) PingServiceEntry {
+	entry := PingServiceEntry{
+		Service:  service,
+		Endpoint: ep,
+	}
+
+	req, err := http.NewRequest("GET", ep+pingPaths[service], nil)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	start := time.Now()
+	resp, err := doHttpWithTimeout(cli, req, timeout)
+	entry.Latency = time.Since(start)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if err := resp.Body.Close(); err != nil {
+		logDebugf("Failed to close socket (%s)", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		entry.Error = fmt.Sprintf("Unexpected status %d", resp.StatusCode)
+		return entry
+	}
+	entry.Success = true
+	return entry
+}
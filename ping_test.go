@@ -0,0 +1,60 @@
+package gocb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPingHttpEp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/admin/ping" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	entry := pingHttpEp(http.DefaultClient, N1qlService, server.URL, time.Second)
+	if !entry.Success || entry.Endpoint != server.URL || entry.Error != "" {
+		t.Fatalf("Expected a successful ping, got %+v", entry)
+	}
+
+	entry = pingHttpEp(http.DefaultClient, FtsService, server.URL, time.Second)
+	if entry.Success || entry.Error == "" {
+		t.Fatalf("Expected a failed ping, got %+v", entry)
+	}
+}
+
+func TestPingReportJSON(t *testing.T) {
+	report := &PingReport{Services: []PingServiceEntry{
+		{Service: MemdService, Endpoint: "10.0.0.1:11210", Latency: 2 * time.Millisecond, Success: true},
+		{Service: N1qlService, Endpoint: "http://10.0.0.1:8093", Latency: time.Millisecond, Error: "refused"},
+	}}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to encode report: %v", err)
+	}
+	var decoded struct {
+		Version  int                               `json:"version"`
+		Services map[string][]jsonPingServiceEntry `json:"services"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if decoded.Version != 1 || decoded.Services["kv"][0].LatencyUs != 2000 || decoded.Services["kv"][0].State != "ok" {
+		t.Fatalf("Unexpected report %s", data)
+	}
+	if decoded.Services["n1ql"][0].State != "error" || decoded.Services["n1ql"][0].Error != "refused" {
+		t.Fatalf("Unexpected report %s", data)
+	}
+}
+
+func TestPingUnsupportedService(t *testing.T) {
+	b := &Bucket{cluster: &Cluster{}}
+	if _, err := b.Ping(MgmtService); err == nil {
+		t.Fatalf("Expected pinging the management service to fail")
+	}
+}
@@ -0,0 +1,173 @@
+package gocb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CachedQueryResult holds the complete results of a previously executed query.
+type CachedQueryResult struct {
+	Rows            []json.RawMessage
+	RequestId       string
+	ClientContextId string
+	Metrics         QueryResultMetrics
+	TotalRows       int
+}
+
+func (r *CachedQueryResult) size() int {
+	size := 0
+	for _, row := range r.Rows {
+		size += len(row)
+	}
+	return size
+}
+
+// QueryCache is used to store the results of N1QL and view queries so that repeated
+// identical queries can be served without contacting the cluster.
+//
+// Experimental: This API is subject to change at any time.
+type QueryCache interface {
+	Get(key string) (*CachedQueryResult, bool)
+	Set(key string, result *CachedQueryResult)
+}
+
+// CachedResults is implemented by query results which may have been served from a
+// QueryCache.  This is implemented as an additional interface to maintain ABI
+// compatibility for the 1.x series.
+type CachedResults interface {
+	Cached() bool
+}
+
+// MemoryQueryCache is an in-memory QueryCache which evicts the least recently used
+// results once its size limit is reached, and expires results after a fixed time.
+//
+// Experimental: This API is subject to change at any time.
+type MemoryQueryCache struct {
+	cache         *lruCache
+	maxResultSize int
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	onEvict       atomic.Value
+}
+
+// NewMemoryQueryCache creates a MemoryQueryCache holding at most maxSize bytes of
+// rows in total.  Result sets larger than maxResultSize bytes are never cached, and
+// cached results expire after ttl.
+func NewMemoryQueryCache(maxSize, maxResultSize int, ttl time.Duration) *MemoryQueryCache {
+	qc := &MemoryQueryCache{
+		cache:         newLruCache(maxSize, ttl),
+		maxResultSize: maxResultSize,
+	}
+	qc.cache.onEvict = qc.evicted
+	return qc
+}
+
+func (qc *MemoryQueryCache) evicted(key string) {
+	atomic.AddUint64(&qc.evictions, 1)
+	if onEvict, ok := qc.onEvict.Load().(func(key string)); ok {
+		onEvict(key)
+	}
+}
+
+// Get returns the cached result stored for a key, if any.
+func (qc *MemoryQueryCache) Get(key string) (*CachedQueryResult, bool) {
+	value, ok := qc.cache.get(key)
+	if !ok {
+		atomic.AddUint64(&qc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&qc.hits, 1)
+	return value.(*CachedQueryResult), true
+}
+
+// Set stores a result for a key, unless it exceeds the maximum result size.
+func (qc *MemoryQueryCache) Set(key string, result *CachedQueryResult) {
+	size := result.size()
+	if size > qc.maxResultSize {
+		return
+	}
+	qc.cache.set(key, result, size)
+}
+
+// Hits returns the number of lookups which were served from the cache.
+func (qc *MemoryQueryCache) Hits() uint64 {
+	return atomic.LoadUint64(&qc.hits)
+}
+
+// Misses returns the number of lookups which were not found in the cache.
+func (qc *MemoryQueryCache) Misses() uint64 {
+	return atomic.LoadUint64(&qc.misses)
+}
+
+// Evictions returns the number of results which were evicted to make room for others.
+func (qc *MemoryQueryCache) Evictions() uint64 {
+	return atomic.LoadUint64(&qc.evictions)
+}
+
+// lookupQueryCache looks up the result of a query of the specified kind in cache,
+// recording whether it was found in the metrics of the cluster.
+func (c *Cluster) lookupQueryCache(cache QueryCache, kind, bucket, key string) (*CachedQueryResult, bool) {
+	cached, ok := cache.Get(key)
+	event := "miss"
+	if ok {
+		event = "hit"
+	}
+	c.recordQueryCache(kind, bucket, event)
+	return cached, ok
+}
+
+// recordQueryCacheEviction records the eviction of the result stored for a key from
+// the QueryCache.
+func (c *Cluster) recordQueryCacheEviction(key string) {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) != 3 {
+		return
+	}
+	c.recordQueryCache(parts[0], parts[1], "eviction")
+}
+
+func hashQueryCacheKey(kind, bucket string, data []byte) string {
+	digest := sha256.Sum256(data)
+	return kind + ":" + bucket + ":" + hex.EncodeToString(digest[:])
+}
+
+// n1qlQueryCacheKey returns the cache key for a N1QL query, or false if the query
+// must not be cached.  Only SELECT statements without scan consistency
+// requirements are cached.
+func n1qlQueryCacheKey(bucket string, opts map[string]interface{}) (string, bool) {
+	switch opts["scan_consistency"] {
+	case "at_plus", "request_plus", "statement_plus":
+		return "", false
+	}
+
+	statement, _ := opts["statement"].(string)
+	words := strings.Fields(statement)
+	if len(words) == 0 || !strings.EqualFold(words[0], "SELECT") {
+		return "", false
+	}
+
+	data, err := marshalCanonicalJson(opts)
+	if err != nil {
+		return "", false
+	}
+	return hashQueryCacheKey("n1ql", bucket, data), true
+}
+
+// viewQueryCacheKey returns the cache key for a view query, or false if the query
+// must not be cached because it requires the index to be updated first.
+func viewQueryCacheKey(bucket, viewType, ddoc, viewName string, options url.Values, mode viewRowMode) (string, bool) {
+	if options.Get("stale") == "false" {
+		return "", false
+	}
+
+	data := viewType + "/" + ddoc + "/" + viewName + "?" + options.Encode() +
+		"&row_mode=" + strconv.Itoa(int(mode))
+	return hashQueryCacheKey("view", bucket, []byte(data)), true
+}
@@ -0,0 +1,69 @@
+package gocb
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueryCache(t *testing.T) {
+	cache := NewMemoryQueryCache(10, 6, time.Minute)
+
+	cache.Set("a", &CachedQueryResult{Rows: []json.RawMessage{json.RawMessage("1234")}})
+	cache.Set("b", &CachedQueryResult{Rows: []json.RawMessage{json.RawMessage("1234")}})
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+
+	// Storing c exceeds the cache size and evicts b, the least recently used.
+	cache.Set("c", &CachedQueryResult{Rows: []json.RawMessage{json.RawMessage("1234")}})
+	if _, ok := cache.Get("b"); ok {
+		t.Fatalf("Expected b to have been evicted")
+	}
+	if _, ok := cache.Get("c"); !ok {
+		t.Fatalf("Expected c to be cached")
+	}
+
+	cache.Set("d", &CachedQueryResult{Rows: []json.RawMessage{json.RawMessage("1234567")}})
+	if _, ok := cache.Get("d"); ok {
+		t.Fatalf("Expected results over the result size limit not to be cached")
+	}
+
+	if cache.Hits() != 2 || cache.Misses() != 2 {
+		t.Fatalf("Unexpected hit/miss counts %d/%d", cache.Hits(), cache.Misses())
+	}
+
+	expiring := NewMemoryQueryCache(10, 10, time.Millisecond)
+	expiring.Set("a", &CachedQueryResult{})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expiring.Get("a"); ok {
+		t.Fatalf("Expected a to have expired")
+	}
+}
+
+func TestQueryCacheKeys(t *testing.T) {
+	selectOpts := map[string]interface{}{"statement": "SELECT * FROM default", "$name": "frank"}
+	key, ok := n1qlQueryCacheKey("default", selectOpts)
+	if !ok {
+		t.Fatalf("Expected SELECT statements to be cacheable")
+	}
+	otherKey, _ := n1qlQueryCacheKey("default", map[string]interface{}{"statement": "SELECT * FROM default", "$name": "bob"})
+	if key == otherKey {
+		t.Fatalf("Expected different parameters to produce different keys")
+	}
+
+	if _, ok := n1qlQueryCacheKey("default", map[string]interface{}{"statement": "DELETE FROM default"}); ok {
+		t.Fatalf("Expected DML statements not to be cacheable")
+	}
+	if _, ok := n1qlQueryCacheKey("default", map[string]interface{}{
+		"statement":        "SELECT * FROM default",
+		"scan_consistency": "request_plus",
+	}); ok {
+		t.Fatalf("Expected request_plus queries not to be cacheable")
+	}
+
+	if _, ok := viewQueryCacheKey("default", "_view", "ddoc", "view", url.Values{"stale": {"false"}}, viewRowsAll); ok {
+		t.Fatalf("Expected stale=false view queries not to be cacheable")
+	}
+}
@@ -0,0 +1,240 @@
+package gocb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pagerOrderByRegexp = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+	pagerLimitRegexp   = regexp.MustCompile(`(?i)\b(LIMIT|OFFSET)\b`)
+	pagerDescRegexp    = regexp.MustCompile(`(?i)\bDESC\s*$`)
+)
+
+type queryPagerState struct {
+	StatementHash string          `json:"statement"`
+	Offset        int             `json:"offset,omitempty"`
+	LastKey       json.RawMessage `json:"last,omitempty"`
+	Done          bool            `json:"done,omitempty"`
+}
+
+// QueryPager iterates over the results of a N1QL query one page at a time.  By
+// default pages are fetched using LIMIT and OFFSET; Keyset switches to keyset
+// continuation, where each page is fetched using a predicate on the ordering key
+// of the final row of the previous page.  Rather than being rewritten, the statement
+// is then queried as a subquery, filtered and ordered by the field holding the
+// ordering key.
+//
+// The base statement must not include its own LIMIT or OFFSET clauses.
+//
+// Experimental: This API is subject to change at any time.
+type QueryPager struct {
+	cluster     *Cluster
+	bucket      *Bucket
+	query       *N1qlQuery
+	params      map[string]interface{}
+	pageSize    int
+	orderingKey string
+	rowField    string
+	state       queryPagerState
+}
+
+func newQueryPager(c *Cluster, b *Bucket, q *N1qlQuery, params map[string]interface{}, pageSize int) *QueryPager {
+	statement, _ := q.options["statement"].(string)
+	return &QueryPager{
+		cluster:  c,
+		bucket:   b,
+		query:    q,
+		params:   params,
+		pageSize: pageSize,
+		state: queryPagerState{
+			StatementHash: statementHash(statement),
+		},
+	}
+}
+
+// NewQueryPager creates a QueryPager which executes pages of a query against the cluster.
+// For keyset continuation the ordering key must be unique across the results, as rows
+// sharing the ordering key of the final row of a page are skipped.
+func (c *Cluster) NewQueryPager(q *N1qlQuery, params map[string]interface{}, pageSize int) *QueryPager {
+	return newQueryPager(c, nil, q, params, pageSize)
+}
+
+// NewQueryPager creates a QueryPager which executes pages of a query against this bucket.
+// For keyset continuation the ordering key must be unique across the results, as rows
+// sharing the ordering key of the final row of a page are skipped.
+func (b *Bucket) NewQueryPager(q *N1qlQuery, params map[string]interface{}, pageSize int) *QueryPager {
+	return newQueryPager(b.cluster, b, q, params, pageSize)
+}
+
+// Keyset switches the pager to keyset continuation using the specified ordering key,
+// which must be the expression the statement's ORDER BY clause sorts on, and must be
+// unique across the results.  Each row must include the ordering key's value in a field named after its final identifier,
+// for instance `name` for `default.name`; RowField can be used to specify a different
+// field.
+func (p *QueryPager) Keyset(orderingKey string) *QueryPager {
+	p.orderingKey = orderingKey
+	parts := strings.Split(orderingKey, ".")
+	p.rowField = strings.Trim(parts[len(parts)-1], "`")
+	return p
+}
+
+// RowField specifies the field of each row which holds the value of the ordering key.
+func (p *QueryPager) RowField(field string) *QueryPager {
+	p.rowField = field
+	return p
+}
+
+func (p *QueryPager) pageStatement() (string, error) {
+	statement, _ := p.query.options["statement"].(string)
+	if pagerLimitRegexp.MatchString(statement) {
+		return "", clientError{"The statement passed to a QueryPager must not specify LIMIT or OFFSET."}
+	}
+
+	limit := " LIMIT " + strconv.Itoa(p.pageSize)
+	if p.orderingKey == "" {
+		return statement + limit + " OFFSET $offset", nil
+	}
+
+	orderBy := pagerOrderByRegexp.FindAllStringIndex(statement, -1)
+	if len(orderBy) == 0 {
+		return "", clientError{"Keyset pagination requires the statement to specify ORDER BY."}
+	}
+	if strings.Contains(p.rowField, "`") {
+		return "", clientError{"The ordering key field of a QueryPager must not contain backticks."}
+	}
+	if p.state.LastKey == nil {
+		return statement + limit, nil
+	}
+
+	// The rows of the statement are filtered and ordered by the field holding the
+	// ordering key, which avoids rewriting the clauses of the statement itself.
+	field := "p.`" + p.rowField + "`"
+	compare, order := " > ", ""
+	if pagerDescRegexp.MatchString(statement[orderBy[len(orderBy)-1][0]:]) {
+		compare, order = " < ", " DESC"
+	}
+	return "SELECT p.* FROM (" + statement + ") AS p WHERE " + field + compare + "$last" +
+		" ORDER BY " + field + order + limit, nil
+}
+
+// NextPage executes the query for the next page of results, which is read in full
+// before it is returned.  It returns false once all pages have been returned.
+func (p *QueryPager) NextPage() (QueryResults, bool, error) {
+	if p.state.Done {
+		return nil, false, nil
+	}
+
+	statement, err := p.pageStatement()
+	if err != nil {
+		return nil, false, err
+	}
+
+	pageQuery := &N1qlQuery{
+		options: make(map[string]interface{}),
+		adHoc:   p.query.adHoc,
+	}
+	for k, v := range p.query.options {
+		pageQuery.options[k] = v
+	}
+	pageQuery.options["statement"] = statement
+
+	params := make(map[string]interface{})
+	for k, v := range p.params {
+		params[k] = v
+	}
+	if p.orderingKey == "" {
+		params["offset"] = p.state.Offset
+	} else if p.state.LastKey != nil {
+		params["last"] = p.state.LastKey
+	}
+
+	results, err := p.cluster.doN1qlQuery(context.Background(), p.bucket, pageQuery, params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	n1qlRes, ok := results.(*n1qlResults)
+	if !ok {
+		return nil, false, ErrCliInternalError
+	}
+
+	// The page is read in full, as the cursor is taken from its final row.  Rows are
+	// read before any projection so that the ordering key field is always present.
+	projection := n1qlRes.projection
+	n1qlRes.projection = nil
+	var rows []json.RawMessage
+	for row := n1qlRes.NextBytes(); row != nil; row = n1qlRes.NextBytes() {
+		rows = append(rows, row)
+	}
+	if err := n1qlRes.Close(); err != nil {
+		return nil, false, err
+	}
+
+	numRows := len(rows)
+	if numRows < p.pageSize {
+		p.state.Done = true
+	}
+	if numRows == 0 {
+		return nil, false, nil
+	}
+
+	p.state.Offset += numRows
+	if p.orderingKey != "" {
+		var lastRow map[string]json.RawMessage
+		err = json.Unmarshal(rows[numRows-1], &lastRow)
+		if err != nil {
+			return nil, false, err
+		}
+		lastKey, ok := lastRow[p.rowField]
+		if !ok {
+			return nil, false, clientError{"The final row of the page does not include the ordering key field " + p.rowField + "."}
+		}
+		p.state.LastKey = lastKey
+	}
+
+	return &n1qlResults{
+		index:           -1,
+		rows:            rows,
+		requestId:       n1qlRes.requestId,
+		clientContextId: n1qlRes.clientContextId,
+		metrics:         n1qlRes.metrics,
+		cached:          n1qlRes.cached,
+		projection:      projection,
+	}, true, nil
+}
+
+// ContinuationToken returns a token describing the position of the pager, which
+// can be passed to Resume to continue paginating from the same position.
+func (p *QueryPager) ContinuationToken() (string, error) {
+	data, err := json.Marshal(p.state)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Resume restores the position of the pager from a continuation token.  The token
+// must have been produced by a pager for the same statement.
+func (p *QueryPager) Resume(token string) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return err
+	}
+
+	var state queryPagerState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	if state.StatementHash != p.state.StatementHash {
+		return clientError{"The continuation token was produced for a different statement."}
+	}
+
+	p.state = state
+	return nil
+}
@@ -0,0 +1,153 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueryPagerStatements(t *testing.T) {
+	c := &Cluster{}
+
+	pager := c.NewQueryPager(NewN1qlQuery("SELECT name FROM default WHERE type = 'user' OR admin ORDER BY name"), nil, 100)
+	statement, err := pager.pageStatement()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if statement != "SELECT name FROM default WHERE type = 'user' OR admin ORDER BY name LIMIT 100 OFFSET $offset" {
+		t.Fatalf("Unexpected offset statement: %s", statement)
+	}
+
+	pager.Keyset("default.name")
+	pager.state.LastKey = json.RawMessage(`"frank"`)
+	statement, err = pager.pageStatement()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if statement != "SELECT p.* FROM (SELECT name FROM default WHERE type = 'user' OR admin ORDER BY name) AS p WHERE p.`name` > $last ORDER BY p.`name` LIMIT 100" {
+		t.Fatalf("Unexpected keyset statement: %s", statement)
+	}
+	if pager.rowField != "name" {
+		t.Fatalf("Expected the row field to default to the final identifier, got %s", pager.rowField)
+	}
+
+	pager = c.NewQueryPager(NewN1qlQuery("SELECT name FROM default ORDER BY name DESC"), nil, 10).Keyset("name")
+	pager.state.LastKey = json.RawMessage(`"frank"`)
+	statement, _ = pager.pageStatement()
+	if statement != "SELECT p.* FROM (SELECT name FROM default ORDER BY name DESC) AS p WHERE p.`name` < $last ORDER BY p.`name` DESC LIMIT 10" {
+		t.Fatalf("Unexpected descending keyset statement: %s", statement)
+	}
+
+	// Clauses following the WHERE clause of the statement are left in place.
+	pager = c.NewQueryPager(NewN1qlQuery("SELECT type, COUNT(*) AS n FROM default WHERE age > 18 GROUP BY type ORDER BY type"), nil, 10).Keyset("type")
+	if statement, _ = pager.pageStatement(); statement != "SELECT type, COUNT(*) AS n FROM default WHERE age > 18 GROUP BY type ORDER BY type LIMIT 10" {
+		t.Fatalf("Unexpected first keyset page statement: %s", statement)
+	}
+	pager.state.LastKey = json.RawMessage(`"hotel"`)
+	statement, _ = pager.pageStatement()
+	if statement != "SELECT p.* FROM (SELECT type, COUNT(*) AS n FROM default WHERE age > 18 GROUP BY type ORDER BY type) AS p WHERE p.`type` > $last ORDER BY p.`type` LIMIT 10" {
+		t.Fatalf("Unexpected grouped keyset statement: %s", statement)
+	}
+
+	pager = c.NewQueryPager(NewN1qlQuery("SELECT name FROM default"), nil, 10).Keyset("name")
+	if _, err := pager.pageStatement(); err == nil {
+		t.Fatalf("Expected keyset pagination without ORDER BY to fail")
+	}
+}
+
+func TestQueryPagerContinuationToken(t *testing.T) {
+	c := &Cluster{}
+	q := NewN1qlQuery("SELECT name FROM default ORDER BY name")
+
+	pager := c.NewQueryPager(q, nil, 10).Keyset("name")
+	pager.state.Offset = 20
+	pager.state.LastKey = json.RawMessage(`"frank"`)
+	token, err := pager.ContinuationToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	resumed := c.NewQueryPager(q, nil, 10).Keyset("name")
+	if err := resumed.Resume(token); err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	if resumed.state.Offset != 20 || string(resumed.state.LastKey) != `"frank"` {
+		t.Fatalf("Unexpected resumed state %+v", resumed.state)
+	}
+
+	other := c.NewQueryPager(NewN1qlQuery("SELECT age FROM default ORDER BY age"), nil, 10)
+	if err := other.Resume(token); err == nil {
+		t.Fatalf("Expected resuming with a token for another statement to fail")
+	}
+}
+
+func TestQueryPagerStreamedPages(t *testing.T) {
+	names := []string{"alice", "bob", "carol", "dave", "erin"}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/pools/default/nodeServices" {
+			port := server.Listener.Addr().(*net.TCPAddr).Port
+			fmt.Fprintf(w, `{"nodesExt":[{"hostname":"127.0.0.1","services":{"n1ql":%d}}]}`, port)
+			return
+		}
+
+		var opts struct {
+			Offset int    `json:"$offset"`
+			Last   string `json:"$last"`
+		}
+		json.NewDecoder(req.Body).Decode(&opts)
+
+		var rows []string
+		for i, name := range names {
+			if i >= opts.Offset && name > opts.Last && len(rows) < 2 {
+				rows = append(rows, fmt.Sprintf(`{"name":"%s"}`, name))
+			}
+		}
+		fmt.Fprintf(w, `{"requestID":"1","results":[%s],"status":"success","metrics":{"resultCount":%d}}`, strings.Join(rows, ","), len(rows))
+	}))
+	defer server.Close()
+
+	c := &Cluster{
+		httpCli:    http.DefaultClient,
+		queryCache: make(map[string]*n1qlCache),
+	}
+	c.agentConfig.HttpAddrs = []string{strings.TrimPrefix(server.URL, "http://")}
+	c.auth = ClusterAuthenticator{Username: "admin", Password: "password"}
+
+	pagers := []*QueryPager{
+		c.NewQueryPager(NewN1qlQuery("SELECT name FROM default ORDER BY name"), nil, 2),
+		c.NewQueryPager(NewN1qlQuery("SELECT name FROM default ORDER BY name"), nil, 2).Keyset("name"),
+	}
+	for _, pager := range pagers {
+		var pages [][]string
+		for {
+			results, more, err := pager.NextPage()
+			if err != nil {
+				t.Fatalf("Failed to get page: %v", err)
+			}
+			if !more {
+				break
+			}
+			var page []string
+			var row struct {
+				Name string `json:"name"`
+			}
+			for results.Next(&row) {
+				page = append(page, row.Name)
+			}
+			if err := results.Close(); err != nil {
+				t.Fatalf("Failed to read page: %v", err)
+			}
+			pages = append(pages, page)
+		}
+
+		if fmt.Sprint(pages) != "[[alice bob] [carol dave] [erin]]" {
+			t.Fatalf("Unexpected pages %v with ordering key %q", pages, pager.orderingKey)
+		}
+	}
+}
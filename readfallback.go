@@ -0,0 +1,239 @@
+package gocb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type readSource int
+
+const (
+	readFromActive = readSource(iota)
+	readFromReplica
+	readFromAnyReplica
+	readFromLocalCache
+)
+
+// ReadStep is a source a document can be read from by GetWithFallback.
+//
+// Experimental: This API is subject to change at any time.
+type ReadStep struct {
+	source  readSource
+	replica int
+}
+
+var (
+	// ReadActive reads a document from its active node.
+	ReadActive = ReadStep{source: readFromActive}
+	// ReadAnyReplica reads a document from each of its replicas in turn, until one
+	// succeeds.  Replicas held by drained nodes are skipped, unless every replica is.
+	ReadAnyReplica = ReadStep{source: readFromAnyReplica}
+	// ReadLocalCache reads a document from the local cache of the bucket, which must
+	// have been enabled with EnableLocalCache.
+	ReadLocalCache = ReadStep{source: readFromLocalCache}
+)
+
+// ReadReplica reads a document from a particular replica, where the first replica is
+// replica 1.
+func ReadReplica(replicaIdx int) ReadStep {
+	return ReadStep{source: readFromReplica, replica: replicaIdx}
+}
+
+func (s ReadStep) String() string {
+	switch s.source {
+	case readFromActive:
+		return "Active"
+	case readFromReplica:
+		return fmt.Sprintf("Replica(%d)", s.replica)
+	case readFromAnyReplica:
+		return "AnyReplica"
+	case readFromLocalCache:
+		return "LocalCache"
+	}
+	return "Unknown"
+}
+
+// ReadFallbackChain is a sequence of sources a document is read from in order, until
+// one of them succeeds.
+//
+// Experimental: This API is subject to change at any time.
+type ReadFallbackChain []ReadStep
+
+// ReadResult describes a document read by GetWithFallback.
+//
+// Experimental: This API is subject to change at any time.
+type ReadResult struct {
+	// Cas is the CAS of the document as read from the step which served it.
+	Cas Cas
+	// Step is the step of the chain which served the read.
+	Step ReadStep
+}
+
+// ReadStepError is the error encountered by a step of a ReadFallbackChain.
+type ReadStepError struct {
+	Step ReadStep
+	Err  error
+}
+
+// ReadFallbackError occurs when every step of a ReadFallbackChain fails.  Its cause is
+// the error of the first step, which is authoritative, and the errors of every step
+// are attached.
+type ReadFallbackError struct {
+	// Err is the error of the first step of the chain.
+	Err error
+	// Steps holds the error of every step of the chain, in order.
+	Steps []ReadStepError
+}
+
+func (e *ReadFallbackError) Error() string {
+	var fallbacks []string
+	for _, step := range e.Steps[1:] {
+		fallbacks = append(fallbacks, fmt.Sprintf("%s: %s", step.Step, step.Err))
+	}
+	return fmt.Sprintf("%s (fallbacks failed: %s)", e.Err, strings.Join(fallbacks, ", "))
+}
+
+// Unwrap returns the error of the first step of the chain.
+func (e *ReadFallbackError) Unwrap() error {
+	return e.Err
+}
+
+// errNotCached occurs when the ReadLocalCache step does not find a document.
+var errNotCached = clientError{"The document is not held by the local cache."}
+
+// readStepFn performs a single step of a chain within the specified timeout.
+type readStepFn func(step ReadStep, timeout time.Duration) (Cas, error)
+
+// runReadFallback performs the steps of a chain in order until one succeeds, sharing
+// the timeout between them.  The remaining time is divided equally between the
+// remaining steps which read from the network, so that a step which times out leaves
+// time for the steps after it.  A document found not to exist by the active node is
+// authoritative, and ends the chain.
+func runReadFallback(chain ReadFallbackChain, timeout time.Duration, now func() time.Time, exec readStepFn) (ReadResult, error) {
+	if len(chain) == 0 {
+		chain = ReadFallbackChain{ReadActive}
+	}
+
+	deadline := now().Add(timeout)
+	var stepErrs []ReadStepError
+	for i, step := range chain {
+		remaining := deadline.Sub(now())
+		if remaining <= 0 {
+			stepErrs = append(stepErrs, ReadStepError{step, ErrTimeout})
+			continue
+		}
+
+		networkSteps := 0
+		for _, later := range chain[i:] {
+			if later.source != readFromLocalCache {
+				networkSteps++
+			}
+		}
+		stepTimeout := remaining
+		if networkSteps > 1 {
+			stepTimeout = remaining / time.Duration(networkSteps)
+		}
+
+		cas, err := exec(step, stepTimeout)
+		if err == nil {
+			return ReadResult{Cas: cas, Step: step}, nil
+		}
+		if step.source == readFromActive && ErrorCause(err) == ErrKeyNotFound {
+			return ReadResult{}, err
+		}
+		stepErrs = append(stepErrs, ReadStepError{step, err})
+	}
+
+	if len(stepErrs) == 1 {
+		return ReadResult{}, stepErrs[0].Err
+	}
+	return ReadResult{}, &ReadFallbackError{Err: stepErrs[0].Err, Steps: stepErrs}
+}
+
+func (b *Bucket) readStep(key string, valuePtr interface{}) readStepFn {
+	return func(step ReadStep, timeout time.Duration) (Cas, error) {
+		timed := &kvOpOptions{timeout: timeout}
+		switch step.source {
+		case readFromActive:
+			if b.softDeleteAware {
+				return b.getSoftDeleteAware(timed, key, valuePtr)
+			}
+			return b.getFromServer(timed, key, valuePtr)
+		case readFromReplica:
+			return b.getReplica(timed, key, valuePtr, step.replica)
+		case readFromAnyReplica:
+			replicas := b.replicaOrder(key)
+			if len(replicas) == 0 {
+				return 0, ErrNoReplicas
+			}
+			var errs MultiError
+			replicaTimeout := timeout / time.Duration(len(replicas))
+			for _, replicaIdx := range replicas {
+				cas, err := b.getReplica(timed.withTimeout(replicaTimeout), key, valuePtr, replicaIdx)
+				if err == nil {
+					return cas, nil
+				}
+				errs.add(err)
+			}
+			return 0, errs.get()
+		case readFromLocalCache:
+			cas, ok, err := b.getCached(key, valuePtr)
+			if !ok {
+				return 0, errNotCached
+			}
+			return cas, err
+		}
+		return 0, clientError{"Unknown read step."}
+	}
+}
+
+func (b *Bucket) getWithFallback(key string, valuePtr interface{}, chain ReadFallbackChain) (ReadResult, error) {
+	res, err := runReadFallback(chain, b.opTimeout, time.Now, b.readStep(key, valuePtr))
+	if err == nil {
+		b.cluster.recordReadStep(res.Step.String())
+	} else {
+		b.cluster.recordReadStep("none")
+	}
+	return res, err
+}
+
+// GetWithFallback retrieves a document by performing the steps of chain in order until
+// one of them succeeds, reporting which step served it.  The steps share the operation
+// timeout of the bucket.  If every step fails, the error of the first step is returned
+// as the cause of a ReadFallbackError which also describes the failures of the others.
+// This trades consistency for availability, as replicas and the local cache may hold
+// stale versions of a document.  A document which the active node reports does not
+// exist is not read from any later step.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) GetWithFallback(key string, valuePtr interface{}, chain ReadFallbackChain) (ReadResult, error) {
+	start := time.Now()
+	res, err := b.getWithFallback(key, valuePtr, chain)
+	return res, b.wrapError(err, "GetWithFallback", key, start)
+}
+
+// FallbackReader is a read-only handle to a bucket which reads documents using a
+// ReadFallbackChain.
+//
+// Experimental: This API is subject to change at any time.
+type FallbackReader struct {
+	bucket *Bucket
+	chain  ReadFallbackChain
+}
+
+// WithReadFallback returns a read-only handle to the bucket which retrieves every
+// document with GetWithFallback using the specified chain.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) WithReadFallback(chain ReadFallbackChain) *FallbackReader {
+	return &FallbackReader{
+		bucket: b,
+		chain:  append(ReadFallbackChain{}, chain...),
+	}
+}
+
+// Get retrieves a document using the chain of the handle.  See GetWithFallback.
+func (r *FallbackReader) Get(key string, valuePtr interface{}) (ReadResult, error) {
+	return r.bucket.GetWithFallback(key, valuePtr, r.chain)
+}
@@ -0,0 +1,120 @@
+package gocb
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeReadSteps imitates the steps of a chain, each of which either times out after
+// its timeout has elapsed on a fake clock or returns a result immediately.
+type fakeReadSteps struct {
+	now      time.Time
+	results  map[ReadStep]error
+	timeouts map[ReadStep]time.Duration
+}
+
+func (f *fakeReadSteps) clock() time.Time {
+	return f.now
+}
+
+func (f *fakeReadSteps) exec(step ReadStep, timeout time.Duration) (Cas, error) {
+	f.timeouts[step] = timeout
+	err := f.results[step]
+	if err == ErrTimeout {
+		f.now = f.now.Add(timeout)
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return Cas(42), nil
+}
+
+func newFakeReadSteps(results map[ReadStep]error) *fakeReadSteps {
+	return &fakeReadSteps{
+		now:      time.Unix(1000, 0),
+		results:  results,
+		timeouts: make(map[ReadStep]time.Duration),
+	}
+}
+
+func TestReadFallbackActiveTimeout(t *testing.T) {
+	f := newFakeReadSteps(map[ReadStep]error{
+		ReadActive:     ErrTimeout,
+		ReadReplica(1): ErrTimeout,
+		ReadLocalCache: errNotCached,
+	})
+	chain := ReadFallbackChain{ReadActive, ReadLocalCache, ReadReplica(1), ReadReplica(2)}
+
+	res, err := runReadFallback(chain, 3*time.Second, f.clock, f.exec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Step != ReadReplica(2) || res.Cas != 42 {
+		t.Fatalf("Expected to be served by Replica(2), got %+v", res)
+	}
+
+	// The local cache does not take a share of the budget, and each step which timed
+	// out leaves the remainder to the steps after it.
+	if f.timeouts[ReadActive] != time.Second || f.timeouts[ReadReplica(1)] != time.Second ||
+		f.timeouts[ReadReplica(2)] != time.Second {
+		t.Fatalf("Unexpected step timeouts %v", f.timeouts)
+	}
+}
+
+func TestReadFallbackAllFail(t *testing.T) {
+	f := newFakeReadSteps(map[ReadStep]error{
+		ReadActive:     ErrTimeout,
+		ReadAnyReplica: ErrNoReplicas,
+		ReadLocalCache: errNotCached,
+	})
+	chain := ReadFallbackChain{ReadActive, ReadAnyReplica, ReadLocalCache}
+
+	_, err := runReadFallback(chain, time.Second, f.clock, f.exec)
+	fallbackErr, ok := err.(*ReadFallbackError)
+	if !ok {
+		t.Fatalf("Expected a ReadFallbackError, got %v", err)
+	}
+	if ErrorCause(err) != ErrTimeout || fallbackErr.Err != ErrTimeout {
+		t.Fatalf("Expected the error of the first step, got %v", ErrorCause(err))
+	}
+	if len(fallbackErr.Steps) != 3 || fallbackErr.Steps[1].Err != ErrNoReplicas ||
+		fallbackErr.Steps[2].Step != ReadLocalCache {
+		t.Fatalf("Unexpected step errors %v", fallbackErr.Steps)
+	}
+}
+
+func TestReadFallbackBudgetExhausted(t *testing.T) {
+	f := newFakeReadSteps(map[ReadStep]error{
+		ReadActive: ErrTimeout,
+	})
+	chain := ReadFallbackChain{ReadActive}
+
+	// A single step receives the whole budget, and its error is returned directly.
+	_, err := runReadFallback(chain, time.Second, f.clock, f.exec)
+	if err != ErrTimeout || f.timeouts[ReadActive] != time.Second {
+		t.Fatalf("Expected ErrTimeout after 1s, got %v after %v", err, f.timeouts[ReadActive])
+	}
+
+	// Steps after the deadline fail without being attempted.
+	f = newFakeReadSteps(map[ReadStep]error{ReadActive: ErrTimeout})
+	_, err = runReadFallback(ReadFallbackChain{ReadActive, ReadReplica(1)}, 0, f.clock, f.exec)
+	if _, attempted := f.timeouts[ReadReplica(1)]; attempted || ErrorCause(err) != ErrTimeout {
+		t.Fatalf("Expected no steps to be attempted, got %v", f.timeouts)
+	}
+}
+
+func TestReadFallbackActiveNotFound(t *testing.T) {
+	f := newFakeReadSteps(map[ReadStep]error{
+		ReadActive: ErrKeyNotFound,
+	})
+	chain := ReadFallbackChain{ReadActive, ReadAnyReplica}
+
+	_, err := runReadFallback(chain, time.Second, f.clock, f.exec)
+	if err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, attempted := f.timeouts[ReadAnyReplica]; attempted {
+		t.Fatalf("Expected replicas not to be read for a missing document")
+	}
+}
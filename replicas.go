@@ -0,0 +1,138 @@
+package gocb
+
+import (
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sync"
+	"time"
+)
+
+// ReplicaResult describes a copy of a document read by GetAllReplicas.
+//
+// Experimental: This API is subject to change at any time.
+type ReplicaResult struct {
+	// Cas is the CAS of the copy of the document.
+	Cas Cas
+	// IsReplica is false when the copy was read from the active vbucket, and true when
+	// it was read from a replica vbucket.
+	IsReplica bool
+	// ReplicaIdx is the index of the replica the copy was read from, where the first
+	// replica is replica 1, or 0 for the active.
+	ReplicaIdx int
+}
+
+type replicaRead struct {
+	result ReplicaResult
+	value  archivedValue
+	err    error
+}
+
+// ReplicaResults streams the copies of a document read by GetAllReplicas, in the order
+// in which they are received.
+//
+// Experimental: This API is subject to change at any time.
+type ReplicaResults struct {
+	transcoder Transcoder
+	reads      chan replicaRead
+	errs       MultiError
+	found      bool
+	err        error
+}
+
+// Next waits for the next copy of the document to be received and decodes it into
+// valuePtr, returning false once every copy has been received.  Copies which could not
+// be read are skipped, and their errors are available from Close.
+func (r *ReplicaResults) Next(valuePtr interface{}) (ReplicaResult, bool) {
+	if r.err != nil {
+		return ReplicaResult{}, false
+	}
+	for read := range r.reads {
+		if read.err != nil {
+			r.errs.add(read.err)
+			continue
+		}
+		if err := r.transcoder.Decode(read.value.bytes, read.value.flags, valuePtr); err != nil {
+			r.err = err
+			return ReplicaResult{}, false
+		}
+		r.found = true
+		return read.result, true
+	}
+	return ReplicaResult{}, false
+}
+
+// Close waits for any reads which are still in progress, discarding their copies.  It
+// returns an error if a copy could not be decoded, or if no copy of the document could
+// be read, in which case the errors of every read are returned.
+func (r *ReplicaResults) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	for read := range r.reads {
+		if read.err != nil {
+			r.errs.add(read.err)
+		} else {
+			r.found = true
+		}
+	}
+	if r.found {
+		return nil
+	}
+	return r.errs.get()
+}
+
+// runAllReplicas performs read for the active and each of the replicas at once,
+// streaming their outcomes.
+func runAllReplicas(replicas []int, transcoder Transcoder, read func(replicaIdx int) (archivedValue, Cas, error)) *ReplicaResults {
+	reads := make(chan replicaRead, len(replicas)+1)
+	var pending sync.WaitGroup
+	for _, replicaIdx := range append([]int{0}, replicas...) {
+		pending.Add(1)
+		go func(replicaIdx int) {
+			defer pending.Done()
+			value, cas, err := read(replicaIdx)
+			reads <- replicaRead{
+				result: ReplicaResult{Cas: cas, IsReplica: replicaIdx != 0, ReplicaIdx: replicaIdx},
+				value:  value,
+				err:    err,
+			}
+		}(replicaIdx)
+	}
+	go func() {
+		pending.Wait()
+		close(reads)
+	}()
+
+	return &ReplicaResults{
+		transcoder: transcoder,
+		reads:      reads,
+	}
+}
+
+// GetAllReplicas reads a document from its active and every one of its replicas at
+// once, streaming each copy as it is received.  This allows a document to be read
+// while some of the nodes holding it are unavailable, and the first copy received to
+// be used to reduce latency, at the cost that replicas may hold stale versions of the
+// document.  Replicas held by drained nodes are not read, unless every replica is.
+// Each read is subject to the operation timeout of the bucket.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) GetAllReplicas(key string) *ReplicaResults {
+	raw := *b
+	raw.transcoder = archiveTranscoder{}
+
+	return runAllReplicas(b.replicaOrder(key), b.transcoder, func(replicaIdx int) (archivedValue, Cas, error) {
+		start := time.Now()
+		var value archivedValue
+		var cas Cas
+		var err error
+		if replicaIdx == 0 {
+			cas, err = raw.hlpGetExecIdempotent(nil, key, &value, func(cb ioGetCallback) (pendingOp, error) {
+				op, err := raw.client.Get([]byte(key), gocbcore.GetCallback(cb))
+				return op, err
+			})
+		} else {
+			cas, err = raw.getReplica(nil, key, &value, replicaIdx)
+		}
+		return value, cas, b.wrapError(err, "GetAllReplicas", key, start)
+	})
+}
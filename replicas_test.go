@@ -0,0 +1,51 @@
+package gocb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAllReplicasStreamsCopies(t *testing.T) {
+	transcoder := DefaultTranscoder{}
+	results := runAllReplicas([]int{1, 2}, transcoder, func(replicaIdx int) (archivedValue, Cas, error) {
+		switch replicaIdx {
+		case 0:
+			// The active is slow to respond, so the replica is received first.
+			time.Sleep(20 * time.Millisecond)
+		case 2:
+			return archivedValue{}, 0, ErrTimeout
+		}
+		bytes, flags, err := transcoder.Encode(map[string]int{"replica": replicaIdx})
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		return archivedValue{bytes: bytes, flags: flags}, Cas(100 + replicaIdx), nil
+	})
+
+	var value map[string]int
+	first, ok := results.Next(&value)
+	if !ok || !first.IsReplica || first.ReplicaIdx != 1 || first.Cas != 101 || value["replica"] != 1 {
+		t.Fatalf("Expected the replica copy first, got %+v %v", first, value)
+	}
+	second, ok := results.Next(&value)
+	if !ok || second.IsReplica || second.ReplicaIdx != 0 || value["replica"] != 0 {
+		t.Fatalf("Expected the active copy second, got %+v %v", second, value)
+	}
+	if _, ok := results.Next(&value); ok {
+		t.Fatalf("Expected the failed replica to be skipped")
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Expected no error when a copy was read, got %v", err)
+	}
+}
+
+func TestGetAllReplicasAllFailed(t *testing.T) {
+	results := runAllReplicas([]int{1}, DefaultTranscoder{}, func(replicaIdx int) (archivedValue, Cas, error) {
+		return archivedValue{}, 0, ErrTimeout
+	})
+	if err := results.Close(); err == nil {
+		t.Fatalf("Expected an error when no copy could be read")
+	} else if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 2 {
+		t.Fatalf("Expected the errors of both reads, got %v", err)
+	}
+}
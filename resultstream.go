@@ -0,0 +1,175 @@
+package gocb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// resultStream reads a query response whose rows are held in an array under rowsKey.
+// The response is read up to and including its first row before the results are
+// returned, so that a caller only interested in the first row can abort the rest of
+// the response rather than have it transferred.
+type resultStream struct {
+	body    io.ReadCloser
+	cancel  func()
+	dec     *json.Decoder
+	rowsKey string
+	fields  map[string]json.RawMessage
+	rows    []json.RawMessage
+	inRows  bool
+	done    bool
+	aborted bool
+	// rowFilter, if set, replaces each row as it is read, such as to discard the parts
+	// of it which are not needed.
+	rowFilter func(row json.RawMessage) (json.RawMessage, error)
+}
+
+func newResultStream(body io.ReadCloser, cancel func(), rowsKey string) *resultStream {
+	return &resultStream{
+		body:    body,
+		cancel:  cancel,
+		dec:     json.NewDecoder(body),
+		rowsKey: rowsKey,
+		fields:  make(map[string]json.RawMessage),
+	}
+}
+
+// readFirstRow reads the response until its first row has been read, or until the end
+// of the response if it contains no rows.
+func (s *resultStream) readFirstRow() error {
+	if _, err := s.dec.Token(); err != nil {
+		return err
+	}
+	return s.read(true)
+}
+
+// nextRow reads the next row of the response without retaining it, returning nil once
+// every row has been read, by which point the rest of the response has been read too.
+func (s *resultStream) nextRow() (json.RawMessage, error) {
+	if s.inRows {
+		if s.dec.More() {
+			return s.decodeRow()
+		}
+		if _, err := s.dec.Token(); err != nil {
+			return nil, err
+		}
+		s.inRows = false
+	}
+	if s.done {
+		return nil, nil
+	}
+	return nil, s.read(false)
+}
+
+// decodeRow decodes the next row of the response, passing it through the row filter.
+func (s *resultStream) decodeRow() (json.RawMessage, error) {
+	var row json.RawMessage
+	if err := s.dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	if s.rowFilter != nil {
+		return s.rowFilter(row)
+	}
+	return row, nil
+}
+
+func (s *resultStream) read(stopAtRow bool) error {
+	if s.inRows {
+		for s.dec.More() {
+			row, err := s.decodeRow()
+			if err != nil {
+				return err
+			}
+			s.rows = append(s.rows, row)
+		}
+		if _, err := s.dec.Token(); err != nil {
+			return err
+		}
+		s.inRows = false
+	}
+
+	for s.dec.More() {
+		keyToken, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		key, _ := keyToken.(string)
+		if key != s.rowsKey {
+			var field json.RawMessage
+			if err := s.dec.Decode(&field); err != nil {
+				return err
+			}
+			s.fields[key] = field
+			continue
+		}
+
+		rowsToken, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		if rowsToken == nil {
+			// The rows are null rather than an empty array.
+			continue
+		}
+		if stopAtRow && s.dec.More() {
+			row, err := s.decodeRow()
+			if err != nil {
+				return err
+			}
+			s.rows = append(s.rows, row)
+			s.inRows = true
+			return nil
+		}
+		s.inRows = true
+		return s.read(false)
+	}
+
+	if _, err := s.dec.Token(); err != nil {
+		return err
+	}
+	s.done = true
+	s.close()
+	return nil
+}
+
+// decodeFields decodes every field of the response other than its rows into valuePtr.
+func (s *resultStream) decodeFields(valuePtr interface{}) error {
+	var obj bytes.Buffer
+	obj.WriteByte('{')
+	for key, field := range s.fields {
+		if obj.Len() > 1 {
+			obj.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		obj.Write(encodedKey)
+		obj.WriteByte(':')
+		obj.Write(field)
+	}
+	obj.WriteByte('}')
+	return json.Unmarshal(obj.Bytes(), valuePtr)
+}
+
+// abort stops the rest of the response from being transferred.
+func (s *resultStream) abort() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.aborted = true
+	s.close()
+}
+
+func (s *resultStream) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	err := s.body.Close()
+	if err != nil {
+		logDebugf("Failed to close socket (%s)", err)
+	}
+}
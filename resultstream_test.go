@@ -0,0 +1,270 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestN1qlOneAbortsStream(t *testing.T) {
+	aborted := make(chan struct{})
+	cancelled := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		var opts map[string]interface{}
+		json.Unmarshal(body, &opts)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(opts["statement"].(string), "system:active_requests") {
+			cancelled <- opts["args"].([]interface{})[0].(string)
+			fmt.Fprint(w, `{"requestID":"cancel","results":[],"status":"success","metrics":{}}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"requestID":"req1","clientContextID":"%s","results":[{"n":0}`, opts["client_context_id"])
+		w.(http.Flusher).Flush()
+		for i := 1; ; i++ {
+			select {
+			case <-req.Context().Done():
+				close(aborted)
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			if _, err := fmt.Fprintf(w, `,{"n":%d}`, i); err != nil {
+				close(aborted)
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	opts := map[string]interface{}{"statement": "SELECT n FROM huge"}
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, opts, nil, 5*time.Second, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	var row map[string]int
+	if err := results.One(&row); err != nil || row["n"] != 0 {
+		t.Fatalf("Expected the first row, got %v (%v)", row, err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Expected the response to be aborted after the first row")
+	}
+
+	select {
+	case clientContextId := <-cancelled:
+		if clientContextId == "" || clientContextId != opts["client_context_id"] {
+			t.Fatalf("Expected the query to be cancelled by its client context id, got %q", clientContextId)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Expected the query to be cancelled on the server")
+	}
+
+	if results.RequestId() != "req1" {
+		t.Fatalf("Expected the request id to be available, got %s", results.RequestId())
+	}
+	if !reflect.DeepEqual(results.Metrics(), QueryResultMetrics{}) {
+		t.Fatalf("Expected no metrics for aborted results, got %+v", results.Metrics())
+	}
+	if err := results.(ResultMetadataErr).Err(); err != ErrAborted {
+		t.Fatalf("Expected Err to report ErrAborted, got %v", err)
+	}
+}
+
+func TestN1qlStreamLateErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"requestID":"req1","results":[{"n":0},{"n":1}],"errors":[{"code":5000,"msg":"failed"}],"status":"errors","metrics":{}}`)
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, map[string]interface{}{"statement": "SELECT 1"}, nil, 5*time.Second, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	var row map[string]int
+	count := 0
+	for results.Next(&row) {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("Expected both rows, got %d", count)
+	}
+	if _, ok := results.Close().(*n1qlMultiError); !ok {
+		t.Fatalf("Expected the errors following the rows to be returned by Close")
+	}
+}
+
+func TestViewOneAbortsStream(t *testing.T) {
+	body, writer := io.Pipe()
+	go fmt.Fprint(writer, `{"total_rows":1000,"rows":[{"id":"a"}`)
+
+	results, err := readViewStream(newResultStream(body, nil, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read the first row: %v", err)
+	}
+
+	var row map[string]string
+	if err := results.One(&row); err != nil || row["id"] != "a" {
+		t.Fatalf("Expected the first row, got %v (%v)", row, err)
+	}
+	if _, err := fmt.Fprint(writer, `,{"id":"b"}`); err != io.ErrClosedPipe {
+		t.Fatalf("Expected the response to be closed, got %v", err)
+	}
+	if results.TotalRows() != 1000 {
+		t.Fatalf("Expected the total rows received before the abort, got %d", results.TotalRows())
+	}
+	if err := results.Err(); err != nil {
+		t.Fatalf("Expected no error once the total rows were received, got %v", err)
+	}
+}
+
+func TestViewOneAbortsStreamBeforeTotalRows(t *testing.T) {
+	body, writer := io.Pipe()
+	go fmt.Fprint(writer, `{"rows":[{"id":"a"}`)
+
+	results, err := readViewStream(newResultStream(body, nil, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read the first row: %v", err)
+	}
+
+	var row map[string]string
+	if err := results.One(&row); err != nil || row["id"] != "a" {
+		t.Fatalf("Expected the first row, got %v (%v)", row, err)
+	}
+	if results.TotalRows() != 0 {
+		t.Fatalf("Expected no total rows for aborted results, got %d", results.TotalRows())
+	}
+	if err := results.Err(); err != ErrAborted {
+		t.Fatalf("Expected Err to report ErrAborted, got %v", err)
+	}
+}
+
+func TestViewStreamRows(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(
+		`{"total_rows":3,"rows":[{"id":"a"},{"id":"b"},{"id":"c"}],"errors":[{"message":"partial","reason":"node down"}]}`))
+	results, err := readViewStream(newResultStream(body, nil, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read the first row: %v", err)
+	}
+
+	var ids []string
+	var row map[string]string
+	for results.Next(&row) {
+		ids = append(ids, row["id"])
+	}
+	if strings.Join(ids, ",") != "a,b,c" || results.TotalRows() != 3 {
+		t.Fatalf("Unexpected rows %v with total %d", ids, results.TotalRows())
+	}
+	if _, ok := results.Close().(*viewError); !ok {
+		t.Fatalf("Expected the errors following the rows to be returned by Close")
+	}
+}
+
+func TestViewStreamReadsRowsIncrementally(t *testing.T) {
+	body, writer := io.Pipe()
+	go fmt.Fprint(writer, `{"total_rows":3,"rows":[{"id":"a"}`)
+
+	results, err := readViewStream(newResultStream(body, nil, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read the first row: %v", err)
+	}
+
+	var row map[string]string
+	for _, id := range []string{"a", "b", "c"} {
+		if id != "a" {
+			go fmt.Fprintf(writer, `,{"id":"%s"}`, id)
+		}
+		if !results.Next(&row) || row["id"] != id {
+			t.Fatalf("Expected row %s before the response completed, got %v", id, row)
+		}
+		if rows := len(results.rows); rows > 1 {
+			t.Fatalf("Expected iterated rows to be released, %d are held", rows)
+		}
+	}
+
+	go fmt.Fprint(writer, `]}`)
+	if results.Next(&row) {
+		t.Fatalf("Expected no more rows, got %v", row)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to close results: %v", err)
+	}
+}
+
+func TestViewStreamRetainsRowsForCache(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(`{"total_rows":3,"rows":[{"id":"a"},{"id":"b"},{"id":"c"}]}`))
+	results, err := readViewStream(newResultStream(body, nil, "rows"), 200)
+	if err != nil {
+		t.Fatalf("Failed to read the first row: %v", err)
+	}
+	results.retainRows = true
+
+	var row map[string]string
+	results.Next(&row)
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to close results: %v", err)
+	}
+	if len(results.rows) != 3 {
+		t.Fatalf("Expected every row to be retained, got %d", len(results.rows))
+	}
+}
+
+func TestN1qlStreamReadsRowsIncrementally(t *testing.T) {
+	rows := make(chan string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"requestID":"req1","results":[{"n":0}`)
+		w.(http.Flusher).Flush()
+		for row := range rows {
+			fmt.Fprint(w, row)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, `],"status":"success","metrics":{"elapsedTime":"1ms","executionTime":"1ms","resultCount":3}}`)
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	results, err := c.executeN1qlQuery(context.Background(), server.URL, map[string]interface{}{"statement": "SELECT n"}, nil, 5*time.Second, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatalf("Failed to execute query: %v", err)
+	}
+
+	var row map[string]int
+	for n := 0; n < 3; n++ {
+		if n > 0 {
+			rows <- fmt.Sprintf(`,{"n":%d}`, n)
+		}
+		if !results.Next(&row) || row["n"] != n {
+			t.Fatalf("Expected row %d before the response completed, got %v", n, row)
+		}
+		if held := len(results.(*n1qlResults).rows); held > 1 {
+			t.Fatalf("Expected iterated rows to be released, %d are held", held)
+		}
+	}
+	close(rows)
+
+	if results.Next(&row) {
+		t.Fatalf("Expected no more rows, got %v", row)
+	}
+	if err := results.Close(); err != nil {
+		t.Fatalf("Failed to close results: %v", err)
+	}
+	if results.Metrics().ResultCount != 3 {
+		t.Fatalf("Expected metrics once closed, got %+v", results.Metrics())
+	}
+}
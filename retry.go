@@ -0,0 +1,190 @@
+package gocb
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffFn calculates the amount of time to wait before the specified retry
+// attempt, where the first retry of an operation is attempt zero.
+type BackoffFn func(retryAttempts uint32) time.Duration
+
+// ExponentialBackoff returns a BackoffFn which waits for min before the first
+// retry, multiplying the wait by factor on each subsequent retry until it
+// reaches max.
+func ExponentialBackoff(min, max time.Duration, factor float64) BackoffFn {
+	minDelay := float64(min)
+	maxDelay := float64(max)
+
+	return func(retryAttempts uint32) time.Duration {
+		delay := minDelay * math.Pow(factor, float64(retryAttempts))
+		if delay > maxDelay || math.IsInf(delay, 0) || math.IsNaN(delay) {
+			delay = maxDelay
+		}
+		if delay < minDelay {
+			delay = minDelay
+		}
+		return time.Duration(delay)
+	}
+}
+
+// FullJitter wraps a BackoffFn such that each wait is chosen uniformly at random
+// between zero and the wait calculated by the wrapped function.  This avoids many
+// clients which failed at the same time retrying in lock-step.
+func FullJitter(backoff BackoffFn) BackoffFn {
+	return func(retryAttempts uint32) time.Duration {
+		delay := backoff(retryAttempts)
+		if delay <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+}
+
+// RetryBudget limits the retries performed over the lifetime of a single logical
+// operation, regardless of the reason for each retry.  Zero values indicate that
+// there is no limit.
+type RetryBudget struct {
+	// MaxRetries is the maximum number of retries performed.
+	MaxRetries int
+	// MaxBackoff is the maximum total time spent waiting between retries.
+	MaxBackoff time.Duration
+}
+
+// RetryReport describes the retries which were performed during an operation.
+type RetryReport struct {
+	// Retries is the total number of retries performed.
+	Retries int
+	// Reasons is the number of retries performed for each reason.
+	Reasons map[string]int
+	// TotalBackoff is the total time spent waiting between retries.
+	TotalBackoff time.Duration
+	// BudgetExhausted indicates that a retry was abandoned because the retry budget
+	// of the operation had been consumed.
+	BudgetExhausted bool
+}
+
+// retryTracker records the retries of a single logical operation against its budget.
+// Every retry mechanism involved in the operation consults the same tracker, so the
+// budget is shared across all reasons for retrying.
+type retryTracker struct {
+	budget  RetryBudget
+	report  RetryReport
+	now     func() time.Time
+	onRetry func(reason string)
+}
+
+func newRetryTracker(budget RetryBudget) *retryTracker {
+	return &retryTracker{
+		budget: budget,
+		now:    time.Now,
+	}
+}
+
+// newClusterRetryTracker returns a tracker limited by the retry budget of the cluster,
+// which records every retry it allows in the metrics of the cluster.
+func newClusterRetryTracker(c *Cluster) *retryTracker {
+	var budget RetryBudget
+	if c != nil {
+		budget = c.retryBudget
+	}
+	tracker := newRetryTracker(budget)
+	tracker.onRetry = c.recordRetry
+	return tracker
+}
+
+// allow records a retry for the specified reason, returning false without recording
+// it if it would exceed the budget.
+func (t *retryTracker) allow(reason string, backoff time.Duration) bool {
+	if t.budget.MaxRetries > 0 && t.report.Retries >= t.budget.MaxRetries {
+		t.report.BudgetExhausted = true
+		return false
+	}
+	if t.budget.MaxBackoff > 0 && t.report.TotalBackoff+backoff > t.budget.MaxBackoff {
+		t.report.BudgetExhausted = true
+		return false
+	}
+
+	if t.report.Reasons == nil {
+		t.report.Reasons = make(map[string]int)
+	}
+	t.report.Retries++
+	t.report.Reasons[reason]++
+	t.report.TotalBackoff += backoff
+	if t.onRetry != nil {
+		t.onRetry(reason)
+	}
+	return true
+}
+
+// retryReport returns a copy of the report of the retries performed, or nil if no
+// retries were attempted.
+func (t *retryTracker) retryReport() *RetryReport {
+	if t.report.Retries == 0 && !t.report.BudgetExhausted {
+		return nil
+	}
+
+	report := t.report
+	report.Reasons = make(map[string]int)
+	for reason, count := range t.report.Reasons {
+		report.Reasons[reason] = count
+	}
+	return &report
+}
+
+// RetryWithBackoff invokes fn until it succeeds or returns an error for which
+// shouldRetry returns false, waiting between attempts as calculated by backoff.
+// Once ctx is done, or when the next wait would extend beyond the deadline of
+// ctx, the error from the last attempt is returned without waiting.
+func RetryWithBackoff(ctx context.Context, fn func() error, shouldRetry func(error) bool, backoff BackoffFn) error {
+	return retryWithTracker(ctx, newRetryTracker(RetryBudget{}), fn, retryWithBackoff("error", shouldRetry, backoff))
+}
+
+// retryWithBackoff returns a retryAfter function for retryWithTracker which retries
+// the errors shouldRetry accepts for reason, waiting as calculated by backoff.
+func retryWithBackoff(reason string, shouldRetry func(error) bool, backoff BackoffFn) func(error, uint32) (string, time.Duration, bool) {
+	return func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		if !shouldRetry(err) {
+			return "", 0, false
+		}
+		return reason, backoff(retryAttempts), true
+	}
+}
+
+// retryWithTracker behaves as RetryWithBackoff, additionally stopping once the retry
+// budget of the tracker has been consumed.  retryAfter returns the reason an error
+// should be retried for and how long to wait before retrying it, or false if it should
+// not be retried.  No attempt is made once no time would remain before the deadline of
+// ctx.
+func retryWithTracker(ctx context.Context, tracker *retryTracker, fn func() error, retryAfter func(err error, retryAttempts uint32) (string, time.Duration, bool)) error {
+	for retryAttempts := uint32(0); ; retryAttempts++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		reason, delay, shouldRetry := retryAfter(err, retryAttempts)
+		if !shouldRetry {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && !tracker.now().Add(delay).Before(deadline) {
+			return err
+		}
+		if ctx.Err() != nil || !tracker.allow(reason, delay) {
+			return err
+		}
+		if delay <= 0 {
+			continue
+		}
+
+		waitTmr := time.NewTimer(delay)
+		select {
+		case <-waitTmr.C:
+		case <-ctx.Done():
+			waitTmr.Stop()
+			return err
+		}
+	}
+}
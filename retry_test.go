@@ -0,0 +1,156 @@
+package gocb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 100*time.Millisecond, 2)
+
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	}
+	for i, delay := range expected {
+		if backoff(uint32(i)) != delay {
+			t.Errorf("Expected delay %v for attempt %d, got %v", delay, i, backoff(uint32(i)))
+		}
+	}
+
+	if backoff(5000) != 100*time.Millisecond {
+		t.Errorf("Expected very large attempt counts to be capped, got %v", backoff(5000))
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	backoff := FullJitter(ExponentialBackoff(10*time.Millisecond, 100*time.Millisecond, 2))
+
+	for i := 0; i < 1000; i++ {
+		delay := backoff(2)
+		if delay < 0 || delay > 40*time.Millisecond {
+			t.Fatalf("Jittered delay %v outside of the expected range", delay)
+		}
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	errRetry := errors.New("retry")
+	errFatal := errors.New("fatal")
+	shouldRetry := func(err error) bool {
+		return err == errRetry
+	}
+	backoff := ExponentialBackoff(time.Millisecond, time.Millisecond, 1)
+
+	attempts := 0
+	err := RetryWithBackoff(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return errRetry
+		}
+		return nil
+	}, shouldRetry, backoff)
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = RetryWithBackoff(context.Background(), func() error {
+		attempts++
+		return errFatal
+	}, shouldRetry, backoff)
+	if err != errFatal || attempts != 1 {
+		t.Fatalf("Expected no retries of a fatal error, got %v after %d", err, attempts)
+	}
+}
+
+// deadlineContext is a context which has a deadline but is never done, so that the
+// deadline is only ever compared against the clock of a retry tracker.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (ctx deadlineContext) Deadline() (time.Time, bool) {
+	return ctx.deadline, true
+}
+
+func TestRetryWithBackoffDeadline(t *testing.T) {
+	errRetry := errors.New("retry")
+	now := time.Unix(0, 0)
+	ctx := deadlineContext{context.Background(), now.Add(50 * time.Millisecond)}
+	tracker := newRetryTracker(RetryBudget{})
+	tracker.now = func() time.Time {
+		return now
+	}
+
+	// Every attempt takes 20ms of the deadline, and is followed by a wait of a further
+	// 20ms, so a third attempt would only be made after the deadline.
+	attempts := 0
+	err := retryWithTracker(ctx, tracker, func() error {
+		attempts++
+		now = now.Add(20 * time.Millisecond)
+		return errRetry
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		return "error", 20 * time.Millisecond, true
+	})
+	if err != errRetry {
+		t.Fatalf("Expected the last error once the deadline was reached, got %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("Expected 2 attempts within the deadline, got %d", attempts)
+	}
+}
+
+func TestRetryBudgetCapsMixedReasons(t *testing.T) {
+	errTmpFail := errors.New("temporary failure")
+	errNotMyVbucket := errors.New("not my vbucket")
+
+	attempts := 0
+	tracker := newRetryTracker(RetryBudget{MaxRetries: 3})
+	err := retryWithTracker(context.Background(), tracker, func() error {
+		attempts++
+		if attempts%2 == 0 {
+			return errNotMyVbucket
+		}
+		return errTmpFail
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		if err == errNotMyVbucket {
+			return "not_my_vbucket", time.Millisecond, true
+		}
+		return "tmpfail", time.Millisecond, true
+	})
+
+	if attempts != 4 {
+		t.Fatalf("Expected the budget to cap the operation at 4 attempts, got %d", attempts)
+	}
+	if err != errNotMyVbucket {
+		t.Fatalf("Expected the error of the final attempt, got %v", err)
+	}
+
+	report := tracker.retryReport()
+	if report.Retries != 3 || report.Reasons["tmpfail"] != 2 || report.Reasons["not_my_vbucket"] != 1 {
+		t.Fatalf("Unexpected retry report %+v", report)
+	}
+	if !report.BudgetExhausted || report.TotalBackoff != 3*time.Millisecond {
+		t.Fatalf("Unexpected retry report %+v", report)
+	}
+
+	attempts = 0
+	tracker = newRetryTracker(RetryBudget{MaxBackoff: 5 * time.Millisecond})
+	retryWithTracker(context.Background(), tracker, func() error {
+		attempts++
+		return errTmpFail
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		return "tmpfail", 2 * time.Millisecond, true
+	})
+	if attempts != 3 || !tracker.report.BudgetExhausted {
+		t.Fatalf("Expected the backoff budget to cap the operation at 3 attempts, got %d", attempts)
+	}
+}
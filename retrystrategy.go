@@ -0,0 +1,199 @@
+package gocb
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errServiceUnavailable is the error of an HTTP request which the service responded to
+// with status 503, while it is being retried.
+var errServiceUnavailable = errors.New("The service is unavailable.")
+
+// RetryReason identifies a failure which an operation may be retried for.
+type RetryReason string
+
+const (
+	// RetryReasonTemporaryFailure indicates that a memcached operation was rejected with
+	// ErrTmpFail or ErrBusy, meaning that it was not applied.
+	RetryReasonTemporaryFailure = RetryReason("kv_temporary_failure")
+	// RetryReasonServiceUnavailable indicates that a request to the query or view service
+	// was rejected with HTTP status 503, such as while the node was warming up.
+	RetryReasonServiceUnavailable = RetryReason("service_unavailable")
+)
+
+// RetryStrategy decides whether an operation which failed for a RetryReason is
+// retried, and how long to wait before doing so.  Retries are always limited by the
+// timeout of the operation and by the RetryBudget of the cluster.  Operations rejected
+// because the vbucket has moved to another node are retried by the underlying client
+// regardless of the strategy, once it has received the updated cluster map.
+//
+// Experimental: This API is subject to change at any time.
+type RetryStrategy interface {
+	// RetryAfter returns the time to wait before retrying an operation which has
+	// already been retried retryAttempts times, or false if it should not be retried.
+	RetryAfter(reason RetryReason, retryAttempts uint32) (time.Duration, bool)
+}
+
+// BestEffortRetryStrategy retries every RetryReason for as long as the timeout of the
+// operation allows.
+//
+// Experimental: This API is subject to change at any time.
+type BestEffortRetryStrategy struct {
+	backoff BackoffFn
+}
+
+// NewBestEffortRetryStrategy returns a BestEffortRetryStrategy which waits between
+// retries as calculated by backoff.  If backoff is nil, the wait grows exponentially
+// from 1ms to 500ms with full jitter.
+func NewBestEffortRetryStrategy(backoff BackoffFn) *BestEffortRetryStrategy {
+	if backoff == nil {
+		backoff = FullJitter(ExponentialBackoff(1*time.Millisecond, 500*time.Millisecond, 2))
+	}
+	return &BestEffortRetryStrategy{
+		backoff: backoff,
+	}
+}
+
+// RetryAfter returns the wait calculated by the backoff of the strategy.
+func (s *BestEffortRetryStrategy) RetryAfter(reason RetryReason, retryAttempts uint32) (time.Duration, bool) {
+	return s.backoff(retryAttempts), true
+}
+
+// RetryStrategy returns the strategy used to retry operations which fail for a
+// RetryReason, or nil if they are not retried.
+func (c *Cluster) RetryStrategy() RetryStrategy {
+	return c.retryStrategy
+}
+
+// SetRetryStrategy sets the strategy used to retry operations which fail for a
+// RetryReason.  By default such operations are not retried.  The strategy can be
+// overridden for individual operations through their options.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetRetryStrategy(strategy RetryStrategy) {
+	c.retryStrategy = strategy
+}
+
+// effectiveRetryStrategy returns the strategy used for an operation on the bucket,
+// which is that of the cluster unless the options of the operation override it.
+func (b *Bucket) effectiveRetryStrategy(opts *kvOpOptions) RetryStrategy {
+	if opts != nil && opts.retryStrategy != nil {
+		return opts.retryStrategy
+	}
+	if b.cluster != nil {
+		return b.cluster.retryStrategy
+	}
+	return nil
+}
+
+// retryKv performs a memcached operation, dispatching it again within the remaining
+// operation timeout whenever it fails with a temporary failure the retry strategy
+// allows retrying, or, if idempotent, whenever its connection was dropped.
+func (b *Bucket) retryKv(opts *kvOpOptions, key string, idempotent bool, fn func(opts *kvOpOptions) error) error {
+	networkRetry := idempotent && !b.disableNetworkRetry
+	strategy := b.effectiveRetryStrategy(opts)
+	if !networkRetry && strategy == nil {
+		return b.withKvCircuitBreaker(key, func() error { return fn(opts) })
+	}
+
+	deadline := time.Now().Add(b.kvTimeout(opts))
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// Each retry is given only what remains of the timeout once its backoff has elapsed.
+	tracker := newClusterRetryTracker(b.cluster)
+	attempts := 0
+	err := retryWithTracker(ctx, tracker, func() error {
+		attempt := opts
+		if attempts > 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return ErrTimeout
+			}
+			attempt = opts.withTimeout(remaining)
+		}
+		attempts++
+		return b.withKvCircuitBreaker(key, func() error { return fn(attempt) })
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		switch cause := ErrorCause(err); {
+		case networkRetry && cause == ErrNetwork:
+			return "kv_network", networkRetryBackoff(retryAttempts), true
+		case strategy != nil && (cause == ErrTmpFail || cause == ErrBusy):
+			delay, retry := strategy.RetryAfter(RetryReasonTemporaryFailure, retryAttempts)
+			return string(RetryReasonTemporaryFailure), delay, retry
+		}
+		return "", 0, false
+	})
+	return withRetryReport(err, tracker)
+}
+
+// doHttpWithRetry performs an HTTP request, sending it again within its timeout
+// whenever the service responds with status 503 and the retry strategy allows retrying.
+// The request must have been created with a body which can be replayed.  The retries
+// are limited by and reported in the tracker of the operation performing the request,
+// or in a new tracker for the request if tracker is nil.
+func (c *Cluster) doHttpWithRetry(tracker *retryTracker, strategy RetryStrategy, cli *http.Client, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if strategy == nil {
+		return doHttpWithTimeout(cli, req, timeout)
+	}
+	if tracker == nil {
+		tracker = newClusterRetryTracker(c)
+	}
+
+	ctx := req.Context()
+	start := time.Now()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(timeout))
+		defer cancel()
+	}
+
+	var resp *http.Response
+	err := retryWithTracker(ctx, tracker, func() error {
+		if resp != nil {
+			if err := resp.Body.Close(); err != nil {
+				logDebugf("Failed to close socket (%s)", err)
+			}
+			resp = nil
+
+			retryReq := req.WithContext(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				retryReq.Body = body
+			}
+			req = retryReq
+		}
+
+		remaining := timeout
+		if timeout > 0 {
+			remaining = timeout - time.Since(start)
+		}
+		var err error
+		resp, err = doHttpWithTimeout(cli, req, remaining)
+		if err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+			return errServiceUnavailable
+		}
+		return err
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		if err != errServiceUnavailable || (req.Body != nil && req.GetBody == nil) {
+			return "", 0, false
+		}
+		delay, retry := strategy.RetryAfter(RetryReasonServiceUnavailable, retryAttempts)
+		return string(RetryReasonServiceUnavailable), delay, retry
+	})
+	if err != errServiceUnavailable {
+		return resp, err
+	}
+	if err := req.Context().Err(); err != nil {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
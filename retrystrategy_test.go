@@ -0,0 +1,164 @@
+package gocb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryStrategyRetriesTemporaryFailures(t *testing.T) {
+	c := &Cluster{}
+	b := &Bucket{cluster: c, name: "default", opTimeout: time.Second}
+
+	attempts := 0
+	err := b.retryKv(nil, "", false, func(attempt *kvOpOptions) error {
+		attempts++
+		return ErrTmpFail
+	})
+	if err != ErrTmpFail || attempts != 1 {
+		t.Fatalf("Expected no retries without a strategy, got %d attempts (%v)", attempts, err)
+	}
+
+	c.SetRetryStrategy(NewBestEffortRetryStrategy(func(retryAttempts uint32) time.Duration {
+		return time.Millisecond
+	}))
+	attempts = 0
+	err = b.retryKv(nil, "", false, func(attempt *kvOpOptions) error {
+		attempts++
+		if attempts < 3 {
+			return ErrBusy
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success after 3 attempts, got %d attempts (%v)", attempts, err)
+	}
+
+	// Network errors are only retried for idempotent operations.
+	attempts = 0
+	err = b.retryKv(nil, "", false, func(attempt *kvOpOptions) error {
+		attempts++
+		return ErrNetwork
+	})
+	if err != ErrNetwork || attempts != 1 {
+		t.Fatalf("Expected a single attempt failing with ErrNetwork, got %d (%v)", attempts, err)
+	}
+}
+
+func TestRetryStrategyLimitsRetriesToRemainingTimeout(t *testing.T) {
+	c := &Cluster{}
+	c.SetRetryStrategy(NewBestEffortRetryStrategy(func(retryAttempts uint32) time.Duration {
+		return 50 * time.Millisecond
+	}))
+	b := &Bucket{cluster: c, name: "default", opTimeout: 500 * time.Millisecond}
+
+	var timeouts []time.Duration
+	err := b.retryKv(nil, "", false, func(attempt *kvOpOptions) error {
+		timeouts = append(timeouts, b.kvTimeout(attempt))
+		if len(timeouts) < 3 {
+			return ErrTmpFail
+		}
+		return nil
+	})
+	if err != nil || len(timeouts) != 3 {
+		t.Fatalf("Expected success after 3 attempts, got %d attempts (%v)", len(timeouts), err)
+	}
+	if timeouts[0] != 500*time.Millisecond {
+		t.Fatalf("Expected the first attempt to use the full timeout, got %s", timeouts[0])
+	}
+	// The backoff before each retry is deducted from the timeout of that retry.
+	if timeouts[1] > 450*time.Millisecond || timeouts[2] > 400*time.Millisecond {
+		t.Fatalf("Expected retries to be limited to the remaining timeout, got %v", timeouts)
+	}
+}
+
+type neverRetryStrategy struct {
+}
+
+func (s neverRetryStrategy) RetryAfter(reason RetryReason, retryAttempts uint32) (time.Duration, bool) {
+	return 0, false
+}
+
+func TestRetryStrategyOperationOverride(t *testing.T) {
+	c := &Cluster{}
+	c.SetRetryStrategy(NewBestEffortRetryStrategy(nil))
+	b := &Bucket{cluster: c, opTimeout: time.Second}
+
+	attempts := 0
+	err := b.retryKv(newKvOpOptions(PriorityNormal, neverRetryStrategy{}, nil, 0), "", false, func(attempt *kvOpOptions) error {
+		attempts++
+		return ErrTmpFail
+	})
+	if ErrorCause(err) != ErrTmpFail || attempts != 1 {
+		t.Fatalf("Expected the operation strategy to prevent retries, got %d attempts (%v)", attempts, err)
+	}
+	if _, ok := b.effectiveRetryStrategy(newKvOpOptions(PriorityNormal, nil, nil, 0)).(*BestEffortRetryStrategy); !ok {
+		t.Fatalf("Expected no override without a strategy")
+	}
+}
+
+func TestRetryStrategyRetriesServiceUnavailable(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(data))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	strategy := NewBestEffortRetryStrategy(func(retryAttempts uint32) time.Duration {
+		return time.Millisecond
+	})
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString("statement"))
+	resp, err := c.doHttpWithRetry(nil, strategy, http.DefaultClient, req, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || len(bodies) != 3 || bodies[2] != "statement" {
+		t.Fatalf("Expected the request to be replayed until it succeeded, got %d after %v", resp.StatusCode, bodies)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	bodies = nil
+	resp, err = c.doHttpWithRetry(nil, nil, http.DefaultClient, req, time.Second)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || len(bodies) != 1 {
+		t.Fatalf("Expected no retries without a strategy, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestServiceUnavailableRetriesShareOperationTracker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := &Cluster{}
+	strategy := NewBestEffortRetryStrategy(func(retryAttempts uint32) time.Duration {
+		return time.Millisecond
+	})
+	tracker := newRetryTracker(RetryBudget{MaxRetries: 3})
+	tracker.allow("n1ql_connect", 0)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := c.doHttpWithRetry(tracker, strategy, http.DefaultClient, req, time.Second)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the final 503 response, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	report := tracker.retryReport()
+	if report.Retries != 3 || report.Reasons["service_unavailable"] != 2 || !report.BudgetExhausted {
+		t.Fatalf("Expected the retries to be limited by and reported in the operation tracker, got %+v", report)
+	}
+}
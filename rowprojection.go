@@ -0,0 +1,325 @@
+package gocb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RowProjectionError occurs when a row cannot be projected because it is not valid JSON.
+type RowProjectionError struct {
+	Offset int
+	Reason string
+}
+
+func (e *RowProjectionError) Error() string {
+	return fmt.Sprintf("Malformed JSON row at offset %d: %s", e.Offset, e.Reason)
+}
+
+// rowProjection extracts the values at a set of JSON pointers from rows in a single
+// pass, skipping over everything else without decoding it.
+type rowProjection struct {
+	paths []string
+	keys  [][]byte
+	root  *projectionNode
+}
+
+// projectionNode is a step of one or more JSON pointers.  Index identifies the pointer
+// which ends at this node, or is -1 if only longer pointers pass through it.
+type projectionNode struct {
+	index    int
+	children map[string]*projectionNode
+}
+
+// newRowProjection compiles a set of JSON pointers, as described by RFC 6901.
+func newRowProjection(paths []string) (*rowProjection, error) {
+	p := &rowProjection{
+		paths: paths,
+		root:  &projectionNode{index: -1},
+	}
+	for i, path := range paths {
+		tokens, err := parseJsonPointer(path)
+		if err != nil {
+			return nil, err
+		}
+
+		node := p.root
+		for _, token := range tokens {
+			child := node.children[token]
+			if child == nil {
+				if node.children == nil {
+					node.children = make(map[string]*projectionNode)
+				}
+				child = &projectionNode{index: -1}
+				node.children[token] = child
+			}
+			node = child
+		}
+		node.index = i
+
+		key, _ := json.Marshal(path)
+		p.keys = append(p.keys, key)
+	}
+	return p, nil
+}
+
+func parseJsonPointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("Invalid JSON pointer '%s', pointers must begin with '/'", path)
+	}
+
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// project returns a JSON object holding the value at each pointer of the projection,
+// keyed by the pointer.  Values which are absent from the row are null.
+func (p *rowProjection) project(row []byte) ([]byte, error) {
+	values := make([][]byte, len(p.paths))
+	s := jsonScanner{data: row}
+	if err := s.scanValue(p.root, values); err != nil {
+		return nil, err
+	}
+	s.skipSpace()
+	if s.pos != len(s.data) {
+		return nil, s.errorf("unexpected data after the end of the row")
+	}
+
+	size := 2
+	for i, key := range p.keys {
+		size += len(key) + len(values[i]) + 6
+	}
+	out := make([]byte, 0, size)
+	out = append(out, '{')
+	for i, key := range p.keys {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, key...)
+		out = append(out, ':')
+		if values[i] == nil {
+			out = append(out, "null"...)
+		} else {
+			out = append(out, values[i]...)
+		}
+	}
+	return append(out, '}'), nil
+}
+
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) errorf(format string, args ...interface{}) error {
+	return &RowProjectionError{Offset: s.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// scanValue scans the value at the current position, recording it if a pointer ends at
+// node and descending into it if longer pointers pass through node.
+func (s *jsonScanner) scanValue(node *projectionNode, values [][]byte) error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return s.errorf("unexpected end of row")
+	}
+
+	start := s.pos
+	var err error
+	if node != nil && len(node.children) > 0 && s.data[s.pos] == '{' {
+		err = s.scanObject(node, values)
+	} else if node != nil && len(node.children) > 0 && s.data[s.pos] == '[' {
+		err = s.scanArray(node, values)
+	} else {
+		err = s.skipValue()
+	}
+	if err != nil {
+		return err
+	}
+
+	if node != nil && node.index >= 0 {
+		values[node.index] = s.data[start:s.pos]
+	}
+	return nil
+}
+
+// scanObject scans the object at the current position, as scanValue does.  A nil node
+// skips the object, checking only that it is well formed.
+func (s *jsonScanner) scanObject(node *projectionNode, values [][]byte) error {
+	s.pos++
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == '}' {
+		s.pos++
+		return nil
+	}
+
+	for {
+		s.skipSpace()
+		if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+			return s.errorf("expected an object key")
+		}
+		keyStart := s.pos
+		escaped, err := s.skipString()
+		if err != nil {
+			return err
+		}
+
+		var child *projectionNode
+		if node != nil && escaped {
+			var key string
+			if err := json.Unmarshal(s.data[keyStart:s.pos], &key); err != nil {
+				return s.errorf("invalid object key")
+			}
+			child = node.children[key]
+		} else if node != nil {
+			child = node.children[string(s.data[keyStart+1:s.pos-1])]
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.data) || s.data[s.pos] != ':' {
+			return s.errorf("expected ':' after an object key")
+		}
+		s.pos++
+
+		if err := s.scanValue(child, values); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return s.errorf("unexpected end of row")
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return nil
+		default:
+			return s.errorf("expected ',' or '}' in an object")
+		}
+	}
+}
+
+// scanArray scans the array at the current position, as scanValue does.  A nil node
+// skips the array, checking only that it is well formed.
+func (s *jsonScanner) scanArray(node *projectionNode, values [][]byte) error {
+	s.pos++
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == ']' {
+		s.pos++
+		return nil
+	}
+
+	for i := 0; ; i++ {
+		var child *projectionNode
+		if node != nil {
+			child = node.children[strconv.Itoa(i)]
+		}
+		if err := s.scanValue(child, values); err != nil {
+			return err
+		}
+
+		s.skipSpace()
+		if s.pos >= len(s.data) {
+			return s.errorf("unexpected end of row")
+		}
+		switch s.data[s.pos] {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		default:
+			return s.errorf("expected ',' or ']' in an array")
+		}
+	}
+}
+
+// skipString skips the string at the current position, returning whether it contains
+// any escape sequences.
+func (s *jsonScanner) skipString() (bool, error) {
+	escaped := false
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\\':
+			escaped = true
+			s.pos++
+		case '"':
+			s.pos++
+			return escaped, nil
+		}
+	}
+	return false, s.errorf("unterminated string")
+}
+
+var (
+	jsonTrue  = []byte("true")
+	jsonFalse = []byte("false")
+	jsonNull  = []byte("null")
+)
+
+// skipValue skips the value at the current position, checking that objects and arrays
+// are well formed and that the value is made up of valid tokens.
+func (s *jsonScanner) skipValue() error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return s.errorf("unexpected end of row")
+	}
+
+	switch c := s.data[s.pos]; {
+	case c == '{':
+		return s.scanObject(nil, nil)
+	case c == '[':
+		return s.scanArray(nil, nil)
+	case c == '"':
+		_, err := s.skipString()
+		return err
+	case c == '-' || (c >= '0' && c <= '9'):
+		s.skipNumber()
+		return nil
+	case c == 't':
+		return s.skipLiteral(jsonTrue)
+	case c == 'f':
+		return s.skipLiteral(jsonFalse)
+	case c == 'n':
+		return s.skipLiteral(jsonNull)
+	}
+	return s.errorf("unexpected '%c'", s.data[s.pos])
+}
+
+func (s *jsonScanner) skipNumber() {
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch c := s.data[s.pos]; {
+		case c >= '0' && c <= '9', c == '.', c == 'e', c == 'E', c == '+', c == '-':
+		default:
+			return
+		}
+	}
+}
+
+func (s *jsonScanner) skipLiteral(literal []byte) error {
+	if !bytes.HasPrefix(s.data[s.pos:], literal) {
+		return s.errorf("invalid literal")
+	}
+	s.pos += len(literal)
+	return nil
+}
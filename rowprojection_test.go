@@ -0,0 +1,162 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRowProjection(t *testing.T) {
+	projection, err := newRowProjection([]string{"/id", "/doc/name", "/doc/tags/1", "/a~1b", "/missing", "/doc/name/first"})
+	if err != nil {
+		t.Fatalf("Failed to compile projection: %v", err)
+	}
+
+	row := []byte(` {"skip": [1, {"x": "}"}, -2.5e3, true, null], "id": "doc\"1",
+		"doc": {"name": {"first": "ann"}, "tags": ["a", "b"]}, "a/b": false} `)
+	projected, err := projection.project(row)
+	if err != nil {
+		t.Fatalf("Failed to project row: %v", err)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(projected, &values); err != nil {
+		t.Fatalf("Projected row is not valid JSON: %v (%s)", err, projected)
+	}
+	expected := map[string]interface{}{
+		"/id":             `doc"1`,
+		"/doc/name":       map[string]interface{}{"first": "ann"},
+		"/doc/tags/1":     "b",
+		"/a~1b":           false,
+		"/missing":        nil,
+		"/doc/name/first": "ann",
+	}
+	if fmt.Sprint(values) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, values)
+	}
+
+	var target struct {
+		ID   string `json:"/id"`
+		Name struct {
+			First string `json:"first"`
+		} `json:"/doc/name"`
+	}
+	if err := json.Unmarshal(projected, &target); err != nil || target.ID != `doc"1` || target.Name.First != "ann" {
+		t.Fatalf("Failed to decode projected row into a struct: %v (%+v)", err, target)
+	}
+}
+
+func TestRowProjectionEscapedKeys(t *testing.T) {
+	projection, err := newRowProjection([]string{"/na\"me", ""})
+	if err != nil {
+		t.Fatalf("Failed to compile projection: %v", err)
+	}
+
+	projected, err := projection.project([]byte(`{"na\"me":1,"name":2}`))
+	if err != nil {
+		t.Fatalf("Failed to project row: %v", err)
+	}
+	expected := `{"/na\"me":1,"":{"na\"me":1,"name":2}}`
+	if string(projected) != expected {
+		t.Fatalf("Expected %s, got %s", expected, projected)
+	}
+}
+
+func TestRowProjectionMalformed(t *testing.T) {
+	projection, _ := newRowProjection([]string{"/a"})
+	rows := []string{
+		`{"a": 1`,
+		`{"b": [1, 2}, "a": 1}`,
+		`{"b": "unterminated, "a": 1}`,
+		`{"a": tru}`,
+		`{"a" 1}`,
+		`{"a": 1} trailing`,
+		``,
+		// Separators within skipped values are checked too.
+		`{"b": [1 2], "a": 1}`,
+		`{"b": {"c" "d"}, "a": 1}`,
+		`{"b": {"c": 1 "d": 2}, "a": 1}`,
+		`{"b": [1,, 2], "a": 1}`,
+		`{"b": {"c": 1,}, "a": 1}`,
+		`{"b": {1: 2}, "a": 1}`,
+		`{"b": [:], "a": 1}`,
+	}
+	for _, row := range rows {
+		if _, err := projection.project([]byte(row)); err == nil {
+			t.Fatalf("Expected %q to fail projection", row)
+		} else if _, ok := err.(*RowProjectionError); !ok {
+			t.Fatalf("Expected a RowProjectionError, got %T", err)
+		}
+	}
+
+	if _, err := newRowProjection([]string{"a"}); err == nil {
+		t.Fatalf("Expected a pointer without a leading '/' to be rejected")
+	}
+}
+
+func TestQueryResultsProjection(t *testing.T) {
+	q := NewN1qlQuery("SELECT * FROM default").Project("/default/name")
+	results := &n1qlResults{
+		index:      -1,
+		rows:       []json.RawMessage{json.RawMessage(`{"default":{"name":"x","big":[1,2,3]}}`), json.RawMessage(`{"default":`)},
+		projection: q.projection,
+	}
+
+	var row map[string]string
+	if !results.Next(&row) || row["/default/name"] != "x" {
+		t.Fatalf("Expected the projected row, got %v", row)
+	}
+	if results.Next(&row) {
+		t.Fatalf("Expected a malformed row to fail")
+	}
+	if _, ok := results.Close().(*RowProjectionError); !ok {
+		t.Fatalf("Expected the malformed row to be reported by Close")
+	}
+
+	vq := NewViewQuery("ddoc", "view").Project("no-slash")
+	if _, _, _, err := vq.getInfo(); err == nil {
+		t.Fatalf("Expected an invalid view projection to be reported")
+	}
+	c := &Cluster{}
+	if _, err := c.ExecuteN1qlQuery(NewN1qlQuery("SELECT * FROM default").Project("no-slash"), nil); err == nil {
+		t.Fatalf("Expected an invalid N1QL projection to be returned by ExecuteN1qlQuery")
+	}
+}
+
+func largeProjectionRow() []byte {
+	fields := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		fields = append(fields, fmt.Sprintf(`"field%d":{"text":"%s","n":%d}`, i, strings.Repeat("x", 20), i))
+	}
+	return []byte(`{"id":"doc1",` + strings.Join(fields, ",") + `,"meta":{"type":"user"}}`)
+}
+
+func BenchmarkRowProjection(b *testing.B) {
+	row := largeProjectionRow()
+	projection, _ := newRowProjection([]string{"/id", "/meta/type"})
+	b.SetBytes(int64(len(row)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var values map[string]interface{}
+		projected, err := projection.project(row)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := json.Unmarshal(projected, &values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRowFullUnmarshal(b *testing.B) {
+	row := largeProjectionRow()
+	b.SetBytes(int64(len(row)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var values map[string]interface{}
+		if err := json.Unmarshal(row, &values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
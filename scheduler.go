@@ -0,0 +1,253 @@
+package gocb
+
+import (
+	"container/list"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sync"
+)
+
+// OpPriority specifies the priority with which a KV operation is dispatched when the
+// operation queue of a bucket is congested.
+type OpPriority int
+
+const (
+	// PriorityNormal indicates an operation is dispatched with normal priority.
+	PriorityNormal = OpPriority(0)
+
+	// PriorityHigh indicates an operation is dispatched ahead of normal and low
+	// priority operations.
+	PriorityHigh = OpPriority(1)
+
+	// PriorityLow indicates an operation yields to higher priority operations, and
+	// is the first to be shed when the operation queue is full.
+	PriorityLow = OpPriority(2)
+)
+
+// The number of times a waiting lane may be passed over in favour of a higher
+// priority lane before it is served regardless.
+const maxLaneSkips = 8
+
+func (p OpPriority) lane() int {
+	switch p {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// String returns the name of the priority.
+func (p OpPriority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+var lanePriorities = [3]OpPriority{PriorityHigh, PriorityNormal, PriorityLow}
+
+type opWaiter struct {
+	lane  int
+	elem  *list.Element
+	ready chan error
+}
+
+// opScheduler limits the number of operations in flight on a bucket, queueing further
+// operations in one lane per priority.  Higher priority lanes are served first, but a
+// lane which has been passed over maxLaneSkips times is served next so that lower
+// priority operations cannot be starved.
+type opScheduler struct {
+	lock        sync.Mutex
+	maxInFlight int
+	maxQueued   int
+	inFlight    int
+	queued      int
+	lanes       [3]*list.List
+	skips       [3]int
+}
+
+func newOpScheduler(maxInFlight, maxQueued int) *opScheduler {
+	s := &opScheduler{
+		maxInFlight: maxInFlight,
+		maxQueued:   maxQueued,
+	}
+	for i := range s.lanes {
+		s.lanes[i] = list.New()
+	}
+	return s
+}
+
+// enqueue requests permission to dispatch an operation.  The returned waiter's ready
+// channel receives nil once the operation may be dispatched, or ErrOverload if it was
+// shed from the queue.
+func (s *opScheduler) enqueue(priority OpPriority) *opWaiter {
+	w := &opWaiter{
+		lane:  priority.lane(),
+		ready: make(chan error, 1),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.inFlight < s.maxInFlight && s.queued == 0 {
+		s.inFlight++
+		w.ready <- nil
+		return w
+	}
+
+	if s.queued >= s.maxQueued {
+		// Shed the most recently queued operation of the lowest priority lane below
+		// our own, or this operation if there is none.
+		shed := false
+		for lane := len(s.lanes) - 1; lane > w.lane; lane-- {
+			if back := s.lanes[lane].Back(); back != nil {
+				victim := back.Value.(*opWaiter)
+				s.lanes[lane].Remove(back)
+				victim.elem = nil
+				s.queued--
+				victim.ready <- ErrOverload
+				shed = true
+				break
+			}
+		}
+		if !shed {
+			w.ready <- ErrOverload
+			return w
+		}
+	}
+
+	w.elem = s.lanes[w.lane].PushBack(w)
+	s.queued++
+	return w
+}
+
+// cancel removes a waiter from the queue, returning false if it has already been
+// granted or shed.
+func (s *opScheduler) cancel(w *opWaiter) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if w.elem == nil {
+		return false
+	}
+	s.lanes[w.lane].Remove(w.elem)
+	w.elem = nil
+	s.queued--
+	return true
+}
+
+// release marks an operation as completed, dispatching the next queued operation.
+func (s *opScheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.inFlight--
+	if s.queued == 0 {
+		return
+	}
+
+	next := -1
+	for lane := range s.lanes {
+		if s.lanes[lane].Len() == 0 {
+			continue
+		}
+		if next == -1 {
+			next = lane
+			continue
+		}
+		s.skips[lane]++
+		if s.skips[lane] >= maxLaneSkips {
+			next = lane
+		}
+	}
+	s.skips[next] = 0
+
+	w := s.lanes[next].Remove(s.lanes[next].Front()).(*opWaiter)
+	w.elem = nil
+	s.queued--
+	s.inFlight++
+	w.ready <- nil
+}
+
+func (s *opScheduler) queueDepths() map[OpPriority]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	depths := make(map[OpPriority]int)
+	for lane, priority := range lanePriorities {
+		depths[priority] = s.lanes[lane].Len()
+	}
+	return depths
+}
+
+func noopRelease() {}
+
+// opScheduler returns the scheduler enforcing the operation queue limits of the bucket,
+// or nil if it has no limits.  The scheduler may be replaced by SetOperationQueueLimits
+// while operations are being admitted.
+func (b *Bucket) opScheduler() *opScheduler {
+	s, _ := b.scheduler.Load().(*opScheduler)
+	return s
+}
+
+// admitOp waits until an operation may be dispatched according to the bucket's
+// operation queue limits, returning a function which must be called once the
+// operation has completed.
+func (b *Bucket) admitOp(opts *kvOpOptions) (func(), error) {
+	s := b.opScheduler()
+	if s == nil {
+		return noopRelease, nil
+	}
+
+	w := s.enqueue(opts.opPriority())
+	timeoutTmr := gocbcore.AcquireTimer(b.kvTimeout(opts))
+	select {
+	case err := <-w.ready:
+		gocbcore.ReleaseTimer(timeoutTmr, false)
+		if err != nil {
+			return nil, err
+		}
+		return s.release, nil
+	case <-timeoutTmr.C:
+		gocbcore.ReleaseTimer(timeoutTmr, true)
+		if s.cancel(w) {
+			return nil, ErrTimeout
+		}
+		if err := <-w.ready; err != nil {
+			return nil, err
+		}
+		return s.release, nil
+	}
+}
+
+// SetOperationQueueLimits limits the number of KV operations this bucket has in
+// flight at once.  Further operations are queued according to their OpPriority, and
+// once maxQueued operations are waiting the lowest priority operations are rejected
+// with ErrOverload.  Passing a maxInFlight of zero removes the limits, which is the
+// default.  The limits may be changed while operations are in progress, in which case
+// operations already admitted or queued remain subject to the previous limits.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetOperationQueueLimits(maxInFlight, maxQueued int) {
+	if maxInFlight <= 0 {
+		b.scheduler.Store((*opScheduler)(nil))
+		return
+	}
+	b.scheduler.Store(newOpScheduler(maxInFlight, maxQueued))
+}
+
+// OperationQueueDepths returns the number of operations waiting to be dispatched at
+// each priority.
+func (b *Bucket) OperationQueueDepths() map[OpPriority]int {
+	s := b.opScheduler()
+	if s == nil {
+		return map[OpPriority]int{}
+	}
+	return s.queueDepths()
+}
@@ -0,0 +1,103 @@
+package gocb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOpSchedulerPriority(t *testing.T) {
+	s := newOpScheduler(1, 10)
+
+	first := s.enqueue(PriorityNormal)
+	if err := <-first.ready; err != nil {
+		t.Fatalf("Expected the first operation to be admitted immediately: %v", err)
+	}
+
+	low := s.enqueue(PriorityLow)
+	normal := s.enqueue(PriorityNormal)
+	high := s.enqueue(PriorityHigh)
+
+	depths := s.queueDepths()
+	if depths[PriorityHigh] != 1 || depths[PriorityNormal] != 1 || depths[PriorityLow] != 1 {
+		t.Fatalf("Unexpected queue depths %v", depths)
+	}
+
+	expected := []*opWaiter{high, normal, low}
+	for i, w := range expected {
+		s.release()
+		select {
+		case err := <-w.ready:
+			if err != nil {
+				t.Fatalf("Unexpected error admitting operation %d: %v", i, err)
+			}
+		default:
+			t.Fatalf("Expected operation %d to be admitted in priority order", i)
+		}
+	}
+}
+
+func TestOpSchedulerNoStarvation(t *testing.T) {
+	s := newOpScheduler(1, 100)
+	<-s.enqueue(PriorityHigh).ready
+
+	low := s.enqueue(PriorityLow)
+	for i := 0; i < maxLaneSkips; i++ {
+		s.enqueue(PriorityHigh)
+	}
+
+	for i := 0; i < maxLaneSkips; i++ {
+		s.release()
+	}
+	select {
+	case <-low.ready:
+	default:
+		t.Fatalf("Expected the low priority operation to be admitted after %d skips", maxLaneSkips)
+	}
+}
+
+func TestOpSchedulerShedding(t *testing.T) {
+	s := newOpScheduler(1, 1)
+	<-s.enqueue(PriorityNormal).ready
+
+	low := s.enqueue(PriorityLow)
+	high := s.enqueue(PriorityHigh)
+	if err := <-low.ready; err != ErrOverload {
+		t.Fatalf("Expected the low priority operation to be shed, got %v", err)
+	}
+
+	rejected := s.enqueue(PriorityNormal)
+	if err := <-rejected.ready; err != ErrOverload {
+		t.Fatalf("Expected the new operation to be rejected, got %v", err)
+	}
+
+	s.release()
+	if err := <-high.ready; err != nil {
+		t.Fatalf("Expected the high priority operation to be admitted: %v", err)
+	}
+}
+
+func TestSetOperationQueueLimitsWhileAdmitting(t *testing.T) {
+	b := &Bucket{opTimeout: time.Second}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				release, err := b.admitOp(nil)
+				if err != nil {
+					t.Errorf("Failed to admit operation: %v", err)
+					return
+				}
+				release()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		b.SetOperationQueueLimits(i%3, 100)
+		b.OperationQueueDepths()
+	}
+	wg.Wait()
+}
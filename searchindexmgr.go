@@ -0,0 +1,219 @@
+package gocb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SearchIndex describes the definition of a full-text search index.
+type SearchIndex struct {
+	// Name is the name of the index.
+	Name string `json:"name"`
+	// Type is the type of the index, which is "fulltext-index" for search indexes and
+	// "fulltext-alias" for aliases of them.
+	Type string `json:"type"`
+	// UUID identifies the version of the definition.  It must be set to the UUID of
+	// the current definition to update an index, and left empty to create one.
+	UUID string `json:"uuid,omitempty"`
+	// Params holds the mapping and store options of the index.
+	Params map[string]interface{} `json:"params,omitempty"`
+	// SourceType is the type of the source of the documents indexed, which is
+	// "couchbase" for a bucket.
+	SourceType string `json:"sourceType"`
+	// SourceName is the name of the bucket whose documents are indexed.
+	SourceName string `json:"sourceName,omitempty"`
+	// SourceUUID is the UUID of the bucket whose documents are indexed.
+	SourceUUID   string                 `json:"sourceUUID,omitempty"`
+	SourceParams map[string]interface{} `json:"sourceParams,omitempty"`
+	PlanParams   map[string]interface{} `json:"planParams,omitempty"`
+}
+
+// SearchIndexManager provides methods for managing the full-text search indexes of a
+// cluster using the search service REST API.
+//
+// Experimental: This API is subject to change at any time.
+type SearchIndexManager struct {
+	cm    *ClusterManager
+	getEp func() (string, error)
+}
+
+// SearchIndexManager returns a SearchIndexManager for managing the full-text search
+// indexes of the cluster.  The search service endpoints are discovered from the
+// configuration of an open bucket.
+//
+// Experimental: This API is subject to change at any time.
+func (cm *ClusterManager) SearchIndexManager() *SearchIndexManager {
+	return &SearchIndexManager{
+		cm: cm,
+		getEp: func() (string, error) {
+			return cm.getServiceEp(FtsService)
+		},
+	}
+}
+
+type searchIndexResponse struct {
+	Status    string          `json:"status"`
+	IndexDef  *SearchIndex    `json:"indexDef,omitempty"`
+	IndexDefs json.RawMessage `json:"indexDefs,omitempty"`
+	Count     uint64          `json:"count,omitempty"`
+}
+
+func (sm *SearchIndexManager) doRequest(method, uri, contentType string, body io.Reader) (*searchIndexResponse, error) {
+	ep, err := sm.getEp()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sm.cm.httpRequest(ep, method, uri, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if strings.Contains(string(data), "index not found") {
+			return nil, ErrSearchIndexNotFound
+		}
+		return nil, clientError{string(data)}
+	}
+
+	var searchResp searchIndexResponse
+	if err := json.Unmarshal(data, &searchResp); err != nil {
+		return nil, err
+	}
+	return &searchResp, nil
+}
+
+func searchIndexUri(name string, parts ...string) string {
+	uri := fmt.Sprintf("/api/index/%s", url.PathEscape(name))
+	for _, part := range parts {
+		uri += "/" + part
+	}
+	return uri
+}
+
+// GetAllIndexes returns the definitions of every search index on the cluster.
+func (sm *SearchIndexManager) GetAllIndexes() ([]*SearchIndex, error) {
+	resp, err := sm.doRequest("GET", "/api/index", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var defs struct {
+		IndexDefs map[string]*SearchIndex `json:"indexDefs"`
+	}
+	if len(resp.IndexDefs) > 0 && string(resp.IndexDefs) != "null" {
+		if err := json.Unmarshal(resp.IndexDefs, &defs); err != nil {
+			return nil, err
+		}
+	}
+
+	var indexes []*SearchIndex
+	for _, index := range defs.IndexDefs {
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// GetIndex returns the definition of a search index.  If the index does not exist,
+// ErrSearchIndexNotFound is returned.
+func (sm *SearchIndexManager) GetIndex(name string) (*SearchIndex, error) {
+	resp, err := sm.doRequest("GET", searchIndexUri(name), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IndexDef == nil {
+		return nil, ErrSearchIndexNotFound
+	}
+	return resp.IndexDef, nil
+}
+
+// UpsertIndex creates a search index, or updates the index with the same name.  An
+// existing index is only updated if the UUID of the definition matches that of the
+// current definition of the index.
+func (sm *SearchIndexManager) UpsertIndex(index *SearchIndex) error {
+	if index.Name == "" {
+		return clientError{"A search index must have a name."}
+	}
+	if index.Type == "" {
+		index.Type = "fulltext-index"
+	}
+	if index.SourceType == "" {
+		index.SourceType = "couchbase"
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = sm.doRequest("PUT", searchIndexUri(index.Name), "application/json", bytes.NewReader(data))
+	return err
+}
+
+// DropIndex removes a search index.  If the index does not exist,
+// ErrSearchIndexNotFound is returned.
+func (sm *SearchIndexManager) DropIndex(name string) error {
+	_, err := sm.doRequest("DELETE", searchIndexUri(name), "", nil)
+	return err
+}
+
+// GetIndexedDocumentCount returns the number of documents a search index has indexed.
+func (sm *SearchIndexManager) GetIndexedDocumentCount(name string) (uint64, error) {
+	resp, err := sm.doRequest("GET", searchIndexUri(name, "count"), "", nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func (sm *SearchIndexManager) control(name, control, action string) error {
+	_, err := sm.doRequest("POST", searchIndexUri(name, control, action), "", nil)
+	return err
+}
+
+// PauseIngest stops a search index from indexing changes to documents.
+func (sm *SearchIndexManager) PauseIngest(name string) error {
+	return sm.control(name, "ingestControl", "pause")
+}
+
+// ResumeIngest resumes indexing changes to documents for a search index which was
+// paused with PauseIngest.
+func (sm *SearchIndexManager) ResumeIngest(name string) error {
+	return sm.control(name, "ingestControl", "resume")
+}
+
+// AllowQuerying allows a search index to be queried.
+func (sm *SearchIndexManager) AllowQuerying(name string) error {
+	return sm.control(name, "queryControl", "allow")
+}
+
+// DisallowQuerying stops a search index from being queried.
+func (sm *SearchIndexManager) DisallowQuerying(name string) error {
+	return sm.control(name, "queryControl", "disallow")
+}
+
+// FreezePlan stops the partitions of a search index from being reassigned between
+// nodes, such as during a rebalance.
+func (sm *SearchIndexManager) FreezePlan(name string) error {
+	return sm.control(name, "planFreezeControl", "freeze")
+}
+
+// UnfreezePlan allows the partitions of a search index to be reassigned between nodes
+// again after FreezePlan.
+func (sm *SearchIndexManager) UnfreezePlan(name string) error {
+	return sm.control(name, "planFreezeControl", "unfreeze")
+}
@@ -0,0 +1,106 @@
+package gocb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestSearchIndexManager(status int, body string) (*SearchIndexManager, *[]*http.Request, *[]string, *httptest.Server) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		requests = append(requests, req)
+		bodies = append(bodies, string(data))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	cm := &ClusterManager{httpCli: http.DefaultClient}
+	sm := &SearchIndexManager{cm: cm, getEp: func() (string, error) {
+		return server.URL, nil
+	}}
+	return sm, &requests, &bodies, server
+}
+
+func TestSearchIndexManagerGetAllIndexes(t *testing.T) {
+	sm, _, _, server := newTestSearchIndexManager(200,
+		`{"status":"ok","indexDefs":{"uuid":"abc","indexDefs":{"travel":{"name":"travel","type":"fulltext-index","sourceType":"couchbase","sourceName":"travel-sample"}}}}`)
+	defer server.Close()
+
+	indexes, err := sm.GetAllIndexes()
+	if err != nil {
+		t.Fatalf("Failed to get indexes: %v", err)
+	}
+	if len(indexes) != 1 || indexes[0].Name != "travel" || indexes[0].SourceName != "travel-sample" {
+		t.Fatalf("Unexpected indexes %+v", indexes)
+	}
+}
+
+func TestSearchIndexManagerGetIndexNotFound(t *testing.T) {
+	sm, _, _, server := newTestSearchIndexManager(400, `{"error":"rest_auth: preparePerms, err: index not found","status":"fail"}`)
+	defer server.Close()
+
+	_, err := sm.GetIndex("missing")
+	if err != ErrSearchIndexNotFound {
+		t.Fatalf("Expected ErrSearchIndexNotFound, got %v", err)
+	}
+}
+
+func TestSearchIndexManagerUpsertIndex(t *testing.T) {
+	sm, requests, bodies, server := newTestSearchIndexManager(200, `{"status":"ok"}`)
+	defer server.Close()
+
+	err := sm.UpsertIndex(&SearchIndex{Name: "travel", SourceName: "travel-sample"})
+	if err != nil {
+		t.Fatalf("Failed to upsert index: %v", err)
+	}
+
+	if len(*requests) != 1 || (*requests)[0].Method != "PUT" || (*requests)[0].URL.Path != "/api/index/travel" {
+		t.Fatalf("Expected the definition to be put to the index, got %+v", *requests)
+	}
+	var def SearchIndex
+	if err := json.Unmarshal([]byte((*bodies)[0]), &def); err != nil {
+		t.Fatalf("Failed to decode definition: %v", err)
+	}
+	if def.Type != "fulltext-index" || def.SourceType != "couchbase" || def.SourceName != "travel-sample" {
+		t.Fatalf("Unexpected definition %+v", def)
+	}
+}
+
+func TestSearchIndexManagerControls(t *testing.T) {
+	sm, requests, _, server := newTestSearchIndexManager(200, `{"status":"ok","count":42}`)
+	defer server.Close()
+
+	count, err := sm.GetIndexedDocumentCount("travel")
+	if err != nil || count != 42 {
+		t.Fatalf("Unexpected count %d %v", count, err)
+	}
+	if err := sm.PauseIngest("travel"); err != nil {
+		t.Fatalf("Failed to pause ingest: %v", err)
+	}
+	if err := sm.FreezePlan("travel"); err != nil {
+		t.Fatalf("Failed to freeze plan: %v", err)
+	}
+	if err := sm.DropIndex("travel"); err != nil {
+		t.Fatalf("Failed to drop index: %v", err)
+	}
+
+	expected := []string{
+		"GET /api/index/travel/count",
+		"POST /api/index/travel/ingestControl/pause",
+		"POST /api/index/travel/planFreezeControl/freeze",
+		"DELETE /api/index/travel",
+	}
+	if len(*requests) != len(expected) {
+		t.Fatalf("Unexpected requests %+v", *requests)
+	}
+	for i, req := range *requests {
+		if req.Method+" "+req.URL.Path != expected[i] {
+			t.Fatalf("Expected request %s, got %s %s", expected[i], req.Method, req.URL.Path)
+		}
+	}
+}
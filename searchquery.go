@@ -1,6 +1,9 @@
 package gocb
 
 import (
+	"encoding/json"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -24,7 +27,7 @@ type searchQueryHighlightData struct {
 }
 type searchQueryConsistencyData struct {
 	Level   string         `json:"level,omitempty"`
-	Vectors *MutationState `json:"vectors,omitempty"`
+	Vectors *searchVectors `json:"vectors,omitempty"`
 }
 type searchQueryCtlData struct {
 	Timeout     uint                        `json:"timeout,omitempty"`
@@ -44,8 +47,9 @@ type searchQueryData struct {
 
 // SearchQuery represents a pending search query.
 type SearchQuery struct {
-	name string
-	data searchQueryData
+	name        string
+	data        searchQueryData
+	deduplicate bool
 }
 
 // Limit specifies a limit on the number of results to return.
@@ -54,6 +58,19 @@ func (sq *SearchQuery) Limit(value int) *SearchQuery {
 	return sq
 }
 
+// DeduplicateHits specifies whether hits for a document which has already been hit are
+// dropped from the results.  While index partitions are being rebalanced, more than one
+// replica of a partition may answer the query so the same document can be hit twice.
+// The number of hits dropped is returned by SearchResultDuplicates, while TotalHits remains as
+// reported by the server.  Deduplicating requires memory proportional to the number of
+// hits returned.
+//
+// Experimental: This API is subject to change at any time.
+func (sq *SearchQuery) DeduplicateHits(enabled bool) *SearchQuery {
+	sq.deduplicate = enabled
+	return sq
+}
+
 // Skip specifies how many results to skip at the beginning of the result list.
 func (sq *SearchQuery) Skip(value int) *SearchQuery {
 	sq.data.From = value
@@ -97,7 +114,8 @@ func (sq *SearchQuery) AddFacet(name string, facet interface{}) *SearchQuery {
 	return sq
 }
 
-// Timeout indicates the maximum time to wait for this query to complete.
+// Timeout indicates the maximum time to wait for this query to complete, overriding
+// the FTS timeouts of the bucket and cluster.
 func (sq *SearchQuery) Timeout(value time.Duration) *SearchQuery {
 	if sq.data.Ctl == nil {
 		sq.data.Ctl = &searchQueryCtlData{}
@@ -126,7 +144,9 @@ func (sq *SearchQuery) Consistency(stale ConsistencyMode) *SearchQuery {
 	return sq
 }
 
-// ConsistentWith specifies a mutation state to be consistent with for this query.
+// ConsistentWith specifies a mutation state to be consistent with for this query.  The
+// state is read when the query is executed, so mutations added to it in the meantime
+// are included.
 func (sq *SearchQuery) ConsistentWith(state *MutationState) *SearchQuery {
 	if sq.data.Ctl == nil {
 		sq.data.Ctl = &searchQueryCtlData{}
@@ -139,7 +159,7 @@ func (sq *SearchQuery) ConsistentWith(state *MutationState) *SearchQuery {
 		panic("Consistent and ConsistentWith must be used exclusively")
 	}
 	sq.data.Ctl.Consistency.Level = "at_plus"
-	sq.data.Ctl.Consistency.Vectors = state
+	sq.data.Ctl.Consistency.Vectors = &searchVectors{indexName: sq.name, state: state}
 	return sq
 }
 
@@ -151,6 +171,35 @@ func (sq *SearchQuery) queryData() interface{} {
 	return sq.data
 }
 
+// String returns a one-line description of the search query and its options.
+func (sq *SearchQuery) String() string {
+	fields := []string{"index=" + sq.indexName()}
+
+	var opts map[string]json.RawMessage
+	bytes, err := json.Marshal(sq.queryData())
+	if err == nil {
+		err = json.Unmarshal(bytes, &opts)
+	}
+	if err != nil {
+		return strings.Join(append(fields, "error="+err.Error()), " ")
+	}
+
+	var names []string
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "query" {
+			fields = append(fields, name+"="+redactUserData(string(opts[name])))
+			continue
+		}
+		fields = append(fields, name+"="+string(opts[name]))
+	}
+	return strings.Join(fields, " ")
+}
+
 // NewSearchQuery creates a new SearchQuery object from an index name and query.
 func NewSearchQuery(indexName string, query interface{}) *SearchQuery {
 	q := &SearchQuery{
@@ -0,0 +1,200 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SearchHitStream allows the hits of a search query to be iterated over as they are
+// read from the response, rather than being held in memory together.  The status of
+// the index partitions, the total number of hits, the maximum score, the time taken
+// and the facets become available once the results have been closed, as most of them
+// follow the hits in the response.
+//
+// Experimental: This API is subject to change at any time.
+type SearchHitStream interface {
+	NextHit(hit *SearchResultHit) bool
+	Close() error
+
+	Status() SearchResultStatus
+	Errors() []string
+	TotalHits() int
+	Facets() map[string]SearchResultFacet
+	Took() time.Duration
+	MaxScore() float64
+	DuplicateHits() int
+}
+
+type searchHitStream struct {
+	stream     *resultStream
+	query      *SearchQuery
+	index      int
+	err        error
+	closed     bool
+	data       searchResponse
+	seen       map[string]struct{}
+	duplicates int
+}
+
+// nextRow returns the next hit of the response, or nil once every hit has been read.
+func (r *searchHitStream) nextRow() json.RawMessage {
+	if r.index+1 < len(r.stream.rows) {
+		r.index++
+		return r.stream.rows[r.index]
+	}
+	r.stream.rows = nil
+	r.index = -1
+
+	row, err := r.stream.nextRow()
+	if err != nil {
+		r.stream.abort()
+		r.err = err
+		return nil
+	}
+	return row
+}
+
+// NextHit decodes the next hit into hit, returning false once every hit has been read
+// or the results failed.  Hits which are duplicates of earlier hits are skipped when
+// DeduplicateHits was specified for the query.
+func (r *searchHitStream) NextHit(hit *SearchResultHit) bool {
+	for r.err == nil && !r.closed {
+		row := r.nextRow()
+		if row == nil {
+			return false
+		}
+
+		var decoded SearchResultHit
+		if r.err = json.Unmarshal(row, &decoded); r.err != nil {
+			return false
+		}
+		if r.seen != nil {
+			if _, ok := r.seen[decoded.Id]; ok {
+				r.duplicates++
+				continue
+			}
+			r.seen[decoded.Id] = struct{}{}
+		}
+		*hit = decoded
+		return true
+	}
+	return false
+}
+
+// Close reads the rest of the response, discarding any hits which were not iterated
+// over, and returns the error the results failed with, if any.
+func (r *searchHitStream) Close() error {
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+
+	for r.err == nil && !r.stream.done {
+		if _, err := r.stream.nextRow(); err != nil {
+			r.stream.abort()
+			r.err = err
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if err := r.stream.decodeFields(&r.data); err != nil {
+		r.err = err
+		return err
+	}
+	logPartialSearchResults(r.query, r.data.Status)
+	return nil
+}
+
+func (r *searchHitStream) checkClosed() {
+	if !r.closed {
+		panic("Result must be closed before accessing meta-data")
+	}
+}
+
+func (r *searchHitStream) Status() SearchResultStatus {
+	r.checkClosed()
+	return r.data.Status
+}
+func (r *searchHitStream) Errors() []string {
+	r.checkClosed()
+	return r.data.Errors
+}
+func (r *searchHitStream) TotalHits() int {
+	r.checkClosed()
+	return r.data.TotalHits
+}
+func (r *searchHitStream) Facets() map[string]SearchResultFacet {
+	r.checkClosed()
+	return r.data.Facets
+}
+func (r *searchHitStream) Took() time.Duration {
+	r.checkClosed()
+	return time.Duration(r.data.Took) / time.Nanosecond
+}
+func (r *searchHitStream) MaxScore() float64 {
+	r.checkClosed()
+	return r.data.MaxScore
+}
+
+// DuplicateHits returns the number of hits which were skipped as duplicates of earlier
+// hits, when DeduplicateHits was specified for the query.
+func (r *searchHitStream) DuplicateHits() int {
+	return r.duplicates
+}
+
+func newSearchHitStream(q *SearchQuery, stream *resultStream) (*searchHitStream, error) {
+	err := stream.readFirstRow()
+	if err != nil {
+		stream.abort()
+		return nil, err
+	}
+
+	results := &searchHitStream{
+		stream: stream,
+		query:  q,
+		index:  -1,
+	}
+	if q.deduplicate {
+		results.seen = make(map[string]struct{})
+	}
+	return results, nil
+}
+
+// Performs a search query and returns a stream of its hits or an error.
+func (c *Cluster) doSearchQueryStream(ctx context.Context, b *Bucket, q *SearchQuery) (SearchHitStream, error) {
+	var results SearchHitStream
+	err := c.executeSearchQuery(ctx, b, q, func(resp *http.Response, cancel context.CancelFunc) error {
+		stream, err := newSearchHitStream(q, newResultStream(resp.Body, cancel, "hits"))
+		if err != nil {
+			return err
+		}
+		results = stream
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ExecuteSearchQueryStream performs a search query, returning its hits as they are read
+// from the response.  The results must be closed once they are no longer needed.
+// Cancelling ctx while the hits are being read causes them to fail with its error.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) ExecuteSearchQueryStream(ctx context.Context, q *SearchQuery) (SearchHitStream, error) {
+	return b.cluster.doSearchQueryStream(ctx, b, q)
+}
+
+// ExecuteSearchQueryStream performs a search query using the cluster credentials,
+// returning its hits as they are read from the response.  The results must be closed
+// once they are no longer needed.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) ExecuteSearchQueryStream(ctx context.Context, q *SearchQuery) (SearchHitStream, error) {
+	return c.doSearchQueryStream(ctx, nil, q)
+}
@@ -0,0 +1,46 @@
+package gocb
+
+// The N1QL, FTS and analytics services are not specific to a bucket, so their endpoints
+// are managed once per cluster rather than by each bucket opened from it.  Every bucket
+// then sends its queries to the same endpoints, through the same HTTP client, choosing
+// the credentials of each request itself, while keeping its own KV connections.
+
+// serviceConfigBucket returns the bucket whose configuration the endpoints of the
+// N1QL, FTS and analytics services are taken from.  This is the earliest opened bucket
+// which is still open, so that closing any other bucket does not change the endpoints
+// in use, or fallback if no bucket is open.
+func (c *Cluster) serviceConfigBucket(fallback *Bucket) *Bucket {
+	if c == nil {
+		return fallback
+	}
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	if len(c.bucketList) == 0 {
+		return fallback
+	}
+	return c.bucketList[0]
+}
+
+// serviceEps returns the endpoints of the N1QL, FTS or analytics service shared by
+// every bucket of the cluster.  Analytics endpoints specified by EnableAnalytics are
+// used in place of those in the configuration.  Nil is returned if no bucket is open
+// and fallback is nil.
+func (c *Cluster) serviceEps(service ServiceType, fallback *Bucket) []string {
+	if service == CbasService && c != nil && len(c.analyticsHosts) > 0 {
+		return c.analyticsHosts
+	}
+
+	b := c.serviceConfigBucket(fallback)
+	if b == nil {
+		return nil
+	}
+	switch service {
+	case N1qlService:
+		return b.client.N1qlEps()
+	case FtsService:
+		return b.client.FtsEps()
+	case CbasService:
+		return b.client.CbasEps()
+	}
+	return nil
+}
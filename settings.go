@@ -0,0 +1,200 @@
+package gocb
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Setting is the effective value of a single setting.
+type Setting struct {
+	// Value is the effective value of the setting.  Durations are given as strings,
+	// such as "2.5s", and types such as transcoders by their type name.
+	Value interface{} `json:"value"`
+	// Default is whether the value is the default, rather than having been configured
+	// to a different value.
+	Default bool `json:"default"`
+}
+
+// Settings is a snapshot of the effective settings of a Cluster or Bucket, keyed by
+// setting name.  Secrets such as passwords and keys are never included.  Modifying a
+// snapshot does not affect the settings it was taken from.
+//
+// Experimental: This API is subject to change at any time.
+type Settings map[string]Setting
+
+// Names returns the names of the settings in the snapshot, sorted.
+func (s Settings) Names() []string {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// set records the value of a setting, which is the default when it equals def.  Both
+// value and def must be comparable.
+func (s Settings) set(name string, value, def interface{}) {
+	isDefault := value == def
+	if d, ok := value.(time.Duration); ok {
+		value = d.String()
+	}
+	s[name] = Setting{Value: value, Default: isDefault}
+}
+
+// settingTypeName describes the implementation of a setting such as a transcoder, or
+// returns "none" if it is not set.
+func settingTypeName(value interface{}) string {
+	if value == nil {
+		return "none"
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Func:
+		if rv.IsNil() {
+			return "none"
+		}
+		if fn := runtime.FuncForPC(rv.Pointer()); fn != nil {
+			return fn.Name()
+		}
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		if rv.IsNil() {
+			return "none"
+		}
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// isSecretOption returns whether a connection string option may hold a secret, and
+// so must not be included in settings snapshots.
+func isSecretOption(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range []string{"password", "secret", "token", "key"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedConnSpecOptions returns a copy of parsed connection string options with the
+// values of options which may hold secrets redacted.
+func redactedConnSpecOptions(options map[string][]string) map[string][]string {
+	redacted := make(map[string][]string, len(options))
+	for name, values := range options {
+		if isSecretOption(name) {
+			redacted[name] = []string{"<redacted>"}
+			continue
+		}
+		redacted[name] = append([]string{}, values...)
+	}
+	return redacted
+}
+
+// Settings returns a snapshot of the effective settings of the cluster, identifying
+// which have been configured and which remain at their defaults.  The snapshot is
+// cheap to take, and is intended to be logged to help diagnose differences in
+// behaviour between deployments.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) Settings() Settings {
+	s := make(Settings)
+	config := c.agentConfig
+
+	s.set("connect_timeout", config.ConnectTimeout, 60000*time.Millisecond)
+	s.set("server_connect_timeout", config.ServerConnectTimeout, 7000*time.Millisecond)
+	s.set("nmv_retry_delay", config.NmvRetryDelay, 100*time.Millisecond)
+	s.set("n1ql_timeout", c.n1qlTimeout, 75*time.Second)
+	s.set("fts_timeout", c.ftsTimeout, 75*time.Second)
+	s.set("analytics_timeout", c.analyticsTimeout, time.Duration(0))
+	s.set("management_timeout", c.mgmtTimeout, 75*time.Second)
+
+	s.set("kv_pool_size", config.KvPoolSize, 0)
+	s.set("max_queue_size", config.MaxQueueSize, 0)
+	s.set("compression", config.UseCompression, true)
+	s.set("compression_min_size", config.CompressionMinSize, defaultCompressionMinSize)
+	s.set("compression_min_ratio", config.CompressionMinRatio, float64(defaultCompressionMinRatio))
+	s.set("max_value_size", c.MaxValueSize(), maxServerValueSize)
+
+	s.set("mutation_tokens", config.UseMutationTokens, false)
+	s.set("kv_error_maps", config.UseKvErrorMaps, true)
+	s.set("enhanced_errors", config.UseEnhancedErrors, true)
+	s.set("enriched_errors", c.enrichedErrors, true)
+	s.set("strict_statements", c.strictStatements, false)
+
+	s.set("retry_budget_max_retries", c.retryBudget.MaxRetries, 0)
+	s.set("retry_budget_max_backoff", c.retryBudget.MaxBackoff, time.Duration(0))
+
+	s.set("endpoint_selection", c.EndpointSelectionPolicy().String(), EndpointRoundRobin.String())
+	s.set("tls", config.TlsConfig != nil, false)
+	s.set("authenticator", settingTypeName(c.auth), "none")
+	s.set("key_generator", settingTypeName(c.keyGenerator), "none")
+	s.set("query_cache", settingTypeName(c.resultCache), "none")
+	s.set("metrics", c.getMeter() != nil, false)
+
+	var transport interface{}
+	if c.httpCli != nil {
+		transport = c.httpCli.Transport
+	}
+	s.set("http_transport", settingTypeName(transport), "*http.Transport")
+
+	s["analytics_hosts"] = Setting{
+		Value:   append([]string{}, c.analyticsHosts...),
+		Default: len(c.analyticsHosts) == 0,
+	}
+	s["connection_string_options"] = Setting{
+		Value:   redactedConnSpecOptions(c.connSpecOptions),
+		Default: len(c.connSpecOptions) == 0,
+	}
+	return s
+}
+
+// Settings returns a snapshot of the effective settings of the bucket, identifying
+// which have been configured and which remain at their defaults.  Settings of the
+// cluster the bucket was opened from are not included, and are available from
+// Cluster.Settings.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) Settings() Settings {
+	s := make(Settings)
+
+	s.set("name", b.name, b.name)
+	s.set("operation_timeout", b.opTimeout, 2500*time.Millisecond)
+	s.set("bulk_operation_timeout", b.bulkOpTimeout, 10000*time.Millisecond)
+	s.set("durability_timeout", b.duraTimeout, 40000*time.Millisecond)
+	s.set("durability_poll_timeout", b.duraPollTimeout, 100*time.Millisecond)
+	s.set("view_timeout", b.viewTimeout, 75*time.Second)
+	s.set("n1ql_timeout", b.n1qlTimeout, 75*time.Second)
+	s.set("fts_timeout", b.ftsTimeout, 75*time.Second)
+
+	s.set("mutation_tokens", b.mtEnabled, false)
+	s.set("transcoder", settingTypeName(b.transcoder), "*gocb.DefaultTranscoder")
+	s.set("key_generator", settingTypeName(b.keyGenerator), "none")
+	s.set("soft_delete_aware", b.softDeleteAware, false)
+	s.set("network_retry", b.NetworkRetry(), true)
+
+	s.set("kv_pool_size", b.kvPoolSize, 0)
+	s["bulk_in_flight_limit"] = Setting{Value: b.BulkInFlightLimit(), Default: b.bulkInFlightPerNode <= 0}
+	s.set("bulk_interleave", b.bulkInterleave, false)
+	s.set("bulk_fail_fast_on_node_down", b.bulkFailFast, false)
+	s.set("bulk_timeout", b.bulkTimeout, time.Duration(0))
+
+	maxInFlight, maxQueued := 0, 0
+	if scheduler := b.opScheduler(); scheduler != nil {
+		maxInFlight, maxQueued = scheduler.maxInFlight, scheduler.maxQueued
+	}
+	s.set("operation_queue_max_in_flight", maxInFlight, 0)
+	s.set("operation_queue_max_queued", maxQueued, 0)
+
+	s.set("local_cache", b.localCache != nil, false)
+	if b.localCache != nil {
+		s.set("local_cache_max_entries", b.localCache.opts.MaxEntries, 0)
+		s.set("local_cache_max_size", b.localCache.opts.MaxSize, 16*1024*1024)
+		s.set("local_cache_ttl", b.localCache.opts.TTL, time.Duration(0))
+	}
+	return s
+}
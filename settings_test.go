@@ -0,0 +1,103 @@
+package gocb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/couchbase/gocbcore.v7"
+)
+
+func TestClusterSettings(t *testing.T) {
+	c, err := Connect("couchbase://localhost")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c.connSpecOptions = map[string][]string{
+		"fts_timeout":    {"1000"},
+		"clientkeypath":  {"/etc/couchbase/client.key"},
+		"bucketpassword": {"hunter2"},
+	}
+	c.SetN1qlTimeout(5 * time.Second)
+	c.SetKeyGenerator(UUIDv4Key)
+
+	settings := c.Settings()
+	if s := settings["n1ql_timeout"]; s.Value != "5s" || s.Default {
+		t.Fatalf("Unexpected n1ql_timeout %+v", s)
+	}
+	if s := settings["management_timeout"]; s.Value != "1m15s" || !s.Default {
+		t.Fatalf("Unexpected management_timeout %+v", s)
+	}
+	if s := settings["key_generator"]; !strings.HasSuffix(s.Value.(string), ".UUIDv4Key") || s.Default {
+		t.Fatalf("Unexpected key_generator %+v", s)
+	}
+	if s := settings["query_cache"]; s.Value != "none" || !s.Default {
+		t.Fatalf("Unexpected query_cache %+v", s)
+	}
+
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("Failed to marshal settings: %v", err)
+	}
+	if strings.Contains(string(encoded), "hunter2") || strings.Contains(string(encoded), "client.key") {
+		t.Fatalf("Secrets were not redacted: %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"fts_timeout":["1000"]`) {
+		t.Fatalf("Connection string options were not included: %s", encoded)
+	}
+}
+
+func TestBucketSettings(t *testing.T) {
+	b, err := createBucket(nil, &gocbcore.AgentConfig{BucketName: "default", Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	b.SetOperationTimeout(time.Second)
+	b.SetBulkInFlightLimit(64)
+
+	settings := b.Settings()
+	if s := settings["operation_timeout"]; s.Value != "1s" || s.Default {
+		t.Fatalf("Unexpected operation_timeout %+v", s)
+	}
+	if s := settings["durability_timeout"]; s.Value != "40s" || !s.Default {
+		t.Fatalf("Unexpected durability_timeout %+v", s)
+	}
+	if s := settings["bulk_in_flight_limit"]; s.Value != 64 || s.Default {
+		t.Fatalf("Unexpected bulk_in_flight_limit %+v", s)
+	}
+	if s := settings["transcoder"]; s.Value != "*gocb.DefaultTranscoder" || !s.Default {
+		t.Fatalf("Unexpected transcoder %+v", s)
+	}
+
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("Failed to marshal settings: %v", err)
+	}
+	if strings.Contains(string(encoded), "hunter2") {
+		t.Fatalf("Password was included: %s", encoded)
+	}
+}
+
+func TestCompressionConfig(t *testing.T) {
+	c, err := Connect("couchbase://localhost")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	config := c.CompressionConfig()
+	if config.Disabled || config.MinSize != 32 || config.MinRatio != 0.83 {
+		t.Fatalf("Unexpected default compression config %+v", config)
+	}
+	if s := c.Settings()["compression"]; s.Value != true || !s.Default {
+		t.Fatalf("Unexpected compression setting %+v", s)
+	}
+
+	c.SetCompressionConfig(CompressionConfig{Disabled: true, MinSize: 1024})
+	agentConfig, err := c.makeAgentConfig("default", "default", "", false)
+	if err != nil {
+		t.Fatalf("Failed to make the agent config: %v", err)
+	}
+	if agentConfig.UseCompression || agentConfig.CompressionMinSize != 1024 || agentConfig.CompressionMinRatio != 0.83 {
+		t.Fatalf("Unexpected agent compression config %v %d %v", agentConfig.UseCompression, agentConfig.CompressionMinSize, agentConfig.CompressionMinRatio)
+	}
+}
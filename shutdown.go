@@ -0,0 +1,104 @@
+package gocb
+
+import (
+	"time"
+)
+
+// InFlightOperations returns the number of KV operations on this bucket which have
+// been dispatched and are still waiting for their results.
+func (b *Bucket) InFlightOperations() int {
+	return b.ops.numPending()
+}
+
+// ActiveConnections returns the number of bucket connections opened from this cluster
+// which have not yet been closed.
+func (c *Cluster) ActiveConnections() int {
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	return len(c.bucketList)
+}
+
+// OnShutdown registers a function to be called when the cluster is closed, once every
+// bucket opened from it has been closed and its in-flight operations have completed.
+// Functions are called in the order they were registered.  A function registered
+// after the cluster has been closed is called immediately.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) OnShutdown(fn func()) {
+	c.shutdownLock.Lock()
+	if !c.isShutdown {
+		c.shutdownHooks = append(c.shutdownHooks, fn)
+		c.shutdownLock.Unlock()
+		return
+	}
+	c.shutdownLock.Unlock()
+	runShutdownHook(fn)
+}
+
+// Close closes every bucket opened from the cluster, waits for their in-flight
+// operations to complete, and then calls the functions registered with OnShutdown.
+// Operations still waiting for results fail with ErrShutdown.  Close may be called
+// more than once and from multiple goroutines, but only the first call closes the
+// cluster, and every call returns once it has been closed.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close()
+	})
+	return c.closeErr
+}
+
+func (c *Cluster) close() error {
+	c.clusterLock.RLock()
+	buckets := append([]*Bucket{}, c.bucketList...)
+	c.clusterLock.RUnlock()
+
+	var errs MultiError
+	for _, bucket := range buckets {
+		if err := bucket.Close(); err != nil {
+			errs.add(err)
+		}
+	}
+	for _, bucket := range buckets {
+		if !bucket.ops.awaitIdle(orphanedOpGracePeriod) {
+			logWarnf("Bucket %s still had %d operations in flight after being closed",
+				redactMetaData(bucket.name), bucket.InFlightOperations())
+		}
+	}
+
+	c.shutdownLock.Lock()
+	c.isShutdown = true
+	hooks := c.shutdownHooks
+	c.shutdownHooks = nil
+	c.shutdownLock.Unlock()
+
+	for _, fn := range hooks {
+		runShutdownHook(fn)
+	}
+	return errs.get()
+}
+
+// runShutdownHook calls a function registered with OnShutdown, recovering from any
+// panic so that the remaining functions are still called.
+func runShutdownHook(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logErrorf("Shutdown callback panicked: %v", r)
+		}
+	}()
+	fn()
+}
+
+// awaitIdle waits for every operation on the tracker to complete, returning false if
+// some are still pending once timeout has elapsed.
+func (t *opTracker) awaitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for t.numPending() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
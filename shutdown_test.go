@@ -0,0 +1,94 @@
+package gocb
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/couchbase/gocbcore.v7"
+)
+
+func TestClusterCloseDuringOperations(t *testing.T) {
+	defer enableOpCompletionAssertions(t)()
+
+	c, err := Connect("couchbase://localhost")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	b, err := createBucket(c, &gocbcore.AgentConfig{BucketName: "default"})
+	if err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	b.opTimeout = 10 * time.Second
+	c.bucketList = append(c.bucketList, b)
+	if c.ActiveConnections() != 1 {
+		t.Fatalf("Expected 1 active connection, got %d", c.ActiveConnections())
+	}
+
+	var calls int32
+	var inFlightAtShutdown, connsAtShutdown int
+	c.OnShutdown(func() {
+		atomic.AddInt32(&calls, 1)
+		inFlightAtShutdown = b.InFlightOperations()
+		connsAtShutdown = c.ActiveConnections()
+	})
+	c.OnShutdown(func() {
+		panic("shutdown callback failure")
+	})
+	var lastCalled int32
+	c.OnShutdown(func() {
+		atomic.AddInt32(&lastCalled, 1)
+	})
+
+	conn := newFakeConn()
+	const numOps = 200
+	var ops sync.WaitGroup
+	for i := 0; i < numOps; i++ {
+		ops.Add(1)
+		go func(i int) {
+			defer ops.Done()
+			delay := time.Hour
+			if i%2 == 0 {
+				delay = time.Duration(i) * 10 * time.Microsecond
+			}
+			b.hlpCasExec(nil, "", func(cb ioCasCallback) (pendingOp, error) {
+				return conn.dispatch(cb, delay), nil
+			})
+		}(i)
+	}
+	for b.InFlightOperations() < numOps/4 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var closers sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if err := c.Close(); err != nil {
+				t.Errorf("Unexpected close error: %v", err)
+			}
+			if atomic.LoadInt32(&lastCalled) != 1 {
+				t.Errorf("Close returned before shutdown callbacks were called")
+			}
+		}()
+	}
+	closers.Wait()
+	ops.Wait()
+	conn.kill()
+
+	if calls != 1 || lastCalled != 1 {
+		t.Fatalf("Expected callbacks to be called once, got %d and %d", calls, lastCalled)
+	}
+	if inFlightAtShutdown != 0 || connsAtShutdown != 0 {
+		t.Fatalf("Expected no operations or connections at shutdown, got %d and %d",
+			inFlightAtShutdown, connsAtShutdown)
+	}
+
+	late := false
+	c.OnShutdown(func() { late = true })
+	if !late {
+		t.Fatalf("Expected a callback registered after close to be called immediately")
+	}
+}
@@ -0,0 +1,244 @@
+package gocb
+
+import (
+	"fmt"
+	"gopkg.in/couchbase/gocbcore.v7"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GetResult holds a document retrieved by GetConsistentSnapshot.
+//
+// Experimental: This API is subject to change at any time.
+type GetResult struct {
+	// Key is the key of the document.
+	Key string
+	// Cas is the CAS of the document when it was retrieved.
+	Cas Cas
+
+	bytes      []byte
+	flags      uint32
+	transcoder Transcoder
+}
+
+// Content decodes the value of the document into valuePtr, using the transcoder of
+// the bucket it was retrieved from.
+func (r *GetResult) Content(valuePtr interface{}) error {
+	return r.transcoder.Decode(r.bytes, r.flags, valuePtr)
+}
+
+// SnapshotContentionError occurs when GetConsistentSnapshot cannot observe a stable
+// snapshot of its documents, because some of them kept changing.  Its cause is
+// ErrSnapshotContention.
+type SnapshotContentionError struct {
+	// Keys are the keys of the documents which changed during the last attempt.
+	Keys []string
+}
+
+func (e *SnapshotContentionError) Error() string {
+	return fmt.Sprintf("Documents changed while the snapshot was being read (%s).", strings.Join(e.Keys, ", "))
+}
+
+// Unwrap returns ErrSnapshotContention.
+func (e *SnapshotContentionError) Unwrap() error {
+	return ErrSnapshotContention
+}
+
+// snapshotGetOp is a GetOp which retains the encoded value of the document.
+type snapshotGetOp struct {
+	bulkOp
+
+	Key    string
+	Result *GetResult
+	Err    error
+}
+
+func (item *snapshotGetOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *snapshotGetOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *snapshotGetOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *snapshotGetOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.Get([]byte(item.Key), func(bytes []byte, flags uint32, cas gocbcore.Cas, err error) {
+		item.Err = err
+		if item.Err == nil {
+			item.Result = &GetResult{
+				Key:        item.Key,
+				Cas:        Cas(cas),
+				bytes:      bytes,
+				flags:      flags,
+				transcoder: b.transcoder,
+			}
+		}
+		signal <- item
+	})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// snapshotObserveOp observes the current CAS of a document on its active node, which
+// is far cheaper than retrieving it again.
+type snapshotObserveOp struct {
+	bulkOp
+
+	Key   string
+	Found bool
+	Cas   Cas
+	Err   error
+}
+
+func (item *snapshotObserveOp) markError(err error) {
+	item.Err = err
+}
+
+func (item *snapshotObserveOp) bulkKey() string {
+	return item.Key
+}
+
+func (item *snapshotObserveOp) bulkErr() error {
+	return item.Err
+}
+
+func (item *snapshotObserveOp) execute(b *Bucket, signal chan BulkOp) {
+	op, err := b.client.Observe([]byte(item.Key), 0, func(ks gocbcore.KeyState, cas gocbcore.Cas, err error) {
+		item.Err = err
+		if item.Err == nil {
+			item.Found = ks == gocbcore.KeyStateNotPersisted || ks == gocbcore.KeyStatePersisted
+			item.Cas = Cas(cas)
+		}
+		signal <- item
+	})
+	if err != nil {
+		item.Err = err
+		signal <- item
+	} else {
+		item.bulkOp.pendop = op
+	}
+}
+
+// GetConsistentSnapshot retrieves a set of documents such that none of them changed
+// while the others were being retrieved.  The documents are retrieved together, and
+// then the CAS of each is checked again without retrieving it.  Documents which
+// changed are retrieved again, and every document checked again, until the CAS of
+// every document matches the one retrieved, or maxAttempts attempts have been made.
+// The documents which were found are returned keyed by their key, and those which do
+// not exist are omitted.  If no stable snapshot is observed, a SnapshotContentionError
+// listing the documents which kept changing is returned along with the last results.
+//
+// This provides read-only consistency between the documents: every document returned
+// held its returned value at a single point in time, once all had been retrieved.  It
+// is not a transaction, and does not prevent the documents from changing afterwards.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) GetConsistentSnapshot(keys []string, maxAttempts int) (map[string]*GetResult, error) {
+	start := time.Now()
+	results, err := consistentSnapshot(keys, maxAttempts, b.snapshotRead, b.snapshotVerify)
+	return results, b.wrapError(err, "GetConsistentSnapshot", "", start)
+}
+
+// consistentSnapshot reads keys with read until verify finds that none of them have
+// changed since they were read.
+func consistentSnapshot(keys []string, maxAttempts int,
+	read func(keys []string, results map[string]*GetResult) error,
+	verify func(keys []string, results map[string]*GetResult) ([]string, error)) (map[string]*GetResult, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	seen := make(map[string]bool)
+	var unique []string
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	results := make(map[string]*GetResult)
+	pending := unique
+	for attempt := 1; ; attempt++ {
+		if err := read(pending, results); err != nil {
+			return nil, err
+		}
+
+		changed, err := verify(unique, results)
+		if err != nil {
+			return nil, err
+		}
+		if len(changed) == 0 {
+			return results, nil
+		}
+		if attempt >= maxAttempts {
+			sort.Strings(changed)
+			return results, &SnapshotContentionError{Keys: changed}
+		}
+		logDebugf("Documents changed while reading snapshot, retrying %d of them", len(changed))
+		pending = changed
+	}
+}
+
+func (b *Bucket) snapshotRead(keys []string, results map[string]*GetResult) error {
+	ops := make([]BulkOp, len(keys))
+	for i, key := range keys {
+		ops[i] = &snapshotGetOp{Key: key}
+	}
+	if err := b.doBatch(ops, PriorityNormal); err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		item := op.(*snapshotGetOp)
+		switch {
+		case item.Err == nil:
+			results[item.Key] = item.Result
+		case ErrorCause(item.Err) == ErrKeyNotFound:
+			delete(results, item.Key)
+		default:
+			return item.Err
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) snapshotVerify(keys []string, results map[string]*GetResult) ([]string, error) {
+	ops := make([]BulkOp, len(keys))
+	for i, key := range keys {
+		ops[i] = &snapshotObserveOp{Key: key}
+	}
+	if err := b.doBatch(ops, PriorityNormal); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, op := range ops {
+		item := op.(*snapshotObserveOp)
+		if item.Err != nil {
+			return nil, item.Err
+		}
+		if snapshotChanged(results[item.Key], item.Found, item.Cas) {
+			changed = append(changed, item.Key)
+		}
+	}
+	return changed, nil
+}
+
+// snapshotChanged returns whether a document has changed since it was read, given its
+// result when it was read, which is nil if it was not found, and its current state.
+func snapshotChanged(result *GetResult, found bool, cas Cas) bool {
+	if result == nil {
+		return found
+	}
+	return !found || result.Cas != cas
+}
@@ -0,0 +1,91 @@
+package gocb
+
+import (
+	"testing"
+)
+
+// fakeSnapshotStore imitates documents which are mutated a number of times as they
+// are first read.
+type fakeSnapshotStore struct {
+	cas       map[string]Cas
+	mutations map[string]int
+	reads     [][]string
+}
+
+func (s *fakeSnapshotStore) read(keys []string, results map[string]*GetResult) error {
+	s.reads = append(s.reads, keys)
+	for _, key := range keys {
+		cas, ok := s.cas[key]
+		if !ok {
+			delete(results, key)
+			continue
+		}
+		results[key] = &GetResult{Key: key, Cas: cas}
+		if s.mutations[key] > 0 {
+			s.mutations[key]--
+			s.cas[key]++
+		}
+	}
+	return nil
+}
+
+func (s *fakeSnapshotStore) verify(keys []string, results map[string]*GetResult) ([]string, error) {
+	var changed []string
+	for _, key := range keys {
+		cas, found := s.cas[key]
+		if snapshotChanged(results[key], found, cas) {
+			changed = append(changed, key)
+		}
+	}
+	return changed, nil
+}
+
+func TestConsistentSnapshot(t *testing.T) {
+	store := &fakeSnapshotStore{
+		cas:       map[string]Cas{"a": 10, "b": 20, "c": 30},
+		mutations: map[string]int{"b": 2},
+	}
+
+	results, err := consistentSnapshot([]string{"a", "b", "c", "missing", "a"}, 5, store.read, store.verify)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 3 || results["a"].Cas != 10 || results["b"].Cas != 22 || results["c"].Cas != 30 {
+		t.Fatalf("Unexpected results %v", results)
+	}
+	if len(store.reads) != 3 || len(store.reads[0]) != 4 || len(store.reads[1]) != 1 || store.reads[1][0] != "b" {
+		t.Fatalf("Expected only changed documents to be read again, got %v", store.reads)
+	}
+}
+
+func TestConsistentSnapshotContention(t *testing.T) {
+	store := &fakeSnapshotStore{
+		cas:       map[string]Cas{"a": 10, "b": 20, "c": 30},
+		mutations: map[string]int{"c": 100, "a": 100},
+	}
+
+	results, err := consistentSnapshot([]string{"a", "b", "c"}, 3, store.read, store.verify)
+	contentionErr, ok := err.(*SnapshotContentionError)
+	if !ok {
+		t.Fatalf("Expected a SnapshotContentionError, got %v", err)
+	}
+	if ErrorCause(err) != ErrSnapshotContention {
+		t.Fatalf("Expected cause to be ErrSnapshotContention, got %v", ErrorCause(err))
+	}
+	if len(contentionErr.Keys) != 2 || contentionErr.Keys[0] != "a" || contentionErr.Keys[1] != "c" {
+		t.Fatalf("Unexpected unstable keys %v", contentionErr.Keys)
+	}
+	if len(store.reads) != 3 || results["b"].Cas != 20 {
+		t.Fatalf("Unexpected reads %v and results %v", store.reads, results)
+	}
+}
+
+func TestSnapshotChanged(t *testing.T) {
+	read := &GetResult{Key: "a", Cas: 5}
+	if snapshotChanged(read, true, 5) || snapshotChanged(nil, false, 0) {
+		t.Fatalf("Expected unchanged documents to be stable")
+	}
+	if !snapshotChanged(read, true, 6) || !snapshotChanged(read, false, 0) || !snapshotChanged(nil, true, 1) {
+		t.Fatalf("Expected changed documents to be detected")
+	}
+}
@@ -1,30 +1,43 @@
 package gocb
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/url"
 	"strconv"
 	"strings"
 )
 
+type spatialUnbounded struct{}
+
+// Unbounded specifies that a dimension of a spatial range has no bound, so that every
+// value is included on that side of the range.
+var Unbounded = spatialUnbounded{}
+
 // SpatialQuery represents a pending spatial query.
 type SpatialQuery struct {
-	ddoc    string
-	name    string
-	options url.Values
+	ddoc       string
+	name       string
+	options    url.Values
+	startRange []string
+	endRange   []string
+	errs       MultiError
 }
 
 // Stale specifies the level of consistency required for this query.
+//
+// Deprecated: Use ScanConsistency instead.
 func (vq *SpatialQuery) Stale(stale StaleMode) *SpatialQuery {
-	if stale == Before {
-		vq.options.Set("stale", "false")
-	} else if stale == None {
-		vq.options.Set("stale", "ok")
-	} else if stale == After {
-		vq.options.Set("stale", "update_after")
-	} else {
+	if stale < Before || stale > After {
 		panic("Unexpected stale option")
 	}
+	return vq.ScanConsistency(ViewScanConsistency(stale))
+}
+
+// ScanConsistency specifies the level of consistency required for this query.
+func (vq *SpatialQuery) ScanConsistency(consistency ViewScanConsistency) *SpatialQuery {
+	vq.options.Set("stale", consistency.staleOption())
 	return vq
 }
 
@@ -50,6 +63,122 @@ func (vq *SpatialQuery) Bbox(bounds []float64) *SpatialQuery {
 	return vq
 }
 
+// encodeSpatialBound encodes a bound of a spatial range as a JSON value.  Bounds must
+// be numbers, as any other value silently matches nothing.
+func encodeSpatialBound(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil, spatialUnbounded:
+		return "null", nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return encodeSpatialFloat(float64(v), 32)
+	case float64:
+		return encodeSpatialFloat(v, 64)
+	case json.Number:
+		if _, err := strconv.ParseFloat(string(v), 64); err != nil {
+			return "", fmt.Errorf("Invalid spatial range bound '%s'", v)
+		}
+		return string(v), nil
+	}
+	return "", fmt.Errorf("Spatial range bounds must be numbers or Unbounded, got %T", value)
+}
+
+func encodeSpatialFloat(value float64, bitSize int) (string, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", fmt.Errorf("Invalid spatial range bound %v", value)
+	}
+	return strconv.FormatFloat(value, 'g', -1, bitSize), nil
+}
+
+func (vq *SpatialQuery) encodeRange(values []interface{}) []string {
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		bound, err := encodeSpatialBound(value)
+		if err != nil {
+			vq.errs.add(err)
+		}
+		encoded[i] = bound
+	}
+	return encoded
+}
+
+func (vq *SpatialQuery) setRanges() {
+	if len(vq.startRange) > 0 {
+		vq.options.Set("start_range", "["+strings.Join(vq.startRange, ",")+"]")
+	} else {
+		vq.options.Del("start_range")
+	}
+	if len(vq.endRange) > 0 {
+		vq.options.Set("end_range", "["+strings.Join(vq.endRange, ",")+"]")
+	} else {
+		vq.options.Del("end_range")
+	}
+}
+
+// StartRange specifies the lower bound of each dimension of a multidimensional range
+// to query.  Bounds must be numbers, or Unbounded for dimensions with no lower bound.
+//
+// Experimental: This API is subject to change at any time.
+func (vq *SpatialQuery) StartRange(values ...interface{}) *SpatialQuery {
+	vq.startRange = vq.encodeRange(values)
+	vq.setRanges()
+	return vq
+}
+
+// EndRange specifies the upper bound of each dimension of a multidimensional range to
+// query.  Bounds must be numbers, or Unbounded for dimensions with no upper bound.  The
+// number of dimensions must match that of StartRange.
+//
+// Experimental: This API is subject to change at any time.
+func (vq *SpatialQuery) EndRange(values ...interface{}) *SpatialQuery {
+	vq.endRange = vq.encodeRange(values)
+	vq.setRanges()
+	return vq
+}
+
+// Dimension specifies the bounds of a single dimension of a multidimensional range to
+// query, where either bound may be Unbounded.  Any dimensions before it which have not
+// been specified are unbounded.
+//
+// Experimental: This API is subject to change at any time.
+func (vq *SpatialQuery) Dimension(idx int, min, max interface{}) *SpatialQuery {
+	if idx < 0 {
+		vq.errs.add(fmt.Errorf("Invalid spatial range dimension %d", idx))
+		return vq
+	}
+
+	for len(vq.startRange) <= idx {
+		vq.startRange = append(vq.startRange, "null")
+	}
+	for len(vq.endRange) <= idx {
+		vq.endRange = append(vq.endRange, "null")
+	}
+	bounds := vq.encodeRange([]interface{}{min, max})
+	vq.startRange[idx] = bounds[0]
+	vq.endRange[idx] = bounds[1]
+	vq.setRanges()
+	return vq
+}
+
 // Development specifies whether to query the production or development design document.
 func (vq *SpatialQuery) Development(val bool) *SpatialQuery {
 	if val {
@@ -69,7 +198,17 @@ func (vq *SpatialQuery) Custom(name, value string) *SpatialQuery {
 }
 
 func (vq *SpatialQuery) getInfo() (string, string, url.Values, error) {
-	return vq.ddoc, vq.name, vq.options, nil
+	if len(vq.startRange) > 0 && len(vq.endRange) > 0 && len(vq.startRange) != len(vq.endRange) {
+		return vq.ddoc, vq.name, vq.options, fmt.Errorf("The start range has %d dimensions but the end range has %d",
+			len(vq.startRange), len(vq.endRange))
+	}
+	return vq.ddoc, vq.name, vq.options, vq.errs.get()
+}
+
+// String returns a one-line description of the spatial query and its options.
+func (vq *SpatialQuery) String() string {
+	ddoc, name, opts, _ := vq.getInfo()
+	return formatViewQuery(ddoc, name, opts)
 }
 
 // NewSpatialQuery creates a new SpatialQuery object from a design document and view name.
@@ -80,3 +219,49 @@ func NewSpatialQuery(ddoc, name string) *SpatialQuery {
 		options: url.Values{},
 	}
 }
+
+// SpatialGeometry is the GeoJSON geometry emitted for a row of spatial view results.
+type SpatialGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// SpatialViewRow holds the fields of a row of spatial view results.
+type SpatialViewRow struct {
+	Id string `json:"id"`
+	// Key holds the range of each dimension of the row, as a [min, max] pair.
+	Key   [][]float64     `json:"key"`
+	Value json.RawMessage `json:"value"`
+	// Geometry is the geometry the row was emitted with, or nil if it was emitted
+	// with a bounding box instead.
+	Geometry *SpatialGeometry `json:"geometry,omitempty"`
+}
+
+// Bbox returns the bounding box of the row in the form accepted by SpatialQuery.Bbox,
+// which is the minimum of each dimension followed by the maximum of each dimension.
+func (r SpatialViewRow) Bbox() []float64 {
+	bbox := make([]float64, 2*len(r.Key))
+	for i, dimension := range r.Key {
+		if len(dimension) != 2 {
+			return nil
+		}
+		bbox[i] = dimension[0]
+		bbox[len(r.Key)+i] = dimension[1]
+	}
+	return bbox
+}
+
+// SpatialViewResults allows the rows of spatial view results to be decoded along with
+// their geometry and bounding box.  This is implemented as an additional interface to
+// maintain ABI compatibility for the 1.x series.
+//
+// Experimental: This API is subject to change at any time.
+type SpatialViewResults interface {
+	NextSpatialRow(row *SpatialViewRow) bool
+}
+
+// NextSpatialRow decodes the next row of spatial view results into row.
+func (r *viewResults) NextSpatialRow(row *SpatialViewRow) bool {
+	*row = SpatialViewRow{}
+	return r.Next(row)
+}
@@ -0,0 +1,107 @@
+package gocb
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSpatialQueryRanges(t *testing.T) {
+	tests := []struct {
+		query *SpatialQuery
+		start string
+		end   string
+		enc   string
+	}{
+		{
+			NewSpatialQuery("ddoc", "view").StartRange(0, -10.5).EndRange(100, 10.5),
+			"[0,-10.5]", "[100,10.5]",
+			"end_range=%5B100%2C10.5%5D&start_range=%5B0%2C-10.5%5D",
+		},
+		{
+			NewSpatialQuery("ddoc", "view").StartRange(-180.0, Unbounded, int64(-3)).EndRange(180.0, nil, uint8(7)),
+			"[-180,null,-3]", "[180,null,7]",
+			"end_range=%5B180%2Cnull%2C7%5D&start_range=%5B-180%2Cnull%2C-3%5D",
+		},
+		{
+			// Exponents must keep their sign once the range is URL encoded.
+			NewSpatialQuery("ddoc", "view").StartRange(1e-7, -2.5e21).EndRange(float32(0.25), json.Number("6.02e23")),
+			"[1e-07,-2.5e+21]", "[0.25,6.02e23]",
+			"end_range=%5B0.25%2C6.02e23%5D&start_range=%5B1e-07%2C-2.5e%2B21%5D",
+		},
+		{
+			NewSpatialQuery("ddoc", "view").Dimension(2, 5, Unbounded),
+			"[null,null,5]", "[null,null,null]",
+			"end_range=%5Bnull%2Cnull%2Cnull%5D&start_range=%5Bnull%2Cnull%2C5%5D",
+		},
+		{
+			NewSpatialQuery("ddoc", "view").StartRange(1, 2).EndRange(3, 4).Dimension(0, Unbounded, -0.5),
+			"[null,2]", "[-0.5,4]",
+			"end_range=%5B-0.5%2C4%5D&start_range=%5Bnull%2C2%5D",
+		},
+	}
+
+	for i, test := range tests {
+		_, _, opts, err := test.query.getInfo()
+		if err != nil {
+			t.Fatalf("Test %d: unexpected error %v", i, err)
+		}
+		if opts.Get("start_range") != test.start || opts.Get("end_range") != test.end {
+			t.Fatalf("Test %d: expected %s to %s, got %s to %s", i, test.start, test.end,
+				opts.Get("start_range"), opts.Get("end_range"))
+		}
+		if opts.Encode() != test.enc {
+			t.Fatalf("Test %d: expected %s, got %s", i, test.enc, opts.Encode())
+		}
+	}
+}
+
+func TestSpatialQueryRangeErrors(t *testing.T) {
+	queries := []*SpatialQuery{
+		NewSpatialQuery("ddoc", "view").StartRange(0, 0).EndRange(1, 1, 1),
+		NewSpatialQuery("ddoc", "view").StartRange("10").EndRange(20),
+		NewSpatialQuery("ddoc", "view").StartRange(math.NaN()).EndRange(20),
+		NewSpatialQuery("ddoc", "view").StartRange(0).EndRange(math.Inf(1)),
+		NewSpatialQuery("ddoc", "view").StartRange(json.Number("1e")).EndRange(2),
+		NewSpatialQuery("ddoc", "view").Dimension(-1, 0, 1),
+	}
+
+	for i, q := range queries {
+		if _, _, _, err := q.getInfo(); err == nil {
+			t.Fatalf("Query %d: expected an error", i)
+		}
+	}
+}
+
+func TestSpatialViewResultsRows(t *testing.T) {
+	results := &viewResults{
+		index: -1,
+		rows: []json.RawMessage{
+			json.RawMessage(`{"id":"city","key":[[-71.1,-71.1],[42.3,42.3]],"value":{"name":"Boston"},"geometry":{"type":"Point","coordinates":[-71.1,42.3]}}`),
+			json.RawMessage(`{"id":"area","key":[[1,3],[2,4],[0,10]],"value":null}`),
+		},
+	}
+	var spatialResults SpatialViewResults = results
+
+	var row SpatialViewRow
+	if !spatialResults.NextSpatialRow(&row) {
+		t.Fatalf("Failed to read the first row %v", results.err)
+	}
+	if row.Id != "city" || row.Geometry == nil || row.Geometry.Type != "Point" || string(row.Geometry.Coordinates) != "[-71.1,42.3]" {
+		t.Fatalf("Unexpected row %+v", row)
+	}
+	if bbox := row.Bbox(); !reflect.DeepEqual(bbox, []float64{-71.1, 42.3, -71.1, 42.3}) {
+		t.Fatalf("Unexpected bounding box %v", bbox)
+	}
+
+	if !spatialResults.NextSpatialRow(&row) {
+		t.Fatalf("Failed to read the second row %v", results.err)
+	}
+	if row.Geometry != nil {
+		t.Fatalf("Expected the geometry of the previous row to be cleared")
+	}
+	if bbox := row.Bbox(); !reflect.DeepEqual(bbox, []float64{1, 2, 0, 3, 4, 10}) {
+		t.Fatalf("Unexpected bounding box %v", bbox)
+	}
+}
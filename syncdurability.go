@@ -0,0 +1,203 @@
+package gocb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// DurabilityLevel specifies the level of synchronous replication a mutation must
+// achieve before it is reported as successful.  Durability levels require Couchbase
+// Server 6.5 or later.
+//
+// Experimental: This API is subject to change at any time.
+type DurabilityLevel int
+
+const (
+	// DurabilityLevelNone does not require the mutation to be replicated.  This is the
+	// default.
+	DurabilityLevelNone = DurabilityLevel(iota)
+	// DurabilityLevelMajority requires the mutation to reach a majority of the nodes
+	// holding the document.
+	DurabilityLevelMajority
+	// DurabilityLevelMajorityAndPersistOnMaster requires the mutation to reach a majority
+	// of the nodes holding the document, and to be persisted on the active node.
+	DurabilityLevelMajorityAndPersistOnMaster
+	// DurabilityLevelPersistToMajority requires the mutation to be persisted on a
+	// majority of the nodes holding the document.
+	DurabilityLevelPersistToMajority
+)
+
+func (l DurabilityLevel) String() string {
+	switch l {
+	case DurabilityLevelNone:
+		return "None"
+	case DurabilityLevelMajority:
+		return "Majority"
+	case DurabilityLevelMajorityAndPersistOnMaster:
+		return "MajorityAndPersistOnMaster"
+	case DurabilityLevelPersistToMajority:
+		return "PersistToMajority"
+	}
+	return "Unknown"
+}
+
+// syncDurabilityCompatibility is the cluster compatibility version of Couchbase Server
+// 6.5, the first to support synchronous replication.
+const syncDurabilityCompatibility = 6<<16 | 5
+
+// SyncDurabilityNotSupportedError occurs when a mutation requests a DurabilityLevel
+// from a cluster which does not support synchronous replication.  Its cause is
+// ErrSyncDurabilityNotSupported.
+type SyncDurabilityNotSupportedError struct {
+	// Level is the durability level which was requested.
+	Level DurabilityLevel
+	// Compatibility is the cluster compatibility version reported by the cluster, which
+	// encodes the major version in its upper 16 bits and the minor version in its lower.
+	Compatibility int
+}
+
+func (e *SyncDurabilityNotSupportedError) Error() string {
+	return fmt.Sprintf("Durability level %s requires Couchbase Server 6.5 or later, but the cluster is compatible with %d.%d.",
+		e.Level, e.Compatibility>>16, e.Compatibility&0xffff)
+}
+
+// Unwrap returns ErrSyncDurabilityNotSupported.
+func (e *SyncDurabilityNotSupportedError) Unwrap() error {
+	return ErrSyncDurabilityNotSupported
+}
+
+// parseClusterCompatibility returns the cluster compatibility version from the
+// response to /pools/default, which is the lowest reported by any node.
+func parseClusterCompatibility(data []byte) (int, error) {
+	var pool struct {
+		Nodes []struct {
+			ClusterCompatibility int `json:"clusterCompatibility"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &pool); err != nil {
+		return 0, err
+	}
+
+	compat := 0
+	for _, node := range pool.Nodes {
+		if compat == 0 || node.ClusterCompatibility < compat {
+			compat = node.ClusterCompatibility
+		}
+	}
+	if compat == 0 {
+		return 0, clientError{"The cluster did not report its compatibility version."}
+	}
+	return compat, nil
+}
+
+// clusterCompatibility returns the cluster compatibility version, fetching it using
+// fetch if it has not already been retrieved.
+func (c *Cluster) clusterCompatibility(fetch func() (int, error)) (int, error) {
+	c.compatLock.Lock()
+	defer c.compatLock.Unlock()
+
+	if c.compatVersion != 0 {
+		return c.compatVersion, nil
+	}
+	compat, err := fetch()
+	if err != nil {
+		return 0, err
+	}
+	c.compatVersion = compat
+	return compat, nil
+}
+
+func (b *Bucket) fetchClusterCompatibility() (int, error) {
+	resp, err := b.Manager("", "").mgmtRequest("GET", "/pools/default", "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logDebugf("Failed to close socket (%s)", err)
+		}
+	}()
+
+	if resp.StatusCode != 200 {
+		return 0, clientError{fmt.Sprintf("Failed to retrieve the cluster compatibility version (status %d).", resp.StatusCode)}
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return parseClusterCompatibility(data)
+}
+
+// checkDurabilityLevel returns an error if the cluster cannot achieve the requested
+// durability level.
+func (b *Bucket) checkDurabilityLevel(level DurabilityLevel) error {
+	if level == DurabilityLevelNone {
+		return nil
+	}
+	if level > DurabilityLevelPersistToMajority {
+		return clientError{"Unknown durability level."}
+	}
+
+	compat, err := b.cluster.clusterCompatibility(b.fetchClusterCompatibility)
+	if err != nil {
+		return err
+	}
+	if compat < syncDurabilityCompatibility {
+		return &SyncDurabilityNotSupportedError{Level: level, Compatibility: compat}
+	}
+	return nil
+}
+
+// durabilityLevelRequirements returns the number of replicas a mutation must reach and
+// the number of nodes it must be persisted on to achieve level, given the number of
+// replicas of the bucket, and whether it must be persisted on the active node.
+func durabilityLevelRequirements(level DurabilityLevel, numReplicas int) (replicateTo, persistTo uint, persistActive bool) {
+	majority := uint((numReplicas+1)/2 + 1)
+	switch level {
+	case DurabilityLevelMajority:
+		return majority - 1, 0, false
+	case DurabilityLevelMajorityAndPersistOnMaster:
+		return majority - 1, 0, true
+	case DurabilityLevelPersistToMajority:
+		return majority - 1, majority, false
+	}
+	return 0, 0, false
+}
+
+// levelDurability waits for a mutation to achieve the requested durability level.  The
+// version of the memcached protocol used by this library cannot carry durability
+// requirements to the server, so the level is achieved by observing the mutation
+// until it has reached the required nodes.
+func (b *Bucket) levelDurability(key string, cas Cas, level DurabilityLevel, forDelete bool) error {
+	if level == DurabilityLevelNone {
+		return nil
+	}
+
+	replicateTo, persistTo, persistActive := durabilityLevelRequirements(level, b.client.NumReplicas())
+	if err := b.durability(key, cas, MutationToken{}, replicateTo, persistTo, forDelete); err != nil {
+		return err
+	}
+	if !persistActive {
+		return nil
+	}
+
+	keyBytes := []byte(key)
+	return b.awaitDurability(func(replicaIdx int, commCh chan uint) (pendingOp, error) {
+		return b.observeOnceCas(keyBytes, cas, forDelete, replicaIdx, commCh)
+	}, 1, 0, 1, b.duraTimeout)
+}
+
+// mutateWithLevel performs a mutation and waits for it to achieve the requested
+// durability level, first checking that the cluster supports the level so that the
+// mutation is not performed if it cannot be achieved.
+func (b *Bucket) mutateWithLevel(key string, level DurabilityLevel, forDelete bool, mutate func() (Cas, MutationToken, error)) (Cas, MutationToken, error) {
+	if err := b.checkDurabilityLevel(level); err != nil {
+		return 0, MutationToken{}, err
+	}
+	cas, mt, err := mutate()
+	if err != nil {
+		return cas, mt, err
+	}
+	return cas, mt, b.levelDurability(key, cas, level, forDelete)
+}
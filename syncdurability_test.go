@@ -0,0 +1,82 @@
+package gocb
+
+import (
+	"testing"
+)
+
+func TestParseClusterCompatibility(t *testing.T) {
+	compat, err := parseClusterCompatibility([]byte(`{"nodes":[{"clusterCompatibility":393221},{"clusterCompatibility":393222}]}`))
+	if err != nil || compat != 393221 {
+		t.Fatalf("Expected the lowest compatibility of the nodes, got %d %v", compat, err)
+	}
+	if _, err := parseClusterCompatibility([]byte(`{"nodes":[]}`)); err == nil {
+		t.Fatalf("Expected an error for a cluster without nodes")
+	}
+}
+
+func TestClusterCompatibilityIsCached(t *testing.T) {
+	c := &Cluster{}
+	fetches := 0
+	fetch := func() (int, error) {
+		fetches++
+		if fetches == 1 {
+			return 0, ErrTimeout
+		}
+		return 393221, nil
+	}
+
+	if _, err := c.clusterCompatibility(fetch); err != ErrTimeout {
+		t.Fatalf("Expected the fetch error, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if compat, err := c.clusterCompatibility(fetch); err != nil || compat != 393221 {
+			t.Fatalf("Unexpected compatibility %d %v", compat, err)
+		}
+	}
+	if fetches != 2 {
+		t.Fatalf("Expected a failed fetch to be retried and a successful one cached, got %d fetches", fetches)
+	}
+}
+
+func TestCheckDurabilityLevelUnsupported(t *testing.T) {
+	b := &Bucket{cluster: &Cluster{compatVersion: 6<<16 | 0}}
+
+	if err := b.checkDurabilityLevel(DurabilityLevelNone); err != nil {
+		t.Fatalf("Expected no durability level to always be supported, got %v", err)
+	}
+	err := b.checkDurabilityLevel(DurabilityLevelMajority)
+	if _, ok := err.(*SyncDurabilityNotSupportedError); !ok {
+		t.Fatalf("Expected a SyncDurabilityNotSupportedError, got %v", err)
+	}
+	if ErrorCause(err) != ErrSyncDurabilityNotSupported {
+		t.Fatalf("Expected ErrSyncDurabilityNotSupported as the cause, got %v", ErrorCause(err))
+	}
+
+	b.cluster.compatVersion = syncDurabilityCompatibility
+	if err := b.checkDurabilityLevel(DurabilityLevelPersistToMajority); err != nil {
+		t.Fatalf("Expected the durability level to be supported, got %v", err)
+	}
+}
+
+func TestDurabilityLevelRequirements(t *testing.T) {
+	tests := []struct {
+		level         DurabilityLevel
+		numReplicas   int
+		replicateTo   uint
+		persistTo     uint
+		persistActive bool
+	}{
+		{DurabilityLevelMajority, 1, 1, 0, false},
+		{DurabilityLevelMajority, 2, 1, 0, false},
+		{DurabilityLevelMajority, 3, 2, 0, false},
+		{DurabilityLevelMajorityAndPersistOnMaster, 2, 1, 0, true},
+		{DurabilityLevelPersistToMajority, 2, 1, 2, false},
+	}
+	for _, test := range tests {
+		replicateTo, persistTo, persistActive := durabilityLevelRequirements(test.level, test.numReplicas)
+		if replicateTo != test.replicateTo || persistTo != test.persistTo || persistActive != test.persistActive {
+			t.Errorf("Unexpected requirements for %s with %d replicas: %d %d %t",
+				test.level, test.numReplicas, replicateTo, persistTo, persistActive)
+		}
+	}
+}
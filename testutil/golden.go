@@ -0,0 +1,295 @@
+// Package testutil records the requests gocb makes to the HTTP services of a cluster
+// into golden files, and replays them so tests can run without a cluster.
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordEnv is the environment variable which, when set to a non-empty value, causes
+// Golden to record interactions rather than replay them.
+const RecordEnv = "GOCB_RECORD_GOLDEN"
+
+// IgnoredRequestFields are the fields of JSON request bodies and query parameters which
+// differ between otherwise identical requests, and so are not considered when matching
+// requests.
+var IgnoredRequestFields = []string{"client_context_id", "creds"}
+
+// Interaction is a request and the response it received.
+type Interaction struct {
+	Method      string              `json:"method"`
+	Path        string              `json:"path"`
+	BodyHash    string              `json:"body_hash"`
+	RequestBody string              `json:"request_body,omitempty"`
+	Status      int                 `json:"status"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Body        string              `json:"body"`
+}
+
+// Scrubber rewrites a recorded body so that it does not contain volatile or sensitive
+// values.
+type Scrubber func(body []byte) []byte
+
+// ScrubJSONFields returns a Scrubber replacing the values of JSON fields with the
+// specified names, wherever they occur, with "<scrubbed>".
+func ScrubJSONFields(names ...string) Scrubber {
+	var quoted []string
+	for _, name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	fieldExp := regexp.MustCompile(`("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"|-?[0-9][0-9.eE+-]*)`)
+	return func(body []byte) []byte {
+		return fieldExp.ReplaceAll(body, []byte(`$1"<scrubbed>"`))
+	}
+}
+
+var timestampExp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+
+// ScrubTimestamps replaces timestamps, and the durations reported in query metrics,
+// with fixed values.
+func ScrubTimestamps(body []byte) []byte {
+	body = timestampExp.ReplaceAll(body, []byte("2000-01-01T00:00:00Z"))
+	return ScrubJSONFields("elapsedTime", "executionTime", "took")(body)
+}
+
+// ScrubRequestIDs replaces the request and client context ids reported by the query
+// services with fixed values.
+func ScrubRequestIDs(body []byte) []byte {
+	return ScrubJSONFields("requestID", "clientContextID", "client_context_id")(body)
+}
+
+// scrubCredentials removes credentials from recorded bodies.
+var scrubCredentials = ScrubJSONFields("creds", "pass", "password")
+
+// Transport is an http.RoundTripper which records the interactions passing through it
+// to a golden file, or replays them from one.  It is installed using
+// Cluster.SetHttpTransport.
+type Transport struct {
+	t         testing.TB
+	path      string
+	next      http.RoundTripper
+	recording bool
+	scrubbers []Scrubber
+
+	lock         sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// Record returns a Transport which passes requests to next and records each request
+// and its response, writing them to the golden file at path once the test completes.
+// Authorization headers and credentials are never recorded, and response bodies are
+// rewritten by the scrubbers before being recorded.
+func Record(t testing.TB, path string, next http.RoundTripper, scrubbers ...Scrubber) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	rt := &Transport{
+		t:         t,
+		path:      path,
+		next:      next,
+		recording: true,
+		scrubbers: scrubbers,
+	}
+	t.Cleanup(func() {
+		if err := rt.save(); err != nil {
+			t.Errorf("Failed to write golden file %s: %v", path, err)
+		}
+	})
+	return rt
+}
+
+// Replay returns a Transport which serves the responses recorded in the golden file at
+// path.  A request matches a recorded interaction with the same method, path, query
+// and body, with each interaction being served once.  Requests which match no
+// interaction fail the test.
+func Replay(t testing.TB, path string) *Transport {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file %s: %v", path, err)
+	}
+
+	rt := &Transport{t: t, path: path}
+	if err := json.Unmarshal(data, &rt.interactions); err != nil {
+		t.Fatalf("Failed to decode golden file %s: %v", path, err)
+	}
+	rt.used = make([]bool, len(rt.interactions))
+	return rt
+}
+
+// Golden returns a Transport which records to the golden file at path if the
+// GOCB_RECORD_GOLDEN environment variable is set, and replays from it otherwise.
+func Golden(t testing.TB, path string, next http.RoundTripper, scrubbers ...Scrubber) *Transport {
+	if os.Getenv(RecordEnv) != "" {
+		return Record(t, path, next, scrubbers...)
+	}
+	return Replay(t, path)
+}
+
+// Interactions returns the interactions recorded or loaded by the transport.
+func (rt *Transport) Interactions() []Interaction {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]Interaction{}, rt.interactions...)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Body.Close(); err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	key := requestPath(req)
+	hash := canonicalBodyHash(reqBody)
+	if rt.recording {
+		return rt.record(req, key, hash, reqBody)
+	}
+	return rt.replay(req, key, hash)
+}
+
+func (rt *Transport) record(req *http.Request, key, hash string, reqBody []byte) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorded := scrubCredentials(body)
+	for _, scrub := range rt.scrubbers {
+		recorded = scrub(recorded)
+	}
+
+	header := make(map[string][]string)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		header["Content-Type"] = []string{contentType}
+	}
+
+	rt.lock.Lock()
+	rt.interactions = append(rt.interactions, Interaction{
+		Method:      req.Method,
+		Path:        key,
+		BodyHash:    hash,
+		RequestBody: string(scrubCredentials(reqBody)),
+		Status:      resp.StatusCode,
+		Header:      header,
+		Body:        string(recorded),
+	})
+	rt.lock.Unlock()
+	return resp, nil
+}
+
+func (rt *Transport) replay(req *http.Request, key, hash string) (*http.Response, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	for i, interaction := range rt.interactions {
+		if rt.used[i] || interaction.Method != req.Method || interaction.Path != key || interaction.BodyHash != hash {
+			continue
+		}
+		rt.used[i] = true
+
+		header := make(http.Header)
+		for name, values := range interaction.Header {
+			header[name] = values
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+			StatusCode:    interaction.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(interaction.Body)),
+			ContentLength: int64(len(interaction.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	err := fmt.Errorf("no interaction recorded in %s matches %s %s (body hash %s)", rt.path, req.Method, key, hash)
+	rt.t.Errorf("%v", err)
+	return nil, err
+}
+
+func (rt *Transport) save() error {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	rt.lock.Lock()
+	err := enc.Encode(rt.interactions)
+	rt.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rt.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(rt.path, data.Bytes(), 0644)
+}
+
+// requestPath returns the path and query of a request, with the query parameters
+// sorted and IgnoredRequestFields removed, as they are from bodies.  The host is not
+// included, as the ports of test clusters vary.
+func requestPath(req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("password")
+	for _, name := range IgnoredRequestFields {
+		query.Del(name)
+	}
+	if len(query) == 0 {
+		return req.URL.Path
+	}
+	return req.URL.Path + "?" + query.Encode()
+}
+
+// canonicalBodyHash hashes a request body.  JSON bodies are hashed with their keys
+// sorted and IgnoredRequestFields removed, so that equivalent requests match.
+func canonicalBodyHash(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		for _, name := range IgnoredRequestFields {
+			delete(fields, name)
+		}
+		if canonical, err := json.Marshal(fields); err == nil {
+			body = canonical
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"requestID":"abc-123","results":[%s],"metrics":{"elapsedTime":"1.5ms"},"at":"2018-05-02T10:11:12.123Z"}`, body)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gocb-golden")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "query.json")
+
+	t.Run("record", func(t *testing.T) {
+		client := &http.Client{Transport: Record(t, path, nil, ScrubTimestamps, ScrubRequestIDs)}
+		req, _ := http.NewRequest("POST", server.URL+"/query/service?b=2&a=1",
+			strings.NewReader(`{"statement":"SELECT 1","client_context_id":"x1","creds":[{"user":"u","pass":"secret"}]}`))
+		req.SetBasicAuth("Administrator", "password")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to record request: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), "abc-123") {
+			t.Fatalf("Expected the caller to receive the unscrubbed response, got %s", body)
+		}
+	})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the golden file to be written: %v", err)
+	}
+	for _, secret := range []string{"secret", "Administrator", "abc-123", "1.5ms", "2018-05-02"} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("Expected %q to be scrubbed from the golden file:\n%s", secret, data)
+		}
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil || len(interactions) != 1 {
+		t.Fatalf("Unexpected golden file contents %s (%v)", data, err)
+	}
+	if interactions[0].Path != "/query/service?a=1&b=2" {
+		t.Fatalf("Expected the query to be canonicalized, got %s", interactions[0].Path)
+	}
+
+	// Replaying matches the request regardless of host, key order and the fields
+	// which vary between requests.
+	client := &http.Client{Transport: Replay(t, path)}
+	resp, err := client.Post("http://127.0.0.1:1/query/service?a=1&b=2", "application/json",
+		strings.NewReader(`{"creds":[],"client_context_id":"x2","statement":"SELECT 1"}`))
+	if err != nil {
+		t.Fatalf("Failed to replay request: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `"requestID":"<scrubbed>"`) {
+		t.Fatalf("Unexpected replayed response %d %s", resp.StatusCode, body)
+	}
+
+	tb := &recordingTB{TB: t}
+	client = &http.Client{Transport: Replay(tb, path)}
+	_, err = client.Post("http://127.0.0.1:1/query/service?a=1&b=2", "application/json",
+		strings.NewReader(`{"statement":"SELECT 2"}`))
+	if err == nil || len(tb.errors) != 1 {
+		t.Fatalf("Expected an unmatched request to fail the test, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopkg.in/couchbase/gocbcore.v7"
+	"strconv"
 )
 
 // MutationToken holds the mutation state information from an operation.
@@ -12,6 +13,45 @@ type MutationToken struct {
 	bucket *Bucket
 }
 
+type mutationTokenJson struct {
+	VbId   uint16 `json:"vbid"`
+	VbUuid string `json:"vbuuid"`
+	SeqNo  uint64 `json:"seqno"`
+}
+
+// MarshalJSON marshal's this mutation token to JSON so that it can be shared
+// with other processes, such as for use with Bucket.WaitForDurability.
+func (mt MutationToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mutationTokenJson{
+		VbId:   mt.token.VbId,
+		VbUuid: fmt.Sprintf("%d", mt.token.VbUuid),
+		SeqNo:  uint64(mt.token.SeqNo),
+	})
+}
+
+// UnmarshalJSON unmarshal's a mutation token from JSON.  The resulting token is
+// not associated with any bucket.
+func (mt *MutationToken) UnmarshalJSON(data []byte) error {
+	var info mutationTokenJson
+	err := json.Unmarshal(data, &info)
+	if err != nil {
+		return err
+	}
+
+	vbUuid, err := strconv.ParseUint(info.VbUuid, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	mt.token = gocbcore.MutationToken{
+		VbId:   info.VbId,
+		VbUuid: gocbcore.VbUuid(vbUuid),
+		SeqNo:  gocbcore.SeqNo(info.SeqNo),
+	}
+	mt.bucket = nil
+	return nil
+}
+
 type bucketToken struct {
 	SeqNo  uint64 `json:"seqno"`
 	VbUuid string `json:"vbuuid"`
@@ -85,3 +125,23 @@ func (mt *MutationState) MarshalJSON() ([]byte, error) {
 func (mt *MutationState) UnmarshalJSON(data []byte) error {
 	return json.Unmarshal(data, &mt.data)
 }
+
+// searchVectors encodes a mutation state in the form expected by the search service,
+// which keys the sequence number of each vbucket by "vbid/vbuuid" under the name of the
+// index being queried.
+type searchVectors struct {
+	indexName string
+	state     *MutationState
+}
+
+func (sv searchVectors) MarshalJSON() ([]byte, error) {
+	vectors := make(map[string]uint64)
+	if sv.state != nil && sv.state.data != nil {
+		for _, tokens := range *sv.state.data {
+			for vbId, token := range *tokens {
+				vectors[vbId+"/"+token.VbUuid] = token.SeqNo
+			}
+		}
+	}
+	return json.Marshal(map[string]map[string]uint64{sv.indexName: vectors})
+}
@@ -48,3 +48,57 @@ func TestMutationState_Add(t *testing.T) {
 		t.Fatalf("Failed to generate correct JSON output %s", bytes)
 	}
 }
+
+func TestMutationToken_JSON(t *testing.T) {
+	fakeToken := MutationToken{
+		token: gocbcore.MutationToken{
+			VbId:   7,
+			VbUuid: gocbcore.VbUuid(18446744073709551615),
+			SeqNo:  gocbcore.SeqNo(42),
+		},
+		bucket: &Bucket{name: "frank"},
+	}
+
+	bytes, err := json.Marshal(fakeToken)
+	if err != nil {
+		t.Fatalf("Failed to marshal %v", err)
+	}
+
+	if strings.Compare(string(bytes), "{\"vbid\":7,\"vbuuid\":\"18446744073709551615\",\"seqno\":42}") != 0 {
+		t.Fatalf("Failed to generate correct JSON output %s", bytes)
+	}
+
+	var decoded MutationToken
+	err = json.Unmarshal(bytes, &decoded)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal %v", err)
+	}
+
+	if decoded.token != fakeToken.token {
+		t.Fatalf("Decoded token did not match, got %+v", decoded.token)
+	}
+}
+
+func TestSearchQueryConsistentWith(t *testing.T) {
+	fakeToken := MutationToken{
+		token: gocbcore.MutationToken{
+			VbId:   3,
+			VbUuid: gocbcore.VbUuid(77),
+			SeqNo:  gocbcore.SeqNo(15),
+		},
+		bucket: &Bucket{name: "frank"},
+	}
+	state := NewMutationState()
+	q := NewSearchQuery("travel", nil).ConsistentWith(state)
+	state.Add(fakeToken)
+
+	bytes, err := json.Marshal(q.queryData())
+	if err != nil {
+		t.Fatalf("Failed to marshal %v", err)
+	}
+
+	expected := "\"ctl\":{\"consistency\":{\"level\":\"at_plus\",\"vectors\":{\"travel\":{\"3/77\":15}}}}"
+	if !strings.Contains(string(bytes), expected) {
+		t.Fatalf("Failed to generate correct JSON output %s", bytes)
+	}
+}
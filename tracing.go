@@ -0,0 +1,296 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RequestSpanContext identifies a span to the RequestTracer which created it, so that
+// spans can be started as its children.  Its value is opaque to the library.
+//
+// Experimental: This API is subject to change at any time.
+type RequestSpanContext interface{}
+
+// RequestSpan represents a single operation traced by a RequestTracer.
+//
+// Experimental: This API is subject to change at any time.
+type RequestSpan interface {
+	// SetTag annotates the span with a key and value.
+	SetTag(key string, value interface{})
+	// Finish marks the end of the operation.
+	Finish()
+	// Context returns the context identifying the span.
+	Context() RequestSpanContext
+}
+
+// RequestTracer creates the spans which trace the operations performed by the library,
+// allowing them to be reported alongside those of the rest of an application, such as
+// through an OpenTracing or OpenTelemetry adapter.
+//
+// Spans are started for every memcached operation and every view, N1QL, FTS and
+// analytics query.  Each is tagged with "db.type", "db.couchbase.service", and where
+// known "db.instance" with the bucket name, and with "error" if the operation failed.
+//
+// Experimental: This API is subject to change at any time.
+type RequestTracer interface {
+	// StartSpan starts a span for an operation which began at startTime, as a child of
+	// parent if it is not nil.
+	StartSpan(operationName string, parent RequestSpanContext, startTime time.Time) RequestSpan
+}
+
+type noopSpan struct{}
+
+func (span noopSpan) SetTag(key string, value interface{}) {}
+func (span noopSpan) Finish()                              {}
+func (span noopSpan) Context() RequestSpanContext          { return nil }
+
+type parentSpanContextKey struct{}
+
+// ContextWithParentSpan returns a context which causes the view, N1QL and FTS queries
+// executed with it to be traced as children of parent.
+//
+// Experimental: This API is subject to change at any time.
+func ContextWithParentSpan(ctx context.Context, parent RequestSpanContext) context.Context {
+	return context.WithValue(ctx, parentSpanContextKey{}, parent)
+}
+
+func parentSpanFromContext(ctx context.Context) RequestSpanContext {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(parentSpanContextKey{})
+}
+
+// Tracer returns the tracer used to trace the operations of the cluster.
+func (c *Cluster) Tracer() RequestTracer {
+	return c.tracer
+}
+
+// SetTracer sets the tracer used to trace the operations of the cluster.  By default
+// a ThresholdLoggingTracer is used.  A nil tracer disables tracing.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetTracer(tracer RequestTracer) {
+	c.tracer = tracer
+}
+
+// startSpan starts a span for an operation on a service which began at start.
+func (c *Cluster) startSpan(operation, service string, parent RequestSpanContext, start time.Time) RequestSpan {
+	if c == nil || c.tracer == nil {
+		return noopSpan{}
+	}
+	span := c.tracer.StartSpan(operation, parent, start)
+	span.SetTag("db.type", "couchbase")
+	span.SetTag("db.couchbase.service", service)
+	return span
+}
+
+func finishSpan(span RequestSpan, err error) {
+	if err != nil {
+		span.SetTag("error", true)
+	}
+	span.Finish()
+}
+
+// traceOperation reports a completed memcached operation to the tracer of the cluster,
+// as a child of parent if it is not nil.
+func (b *Bucket) traceOperation(operation string, parent RequestSpanContext, start time.Time, err error) {
+	span := b.cluster.startSpan(operation, "kv", parent, start)
+	span.SetTag("db.instance", b.name)
+	finishSpan(span, err)
+}
+
+// ThresholdLoggingTracer is a RequestTracer which periodically logs a report of the
+// slowest recent operations of each service whose duration exceeded the threshold of the
+// service.  Reports are logged at LogInfo level, once the report interval has elapsed,
+// by the first operation to complete afterwards.
+//
+// Experimental: This API is subject to change at any time.
+type ThresholdLoggingTracer struct {
+	// Interval is the minimum time between reports.
+	Interval time.Duration
+	// SampleSize is the maximum number of operations of each service in a report.
+	SampleSize int
+	// KvThreshold, ViewsThreshold, N1qlThreshold, SearchThreshold and
+	// AnalyticsThreshold are the durations beyond which operations of each service
+	// are reported.
+	KvThreshold        time.Duration
+	ViewsThreshold     time.Duration
+	N1qlThreshold      time.Duration
+	SearchThreshold    time.Duration
+	AnalyticsThreshold time.Duration
+
+	lock       sync.Mutex
+	lastReport time.Time
+	slow       map[string]*thresholdGroup
+	now        func() time.Time
+	report     func(data []byte)
+}
+
+// NewThresholdLoggingTracer returns a ThresholdLoggingTracer which reports every 10
+// seconds the 10 slowest operations of each service over its threshold, which is 500ms
+// for memcached operations and 1s for queries.
+//
+// Experimental: This API is subject to change at any time.
+func NewThresholdLoggingTracer() *ThresholdLoggingTracer {
+	return &ThresholdLoggingTracer{
+		Interval:           10 * time.Second,
+		SampleSize:         10,
+		KvThreshold:        500 * time.Millisecond,
+		ViewsThreshold:     1 * time.Second,
+		N1qlThreshold:      1 * time.Second,
+		SearchThreshold:    1 * time.Second,
+		AnalyticsThreshold: 1 * time.Second,
+
+		lastReport: time.Now(),
+	}
+}
+
+func (t *ThresholdLoggingTracer) currentTime() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+type thresholdOperation struct {
+	OperationName string `json:"operation_name"`
+	Instance      string `json:"instance,omitempty"`
+	TotalUs       int64  `json:"total_us"`
+}
+
+type thresholdGroup struct {
+	Service    string               `json:"service"`
+	Count      int                  `json:"count"`
+	Operations []thresholdOperation `json:"top"`
+}
+
+// StartSpan starts a span which is reported if its duration exceeds the threshold for
+// its service.
+func (t *ThresholdLoggingTracer) StartSpan(operationName string, parent RequestSpanContext, startTime time.Time) RequestSpan {
+	return &thresholdSpan{
+		tracer:    t,
+		operation: operationName,
+		start:     startTime,
+	}
+}
+
+func (t *ThresholdLoggingTracer) threshold(service string) time.Duration {
+	switch service {
+	case "kv":
+		return t.KvThreshold
+	case "views":
+		return t.ViewsThreshold
+	case "n1ql":
+		return t.N1qlThreshold
+	case "fts":
+		return t.SearchThreshold
+	case "analytics":
+		return t.AnalyticsThreshold
+	}
+	return 0
+}
+
+func (t *ThresholdLoggingTracer) record(service string, op thresholdOperation, elapsed time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if threshold := t.threshold(service); threshold > 0 && elapsed > threshold {
+		group := t.slow[service]
+		if group == nil {
+			if t.slow == nil {
+				t.slow = make(map[string]*thresholdGroup)
+			}
+			group = &thresholdGroup{Service: service}
+			t.slow[service] = group
+		}
+		group.Count++
+		i := len(group.Operations)
+		for i > 0 && group.Operations[i-1].TotalUs < op.TotalUs {
+			i--
+		}
+		if i < t.SampleSize {
+			group.Operations = append(group.Operations, thresholdOperation{})
+			copy(group.Operations[i+1:], group.Operations[i:])
+			group.Operations[i] = op
+			if len(group.Operations) > t.SampleSize {
+				group.Operations = group.Operations[:t.SampleSize]
+			}
+		}
+	}
+
+	now := t.currentTime()
+	if len(t.slow) == 0 || now.Sub(t.lastReport) < t.Interval {
+		return
+	}
+	t.lastReport = now
+
+	var services []string
+	for service := range t.slow {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	groups := make([]*thresholdGroup, len(services))
+	for i, service := range services {
+		groups[i] = t.slow[service]
+	}
+	t.slow = nil
+
+	data, err := json.Marshal(groups)
+	if err != nil {
+		logDebugf("Failed to encode threshold log (%s)", err)
+		return
+	}
+	if t.report != nil {
+		t.report(data)
+		return
+	}
+	logInfof("Threshold Log: %s", data)
+}
+
+type thresholdSpan struct {
+	tracer    *ThresholdLoggingTracer
+	operation string
+	start     time.Time
+	service   string
+	instance  string
+}
+
+func (span *thresholdSpan) SetTag(key string, value interface{}) {
+	switch key {
+	case "db.couchbase.service":
+		span.service, _ = value.(string)
+	case "db.instance":
+		span.instance, _ = value.(string)
+	}
+}
+
+func (span *thresholdSpan) Finish() {
+	elapsed := span.tracer.currentTime().Sub(span.start)
+	span.tracer.record(span.service, thresholdOperation{
+		OperationName: span.operation,
+		Instance:      span.instance,
+		TotalUs:       int64(elapsed / time.Microsecond),
+	}, elapsed)
+}
+
+func (span *thresholdSpan) Context() RequestSpanContext {
+	return nil
+}
+
+// traceQuery reports a completed query to the tracer of the cluster.  The query is
+// traced as a child of the span carried by ctx, if any.
+func (c *Cluster) traceQuery(ctx context.Context, b *Bucket, operation, service, ep string, start time.Time, err error) {
+	parent := parentSpanFromContext(ctx)
+	span := c.startSpan(operation, service, parent, start)
+	if b != nil {
+		span.SetTag("db.instance", b.name)
+	}
+	if ep != "" {
+		span.SetTag("peer.address", ep)
+	}
+	finishSpan(span, err)
+}
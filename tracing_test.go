@@ -0,0 +1,91 @@
+package gocb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type testSpan struct {
+	operation string
+	parent    RequestSpanContext
+	tags      map[string]interface{}
+	finished  bool
+}
+
+func (span *testSpan) SetTag(key string, value interface{}) { span.tags[key] = value }
+func (span *testSpan) Finish()                              { span.finished = true }
+func (span *testSpan) Context() RequestSpanContext          { return span }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(operationName string, parent RequestSpanContext, startTime time.Time) RequestSpan {
+	span := &testSpan{operation: operationName, parent: parent, tags: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func TestTracerSpans(t *testing.T) {
+	tracer := &testTracer{}
+	c := &Cluster{}
+	c.SetTracer(tracer)
+	b := &Bucket{cluster: c, name: "default"}
+
+	b.wrapOpError(newKvOpOptions(PriorityNormal, nil, "parent", 0), ErrTimeout, "Get", "key", time.Now())
+	c.traceQuery(ContextWithParentSpan(context.Background(), "query-parent"), b, "ExecuteN1qlQuery", "n1ql", "http://a:8093", time.Now(), nil)
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(tracer.spans))
+	}
+	kv := tracer.spans[0]
+	if kv.operation != "Get" || kv.parent != "parent" || !kv.finished {
+		t.Fatalf("Unexpected span %+v", kv)
+	}
+	if kv.tags["db.couchbase.service"] != "kv" || kv.tags["db.instance"] != "default" || kv.tags["error"] != true {
+		t.Fatalf("Unexpected tags %+v", kv.tags)
+	}
+	query := tracer.spans[1]
+	if query.parent != "query-parent" || query.tags["peer.address"] != "http://a:8093" || query.tags["error"] != nil {
+		t.Fatalf("Unexpected span %+v", query)
+	}
+}
+
+func TestThresholdLoggingTracer(t *testing.T) {
+	now := time.Unix(100, 0)
+	var reports [][]byte
+	tracer := NewThresholdLoggingTracer()
+	tracer.SampleSize = 2
+	tracer.lastReport = now
+	tracer.now = func() time.Time { return now }
+	tracer.report = func(data []byte) { reports = append(reports, data) }
+
+	c := &Cluster{tracer: tracer}
+	b := &Bucket{cluster: c, name: "default"}
+	b.traceOperation("Get", nil, now.Add(-10*time.Millisecond), nil)
+	b.traceOperation("Get", nil, now.Add(-time.Second), nil)
+	b.traceOperation("Upsert", nil, now.Add(-2*time.Second), nil)
+	b.traceOperation("Remove", nil, now.Add(-600*time.Millisecond), nil)
+	if len(reports) != 0 {
+		t.Fatalf("Expected no report before the interval elapsed")
+	}
+
+	now = now.Add(10 * time.Second)
+	b.traceOperation("Get", nil, now, nil)
+	if len(reports) != 1 {
+		t.Fatalf("Expected a report, got %d", len(reports))
+	}
+
+	var groups []thresholdGroup
+	if err := json.Unmarshal(reports[0], &groups); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Service != "kv" || groups[0].Count != 3 || len(groups[0].Operations) != 2 {
+		t.Fatalf("Unexpected report %s", reports[0])
+	}
+	if groups[0].Operations[0].OperationName != "Upsert" || groups[0].Operations[1].OperationName != "Get" {
+		t.Fatalf("Expected the slowest operations first, got %s", reports[0])
+	}
+}
@@ -1,6 +1,7 @@
 package gocb
 
 import (
+	"bytes"
 	"encoding/json"
 	"gopkg.in/couchbase/gocbcore.v7"
 )
@@ -96,3 +97,53 @@ func (t DefaultTranscoder) Encode(value interface{}) ([]byte, uint32, error) {
 
 	return bytes, flags, nil
 }
+
+// CanonicalJsonTranscoder behaves identically to DefaultTranscoder, except that
+// values encoded as JSON have the keys of every object, including those of nested
+// maps and structs, written in lexicographical order.  Equal values therefore always
+// encode to identical bytes.  This requires each value to be marshalled twice, which
+// roughly doubles the CPU cost of encoding JSON documents.
+type CanonicalJsonTranscoder struct {
+}
+
+// Decode applies the default Couchbase transcoding behaviour to decode into a Go type.
+func (t CanonicalJsonTranscoder) Decode(bytes []byte, flags uint32, out interface{}) error {
+	return DefaultTranscoder{}.Decode(bytes, flags, out)
+}
+
+// Encode applies the default Couchbase transcoding behaviour to encode a Go type,
+// writing JSON object keys in sorted order.
+func (t CanonicalJsonTranscoder) Encode(value interface{}) ([]byte, uint32, error) {
+	switch typeValue := value.(type) {
+	case []byte, *[]byte, string, *string:
+		return DefaultTranscoder{}.Encode(value)
+	case *interface{}:
+		return t.Encode(*typeValue)
+	}
+
+	bytes, err := marshalCanonicalJson(value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes, gocbcore.EncodeCommonFlags(gocbcore.JsonType, gocbcore.NoCompression), nil
+}
+
+// marshalCanonicalJson marshals a value and then round-trips it through generic maps,
+// which encoding/json always writes with sorted keys.  Numbers are preserved exactly.
+func marshalCanonicalJson(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(generic)
+}
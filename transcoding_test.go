@@ -156,3 +156,106 @@ func TestEncodeString(t *testing.T) {
 	}
 	testBytesEqual(t, bytes, jsonStrStr)
 }
+
+func TestEncodeValueGuards(t *testing.T) {
+	fakeBucket := &Bucket{
+		cluster:    &Cluster{},
+		transcoder: defaultTranscoder,
+	}
+
+	invalidValues := []interface{}{
+		make(chan int),
+		func() {},
+		complex(1, 2),
+		struct{ Ch chan int }{},
+	}
+	for _, value := range invalidValues {
+		_, _, err := fakeBucket.encodeValue(value)
+		if ErrorCause(err) != ErrInvalidValue {
+			t.Errorf("Expected ErrInvalidValue for %T, got %v", value, err)
+		}
+	}
+
+	bigValue := make([]byte, maxServerValueSize+1)
+	_, _, err := fakeBucket.encodeValue(bigValue)
+	if ErrorCause(err) != ErrTooBig {
+		t.Errorf("Expected ErrTooBig for oversized value, got %v", err)
+	}
+
+	fakeBucket.cluster.SetMaxValueSize(10)
+	_, _, err = fakeBucket.encodeValue("this is more than ten bytes")
+	if ErrorCause(err) != ErrTooBig {
+		t.Errorf("Expected ErrTooBig for value over the cluster limit, got %v", err)
+	}
+
+	_, _, err = fakeBucket.encodeValue("small")
+	if err != nil {
+		t.Errorf("Expected small value to encode, got %v", err)
+	}
+}
+
+func TestCanonicalEncodeSortsKeys(t *testing.T) {
+	type testNested struct {
+		Zebra int               `json:"zebra"`
+		Apple map[string]string `json:"apple"`
+	}
+	type testStruct struct {
+		Second string     `json:"second"`
+		First  testNested `json:"first"`
+		Big    uint64     `json:"big"`
+	}
+
+	var transcoder CanonicalJsonTranscoder
+	bytes, _, err := transcoder.Encode(testStruct{
+		Second: "b",
+		First: testNested{
+			Zebra: 1,
+			Apple: map[string]string{"y": "1", "x": "2"},
+		},
+		Big: 18446744073709551615,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode %v", err)
+	}
+	testBytesEqual(t, bytes, []byte(`{"big":18446744073709551615,"first":{"apple":{"x":"2","y":"1"},"zebra":1},"second":"b"}`))
+}
+
+func TestCanonicalEncodeIsStable(t *testing.T) {
+	testIn := map[string]interface{}{
+		"delta": 4,
+		"alpha": []interface{}{map[string]interface{}{"c": 1, "b": 2, "a": 3}},
+		"gamma": map[string]interface{}{"z": true, "m": nil, "a": "x"},
+		"beta":  1.5,
+	}
+
+	var transcoder CanonicalJsonTranscoder
+	first, _, err := transcoder.Encode(testIn)
+	if err != nil {
+		t.Fatalf("Failed to encode %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		bytes, _, err := transcoder.Encode(testIn)
+		if err != nil {
+			t.Fatalf("Failed to encode %v", err)
+		}
+		testBytesEqual(t, bytes, first)
+	}
+	testBytesEqual(t, first, []byte(`{"alpha":[{"a":3,"b":2,"c":1}],"beta":1.5,"delta":4,"gamma":{"a":"x","m":null,"z":true}}`))
+}
+
+func TestWithTranscoder(t *testing.T) {
+	bucket := &Bucket{transcoder: &DefaultTranscoder{}}
+	view := bucket.WithTranscoder(CanonicalJsonTranscoder{})
+
+	if _, ok := view.Transcoder().(CanonicalJsonTranscoder); !ok {
+		t.Fatalf("Expected the view to use the canonical transcoder, got %T", view.Transcoder())
+	}
+	if _, ok := bucket.Transcoder().(*DefaultTranscoder); !ok {
+		t.Fatalf("Expected the bucket to keep its transcoder, got %T", bucket.Transcoder())
+	}
+
+	view.SetTranscoder(nil)
+	if _, ok := view.Transcoder().(*DefaultTranscoder); !ok {
+		t.Fatalf("Expected a nil transcoder to restore the default, got %T", view.Transcoder())
+	}
+}
@@ -0,0 +1,348 @@
+package gocb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrustCertificate describes a CA certificate trusted for TLS connections to the cluster.
+type TrustCertificate struct {
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// trustPool is a set of certificates trusted for TLS connections.  Pools are never
+// modified once created, so that they can be swapped atomically.
+type trustPool struct {
+	verify bool
+	roots  *x509.CertPool
+	certs  []TrustCertificate
+}
+
+func parseTrustPool(pemBytes []byte) (*trustPool, error) {
+	pool := &trustPool{
+		verify: true,
+		roots:  x509.NewCertPool(),
+	}
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pool.roots.AddCert(cert)
+		pool.certs = append(pool.certs, TrustCertificate{
+			Subject:  cert.Subject.String(),
+			NotAfter: cert.NotAfter,
+		})
+	}
+
+	if len(pool.certs) == 0 {
+		return nil, clientError{"No certificates were found in the PEM data."}
+	}
+	return pool, nil
+}
+
+// verifyPeer verifies the certificate chain presented by a server, including that it
+// is valid for the server name which was dialed unless skipHostname is set.
+func (p *trustPool) verifyPeer(cs tls.ConnectionState, skipHostname bool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return clientError{"The server did not present a certificate."}
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	if !skipHostname {
+		opts.DNSName = cs.ServerName
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// The interval at which a watched trust certificate file is checked for changes.
+var trustWatchInterval = 10 * time.Second
+
+// trustStore holds the certificates trusted for TLS connections to the cluster.  It
+// takes over verification for the TLS configuration shared by the memcached, HTTP and
+// config connections of every bucket, so that replacing its pool applies to every new
+// connection without disturbing those already established.
+type trustStore struct {
+	current      atomic.Value
+	skipHostname int32
+
+	lock         sync.Mutex
+	watchPath    string
+	watchModTime time.Time
+	watchStop    chan struct{}
+}
+
+// newTrustStore creates a trust store which verifies connections made using config.  If
+// config skips verification, connections continue to be accepted unverified until
+// certificates are provided.
+func newTrustStore(config *tls.Config, certPath string) *trustStore {
+	s := &trustStore{}
+
+	pool := &trustPool{
+		verify: !config.InsecureSkipVerify,
+		roots:  config.RootCAs,
+	}
+	if certPath != "" {
+		if pemBytes, err := ioutil.ReadFile(certPath); err == nil {
+			if parsed, err := parseTrustPool(pemBytes); err == nil {
+				pool.certs = parsed.certs
+			}
+		}
+	}
+	s.current.Store(pool)
+
+	// Verification is performed by verifyConnection against the current pool, rather
+	// than by crypto/tls against the pool the configuration was created with.
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = s.verifyConnection
+	return s
+}
+
+func (s *trustStore) pool() *trustPool {
+	return s.current.Load().(*trustPool)
+}
+
+func (s *trustStore) update(pemBytes []byte) error {
+	pool, err := parseTrustPool(pemBytes)
+	if err != nil {
+		return err
+	}
+	s.current.Store(pool)
+	return nil
+}
+
+func (s *trustStore) setRoots(roots *x509.CertPool) {
+	s.current.Store(&trustPool{
+		verify: true,
+		roots:  roots,
+	})
+}
+
+func (s *trustStore) certificates() []TrustCertificate {
+	return append([]TrustCertificate{}, s.pool().certs...)
+}
+
+func (s *trustStore) verifyConnection(cs tls.ConnectionState) error {
+	pool := s.pool()
+	if !pool.verify {
+		return nil
+	}
+
+	skipHostname := atomic.LoadInt32(&s.skipHostname) != 0
+	err := pool.verifyPeer(cs, skipHostname)
+	if err == nil {
+		return nil
+	}
+
+	// The server may have rotated its certificate before the new trust certificates
+	// were noticed, so verification is retried once against the latest certificates.
+	s.reloadWatched()
+	if updated := s.pool(); updated != pool {
+		err = updated.verifyPeer(cs, skipHostname)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return detailedError{ErrTLSVerification,
+		fmt.Sprintf("The certificate presented by %s could not be verified against the trusted certificates (%s).", cs.ServerName, err)}
+}
+
+// watch loads the trust certificates from a file and reloads them whenever the file
+// changes, replacing any file previously watched.  An empty path stops watching.
+func (s *trustStore) watch(path string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.watchStop != nil {
+		close(s.watchStop)
+		s.watchStop = nil
+	}
+	s.watchPath = ""
+	if path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = s.update(pemBytes)
+	if err != nil {
+		return err
+	}
+
+	s.watchPath = path
+	s.watchModTime = info.ModTime()
+	s.watchStop = make(chan struct{})
+	go s.watchLoop(s.watchStop)
+	return nil
+}
+
+func (s *trustStore) watchLoop(stop chan struct{}) {
+	ticker := time.NewTicker(trustWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.reloadWatched()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reloadWatched reloads the watched file if it has changed since it was last loaded.
+func (s *trustStore) reloadWatched() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.watchPath == "" {
+		return
+	}
+
+	info, err := os.Stat(s.watchPath)
+	if err != nil {
+		logWarnf("Failed to check trust certificates %s for changes (%s)", redactSystemData(s.watchPath), err)
+		return
+	}
+	if info.ModTime().Equal(s.watchModTime) {
+		return
+	}
+
+	pemBytes, err := ioutil.ReadFile(s.watchPath)
+	if err == nil {
+		err = s.update(pemBytes)
+	}
+	if err != nil {
+		// The file may be part way through being rewritten, so it is checked again
+		// rather than being marked as loaded.
+		logWarnf("Failed to reload trust certificates from %s (%s)", redactSystemData(s.watchPath), err)
+		return
+	}
+	s.watchModTime = info.ModTime()
+	logDebugf("Reloaded trust certificates from %s", redactSystemData(s.watchPath))
+}
+
+// UpdateTrustCertificates replaces the CA certificates trusted for TLS connections to
+// the cluster with those in pemBytes.  The new certificates apply to every connection
+// made afterwards by the memcached, HTTP and configuration clients of every bucket,
+// while established connections are left open.  Connections which fail verification
+// fail with ErrTLSVerification.  This is only available when the connection string
+// uses TLS.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) UpdateTrustCertificates(pemBytes []byte) error {
+	if c.trust == nil {
+		return clientError{"Trust certificates can only be updated when connected using TLS."}
+	}
+	return c.trust.update(pemBytes)
+}
+
+// WatchTrustCertificates loads the CA certificates trusted for TLS connections to the
+// cluster from a PEM file, and reloads them whenever the file changes, as by
+// UpdateTrustCertificates.  The file is checked for changes every 10 seconds, and also
+// whenever a connection fails verification.  Passing an empty path stops watching.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) WatchTrustCertificates(path string) error {
+	if c.trust == nil {
+		return clientError{"Trust certificates can only be updated when connected using TLS."}
+	}
+	return c.trust.watch(path)
+}
+
+// TrustCertificates returns the CA certificates currently trusted for TLS connections
+// to the cluster, if they were loaded from PEM data.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) TrustCertificates() []TrustCertificate {
+	if c.trust == nil {
+		return nil
+	}
+	return c.trust.certificates()
+}
+
+// SetTrustRootCAs replaces the CA certificates trusted for TLS connections to the
+// cluster with a certificate pool, as by UpdateTrustCertificates.  Since the
+// certificates of a pool cannot be listed, TrustCertificates returns none afterwards.
+// This is only available when the connection string uses TLS.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetTrustRootCAs(roots *x509.CertPool) error {
+	if c.trust == nil {
+		return clientError{"Trust certificates can only be updated when connected using TLS."}
+	}
+	if roots == nil {
+		return clientError{"A certificate pool must be specified."}
+	}
+	c.trust.setRoots(roots)
+	return nil
+}
+
+// SetSkipHostnameVerification specifies whether TLS connections to the cluster accept
+// certificates which are signed by a trusted CA but are not valid for the name of the
+// node, such as when connecting to a development cluster by IP address.  This should
+// not be used in production.  This is only available when the connection string uses
+// TLS.
+//
+// Experimental: This API is subject to change at any time.
+func (c *Cluster) SetSkipHostnameVerification(skip bool) error {
+	if c.trust == nil {
+		return clientError{"Hostname verification can only be configured when connected using TLS."}
+	}
+	var value int32
+	if skip {
+		value = 1
+	}
+	atomic.StoreInt32(&c.trust.skipHostname, value)
+	return nil
+}
+
+// secureEps returns the endpoints which use TLS when the connection string uses TLS, so
+// that HTTP requests are never sent in the clear to a cluster connected to securely.
+func (c *Cluster) secureEps(eps []string) []string {
+	if c == nil || c.agentConfig.TlsConfig == nil {
+		return eps
+	}
+
+	var secure []string
+	for _, ep := range eps {
+		if strings.HasPrefix(ep, "https://") {
+			secure = append(secure, ep)
+		} else {
+			logWarnf("Ignoring endpoint %s which does not use TLS", redactSystemData(ep))
+		}
+	}
+	return secure
+}
@@ -0,0 +1,213 @@
+package gocb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) serverCert(t *testing.T) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create server certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{ca.serverCert(t)}}
+	server.StartTLS()
+	return server
+}
+
+func TestTrustStoreRotation(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "old-ca"), newTestCA(t, "new-ca")
+	oldServer, newServer := newTestTLSServer(t, oldCA), newTestTLSServer(t, newCA)
+	defer oldServer.Close()
+	defer newServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(oldCA.pem)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	c := &Cluster{trust: newTrustStore(tlsConfig, "")}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	get := func(url string) error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return unwrapRedirectError(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		return resp.Body.Close()
+	}
+
+	if err := get(oldServer.URL); err != nil {
+		t.Fatalf("Expected the initial certificates to be trusted: %v", err)
+	}
+	if err := get(newServer.URL); ErrorCause(err) != ErrTLSVerification {
+		t.Fatalf("Expected ErrTLSVerification for an untrusted server, got %v", err)
+	}
+
+	if err := c.UpdateTrustCertificates(newCA.pem); err != nil {
+		t.Fatalf("Failed to update trust certificates: %v", err)
+	}
+	if err := get(newServer.URL); err != nil {
+		t.Fatalf("Expected the updated certificates to be trusted: %v", err)
+	}
+	// The connection established before the update remains open and usable.
+	if err := get(oldServer.URL); err != nil {
+		t.Fatalf("Expected the existing connection to be kept: %v", err)
+	}
+
+	certs := c.TrustCertificates()
+	if len(certs) != 1 || certs[0].Subject != "CN=new-ca" || certs[0].NotAfter.IsZero() {
+		t.Fatalf("Unexpected trust certificates %+v", certs)
+	}
+	if err := c.UpdateTrustCertificates([]byte("not a certificate")); err == nil {
+		t.Fatalf("Expected invalid PEM data to be rejected")
+	}
+}
+
+func TestTrustStoreWatchRetriesVerification(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "old-ca"), newTestCA(t, "new-ca")
+	server := newTestTLSServer(t, newCA)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gocb-trust")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(path, oldCA.pem, 0600)
+
+	tlsConfig := &tls.Config{}
+	c := &Cluster{trust: newTrustStore(tlsConfig, "")}
+	if err := c.WatchTrustCertificates(path); err != nil {
+		t.Fatalf("Failed to watch trust certificates: %v", err)
+	}
+	defer c.WatchTrustCertificates("")
+
+	// Rotate the file without waiting for the watcher to notice; the failed
+	// verification reloads it and retries.
+	ioutil.WriteFile(path, newCA.pem, 0600)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the rotated certificates to be loaded on failure: %v", err)
+	}
+	resp.Body.Close()
+
+	if certs := c.TrustCertificates(); len(certs) != 1 || certs[0].Subject != "CN=new-ca" {
+		t.Fatalf("Expected the reloaded certificates to be reported, got %+v", certs)
+	}
+}
+
+func TestTrustStoreRootCAsAndHostnameVerification(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	server := newTestTLSServer(t, ca)
+	defer server.Close()
+
+	tlsConfig := &tls.Config{}
+	c := &Cluster{trust: newTrustStore(tlsConfig, "")}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	if err := c.SetTrustRootCAs(roots); err != nil {
+		t.Fatalf("Failed to set root CAs: %v", err)
+	}
+
+	get := func(url string) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(url)
+		if err != nil {
+			return unwrapRedirectError(err)
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(server.URL); err != nil {
+		t.Fatalf("Expected the root CAs to be trusted: %v", err)
+	}
+	// The certificate is only valid for the IP address of the server.
+	byName := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if err := get(byName); ErrorCause(err) != ErrTLSVerification {
+		t.Fatalf("Expected ErrTLSVerification for a mismatched hostname, got %v", err)
+	}
+	if err := c.SetSkipHostnameVerification(true); err != nil {
+		t.Fatalf("Failed to skip hostname verification: %v", err)
+	}
+	if err := get(byName); err != nil {
+		t.Fatalf("Expected the hostname not to be verified: %v", err)
+	}
+}
+
+func TestSecureEpsRequireTls(t *testing.T) {
+	eps := []string{"http://10.0.0.1:8093", "https://10.0.0.2:18093"}
+
+	c := &Cluster{}
+	if available := c.availableEps(eps); len(available) != 2 {
+		t.Fatalf("Expected every endpoint without TLS, got %v", available)
+	}
+
+	c.agentConfig.TlsConfig = &tls.Config{}
+	if available := c.availableEps(eps); len(available) != 1 || available[0] != "https://10.0.0.2:18093" {
+		t.Fatalf("Expected only the TLS endpoint, got %v", available)
+	}
+}
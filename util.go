@@ -1,7 +1,9 @@
 package gocb
 
 import (
+	"context"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -13,6 +15,7 @@ func doHttpWithTimeout(cli *http.Client, req *http.Request, timeout time.Duratio
 	if timeout.Seconds() == 0 {
 		// No timeout
 		resp, err = cli.Do(req)
+		err = unwrapRedirectError(err)
 		return
 	}
 
@@ -24,5 +27,34 @@ func doHttpWithTimeout(cli *http.Client, req *http.Request, timeout time.Duratio
 	req.Cancel = tmoch
 	resp, err = cli.Do(req)
 	timer.Stop()
+	err = unwrapRedirectError(err)
 	return
 }
+
+// contextTimeout returns the shorter of timeout and the time remaining until the
+// deadline of ctx, if it has one.  A timeout of zero is no timeout.
+func contextTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		remaining = time.Nanosecond
+	}
+	if timeout == 0 || remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
+
+// unwrapRedirectError returns the error raised while checking a redirect directly,
+// rather than wrapped inside of the *url.Error returned by net/http.
+func unwrapRedirectError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		if redirectErr, ok := urlErr.Err.(detailedError); ok {
+			return redirectErr
+		}
+	}
+	return err
+}
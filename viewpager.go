@@ -0,0 +1,171 @@
+package gocb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+type viewPagerState struct {
+	QueryHash string          `json:"query"`
+	LastKey   json.RawMessage `json:"key,omitempty"`
+	LastId    string          `json:"id,omitempty"`
+	Done      bool            `json:"done,omitempty"`
+}
+
+// ViewPager iterates over the results of a view query one page at a time.  Rather
+// than skipping over the rows of the previous pages, which the view engine must still
+// read, each page starts at the key and document id of the final row of the previous
+// page.
+//
+// The query must not specify its own limit or skip options, and pages cannot be fetched
+// for queries which only request the ids of the rows.
+//
+// Experimental: This API is subject to change at any time.
+type ViewPager struct {
+	bucket   *Bucket
+	query    *ViewQuery
+	pageSize int
+	state    viewPagerState
+}
+
+// NewViewPager creates a ViewPager which executes pages of a view query against this bucket.
+func (b *Bucket) NewViewPager(q *ViewQuery, pageSize int) *ViewPager {
+	return &ViewPager{
+		bucket:   b,
+		query:    q,
+		pageSize: pageSize,
+		state: viewPagerState{
+			QueryHash: statementHash(q.String()),
+		},
+	}
+}
+
+func (p *ViewPager) pageOptions() (url.Values, error) {
+	_, _, opts, err := p.query.getInfo()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Get("limit") != "" || opts.Get("skip") != "" {
+		return nil, clientError{"The query passed to a ViewPager must not specify a limit or skip."}
+	}
+	if p.query.idsOnly {
+		return nil, clientError{"A ViewPager requires the key of each row, so cannot be used with IdsOnly."}
+	}
+
+	pageOpts := url.Values{}
+	for name, values := range opts {
+		pageOpts[name] = values
+	}
+	pageOpts.Set("limit", strconv.Itoa(p.pageSize))
+	if p.state.LastKey != nil {
+		// The final row of the previous page is included again by startkey, so it is
+		// skipped over.
+		pageOpts.Set("startkey", string(p.state.LastKey))
+		if p.state.LastId != "" {
+			pageOpts.Set("startkey_docid", p.state.LastId)
+		}
+		pageOpts.Set("skip", "1")
+	}
+	return pageOpts, nil
+}
+
+// recordPage advances the pager past the rows of a page.
+func (p *ViewPager) recordPage(rows []json.RawMessage) error {
+	if len(rows) < p.pageSize {
+		p.state.Done = true
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var lastRow viewRowFields
+	if err := json.Unmarshal(rows[len(rows)-1], &lastRow); err != nil {
+		return err
+	}
+	var lastId string
+	if lastRow.Id != nil {
+		if err := json.Unmarshal(lastRow.Id, &lastId); err != nil {
+			return err
+		}
+	}
+	p.state.LastKey = lastRow.Key
+	p.state.LastId = lastId
+	return nil
+}
+
+// NextPage executes the query for the next page of results.  The rows of the page are
+// read in full before it is returned.  It returns false once all pages have been
+// returned.
+func (p *ViewPager) NextPage() (ViewResults, bool, error) {
+	if p.state.Done {
+		return nil, false, nil
+	}
+
+	opts, err := p.pageOptions()
+	if err != nil {
+		return nil, false, err
+	}
+
+	results, err := p.bucket.executeViewQuery(context.Background(), "_view", p.query.ddoc, p.query.name, opts, viewRowsAll, p.bucket.viewTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	viewRes := results.(*viewResults)
+
+	var rows []json.RawMessage
+	for row := viewRes.NextBytes(); row != nil; row = viewRes.NextBytes() {
+		rows = append(rows, row)
+	}
+	if err := viewRes.Close(); err != nil {
+		return nil, false, err
+	}
+
+	if err := p.recordPage(rows); err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+
+	return &viewResults{
+		index:      -1,
+		rows:       rows,
+		totalRows:  viewRes.totalRows,
+		partial:    viewRes.partial,
+		projection: p.query.projection,
+	}, true, nil
+}
+
+// ContinuationToken returns a token describing the position of the pager, which
+// can be passed to Resume to continue paginating from the same position.
+func (p *ViewPager) ContinuationToken() (string, error) {
+	data, err := json.Marshal(p.state)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Resume restores the position of the pager from a continuation token.  The token
+// must have been produced by a pager for the same query.
+func (p *ViewPager) Resume(token string) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return err
+	}
+
+	var state viewPagerState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	if state.QueryHash != p.state.QueryHash {
+		return clientError{"The continuation token was produced for a different query."}
+	}
+
+	p.state = state
+	return nil
+}
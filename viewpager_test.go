@@ -0,0 +1,69 @@
+package gocb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestViewPagerOptions(t *testing.T) {
+	b := &Bucket{}
+	pager := b.NewViewPager(NewViewQuery("ddoc", "view").Range("a", "z", true), 2)
+
+	opts, err := pager.pageOptions()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if opts.Get("limit") != "2" || opts.Get("startkey") != `"a"` || opts.Get("skip") != "" {
+		t.Fatalf("Unexpected first page options %v", opts)
+	}
+
+	err = pager.recordPage([]json.RawMessage{
+		json.RawMessage(`{"id":"doc1","key":"b","value":null}`),
+		json.RawMessage(`{"id":"doc2","key":"c","value":null}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to record page: %v", err)
+	}
+	opts, _ = pager.pageOptions()
+	if opts.Get("startkey") != `"c"` || opts.Get("startkey_docid") != "doc2" || opts.Get("skip") != "1" || opts.Get("endkey") != `"z"` {
+		t.Fatalf("Unexpected next page options %v", opts)
+	}
+	if pager.query.options.Get("skip") != "" {
+		t.Fatalf("Expected the query itself to be left unchanged")
+	}
+
+	pager.recordPage([]json.RawMessage{json.RawMessage(`{"id":"doc3","key":"d","value":null}`)})
+	if _, more, err := pager.NextPage(); more || err != nil {
+		t.Fatalf("Expected no more pages after a short page")
+	}
+
+	if _, err := b.NewViewPager(NewViewQuery("ddoc", "view").Limit(10), 2).pageOptions(); err == nil {
+		t.Fatalf("Expected queries with a limit to be rejected")
+	}
+}
+
+func TestViewPagerContinuationToken(t *testing.T) {
+	b := &Bucket{}
+	q := NewViewQuery("ddoc", "view")
+
+	pager := b.NewViewPager(q, 10)
+	pager.state.LastKey = json.RawMessage(`["a",1]`)
+	pager.state.LastId = "doc1"
+	token, err := pager.ContinuationToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	resumed := b.NewViewPager(q, 10)
+	if err := resumed.Resume(token); err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	if string(resumed.state.LastKey) != `["a",1]` || resumed.state.LastId != "doc1" {
+		t.Fatalf("Unexpected resumed state %+v", resumed.state)
+	}
+
+	other := b.NewViewPager(NewViewQuery("ddoc", "other"), 10)
+	if err := other.Resume(token); err == nil {
+		t.Fatalf("Expected a token for a different query to be rejected")
+	}
+}
@@ -3,11 +3,15 @@ package gocb
 import (
 	"encoding/json"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // StaleMode specifies the consistency required for a view query.
+//
+// Deprecated: Use ViewScanConsistency instead.
 type StaleMode int
 
 const (
@@ -19,6 +23,34 @@ const (
 	After = StaleMode(3)
 )
 
+// ViewScanConsistency specifies the consistency required for a view query, in line with
+// the consistency options of the other query services.
+type ViewScanConsistency int
+
+const (
+	// ViewScanConsistencyUpdateBefore indicates to update the index before querying it,
+	// so that the results include every mutation made before the query.
+	ViewScanConsistencyUpdateBefore = ViewScanConsistency(1)
+	// ViewScanConsistencyNone indicates to query the index as it is, without updating it.
+	ViewScanConsistencyNone = ViewScanConsistency(2)
+	// ViewScanConsistencyUpdateAfter indicates to query the index as it is, and to update
+	// it asynchronously after querying.
+	ViewScanConsistencyUpdateAfter = ViewScanConsistency(3)
+)
+
+// staleOption returns the value of the stale view option for a scan consistency.
+func (c ViewScanConsistency) staleOption() string {
+	switch c {
+	case ViewScanConsistencyUpdateBefore:
+		return "false"
+	case ViewScanConsistencyNone:
+		return "ok"
+	case ViewScanConsistencyUpdateAfter:
+		return "update_after"
+	}
+	panic("Unexpected scan consistency option")
+}
+
 // SortOrder specifies the ordering for the view queries results.
 type SortOrder int
 
@@ -29,12 +61,28 @@ const (
 	Descending = SortOrder(2)
 )
 
+// ViewErrorMode specifies how a view query behaves when some of the nodes fail to
+// contribute their rows to the results.
+type ViewErrorMode string
+
+const (
+	// ViewErrorContinue indicates to return the rows of the nodes which succeeded,
+	// with the failures of the others being reported once every row has been read.
+	ViewErrorContinue = ViewErrorMode("continue")
+	// ViewErrorStop indicates to stop the query as soon as a node fails, with no
+	// further rows being returned.
+	ViewErrorStop = ViewErrorMode("stop")
+)
+
 // ViewQuery represents a pending view query.
 type ViewQuery struct {
-	ddoc    string
-	name    string
-	options url.Values
-	errs    MultiError
+	ddoc       string
+	name       string
+	options    url.Values
+	errs       MultiError
+	idsOnly    bool
+	projection *rowProjection
+	timeout    time.Duration
 }
 
 func (vq *ViewQuery) marshalJson(value interface{}) []byte {
@@ -47,16 +95,26 @@ func (vq *ViewQuery) marshalJson(value interface{}) []byte {
 }
 
 // Stale specifies the level of consistency required for this query.
+//
+// Deprecated: Use ScanConsistency instead.
 func (vq *ViewQuery) Stale(stale StaleMode) *ViewQuery {
-	if stale == Before {
-		vq.options.Set("stale", "false")
-	} else if stale == None {
-		vq.options.Set("stale", "ok")
-	} else if stale == After {
-		vq.options.Set("stale", "update_after")
-	} else {
+	if stale < Before || stale > After {
 		panic("Unexpected stale option")
 	}
+	return vq.ScanConsistency(ViewScanConsistency(stale))
+}
+
+// ScanConsistency specifies the level of consistency required for this query.
+func (vq *ViewQuery) ScanConsistency(consistency ViewScanConsistency) *ViewQuery {
+	vq.options.Set("stale", consistency.staleOption())
+	return vq
+}
+
+// OnError specifies how the query behaves when some of the nodes fail to contribute
+// their rows to the results.  In either mode the failures are returned by the
+// Errors method of ViewResultErrors and by Close.
+func (vq *ViewQuery) OnError(mode ViewErrorMode) *ViewQuery {
+	vq.options.Set("on_error", string(mode))
 	return vq
 }
 
@@ -179,6 +237,37 @@ func (vq *ViewQuery) Development(val bool) *ViewQuery {
 	return vq
 }
 
+// IdsOnly specifies that only the document id of each row is required.  The server
+// always sends the key and value of each row, so these are discarded as the response
+// is decoded rather than being held in memory.  Each row then contains only an id field.
+func (vq *ViewQuery) IdsOnly(idsOnly bool) *ViewQuery {
+	vq.idsOnly = idsOnly
+	return vq
+}
+
+// Project specifies that only the values at the given JSON pointers are required from
+// each row, such as /id or /value/name.  Each row is then scanned for those values
+// without the rest of the row being decoded, and is returned as an object keyed by the
+// pointers, in which absent values are null.
+//
+// Experimental: This API is subject to change at any time.
+func (vq *ViewQuery) Project(paths ...string) *ViewQuery {
+	projection, err := newRowProjection(paths)
+	if err != nil {
+		vq.errs.add(err)
+		return vq
+	}
+	vq.projection = projection
+	return vq
+}
+
+// Timeout indicates the maximum time to wait for this query to complete, overriding
+// the view timeout of the bucket.
+func (vq *ViewQuery) Timeout(timeout time.Duration) *ViewQuery {
+	vq.timeout = timeout
+	return vq
+}
+
 // Custom allows specifying custom query options.
 func (vq *ViewQuery) Custom(name, value string) *ViewQuery {
 	vq.options.Set(name, value)
@@ -189,6 +278,43 @@ func (vq *ViewQuery) getInfo() (string, string, url.Values, error) {
 	return vq.ddoc, vq.name, vq.options, vq.errs.get()
 }
 
+// String returns a one-line description of the view query and its options.
+func (vq *ViewQuery) String() string {
+	ddoc, name, opts, _ := vq.getInfo()
+	return formatViewQuery(ddoc, name, opts)
+}
+
+// viewQueryUserDataOptions are the options of view and spatial queries whose values
+// are user data, such as keys, to be redacted from their descriptions.
+var viewQueryUserDataOptions = map[string]bool{
+	"key":            true,
+	"keys":           true,
+	"startkey":       true,
+	"endkey":         true,
+	"startkey_docid": true,
+	"endkey_docid":   true,
+	"start_range":    true,
+	"end_range":      true,
+}
+
+func formatViewQuery(ddoc, name string, opts url.Values) string {
+	var names []string
+	for optName := range opts {
+		names = append(names, optName)
+	}
+	sort.Strings(names)
+
+	fields := []string{ddoc + "/" + name}
+	for _, optName := range names {
+		value := strings.Join(opts[optName], ",")
+		if viewQueryUserDataOptions[optName] {
+			value = redactUserData(value)
+		}
+		fields = append(fields, optName+"="+value)
+	}
+	return strings.Join(fields, " ")
+}
+
 // NewViewQuery creates a new ViewQuery object from a design document and view name.
 func NewViewQuery(ddoc, name string) *ViewQuery {
 	return &ViewQuery{
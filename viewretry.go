@@ -0,0 +1,216 @@
+package gocb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The number of times a view query is sent to another node by default when the node it
+// was sent to cannot serve it.
+const defaultViewRetries = 3
+
+// The wait before each retry of a view query which a node could not serve.
+var viewRetryBackoff = ExponentialBackoff(10*time.Millisecond, 500*time.Millisecond, 2)
+
+// errStaleViewNode is the error of a view query which a node could not serve, while it
+// is being retried.
+var errStaleViewNode = errors.New("The view node cannot serve the query.")
+
+// ViewRetries returns the number of times a view query is sent to another node when the
+// node it was sent to is not able to serve it.
+func (b *Bucket) ViewRetries() int {
+	return b.viewRetries
+}
+
+// SetViewRetries sets the number of times a view query is sent to another node when the
+// node it was sent to responds that it is in a pending state or is being rebalanced.
+// Retries wait for a short backoff, are performed within the view timeout of the
+// query, and are limited by the RetryBudget of the cluster.  Zero disables them.
+//
+// Experimental: This API is subject to change at any time.
+func (b *Bucket) SetViewRetries(retries int) {
+	b.viewRetries = retries
+}
+
+// viewQueryTimeout returns the timeout of a view query, which is the view timeout of
+// the bucket unless the query overrides it.
+func (b *Bucket) viewQueryTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return b.viewTimeout
+}
+
+// getViewEpExcluding chooses the endpoint of a view query which is being retried,
+// preferring the endpoints which have not already been tried.
+func (b *Bucket) getViewEpExcluding(tried map[string]bool) (string, error) {
+	capiEps := b.cluster.availableEps(b.client.CapiEps())
+	if len(capiEps) == 0 {
+		return "", &clientError{"No available view nodes."}
+	}
+	capiEps, err := b.cluster.closedEps(capiEps)
+	if err != nil {
+		return "", err
+	}
+
+	var untried []string
+	for _, ep := range capiEps {
+		if !tried[ep] {
+			untried = append(untried, ep)
+		}
+	}
+	if len(untried) > 0 {
+		capiEps = untried
+	}
+	return b.cluster.selectEndpoint("views", capiEps), nil
+}
+
+// sendViewQuery sends a view query, sending it again to the endpoint chosen by nextEp
+// when the node it was sent to could not serve it.  Every attempt shares the timeout
+// of the query, so the attempts together take no longer than a single query may.  The
+// endpoint which served the query is returned along with its response.
+func (b *Bucket) sendViewQuery(ctx context.Context, capiEp string, nextEp func(tried map[string]bool) (string, error), tracker *retryTracker,
+	viewType, ddoc, viewName string, options url.Values, queryTimeout time.Duration) (*http.Response, context.CancelFunc, string, error) {
+	retryCtx := ctx
+	var deadline time.Time
+	if queryTimeout > 0 {
+		deadline = time.Now().Add(queryTimeout)
+		var stop context.CancelFunc
+		retryCtx, stop = context.WithDeadline(ctx, deadline)
+		defer stop()
+	}
+
+	tried := make(map[string]bool)
+	var resp *http.Response
+	var cancel context.CancelFunc
+	attempts := 0
+	err := retryWithTracker(retryCtx, tracker, func() error {
+		if resp != nil {
+			cancel()
+			logDebugf("Retrying view query after status %d from %s", resp.StatusCode, redactSystemData(capiEp))
+			resp = nil
+
+			var err error
+			capiEp, err = nextEp(tried)
+			if err != nil {
+				return err
+			}
+		}
+		tried[capiEp] = true
+		attempts++
+
+		timeout := queryTimeout
+		if !deadline.IsZero() {
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				return ErrTimeout
+			}
+		}
+
+		var err error
+		resp, cancel, err = b.sendViewRequest(ctx, tracker, capiEp, viewType, ddoc, viewName, options, timeout)
+		if err != nil || attempts > b.viewRetries {
+			return err
+		}
+
+		stale, err := isStaleViewResponse(resp)
+		if err != nil {
+			cancel()
+			resp = nil
+			return err
+		}
+		if stale {
+			return errStaleViewNode
+		}
+		return nil
+	}, func(err error, retryAttempts uint32) (string, time.Duration, bool) {
+		return "view_stale_node", viewRetryBackoff(retryAttempts), err == errStaleViewNode
+	})
+	if err == errStaleViewNode {
+		if err := ctx.Err(); err != nil {
+			cancel()
+			return nil, nil, capiEp, err
+		}
+		err = nil
+	}
+	if err != nil {
+		return nil, nil, capiEp, err
+	}
+	return resp, cancel, capiEp, nil
+}
+
+// sendViewRequest sends a view query to a view endpoint, waiting at most timeout for
+// its response.  The returned cancel function must be called once the response is no
+// longer needed.
+func (b *Bucket) sendViewRequest(ctx context.Context, tracker *retryTracker, capiEp, viewType, ddoc, viewName string, options url.Values, timeout time.Duration) (*http.Response, context.CancelFunc, error) {
+	reqUri := fmt.Sprintf("%s/_design/%s/%s/%s?%s", capiEp, ddoc, viewType, viewName, options.Encode())
+
+	req, err := http.NewRequest("GET", reqUri, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b.cluster.auth != nil {
+		userPass := b.cluster.auth.bucketViews(b.name)
+		if userPass.Username != "" || userPass.Password != "" {
+			req.SetBasicAuth(userPass.Username, userPass.Password)
+		}
+	} else {
+		req.SetBasicAuth(b.name, b.password)
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	req = req.WithContext(reqCtx)
+
+	reqStart := time.Now()
+	resp, err := b.cluster.doHttpWithRetry(tracker, b.effectiveRetryStrategy(nil), b.httpClient(), req, contextTimeout(ctx, timeout))
+	if err != nil && ctx.Err() != nil {
+		cancel()
+		return nil, nil, ctx.Err()
+	}
+	b.cluster.recordEndpointLatency(capiEp, time.Since(reqStart), err)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// isStaleViewResponse returns whether a view response indicates that the node could not
+// serve the query as it is in a pending state or is being rebalanced, in which case the
+// query can be sent to another node.  Other failures, such as errors of the map or
+// reduce functions of the view, are returned as they are.  The response body of failed
+// queries is read so that they can be inspected, and replaced so that it can still be
+// decoded afterwards.
+func isStaleViewResponse(resp *http.Response) (bool, error) {
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		logDebugf("Failed to close socket (%s)", closeErr)
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return true, nil
+	}
+	viewResp := viewResponse{}
+	if err := json.Unmarshal(data, &viewResp); err != nil {
+		return false, nil
+	}
+	reason := strings.ToLower(viewResp.Error + " " + viewResp.Reason)
+	return strings.Contains(reason, "pending state") || strings.Contains(reason, "rebalanc"), nil
+}